@@ -0,0 +1,341 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Hardware access backends. The network tester accesses the hardware through
+// two kinds of channels: a register interface (BAR) for configuration and
+// status registers and one or more DMA channels for transferring data to/from
+// the ring buffers in the DRAM of the FPGA board. The Backend interface bundles
+// both. The default backend talks to the NetFPGA-SUME via PCI Express, the
+// Simulator backend (see simulator.go) models the hardware in software.
+
+package gofluent10g
+
+import (
+	"github.com/aoeldemann/gopcie"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BAR provides read and write access to the configuration and status registers
+// of the network tester hardware.
+type BAR interface {
+	Read(addr uint32) uint32
+	Write(addr uint32, data uint32)
+	Close()
+}
+
+// DMA provides read and write access to the DRAM memory of the FPGA board, in
+// which the TX and RX ring buffers are located.
+type DMA interface {
+	Read(addr uint64, data []byte) error
+	Write(addr uint64, data []byte) error
+	Close()
+}
+
+// Backend provides access to the network tester hardware. It provides one
+// register access module, as well as a list of DMA channels for host-to-card
+// (write) and card-to-host (read) transfers.
+type Backend interface {
+	GetBAR() BAR
+	GetDMAWrite() []DMA
+	GetDMARead() []DMA
+	Close()
+}
+
+// pcieBackend is the backend accessing the network tester hardware via PCI
+// Express.
+type pcieBackend struct {
+	bar      BAR
+	dmaWrite []DMA
+	dmaRead  []DMA
+}
+
+// pcieBAR wraps the gopcie BAR access module.
+type pcieBAR struct {
+	bar *gopcie.PCIeBAR
+}
+
+// pcieDMA wraps a gopcie DMA device.
+type pcieDMA struct {
+	dma *gopcie.PCIeDMA
+}
+
+// pcieBackendOpen opens the PCI Express BAR and the XDMA devices of the
+// network tester. IDs and device names are taken from the board profile.
+func pcieBackendOpen(board *BoardProfile) (*pcieBackend, error) {
+	backend := &pcieBackend{}
+	xdmaPrefix := board.PCIeXDMAPrefix
+
+	if board.PCIeAddr != "" {
+		// open the PCIExpress BAR of the board with the specified address
+		bar, err := pcieSysfsBAROpen(board.PCIeAddr, board.PCIeBARID)
+		if err != nil {
+			return nil, err
+		}
+		backend.bar = bar
+
+		// the xdma device prefix depends on the order in which the driver
+		// probed the boards, look it up
+		if prefix, err := pcieSysfsXDMAPrefix(board.PCIeAddr); err == nil {
+			xdmaPrefix = prefix
+		} else if len(board.PCIeDevH2C) == 0 || len(board.PCIeDevC2H) == 0 {
+			backend.Close()
+			return nil, err
+		}
+	} else {
+		// open PCIExpress BAR of the first matching board
+		bar, err := gopcie.PCIeBAROpen(
+			board.PCIeBARFunctionID,
+			board.PCIeVendorID,
+			board.PCIeDeviceID,
+			board.PCIeBARID)
+		if err != nil {
+			return nil, ErrorCreate(ErrHardwareAccess, "%s", err.Error())
+		}
+		backend.bar = &pcieBAR{bar: bar}
+	}
+
+	// get the names of the DMA devices. if not specified by the board
+	// profile, use all available channels
+	devsH2C, devsC2H := board.PCIeDevH2C, board.PCIeDevC2H
+	if len(devsH2C) == 0 {
+		devsH2C = pcieDMADiscover(xdmaPrefix + "_h2c_")
+	}
+	if len(devsC2H) == 0 {
+		devsC2H = pcieDMADiscover(xdmaPrefix + "_c2h_")
+	}
+	if len(devsH2C) == 0 || len(devsC2H) == 0 {
+		backend.Close()
+		return nil, ErrorCreate(ErrHardwareAccess, "No XDMA devices found "+
+			"(%s)", xdmaPrefix)
+	}
+
+	Log(LOG_DEBUG, "DMA devices: %s, %s", devsH2C, devsC2H)
+
+	// open PCIExpress DMA for writing
+	for i := 0; i < len(devsH2C); i++ {
+		dma, err := gopcie.PCIeDMAOpen(devsH2C[i],
+			gopcie.PCIE_ACCESS_WRITE)
+		if err != nil {
+			backend.Close()
+			return nil, ErrorCreate(ErrHardwareAccess, "%s", err.Error())
+		}
+		backend.dmaWrite = append(backend.dmaWrite, &pcieDMA{dma: dma})
+	}
+
+	// open PCIExpress DMA for reading
+	for i := 0; i < len(devsC2H); i++ {
+		dma, err := gopcie.PCIeDMAOpen(devsC2H[i],
+			gopcie.PCIE_ACCESS_READ)
+		if err != nil {
+			backend.Close()
+			return nil, ErrorCreate(ErrHardwareAccess, "%s", err.Error())
+		}
+		backend.dmaRead = append(backend.dmaRead, &pcieDMA{dma: dma})
+	}
+
+	return backend, nil
+}
+
+// pcieDMADiscover returns the names of all DMA channel devices whose name
+// consists of the prefix followed by the channel number, ordered by channel
+// number.
+func pcieDMADiscover(prefix string) []string {
+	matches, _ := filepath.Glob(prefix + "*")
+
+	var channels []int
+	for _, match := range matches {
+		channel, err := strconv.Atoi(strings.TrimPrefix(match, prefix))
+		if err == nil && channel >= 0 {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Ints(channels)
+
+	devs := make([]string, len(channels))
+	for i, channel := range channels {
+		devs[i] = prefix + strconv.Itoa(channel)
+	}
+	return devs
+}
+
+// GetBAR returns the register access module.
+func (backend *pcieBackend) GetBAR() BAR {
+	return backend.bar
+}
+
+// GetDMAWrite returns the host-to-card DMA channels.
+func (backend *pcieBackend) GetDMAWrite() []DMA {
+	return backend.dmaWrite
+}
+
+// GetDMARead returns the card-to-host DMA channels.
+func (backend *pcieBackend) GetDMARead() []DMA {
+	return backend.dmaRead
+}
+
+// Close closes the PCI Express BAR and all DMA devices.
+func (backend *pcieBackend) Close() {
+	backend.bar.Close()
+	for _, dma := range backend.dmaWrite {
+		dma.Close()
+	}
+	for _, dma := range backend.dmaRead {
+		dma.Close()
+	}
+}
+
+// Read reads a register value.
+func (bar *pcieBAR) Read(addr uint32) uint32 {
+	return bar.bar.Read(addr)
+}
+
+// Write writes a register value.
+func (bar *pcieBAR) Write(addr uint32, data uint32) {
+	bar.bar.Write(addr, data)
+}
+
+// Close closes the BAR.
+func (bar *pcieBAR) Close() {
+	bar.bar.Close()
+}
+
+// Read reads data from the FPGA board's memory.
+func (dma *pcieDMA) Read(addr uint64, data []byte) error {
+	return dma.dma.Read(addr, data)
+}
+
+// Write writes data to the FPGA board's memory.
+func (dma *pcieDMA) Write(addr uint64, data []byte) error {
+	return dma.dma.Write(addr, data)
+}
+
+// Close closes the DMA device.
+func (dma *pcieDMA) Close() {
+	dma.dma.Close()
+}
+
+// DMA channel directions
+const (
+	DMADirectionH2C = 0 // host to card (ring buffer writes)
+	DMADirectionC2H = 1 // card to host (ring buffer reads)
+)
+
+// DMAChannelStats holds the number of bytes transferred through a DMA channel
+// and the time spent transferring them.
+type DMAChannelStats struct {
+	Direction  int // DMADirectionH2C or DMADirectionC2H
+	Channel    int
+	NTransfers uint64
+	NBytes     uint64
+	TimeBusy   time.Duration
+}
+
+// GetThroughput returns the average throughput of the DMA channel while it was
+// transferring data in bits per second.
+func (stats DMAChannelStats) GetThroughput() float64 {
+	if stats.TimeBusy == 0 {
+		return 0
+	}
+	return 8 * float64(stats.NBytes) / stats.TimeBusy.Seconds()
+}
+
+// dmaChannel wraps a DMA channel of the backend and records transfer
+// statistics. Statistics are accessed atomically, since they may be read while
+// the channel is used by a replay or capture goroutine.
+type dmaChannel struct {
+	dma DMA
+
+	direction, channel int
+
+	nTransfers uint64
+	nBytes     uint64
+	nsBusy     int64
+}
+
+// dmaChannelsCreate wraps the DMA channels of the backend.
+func dmaChannelsCreate(dmas []DMA, direction int) []DMA {
+	channels := make([]DMA, len(dmas))
+	for i, dma := range dmas {
+		channels[i] = &dmaChannel{
+			dma:       dma,
+			direction: direction,
+			channel:   i,
+		}
+	}
+	return channels
+}
+
+// Read reads data from the FPGA board's memory.
+func (channel *dmaChannel) Read(addr uint64, data []byte) error {
+	tStart := time.Now()
+	err := channel.dma.Read(addr, data)
+	channel.record(len(data), time.Since(tStart))
+	return err
+}
+
+// Write writes data to the FPGA board's memory.
+func (channel *dmaChannel) Write(addr uint64, data []byte) error {
+	tStart := time.Now()
+	err := channel.dma.Write(addr, data)
+	channel.record(len(data), time.Since(tStart))
+	return err
+}
+
+// Close closes the DMA channel.
+func (channel *dmaChannel) Close() {
+	channel.dma.Close()
+}
+
+// record adds a transfer to the statistics.
+func (channel *dmaChannel) record(size int, duration time.Duration) {
+	atomic.AddUint64(&channel.nTransfers, 1)
+	atomic.AddUint64(&channel.nBytes, uint64(size))
+	atomic.AddInt64(&channel.nsBusy, int64(duration))
+}
+
+// getStats returns the transfer statistics.
+func (channel *dmaChannel) getStats() DMAChannelStats {
+	return DMAChannelStats{
+		Direction:  channel.direction,
+		Channel:    channel.channel,
+		NTransfers: atomic.LoadUint64(&channel.nTransfers),
+		NBytes:     atomic.LoadUint64(&channel.nBytes),
+		TimeBusy:   time.Duration(atomic.LoadInt64(&channel.nsBusy)),
+	}
+}
+
+// resetStats resets the transfer statistics.
+func (channel *dmaChannel) resetStats() {
+	atomic.StoreUint64(&channel.nTransfers, 0)
+	atomic.StoreUint64(&channel.nBytes, 0)
+	atomic.StoreInt64(&channel.nsBusy, 0)
+}
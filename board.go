@@ -0,0 +1,194 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Board profiles describe the FPGA board and hardware variant the network
+// tester runs on: number of network interfaces, register base addresses,
+// memory banks, clock frequencies, line rate, expected hardware identification
+// and DMA devices. The NetFPGA-SUME profile is used by default.
+
+package gofluent10g
+
+// BoardProfile describes an FPGA board and hardware variant of the network
+// tester. Register base address tables must contain one entry per network
+// interface.
+type BoardProfile struct {
+	Name string
+
+	// number of network interfaces
+	NInterfaces int
+
+	// frequency of the clock domain the hardware cores run in. It determines
+	// the resolution of capture timestamps, latency values and data rate
+	// sample intervals
+	FreqClock float64
+
+	// frequency of the clock the rate control modules run in. Inter-packet
+	// times of traces are specified in clock cycles of this clock. Traces
+	// must be created for this clock (see TraceBuilderCreateWithClock() and
+	// Trace.SetClock()), otherwise they are rejected by WriteConfig()
+	FreqTrace float64
+
+	// line rate of the network interfaces (bits per second)
+	LineRate float64
+
+	// expected hardware identification values
+	HWCRC16   uint32
+	HWVersion uint32
+
+	// PCI Express Base Address Register IDs and XDMA device names (host to
+	// card and card to host). If no device names are specified, all DMA
+	// channels of the XDMA device with the specified prefix are opened. If
+	// a PCI address (e.g. "0000:03:00.0") is specified, the board with this
+	// address is opened and the XDMA device prefix is determined from sysfs.
+	// Otherwise the first board matching the IDs is opened (see also
+	// BoardsEnumerate())
+	PCIeAddr          string
+	PCIeBARFunctionID int
+	PCIeVendorID      int
+	PCIeDeviceID      int
+	PCIeBARID         int
+	PCIeDevH2C        []string
+	PCIeDevC2H        []string
+	PCIeXDMAPrefix    string
+
+	// DRAM memory banks in which the ring buffers are placed
+	MemoryBanks []MemoryBank
+
+	// peripheral base addresses, one per network interface
+	AddrBaseGenReplay     []uint32
+	AddrBaseGenRateCtrl   []uint32
+	AddrBaseRecvCapture   []uint32
+	AddrBaseRecvFilterMAC []uint32
+	AddrBaseIface         []uint32
+	AddrBaseDatarate      []uint32
+
+	// global peripheral base addresses
+	AddrBaseCtrl      uint32
+	AddrBaseTimestamp uint32
+	AddrBaseIdent     uint32
+}
+
+// BoardNetFPGASUME is the profile of the 4-port 10 Gbps network tester on the
+// NetFPGA-SUME board.
+var BoardNetFPGASUME = BoardProfile{
+	Name:              "NetFPGA-SUME",
+	NInterfaces:       N_INTERFACES,
+	FreqClock:         FREQ_SFP,
+	FreqTrace:         FREQ_SFP,
+	LineRate:          LINE_RATE,
+	HWCRC16:           HW_CRC16,
+	HWVersion:         HW_VERSION,
+	PCIeBARFunctionID: PCIE_BAR_FUNCTION_ID,
+	PCIeVendorID:      PCIE_BAR_VENDOR_ID,
+	PCIeDeviceID:      PCIE_BAR_DEVICE_ID,
+	PCIeBARID:         PCIE_BAR_ID,
+	PCIeXDMAPrefix:    PCIE_XDMA_DEV_PREFIX,
+	MemoryBanks: []MemoryBank{
+		{Addr: ADDR_DDR_A, Size: uint64(ADDR_RANGE_DDR_A) + 1},
+		{Addr: ADDR_DDR_B, Size: uint64(ADDR_RANGE_DDR_B) + 1},
+	},
+	AddrBaseGenReplay:     ADDR_BASE_NT_GEN_REPLAY,
+	AddrBaseGenRateCtrl:   ADDR_BASE_NT_GEN_RATE_CTRL,
+	AddrBaseRecvCapture:   ADDR_BASE_NT_RECV_CAPTURE,
+	AddrBaseRecvFilterMAC: ADDR_BASE_NT_RECV_FILTER_MAC,
+	AddrBaseIface:         ADDR_BASE_IFACE,
+	AddrBaseDatarate:      ADDR_BASE_NT_DATARATE,
+	AddrBaseCtrl:          ADDR_BASE_NT_CTRL,
+	AddrBaseTimestamp:     ADDR_BASE_NT_TIMESTAMP,
+	AddrBaseIdent:         ADDR_BASE_NT_IDENT,
+}
+
+// copy returns a deep copy of the board profile, which does not share the
+// device name, memory bank and base address slices with the original profile.
+func (board *BoardProfile) copy() BoardProfile {
+	boardCopy := *board
+	boardCopy.PCIeDevH2C = append([]string(nil), board.PCIeDevH2C...)
+	boardCopy.PCIeDevC2H = append([]string(nil), board.PCIeDevC2H...)
+	boardCopy.MemoryBanks = append([]MemoryBank(nil), board.MemoryBanks...)
+	for _, addrs := range []*[]uint32{
+		&boardCopy.AddrBaseGenReplay,
+		&boardCopy.AddrBaseGenRateCtrl,
+		&boardCopy.AddrBaseRecvCapture,
+		&boardCopy.AddrBaseRecvFilterMAC,
+		&boardCopy.AddrBaseIface,
+		&boardCopy.AddrBaseDatarate,
+	} {
+		*addrs = append([]uint32(nil), *addrs...)
+	}
+	return boardCopy
+}
+
+// check makes sure the board profile is complete and consistent.
+func (board *BoardProfile) check() error {
+	// the rate control modules are activated through a 32 bit interface mask
+	if board.NInterfaces <= 0 || board.NInterfaces > 32 {
+		return ErrorCreate(ErrInvalidConfig, "Board %s: number of network "+
+			"interfaces must be in the range of 1 and 32", board.Name)
+	}
+
+	if board.FreqClock <= 0 || board.FreqTrace <= 0 {
+		return ErrorCreate(ErrInvalidConfig, "Board %s: clock frequencies "+
+			"must be larger than zero", board.Name)
+	}
+
+	if board.LineRate <= 0 {
+		return ErrorCreate(ErrInvalidConfig, "Board %s: line rate must be "+
+			"larger than zero", board.Name)
+	}
+
+	if (len(board.PCIeDevH2C) == 0 || len(board.PCIeDevC2H) == 0) &&
+		board.PCIeXDMAPrefix == "" && board.PCIeAddr == "" {
+		return ErrorCreate(ErrInvalidConfig, "Board %s: DMA device names or "+
+			"XDMA device prefix required", board.Name)
+	}
+
+	// make sure there is a base address for each network interface
+	addrBases := []struct {
+		name  string
+		addrs []uint32
+	}{
+		{"generator replay", board.AddrBaseGenReplay},
+		{"generator rate control", board.AddrBaseGenRateCtrl},
+		{"receiver capture", board.AddrBaseRecvCapture},
+		{"receiver MAC filter", board.AddrBaseRecvFilterMAC},
+		{"interface", board.AddrBaseIface},
+		{"data rate", board.AddrBaseDatarate},
+	}
+	for _, addrBase := range addrBases {
+		if len(addrBase.addrs) != board.NInterfaces {
+			return ErrorCreate(ErrInvalidConfig, "Board %s: %d %s base "+
+				"addresses, expected %d", board.Name, len(addrBase.addrs),
+				addrBase.name, board.NInterfaces)
+		}
+	}
+
+	if err := memoryBanksCheck(board.MemoryBanks); err != nil {
+		return err
+	}
+
+	return nil
+}
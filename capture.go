@@ -41,89 +41,64 @@ type Capture struct {
 	wrPtr uint64 // current host memory write pointer position
 	// duration (in seconds) between two latency timestamp counter increments
 	tickPeriodLatency float64
-	caplen            int  // maximum per-packet capture length
-	discard           bool // if true, captured data is discarded
+	freqClock         float64 // hardware clock frequency
+	caplen            int     // maximum per-packet capture length
+	discard           bool    // if true, captured data is discarded
+
+	// if not nil, captured data is streamed to a file instead of being held
+	// in host memory
+	sink *captureSink
 }
 
-// WriteToFile writes the captured data to an output file.
-func (capture *Capture) WriteToFile(filename string) {
-	err := ioutil.WriteFile(filename, capture.data[0:capture.wrPtr], 0644)
+// WriteToFile writes the captured data to an output file. If the capture data
+// has been streamed to a file, it is not held in host memory and the output
+// file remains empty.
+func (capture *Capture) WriteToFile(filename string) (err error) {
+	defer ErrorReturn(&err)
+
+	err = ioutil.WriteFile(filename, capture.data[0:capture.wrPtr], 0644)
 	if err != nil {
-		Log(LOG_ERR, "Capture '%s': could not write to file", filename)
+		return ErrorCreate(ErrFile, "Capture '%s': could not write to file",
+			filename)
 	}
 
 	Log(LOG_DEBUG, "Capture '%s': wrote to file", filename)
+
+	return nil
 }
 
-// GetPackets returns a list of captured packets.
+// GetPackets returns a list of captured packets. If the capture data has been
+// streamed to a file, no packets are held in host memory and the returned list
+// is empty.
 func (capture *Capture) GetPackets() CapturePackets {
 	var pkts CapturePackets
 	var posRd uint64
 
+	if capture.sink != nil {
+		return pkts
+	}
+
 	for posRd < capture.wrPtr {
-		// get 8 byte meta data word
-		meta := binary.LittleEndian.Uint64(capture.data[posRd : posRd+8])
+		pkt, size, valid := captureRecordParse(
+			capture.data[posRd:capture.wrPtr], capture.caplen,
+			capture.tickPeriodLatency, capture.freqClock)
 
-		if meta == 0xFFFFFFFFFFFFFFFF {
+		if size == 0 || !valid {
 			// end of capture data
 			break
 		}
 
-		// has a latency value been calculated for this packet?
-		hasLatency := (meta>>24)&0x1 == 0x1
-
-		// extract latency value, if present
-		var latency float64
-		if hasLatency {
-			// calculate latency in seconds
-			latency = float64(meta&0xFFFFFF) * capture.tickPeriodLatency
-
-			// subtract latency error induced by the MACs and PHYs of the
-			// network tester itself
-			latency -= float64(LATENCY_ERR_CORRECTION_CYCLES) / FREQ_SFP
-		}
-
-		// get packet's arrival-time (time since previous packet arrived, the
-		// arrival-time value of the first packet is not meaningful)
-		arrivalTime := float64((meta>>25)&0xFFFFFFF) / FREQ_SFP
-
-		// get packet's wire length
-		wirelen := int((meta >> 53) & 0x7FF)
-
-		// determine capture length
-		var caplen int
-		if wirelen > capture.caplen {
-			caplen = capture.caplen
-		} else {
-			caplen = wirelen
-		}
-
-		// increment wire length by 4 byte, because MAC strips off the FCS
-		wirelen += 4
-
-		// create new CapturePacket struct
-		pkt := CapturePacket{
-			ArrivalTime: arrivalTime,
-			HasLatency:  hasLatency,
-			Latency:     latency,
-			Wirelen:     wirelen,
-		}
-
-		// save packet data
-		pkt.Data = make([]byte, caplen)
-		copy(pkt.Data, capture.data[posRd+8:posRd+8+uint64(caplen)])
+		// save a copy of the packet data, so that the capture data memory
+		// can be released independently of the packet list
+		data := make([]byte, len(pkt.Data))
+		copy(data, pkt.Data)
+		pkt.Data = data
 
 		// apennd CapturePacket struct to list
 		pkts = append(pkts, pkt)
 
-		// calculate position of next packet's meta data (each 8 byte meta data
-		// word is followed by the capture data, which is aligned to 8 byte
-		// boundaries)
-		if caplen%8 == 0 {
-			posRd += 8 + uint64(caplen)
-		} else {
-			posRd += 16 + uint64(caplen-caplen%8)
-		}
+		// continue with next packet's meta data
+		posRd += uint64(size)
 	}
 
 	return pkts
@@ -131,17 +106,36 @@ func (capture *Capture) GetPackets() CapturePackets {
 
 // GetSize returns the size of trace capture data in bytes.
 func (capture *Capture) GetSize() uint64 {
+	if capture.sink != nil {
+		// size of data that has been streamed to the capture file
+		return capture.sink.getSize()
+	}
+
 	// size of captured data is equal to current write pointer position
 	return capture.wrPtr
 }
 
 // getWriteSlice returns an empty byte slice of size 'size' to which capture
-// data can be written to.
-func (capture *Capture) getWriteSlice(size uint32) []byte {
+// data can be written to. It returns an error if the reserved host memory is
+// exhausted. If capture data is streamed to a file and all buffers are
+// currently being written to disk, the function returns a nil slice, unless
+// wait is set to true. In that case it waits until a buffer becomes available.
+func (capture *Capture) getWriteSlice(size uint32, wait bool) ([]byte, error) {
+	if capture.sink != nil {
+		// captured data is streamed to a file
+		return capture.sink.getWriteSlice(size, wait)
+	}
+
 	if capture.discard {
 		// captured data shall be discarded. always write data to the same
 		// (sub-) byte slice
-		return capture.data[0:size]
+		return capture.data[0:size], nil
+	}
+
+	// make sure the capture data fits into the reserved host memory
+	if capture.wrPtr+uint64(size) > uint64(len(capture.data)) {
+		return nil, ErrorCreate(ErrRingBuffOverflow,
+			"Capture: host memory capture size exceeded")
 	}
 
 	// get slice
@@ -151,5 +145,79 @@ func (capture *Capture) getWriteSlice(size uint32) []byte {
 	capture.wrPtr += uint64(size)
 
 	// return slice
-	return wrSlice
+	return wrSlice, nil
+}
+
+// GetBackPressureCount returns the number of times the RX ring buffer could not
+// be read, because the capture data streamed to a file could not be written to
+// disk fast enough. It returns zero if capture data is held in host memory.
+func (capture *Capture) GetBackPressureCount() int {
+	if capture.sink == nil {
+		return 0
+	}
+	return capture.sink.nBackPressure
+}
+
+// captureRecordParse parses the capture record (8 byte meta data word followed
+// by the packet data) at the beginning of buf. It returns the packet, the size
+// of the record in bytes and whether the record contains a packet. Records
+// not containing a packet mark the end of the capture data. The packet data
+// is not copied, i.e. it references buf. If buf does not hold the entire
+// record, the returned size is zero. freqClock is the clock frequency of the
+// hardware, in whose clock cycles arrival times are recorded.
+func captureRecordParse(buf []byte, caplenMax int, tickPeriodLatency float64, freqClock float64) (CapturePacket, int, bool) {
+	var pkt CapturePacket
+
+	if len(buf) < 8 {
+		return pkt, 0, false
+	}
+
+	// get 8 byte meta data word
+	meta := binary.LittleEndian.Uint64(buf[0:8])
+
+	if meta == 0xFFFFFFFFFFFFFFFF {
+		// end of capture data
+		return pkt, 8, false
+	}
+
+	// has a latency value been calculated for this packet?
+	pkt.HasLatency = (meta>>24)&0x1 == 0x1
+
+	// extract latency value, if present
+	if pkt.HasLatency {
+		// calculate latency in seconds
+		pkt.Latency = float64(meta&0xFFFFFF) * tickPeriodLatency
+
+		// subtract latency error induced by the MACs and PHYs of the
+		// network tester itself
+		pkt.Latency -= float64(LATENCY_ERR_CORRECTION_CYCLES) / freqClock
+	}
+
+	// get packet's arrival-time (time since previous packet arrived, the
+	// arrival-time value of the first packet is not meaningful)
+	pkt.ArrivalTime = float64((meta>>25)&0xFFFFFFF) / freqClock
+
+	// get packet's wire length
+	wirelen := int((meta >> 53) & 0x7FF)
+
+	// determine capture length
+	caplen := wirelen
+	if caplen > caplenMax {
+		caplen = caplenMax
+	}
+
+	// increment wire length by 4 byte, because MAC strips off the FCS
+	pkt.Wirelen = wirelen + 4
+
+	// each 8 byte meta data word is followed by the capture data, which is
+	// aligned to 8 byte boundaries
+	size := 8 + 8*((caplen+7)/8)
+	if len(buf) < size {
+		return pkt, 0, false
+	}
+
+	// packet data
+	pkt.Data = buf[8 : 8+caplen]
+
+	return pkt, size, true
 }
@@ -83,16 +83,24 @@ func (pkts CapturePacketsSortByLatency) Less(i, j int) bool {
 
 // WriteToFile writes the captured latency values to an output file. It writes
 // one floating point latency value per line.
-func (latencies Latencies) WriteToFile(filename string) {
+func (latencies Latencies) WriteToFile(filename string) (err error) {
+	defer ErrorReturn(&err)
+
 	// create file
 	f, err := os.Create(filename)
 	if err != nil {
-		Log(LOG_ERR, "could not create latency file '%s'", filename)
+		return ErrorCreate(ErrFile, "could not create latency file '%s'",
+			filename)
 	}
 	defer f.Close()
 
 	// write latency values to file
 	for _, latency := range latencies {
-		fmt.Fprintf(f, "%.10f\n", latency)
+		if _, err := fmt.Fprintf(f, "%.10f\n", latency); err != nil {
+			return ErrorCreate(ErrFile, "could not write latency file '%s'",
+				filename)
+		}
 	}
+
+	return nil
 }
@@ -0,0 +1,271 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Export of captured packets to pcap and pcapng files, which can be opened by
+// standard tools (e.g. Wireshark). The network tester only records the time
+// between the arrival of two consecutive packets, so absolute packet
+// timestamps are reconstructed by accumulating the arrival times starting from
+// a timestamp provided by the application. Since the MAC strips off the FCS,
+// the original length of each packet in the file is the wire length minus 4
+// bytes. In pcapng files, the measured latency of each timestamped packet is
+// stored in the packet's comment option.
+
+package gofluent10g
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"os"
+	"time"
+)
+
+// pcapng block types and option codes
+const (
+	pcapngBlockTypeSHB   = uint32(0x0A0D0D0A) // section header block
+	pcapngBlockTypeIDB   = uint32(0x00000001) // interface description block
+	pcapngBlockTypeEPB   = uint32(0x00000006) // enhanced packet block
+	pcapngByteOrderMagic = uint32(0x1A2B3C4D)
+	pcapngOptEndOfOpt    = uint16(0)
+	pcapngOptComment     = uint16(1)
+	pcapngOptIfTsresol   = uint16(9)
+)
+
+// WriteToPcap writes the captured packets to a pcap file. The timestamp of the
+// first packet is set to tStart.
+func (capture *Capture) WriteToPcap(filename string, tStart time.Time) (err error) {
+	defer ErrorReturn(&err)
+
+	return capture.GetPackets().writePcap(filename, capture.caplen, tStart)
+}
+
+// WriteToPcapng writes the captured packets to a pcapng file. The timestamp of
+// the first packet is set to tStart. The measured latency of timestamped
+// packets is stored in the packet comment.
+func (capture *Capture) WriteToPcapng(filename string, tStart time.Time) (err error) {
+	defer ErrorReturn(&err)
+
+	return capture.GetPackets().writePcapng(filename, capture.caplen, tStart)
+}
+
+// WriteToPcap writes the packets to a pcap file. The timestamp of the first
+// packet is set to tStart.
+func (pkts CapturePackets) WriteToPcap(filename string, tStart time.Time) (err error) {
+	defer ErrorReturn(&err)
+
+	return pkts.writePcap(filename, pkts.getSnaplen(), tStart)
+}
+
+// WriteToPcapng writes the packets to a pcapng file. The timestamp of the
+// first packet is set to tStart. The measured latency of timestamped packets
+// is stored in the packet comment.
+func (pkts CapturePackets) WriteToPcapng(filename string, tStart time.Time) (err error) {
+	defer ErrorReturn(&err)
+
+	return pkts.writePcapng(filename, pkts.getSnaplen(), tStart)
+}
+
+// GetTimestamps returns a list containing the absolute timestamps of the
+// packets. The timestamp of the first packet is set to tStart, the timestamps
+// of all following packets are reconstructed by accumulating the arrival times.
+func (pkts CapturePackets) GetTimestamps(tStart time.Time) []time.Time {
+	timestamps := make([]time.Time, len(pkts))
+
+	// accumulated time since the arrival of the first packet in seconds. the
+	// arrival-time value of the first packet is not meaningful, so skip it
+	accArrivalTime := 0.0
+
+	for i := 0; i < len(pkts); i++ {
+		if i > 0 {
+			accArrivalTime += pkts[i].ArrivalTime
+		}
+		timestamps[i] = tStart.Add(time.Duration(accArrivalTime * 1e9))
+	}
+
+	return timestamps
+}
+
+// getSnaplen returns the largest capture length of all packets.
+func (pkts CapturePackets) getSnaplen() int {
+	snaplen := 0
+	for _, pkt := range pkts {
+		if len(pkt.Data) > snaplen {
+			snaplen = len(pkt.Data)
+		}
+	}
+	return snaplen
+}
+
+// writePcap writes the packets to a pcap file with nanosecond timestamp
+// resolution.
+func (pkts CapturePackets) writePcap(filename string, snaplen int, tStart time.Time) error {
+	// create file
+	f, err := os.Create(filename)
+	if err != nil {
+		return ErrorCreate(ErrFile, "could not create pcap file '%s'",
+			filename)
+	}
+	defer f.Close()
+
+	wr := bufio.NewWriter(f)
+	w := pcapgo.NewWriterNanos(wr)
+
+	// write file header
+	if err := w.WriteFileHeader(uint32(snaplen), layers.LinkTypeEthernet); err != nil {
+		return ErrorCreate(ErrFile, "could not write pcap file '%s'",
+			filename)
+	}
+
+	// write packets
+	timestamps := pkts.GetTimestamps(tStart)
+	for i, pkt := range pkts {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     timestamps[i],
+			CaptureLength: len(pkt.Data),
+			Length:        pkt.Wirelen - 4,
+		}
+		if err := w.WritePacket(ci, pkt.Data); err != nil {
+			return ErrorCreate(ErrFile, "could not write pcap file '%s'",
+				filename)
+		}
+	}
+
+	if err := wr.Flush(); err != nil {
+		return ErrorCreate(ErrFile, "could not write pcap file '%s'",
+			filename)
+	}
+
+	// close the file explicitly, since data may only be written to disk now.
+	// the deferred close only covers the error paths above
+	if err := f.Close(); err != nil {
+		return ErrorCreate(ErrFile, "could not write pcap file '%s'",
+			filename)
+	}
+
+	Log(LOG_DEBUG, "Capture '%s': wrote %d packets to pcap file", filename,
+		len(pkts))
+
+	return nil
+}
+
+// writePcapng writes the packets to a pcapng file with nanosecond timestamp
+// resolution. The file contains a single section with a single interface.
+func (pkts CapturePackets) writePcapng(filename string, snaplen int, tStart time.Time) error {
+	// create file
+	f, err := os.Create(filename)
+	if err != nil {
+		return ErrorCreate(ErrFile, "could not create pcapng file '%s'",
+			filename)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	// section header block: byte-order magic, version 1.0 and unspecified
+	// section length
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	binary.LittleEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+	pcapngBlockWrite(w, pcapngBlockTypeSHB, body)
+
+	// interface description block: link type, snaplen and timestamp
+	// resolution option (10^-9 seconds)
+	body = make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], uint16(layers.LinkTypeEthernet))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(snaplen))
+	body = pcapngOptionAppend(body, pcapngOptIfTsresol, []byte{9})
+	body = pcapngOptionAppend(body, pcapngOptEndOfOpt, nil)
+	pcapngBlockWrite(w, pcapngBlockTypeIDB, body)
+
+	// write packets
+	timestamps := pkts.GetTimestamps(tStart)
+	for i, pkt := range pkts {
+		ts := uint64(timestamps[i].UnixNano())
+
+		// enhanced packet block: interface id, timestamp, captured length,
+		// original length and packet data padded to 32 bit
+		body = make([]byte, 20+4*((len(pkt.Data)+3)/4))
+		binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+		binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+		binary.LittleEndian.PutUint32(body[12:16], uint32(len(pkt.Data)))
+		binary.LittleEndian.PutUint32(body[16:20], uint32(pkt.Wirelen-4))
+		copy(body[20:], pkt.Data)
+
+		// store latency in the packet comment
+		if pkt.HasLatency {
+			comment := fmt.Sprintf("latency=%.10fs", pkt.Latency)
+			body = pcapngOptionAppend(body, pcapngOptComment, []byte(comment))
+			body = pcapngOptionAppend(body, pcapngOptEndOfOpt, nil)
+		}
+
+		pcapngBlockWrite(w, pcapngBlockTypeEPB, body)
+	}
+
+	if err := w.Flush(); err != nil {
+		return ErrorCreate(ErrFile, "could not write pcapng file '%s'",
+			filename)
+	}
+
+	// close the file explicitly, since data may only be written to disk now.
+	// the deferred close only covers the error paths above
+	if err := f.Close(); err != nil {
+		return ErrorCreate(ErrFile, "could not write pcapng file '%s'",
+			filename)
+	}
+
+	Log(LOG_DEBUG, "Capture '%s': wrote %d packets to pcapng file", filename,
+		len(pkts))
+
+	return nil
+}
+
+// pcapngBlockWrite writes a pcapng block with the specified type and body.
+// The body length must be a multiple of 4 bytes. Write errors are reported
+// when the buffered writer is flushed.
+func pcapngBlockWrite(w *bufio.Writer, blockType uint32, body []byte) {
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr[0:4], blockType)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(12+len(body)))
+	w.Write(hdr)
+	w.Write(body)
+	w.Write(hdr[4:8])
+}
+
+// pcapngOptionAppend appends an option with the specified code and value to a
+// pcapng block body. The option value is padded to 32 bit.
+func pcapngOptionAppend(body []byte, code uint16, value []byte) []byte {
+	opt := make([]byte, 4+4*((len(value)+3)/4))
+	binary.LittleEndian.PutUint16(opt[0:2], code)
+	binary.LittleEndian.PutUint16(opt[2:4], uint16(len(value)))
+	copy(opt[4:], value)
+	return append(body, opt...)
+}
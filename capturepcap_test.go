@@ -0,0 +1,276 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the export of captured packets to pcap and pcapng files.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPcapRead reads all packets from a pcap file.
+func testPcapRead(t *testing.T, filename string) ([]gopacket.CaptureInfo, [][]byte) {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cis []gopacket.CaptureInfo
+	var data [][]byte
+	for {
+		pktData, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		cis = append(cis, ci)
+		data = append(data, pktData)
+	}
+	return cis, data
+}
+
+// testPcapngComments returns the comment options of the enhanced packet
+// blocks of a little endian pcapng file. Packets without a comment get an
+// empty string.
+func testPcapngComments(t *testing.T, filename string) []string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var comments []string
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatal("truncated pcapng block")
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:4])
+		blockLen := int(binary.LittleEndian.Uint32(data[4:8]))
+		if blockLen < 12 || blockLen%4 != 0 || blockLen > len(data) {
+			t.Fatalf("invalid pcapng block length %d", blockLen)
+		}
+		if binary.LittleEndian.Uint32(data[blockLen-4:blockLen]) !=
+			uint32(blockLen) {
+			t.Fatal("pcapng block lengths do not match")
+		}
+
+		if blockType == pcapngBlockTypeEPB {
+			body := data[8 : blockLen-4]
+			caplen := int(binary.LittleEndian.Uint32(body[12:16]))
+
+			// options follow the packet data, which is padded to 32 bit
+			opts := body[20+4*((caplen+3)/4):]
+			comment := ""
+			for len(opts) >= 4 {
+				code := binary.LittleEndian.Uint16(opts[0:2])
+				length := int(binary.LittleEndian.Uint16(opts[2:4]))
+				if code == pcapngOptEndOfOpt {
+					break
+				}
+				if code == pcapngOptComment {
+					comment = string(opts[4 : 4+length])
+				}
+				opts = opts[4+4*((length+3)/4):]
+			}
+			comments = append(comments, comment)
+		}
+
+		data = data[blockLen:]
+	}
+	return comments
+}
+
+// TestCaptureWriteToPcapRoundTrip imports a pcap file, replays the trace on
+// the simulated hardware, writes the captured packets to a pcap file and
+// checks that packet data, lengths and timestamps are preserved.
+func TestCaptureWriteToPcapRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	nPkts := 200
+	cis, data := testPcapPackets(nPkts)
+	testPcapWrite(t, filepath.Join(dir, "in.pcap"), layers.LinkTypeEthernet,
+		cis, data)
+
+	trace, err := TraceCreateFromPcap(filepath.Join(dir, "in.pcap"), 1514, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(1518, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+	testReplayCheck(t, nt, trace)
+
+	capture, _ := recv.GetCapture()
+	if err := capture.WriteToPcap(filepath.Join(dir, "out.pcap"),
+		cis[0].Timestamp); err != nil {
+		t.Fatal(err)
+	}
+
+	cisOut, dataOut := testPcapRead(t, filepath.Join(dir, "out.pcap"))
+	if len(cisOut) != nPkts {
+		t.Fatalf("pcap file contains %d packets, expected %d", len(cisOut),
+			nPkts)
+	}
+
+	// inter-packet times are rounded to 6.4 ns clock cycles. the rounding error
+	// does not accumulate
+	tolerance := 20 * time.Nanosecond
+
+	for i := range cisOut {
+		if cisOut[i].Length != cis[i].Length {
+			t.Fatalf("packet %d: length %d, expected %d", i,
+				cisOut[i].Length, cis[i].Length)
+		}
+		if bytes.Equal(dataOut[i], data[i]) == false {
+			t.Fatalf("packet %d: data does not match", i)
+		}
+
+		d := cisOut[i].Timestamp.Sub(cis[i].Timestamp)
+		if d < -tolerance || d > tolerance {
+			t.Fatalf("packet %d: timestamp differs by %s", i, d)
+		}
+	}
+}
+
+// TestCapturePacketsWriteToPcapng writes packets with and without latency
+// values to a pcapng file and checks that the file can be read by a standard
+// pcapng reader and that the latency is stored in the packet comment option.
+func TestCapturePacketsWriteToPcapng(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	pkts := CapturePackets{
+		{
+			Data:       testPacketData(0, 64),
+			Wirelen:    68,
+			HasLatency: true,
+			Latency:    1.5e-6,
+		},
+		{
+			Data:        testPacketData(1, 61),
+			Wirelen:     1518,
+			ArrivalTime: 2e-6,
+		},
+		{
+			Data:        testPacketData(2, 100),
+			Wirelen:     104,
+			ArrivalTime: 5e-6,
+			HasLatency:  true,
+			Latency:     2.25e-7,
+		},
+	}
+	tStart := time.Unix(1500000000, 123)
+
+	if err := pkts.WriteToPcapng(filename, tStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// the latency is stored in the comment option, packets without latency
+	// do not carry options
+	commentsExp := []string{"latency=0.0000015000s", "",
+		"latency=0.0000002250s"}
+	comments := testPcapngComments(t, filename)
+	if len(comments) != len(commentsExp) {
+		t.Fatalf("file contains %d packets, expected %d", len(comments),
+			len(commentsExp))
+	}
+	for i := range comments {
+		if comments[i] != commentsExp[i] {
+			t.Fatalf("packet %d: comment '%s', expected '%s'", i,
+				comments[i], commentsExp[i])
+		}
+	}
+
+	// read packets with a standard pcapng reader
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamps := pkts.GetTimestamps(tStart)
+	for i := 0; ; i++ {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			if i != len(pkts) {
+				t.Fatalf("read %d packets, expected %d", i, len(pkts))
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Equal(data, pkts[i].Data) == false {
+			t.Fatalf("packet %d: data does not match", i)
+		}
+		if ci.Length != pkts[i].Wirelen-4 {
+			t.Fatalf("packet %d: length %d, expected %d", i, ci.Length,
+				pkts[i].Wirelen-4)
+		}
+		if ci.Timestamp.Equal(timestamps[i]) == false {
+			t.Fatalf("packet %d: timestamp %s, expected %s", i,
+				ci.Timestamp, timestamps[i])
+		}
+	}
+}
+
+// TestCapturePacketsWriteToPcapngError checks that an error is returned if
+// the pcapng file cannot be written.
+func TestCapturePacketsWriteToPcapngError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "missing", "capture.pcapng")
+
+	pkts := CapturePackets{{Data: testPacketData(0, 60), Wirelen: 64}}
+	err := pkts.WriteToPcapng(filename, time.Now())
+	if errors.Is(err, ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+}
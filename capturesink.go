@@ -0,0 +1,363 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Streaming of capture data to a file. Instead of holding all capture data in
+// host memory, data read from the RX ring buffer is copied to one of two
+// buffers. While one buffer is being filled, the other one is written to disk
+// by a background goroutine (double-buffering). If the disk cannot keep up and
+// both buffers are in use, no further data is read from the RX ring buffer
+// until a buffer becomes available again (back-pressure). In that case the RX
+// ring buffer fills up, if it overflows the hardware flags an error.
+
+package gofluent10g
+
+import (
+	"bufio"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"os"
+	"sync"
+	"time"
+)
+
+// capture file formats
+const (
+	CaptureFileFormatRaw  int = 0 // ring buffer data (see Capture.WriteToFile)
+	CaptureFileFormatPcap int = 1 // pcap with nanosecond timestamps
+)
+
+const (
+	// number of buffers used for streaming capture data to disk
+	captureSinkNBufs = 2
+
+	// size of each buffer. must be able to hold an entire dma transfer
+	captureSinkBufSize = RING_BUFF_RD_TRANSFER_SIZE_MIN
+
+	// maximum size of a single capture record (8 byte meta data word plus up
+	// to 1518 bytes of packet data aligned to 8 byte)
+	captureSinkRecordSizeMax = 8 + 1520
+)
+
+// captureSink streams capture data to a file.
+type captureSink struct {
+	filename string
+	format   int
+
+	file *os.File
+	w    *bufio.Writer
+
+	// pcap writer and state needed for the conversion of capture data to pcap
+	pcapWriter        *pcapgo.Writer
+	caplen            int       // maximum per-packet capture length
+	tickPeriodLatency float64   // duration between latency counter increments
+	freqClock         float64   // hardware clock frequency
+	tStart            time.Time // timestamp of the first packet
+	accArrivalTime    float64   // accumulated arrival time in seconds
+	nPkts             int       // number of packets written to the file
+	pending           []byte    // incomplete capture record
+
+	buf     []byte      // buffer that is currently being filled
+	bufFree chan []byte // buffers available for filling
+	bufFull chan []byte // buffers waiting to be written to disk
+	done    chan bool   // closed when writer goroutine has completed
+	closed  bool        // true if the file has been closed
+
+	// number of times the RX ring buffer could not be read, because the disk
+	// could not keep up
+	nBackPressure int
+	backPressure  bool // true if currently no buffer is available
+
+	nBytes uint64 // number of bytes handed over for writing
+
+	err      error // first error that occured while writing the file
+	errMutex sync.Mutex
+}
+
+// captureSinkOpen creates the capture file and starts the goroutine writing
+// data to it.
+func captureSinkOpen(filename string, format int, caplen int, tickPeriodLatency float64, freqClock float64) (*captureSink, error) {
+	if format != CaptureFileFormatRaw && format != CaptureFileFormatPcap {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Capture '%s': invalid file format", filename)
+	}
+
+	// create file
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, ErrorCreate(ErrFile, "Capture '%s': could not create "+
+			"file", filename)
+	}
+
+	sink := &captureSink{
+		filename:          filename,
+		format:            format,
+		file:              file,
+		w:                 bufio.NewWriterSize(file, 1024*1024),
+		caplen:            caplen,
+		tickPeriodLatency: tickPeriodLatency,
+		freqClock:         freqClock,
+		tStart:            time.Now(),
+		bufFree:           make(chan []byte, captureSinkNBufs),
+		bufFull:           make(chan []byte, captureSinkNBufs),
+		done:              make(chan bool),
+	}
+
+	// write pcap file header
+	if format == CaptureFileFormatPcap {
+		sink.pcapWriter = pcapgo.NewWriterNanos(sink.w)
+		err := sink.pcapWriter.WriteFileHeader(uint32(caplen),
+			layers.LinkTypeEthernet)
+		if err != nil {
+			file.Close()
+			return nil, ErrorCreate(ErrFile, "Capture '%s': could not "+
+				"write file", filename)
+		}
+	}
+
+	// allocate buffers
+	for i := 0; i < captureSinkNBufs; i++ {
+		sink.bufFree <- make([]byte, 0, captureSinkBufSize)
+	}
+
+	// start writer goroutine
+	go sink.writer()
+
+	Log(LOG_DEBUG, "Capture '%s': streaming to file", filename)
+
+	return sink, nil
+}
+
+// getWriteSlice returns an empty byte slice of size 'size' to which capture
+// data can be written to. If no buffer is available, because the data of
+// both buffers is still being written to disk, the function returns a nil
+// slice. If wait is true, it instead waits until a buffer becomes available.
+func (sink *captureSink) getWriteSlice(size uint32, wait bool) ([]byte, error) {
+	// return error that occured while writing the file
+	if err := sink.getError(); err != nil {
+		return nil, err
+	}
+
+	if size > captureSinkBufSize {
+		return nil, ErrorCreate(ErrInvalidConfig, "Capture '%s': transfer "+
+			"size exceeds buffer size", sink.filename)
+	}
+
+	// if the current buffer cannot hold the data, hand it over for writing
+	if sink.buf != nil && len(sink.buf)+int(size) > cap(sink.buf) {
+		sink.bufFull <- sink.buf
+		sink.buf = nil
+	}
+
+	// get a new buffer
+	if sink.buf == nil {
+		if wait {
+			sink.buf = <-sink.bufFree
+		} else {
+			select {
+			case sink.buf = <-sink.bufFree:
+			default:
+				// disk cannot keep up. report back-pressure once when it
+				// starts
+				if !sink.backPressure {
+					sink.backPressure = true
+					sink.nBackPressure++
+					Log(LOG_WARN, "Capture '%s': disk cannot keep up, "+
+						"not reading RX ring buffer", sink.filename)
+				}
+				return nil, nil
+			}
+		}
+		sink.backPressure = false
+	}
+
+	// get slice
+	n := len(sink.buf)
+	sink.buf = sink.buf[0 : n+int(size)]
+	sink.nBytes += uint64(size)
+
+	return sink.buf[n : n+int(size)], nil
+}
+
+// getSize returns the number of bytes that have been streamed to the file.
+// For pcap files, the size refers to the capture data before conversion.
+func (sink *captureSink) getSize() uint64 {
+	return sink.nBytes
+}
+
+// close writes the remaining buffered data to the file, waits for the writer
+// goroutine to complete and closes the file.
+func (sink *captureSink) close() error {
+	if sink.closed {
+		return sink.getError()
+	}
+	sink.closed = true
+
+	// hand over partially filled buffer
+	if sink.buf != nil && len(sink.buf) > 0 {
+		sink.bufFull <- sink.buf
+	}
+	sink.buf = nil
+
+	// wait for writer goroutine to complete
+	close(sink.bufFull)
+	<-sink.done
+
+	// flush buffered writer and close the file
+	if err := sink.w.Flush(); err != nil {
+		sink.setError(ErrorCreate(ErrFile, "Capture '%s': could not write "+
+			"file", sink.filename))
+	}
+	if err := sink.file.Close(); err != nil {
+		sink.setError(ErrorCreate(ErrFile, "Capture '%s': could not close "+
+			"file", sink.filename))
+	}
+
+	if sink.nBackPressure > 0 {
+		Log(LOG_WARN, "Capture '%s': disk could not keep up %d time(s)",
+			sink.filename, sink.nBackPressure)
+	}
+
+	Log(LOG_DEBUG, "Capture '%s': streamed %d bytes to file", sink.filename,
+		sink.nBytes)
+
+	return sink.getError()
+}
+
+// writer writes the buffers handed over for writing to the file. It must be
+// started in a goroutine and returns when the channel is closed.
+func (sink *captureSink) writer() {
+	defer close(sink.done)
+
+	for buf := range sink.bufFull {
+		// stop writing after an error occured, but keep on recycling the
+		// buffers
+		if sink.getError() == nil {
+			var err error
+			if sink.format == CaptureFileFormatPcap {
+				err = sink.writePcap(buf)
+			} else {
+				_, err = sink.w.Write(buf)
+			}
+			if err != nil {
+				sink.setError(ErrorCreate(ErrFile, "Capture '%s': could not "+
+					"write file", sink.filename))
+			}
+		}
+
+		// return buffer
+		sink.bufFree <- buf[:0]
+	}
+}
+
+// writePcap converts capture data to pcap packet records and writes them to
+// the file. Capture records may extend across buffer boundaries, so the
+// incomplete record at the end of the data is stored until the next buffer
+// is written.
+func (sink *captureSink) writePcap(data []byte) error {
+	if len(sink.pending) > 0 {
+		// complete record from previous buffer. it extends by at most the
+		// maximum record size into the current buffer
+		n := len(data)
+		if n > captureSinkRecordSizeMax {
+			n = captureSinkRecordSizeMax
+		}
+		buf := append(sink.pending, data[0:n]...)
+
+		pkt, size, valid := captureRecordParse(buf, sink.caplen,
+			sink.tickPeriodLatency, sink.freqClock)
+		if size == 0 {
+			// still incomplete
+			sink.pending = buf
+			return nil
+		}
+		if valid {
+			if err := sink.writePcapPacket(pkt); err != nil {
+				return err
+			}
+		}
+		data = data[size-len(sink.pending):]
+		sink.pending = sink.pending[:0]
+	}
+
+	for {
+		pkt, size, valid := captureRecordParse(data, sink.caplen,
+			sink.tickPeriodLatency, sink.freqClock)
+		if size == 0 {
+			break
+		}
+
+		// records not containing a packet are padding, skip them
+		if valid {
+			if err := sink.writePcapPacket(pkt); err != nil {
+				return err
+			}
+		}
+		data = data[size:]
+	}
+
+	// store incomplete record
+	sink.pending = append(sink.pending[:0], data...)
+
+	return nil
+}
+
+// writePcapPacket writes a single packet to the pcap file. Packet timestamps
+// are reconstructed by accumulating the arrival times, the arrival time of the
+// first packet is not meaningful.
+func (sink *captureSink) writePcapPacket(pkt CapturePacket) error {
+	if sink.nPkts > 0 {
+		sink.accArrivalTime += pkt.ArrivalTime
+	}
+	sink.nPkts++
+
+	ci := gopacket.CaptureInfo{
+		Timestamp:     sink.tStart.Add(time.Duration(sink.accArrivalTime * 1e9)),
+		CaptureLength: len(pkt.Data),
+		Length:        pkt.Wirelen - 4,
+	}
+	return sink.pcapWriter.WritePacket(ci, pkt.Data)
+}
+
+// setError records an error that occured while writing the file. Only the
+// first error is recorded.
+func (sink *captureSink) setError(err error) {
+	sink.errMutex.Lock()
+	defer sink.errMutex.Unlock()
+
+	if sink.err == nil {
+		sink.err = err
+	}
+}
+
+// getError returns an error that occured while writing the file.
+func (sink *captureSink) getError() error {
+	sink.errMutex.Lock()
+	defer sink.errMutex.Unlock()
+
+	return sink.err
+}
@@ -0,0 +1,186 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests streaming capture data to files.
+
+package gofluent10g
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testCaptureToFile replays a trace with varying packet lengths and streams
+// the looped back packets to a file in the specified format.
+func testCaptureToFile(t *testing.T, nPkts int, format int) (string, func(i int) (int, int)) {
+	filename := filepath.Join(t.TempDir(), "capture")
+	pktlen := func(i int) (int, int) {
+		wirelen := 60 + (i*37)%1455
+		return wirelen, wirelen
+	}
+	trace := testTraceCreate(t, nPkts, 1, pktlen, nil)
+
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCaptureToFile(128, filename, format); err != nil {
+		t.Fatal(err)
+	}
+	testReplayCheck(t, nt, trace)
+
+	// captured data is not held in host memory
+	capture, _ := recv.GetCapture()
+	if len(capture.GetPackets()) != 0 {
+		t.Fatal("captured packets are held in host memory")
+	}
+	if capture.GetSize() == 0 {
+		t.Fatal("no capture data streamed to file")
+	}
+
+	return filename, pktlen
+}
+
+// TestCaptureToFileRaw streams capture data to a raw file and parses the
+// capture records of the file.
+func TestCaptureToFileRaw(t *testing.T) {
+	nPkts := 1000
+	filename, pktlen := testCaptureToFile(t, nPkts, CaptureFileFormatRaw)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for len(data) > 0 {
+		pkt, size, valid := captureRecordParse(data, 128, 0, FREQ_SFP)
+		if size == 0 || valid == false {
+			break
+		}
+		wirelen, caplen := pktlen(n)
+		testCheckPacket(t, pkt, n, wirelen, caplen, 128)
+		data = data[size:]
+		n++
+	}
+	if n != nPkts {
+		t.Fatalf("file contains %d packets, expected %d", n, nPkts)
+	}
+}
+
+// TestCaptureToFilePcap streams capture data to a pcap file and reads it with
+// a standard pcap reader.
+func TestCaptureToFilePcap(t *testing.T) {
+	nPkts := 1000
+	filename, pktlen := testCaptureToFile(t, nPkts, CaptureFileFormatPcap)
+
+	cis, data := testPcapRead(t, filename)
+	if len(cis) != nPkts {
+		t.Fatalf("file contains %d packets, expected %d", len(cis), nPkts)
+	}
+
+	for i := range cis {
+		wirelen, caplen := pktlen(i)
+		testCheckPacket(t, CapturePacket{
+			Wirelen: cis[i].Length + 4,
+			Data:    data[i],
+		}, i, wirelen, caplen, 128)
+
+		// timestamps are reconstructed from the arrival times
+		if i > 0 && cis[i].Timestamp.Before(cis[i-1].Timestamp) {
+			t.Fatalf("packet %d: timestamp before previous packet", i)
+		}
+	}
+}
+
+// TestCaptureSinkBackPressure blocks the writing of capture data to disk and
+// checks that no buffer is handed out until the disk catches up.
+func TestCaptureSinkBackPressure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "capture.raw")
+	sink, err := captureSinkOpen(filename, CaptureFileFormatRaw, 1518, 0, FREQ_SFP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data is written to a pipe, which is not read until later
+	r, w := io.Pipe()
+	sink.w = bufio.NewWriter(w)
+
+	// fill both buffers. the first one is being written, the second one is
+	// waiting to be written
+	var dataExp []byte
+	for i := 0; i < captureSinkNBufs; i++ {
+		buf, err := sink.getWriteSlice(captureSinkBufSize, false)
+		if err != nil || buf == nil {
+			t.Fatalf("buffer %d not available (error: %v)", i, err)
+		}
+		copy(buf, testPacketData(i, len(buf)))
+		dataExp = append(dataExp, buf...)
+	}
+
+	for i := 0; i < 2; i++ {
+		buf, err := sink.getWriteSlice(64, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf != nil {
+			t.Fatal("buffer available although disk cannot keep up")
+		}
+	}
+	if sink.nBackPressure != 1 {
+		t.Fatalf("back-pressure counted %d times, expected 1",
+			sink.nBackPressure)
+	}
+
+	// disk catches up
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+
+	buf, err := sink.getWriteSlice(64, true)
+	if err != nil || buf == nil {
+		t.Fatalf("buffer not available (error: %v)", err)
+	}
+	copy(buf, testPacketData(42, 64))
+	dataExp = append(dataExp, buf...)
+
+	if err := sink.close(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if bytes.Equal(<-done, dataExp) == false {
+		t.Fatal("written data does not match")
+	}
+	if sink.getSize() != uint64(len(dataExp)) {
+		t.Fatalf("size %d, expected %d", sink.getSize(), len(dataExp))
+	}
+}
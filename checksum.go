@@ -0,0 +1,191 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Decoding of packet headers and calculation of UDP and TCP checksums. Used
+// when packet data of a trace is modified (e.g. by sequence tags or by the
+// payload patterns of the utils package), so that the checksums of the
+// modified packets remain valid.
+
+package gofluent10g
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// packetHdr is a header located in the packet data.
+type packetHdr struct {
+	typ    gopacket.LayerType
+	offset int
+	len    int
+}
+
+// PacketHeadersLen returns the length of the Ethernet, VLAN, MPLS, IP, UDP,
+// TCP, VXLAN and GRE headers at the beginning of the packet data, i.e. the
+// byte position at which the payload of the packet starts. It returns false
+// if the packet data ends within a header.
+func PacketHeadersLen(data []byte) (int, bool) {
+	hdrs, complete := packetHdrsDecode(data)
+	if len(hdrs) == 0 {
+		return 0, complete
+	}
+	return hdrs[len(hdrs)-1].offset + hdrs[len(hdrs)-1].len, complete
+}
+
+// PacketChecksumsSet recalculates the checksums of the UDP and TCP headers of
+// the packet data (UDP checksums are only recalculated if they are not zero).
+// The packet data may be shorter than the packet on the wire, the remaining
+// bytes are assumed to be zero (as appended by the hardware).
+func PacketChecksumsSet(data []byte) {
+	hdrs, _ := packetHdrsDecode(data)
+
+	// inner headers first, since the checksums of outer headers (e.g. VXLAN)
+	// cover the inner headers
+	for j := len(hdrs) - 1; j >= 0; j-- {
+		if hdrs[j].typ == layers.LayerTypeUDP ||
+			hdrs[j].typ == layers.LayerTypeTCP {
+			packetL4ChecksumSet(data, hdrs[:j+1])
+		}
+	}
+}
+
+// packetHdrsDecode decodes the packet data and returns the headers preceding
+// the payload. It returns false if a header could not be decoded, i.e. if the
+// data ends within a header.
+func packetHdrsDecode(data []byte) ([]packetHdr, bool) {
+	var hdrs []packetHdr
+
+	pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet,
+		gopacket.NoCopy)
+
+	offset := 0
+	for _, layer := range pkt.Layers() {
+		switch layer.LayerType() {
+		case layers.LayerTypeEthernet, layers.LayerTypeDot1Q,
+			layers.LayerTypeMPLS, layers.LayerTypeIPv4, layers.LayerTypeIPv6,
+			layers.LayerTypeUDP, layers.LayerTypeTCP, layers.LayerTypeVXLAN,
+			layers.LayerTypeGRE:
+		case gopacket.LayerTypeDecodeFailure:
+			// header is truncated
+			return hdrs, false
+		default:
+			// all following data is payload
+			return hdrs, true
+		}
+
+		n := len(layer.LayerContents())
+		hdrs = append(hdrs, packetHdr{
+			typ:    layer.LayerType(),
+			offset: offset,
+			len:    n,
+		})
+		offset += n
+	}
+
+	return hdrs, true
+}
+
+// packetL4ChecksumSet recalculates the checksum of the last (UDP or TCP)
+// header in hdrs. The ip header preceding the layer 4 header is used for the
+// calculation of the pseudo header checksum. Packet data beyond the capture
+// length is zero.
+func packetL4ChecksumSet(data []byte, hdrs []packetHdr) {
+	hdrL4 := hdrs[len(hdrs)-1]
+
+	// find the ip header
+	var hdrIp *packetHdr
+	for j := len(hdrs) - 2; j >= 0; j-- {
+		if hdrs[j].typ == layers.LayerTypeIPv4 ||
+			hdrs[j].typ == layers.LayerTypeIPv6 {
+			hdrIp = &hdrs[j]
+			break
+		}
+	}
+	if hdrIp == nil {
+		return
+	}
+
+	// position of the checksum field
+	posChecksum := hdrL4.offset + 6
+	proto := layers.IPProtocolUDP
+	if hdrL4.typ == layers.LayerTypeTCP {
+		posChecksum = hdrL4.offset + 16
+		proto = layers.IPProtocolTCP
+	} else if binary.BigEndian.Uint16(data[posChecksum:]) == 0 {
+		// udp checksum not used
+		return
+	}
+
+	// calculate the pseudo header checksum and the length of the layer 4
+	// segment
+	ip := data[hdrIp.offset:]
+	var sum uint32
+	var segLen int
+	if hdrIp.typ == layers.LayerTypeIPv4 {
+		segLen = int(binary.BigEndian.Uint16(ip[2:4])) - hdrIp.len
+		sum += checksumAdd(ip[12:20])
+	} else {
+		segLen = int(binary.BigEndian.Uint16(ip[4:6]))
+		sum += checksumAdd(ip[8:40])
+	}
+	if segLen < hdrL4.len {
+		// ip length field does not cover the layer 4 header
+		return
+	}
+	sum += uint32(proto) + uint32(segLen)
+
+	// calculate checksum over the layer 4 segment
+	binary.BigEndian.PutUint16(data[posChecksum:], 0)
+	end := hdrL4.offset + segLen
+	if end > len(data) {
+		end = len(data)
+	}
+	sum += checksumAdd(data[hdrL4.offset:end])
+
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	checksum := ^uint16(sum)
+	if checksum == 0 && proto == layers.IPProtocolUDP {
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(data[posChecksum:], checksum)
+}
+
+// checksumAdd returns the sum of the 16 bit words of the data. If the data
+// length is odd, the data is padded with a zero byte.
+func checksumAdd(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
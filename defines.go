@@ -29,10 +29,15 @@
 
 package gofluent10g
 
+import "time"
+
 const (
 	// SFP+ clock domain frequency
 	FREQ_SFP = 156.25e6
 
+	// line rate of the network interfaces (bits per second)
+	LINE_RATE = 10e9
+
 	// PCIExpress Base Address Register IDs
 	PCIE_BAR_FUNCTION_ID = 0x0
 	PCIE_BAR_VENDOR_ID   = 0x10ee
@@ -62,14 +67,36 @@ const (
 	// determined the median latency induced by the network tester to be 64
 	// clock cycles
 	LATENCY_ERR_CORRECTION_CYCLES = 64
-)
 
-// PCIExpress device names
-var (
-	PCIE_XDMA_DEV_H2C = []string{"/dev/xdma0_h2c_0"}
-	PCIE_XDMA_DEV_C2H = []string{"/dev/xdma0_c2h_0"}
+	// maximum length of a packet on the wire (excluding FCS) supported by the
+	// hardware. the capture cores record the wire length in an 11 bit field
+	PKTLEN_WIRE_MAX = 0x7FF
+
+	// interval in which hardware status registers are polled while waiting
+	// for the replay and capture cores
+	STATUS_POLL_INTERVAL = 10 * time.Millisecond
+
+	// maximum time to wait for the TX ring buffers and Block RAM FIFOs to be
+	// filled before the rate control modules are started
+	REPLAY_FILL_TIMEOUT = 500 * time.Millisecond
+
+	// time the TX packet counters must not advance before the Block RAM
+	// FIFOs are considered drained, if the packet count of a trace is unknown
+	REPLAY_DRAIN_IDLE_TIMEOUT = time.Second
+
+	// maximum time to wait for the capture cores to become inactive
+	CAPTURE_STOP_TIMEOUT = time.Second
 )
 
+// PCIExpress XDMA device name prefix. The driver creates one device per DMA
+// channel, named <prefix>_h2c_<n> (host to card) and <prefix>_c2h_<n> (card to
+// host)
+const PCIE_XDMA_DEV_PREFIX = "/dev/xdma0"
+
+// sysfs directory listing the PCI Express devices. Used to enumerate the
+// network tester boards installed in the host
+const PCIE_SYSFS_DEVICES = "/sys/bus/pci/devices"
+
 // DRAM memory addresses and ranges
 const (
 	ADDR_DDR_A       = uint64(0x000000000)
@@ -29,32 +29,57 @@
 
 package dut
 
+import "github.com/aoeldemann/gofluent10g"
+
 // DevicesUnderTest is a slice type holding DeviceUnderTest structs. The.
 // receiver functions defined on it allow easy control of multiple DuTs at once.
 type DevicesUnderTest []DeviceUnderTest
 
-// Disconnect closes the connection with the DuTs.
-func (duts *DevicesUnderTest) Disconnect() {
-	for _, dut := range *duts {
-		dut.Disconnect()
+// Disconnect closes the connection with all DuTs. The connections to the
+// remaining DuTs are closed even if closing one of them fails. It returns the
+// first error that occured.
+func (duts *DevicesUnderTest) Disconnect() (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	var errFirst error
+	for i := range *duts {
+		if err := (*duts)[i].disconnect(); err != nil && errFirst == nil {
+			errFirst = err
+		}
 	}
+	return errFirst
 }
 
 // TriggerEvent triggers a remote DuT event on all DuTs. The function expects
 // the event type name and a JSON argument struct. The parameter blocking
 // determines whether the function call should block until the DuTs acknowledged
-// the event triggers.
+// the event triggers. The event is triggered on all DuTs, even if triggering it
+// on one of them fails. It returns the first error that occured.
 func (duts *DevicesUnderTest) TriggerEvent(evtType string, args interface{},
-	blocking bool) {
-	for _, dut := range *duts {
-		dut.TriggerEvent(evtType, args, blocking)
+	blocking bool) (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	var errFirst error
+	for i := range *duts {
+		_, err := (*duts)[i].triggerEvent(evtType, args, blocking)
+		if err != nil && errFirst == nil {
+			errFirst = err
+		}
 	}
+	return errFirst
 }
 
 // WaitAllEventsCompleted waits for all outstanding non-blocking event triggers
-// on all DuTs to complete.
-func (duts *DevicesUnderTest) WaitAllEventsCompleted() {
-	for _, dut := range *duts {
-		dut.WaitEventCompleted()
+// on all DuTs to complete. It waits for all DuTs, even if waiting for one of
+// them fails. It returns the first error that occured.
+func (duts *DevicesUnderTest) WaitAllEventsCompleted() (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	var errFirst error
+	for i := range *duts {
+		if _, err := (*duts)[i].recvRespMsg(); err != nil && errFirst == nil {
+			errFirst = err
+		}
 	}
+	return errFirst
 }
@@ -0,0 +1,68 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the control of multiple DuTs at once.
+
+package dut
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aoeldemann/gofluent10g"
+)
+
+// TestDevicesUnderTestErrors checks that the functions controlling multiple
+// DuTs process all DuTs and return the error of the first failing one.
+func TestDevicesUnderTestErrors(t *testing.T) {
+	duts := DevicesUnderTest{
+		DeviceUnderTestCreate("dut0", "localhost", 5555),
+		DeviceUnderTestCreate("dut1", "localhost", 5556),
+	}
+
+	// DuTs are not connected
+	errs := []error{
+		duts.TriggerEvent("test", nil, false),
+		duts.WaitAllEventsCompleted(),
+	}
+	for i, err := range errs {
+		if errors.Is(err, ErrConnection) == false {
+			t.Fatalf("call %d: expected connection error, got: %v", i, err)
+		}
+		var errDuT *gofluent10g.Error
+		if errors.As(err, &errDuT) == false ||
+			strings.Contains(errDuT.Msg, "dut0") == false {
+			t.Fatalf("call %d: expected error of first DuT, got: %v", i, err)
+		}
+	}
+
+	// disconnecting unconnected DuTs does nothing
+	if err := duts.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+}
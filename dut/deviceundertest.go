@@ -35,12 +35,25 @@ package dut
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/aoeldemann/gofluent10g"
 	zmq "github.com/pebbe/zmq4"
 )
 
+// error kinds
+var (
+	// connection with the DuT could not be established or is not active
+	ErrConnection = errors.New("dut connection failed")
+
+	// message could not be exchanged with the DuT
+	ErrMessage = errors.New("dut message exchange failed")
+
+	// DuT responded with a NACK
+	ErrNack = errors.New("dut reported an error")
+)
+
 // DeviceUnderTest is a struct providing methods for interaction with the
 // Device-under-Test.
 type DeviceUnderTest struct {
@@ -66,20 +79,23 @@ func DeviceUnderTestCreate(name, hostname string, port uint16) DeviceUnderTest {
 }
 
 // Connect establishes the connection with the DuT.
-func (dut *DeviceUnderTest) Connect() {
+func (dut *DeviceUnderTest) Connect() (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
 	// create zmq socket
 	var sock *zmq.Socket
-	sock, err := zmq.NewSocket(zmq.REQ)
+	sock, err = zmq.NewSocket(zmq.REQ)
 	if err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return gofluent10g.ErrorCreate(ErrConnection,
 			"DuT '%s': could not create socket", dut.Name)
 	}
 
 	// connect to device endpoint
 	err = sock.Connect(fmt.Sprintf("tcp://%s:%d", dut.hostname, dut.port))
 	if err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR, "DuT '%s': could not connect",
-			dut.Name)
+		sock.Close()
+		return gofluent10g.ErrorCreate(ErrConnection,
+			"DuT '%s': could not connect", dut.Name)
 	}
 
 	// save socket
@@ -88,10 +104,20 @@ func (dut *DeviceUnderTest) Connect() {
 	gofluent10g.Log(gofluent10g.LOG_DEBUG,
 		"DuT '%s': socket connected (tcp://%s:%d)", dut.Name, dut.hostname,
 		dut.port)
+
+	return nil
 }
 
 // Disconnect closes the connection with the DuT.
-func (dut *DeviceUnderTest) Disconnect() {
+func (dut *DeviceUnderTest) Disconnect() (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return dut.disconnect()
+}
+
+// disconnect closes the connection with the DuT. Unlike Disconnect(), it does
+// not apply the exit-on-error setting.
+func (dut *DeviceUnderTest) disconnect() error {
 	// only disconnect if connection established
 	if dut.sock != nil {
 		// disconnect
@@ -99,7 +125,7 @@ func (dut *DeviceUnderTest) Disconnect() {
 			fmt.Sprintf("tcp://%s:%d", dut.hostname, dut.port))
 
 		if err != nil {
-			gofluent10g.Log(gofluent10g.LOG_ERR,
+			return gofluent10g.ErrorCreate(ErrConnection,
 				"DuT '%s': could not disconnect", dut.Name)
 		}
 
@@ -109,6 +135,8 @@ func (dut *DeviceUnderTest) Disconnect() {
 		gofluent10g.Log(gofluent10g.LOG_DEBUG, "DuT '%s': disconnected",
 			dut.Name)
 	}
+
+	return nil
 }
 
 // TriggerEvent triggers a remote DuT event. The function expects the event
@@ -118,7 +146,16 @@ func (dut *DeviceUnderTest) Disconnect() {
 // optionally be provided by the DuT. For non-blocking calls, the function
 // always return nil.
 func (dut *DeviceUnderTest) TriggerEvent(evtName string, args interface{},
-	blocking bool) interface{} {
+	blocking bool) (_ interface{}, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return dut.triggerEvent(evtName, args, blocking)
+}
+
+// triggerEvent triggers a remote DuT event. Unlike TriggerEvent(), it does not
+// apply the exit-on-error setting.
+func (dut *DeviceUnderTest) triggerEvent(evtName string, args interface{},
+	blocking bool) (interface{}, error) {
 	gofluent10g.Log(gofluent10g.LOG_DEBUG,
 		"DuT '%s': triggering '%s' event ...", dut.Name, evtName)
 
@@ -134,14 +171,20 @@ func (dut *DeviceUnderTest) TriggerEvent(evtName string, args interface{},
 	msg.Args = args
 
 	// send message
-	dut.sendMsg(msg)
+	if err := dut.sendMsg(msg); err != nil {
+		return nil, err
+	}
 
 	// initialize return data
 	var returnData interface{}
 
 	if blocking {
 		// wait for DuT response
-		returnData = dut.recvRespMsg()
+		var err error
+		returnData, err = dut.recvRespMsg()
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		// non-blocking call, so we are not waiting for return data
 		returnData = nil
@@ -150,19 +193,24 @@ func (dut *DeviceUnderTest) TriggerEvent(evtName string, args interface{},
 	gofluent10g.Log(gofluent10g.LOG_DEBUG,
 		"DuT '%s': sucessfully triggered '%s' event", dut.Name, evtName)
 
-	return returnData
+	return returnData, nil
 }
 
 // WaitEventCompleted waits until outstanding non-blocking event triggers
 // are completed.
-func (dut *DeviceUnderTest) WaitEventCompleted() {
+func (dut *DeviceUnderTest) WaitEventCompleted() (err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
 	// wait for DuT response
-	dut.recvRespMsg()
+	_, err = dut.recvRespMsg()
+	return err
 }
 
 // GetMonitorData fetches and returns monitoring data from the DuT. The
 // function expects the identifier of the data that shall be fetched.
-func (dut *DeviceUnderTest) GetMonitorData(ident string) interface{} {
+func (dut *DeviceUnderTest) GetMonitorData(ident string) (_ interface{}, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
 	// set up event arguments
 	args := struct {
 		Ident string `json:"ident"`
@@ -172,45 +220,47 @@ func (dut *DeviceUnderTest) GetMonitorData(ident string) interface{} {
 
 	// trigger the blocking execution of the 'get_monitor_data' event. the
 	// event's return data contains the requested data.
-	return dut.TriggerEvent("get_monitor_data", args, true)
+	return dut.triggerEvent("get_monitor_data", args, true)
 }
 
 // sendMsg transmits an event message to the DuT.
-func (dut *DeviceUnderTest) sendMsg(msg interface{}) {
+func (dut *DeviceUnderTest) sendMsg(msg interface{}) error {
 	// make sure connection is active
 	if dut.sock == nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return gofluent10g.ErrorCreate(ErrConnection,
 			"DUT '%s': no connection active", dut.Name)
 	}
 
 	// marshal json message
 	data, err := json.Marshal(msg)
 	if err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return gofluent10g.ErrorCreate(ErrMessage,
 			"DuT '%s': failed to encode json message", dut.Name)
 	}
 
 	// send message to dut
 	if _, err := dut.sock.SendBytes(data, 0); err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return gofluent10g.ErrorCreate(ErrMessage,
 			"DuT '%s': failed to send message to DuT", dut.Name)
 	}
+
+	return nil
 }
 
 // recvRespMsg receives a response message (ACK/NACK) from the DuT. If the DuT
-// answers with a NACK, the function raises an error containing the error
+// answers with a NACK, the function returns an error containing the error
 // message that the DuT sent.
-func (dut *DeviceUnderTest) recvRespMsg() interface{} {
+func (dut *DeviceUnderTest) recvRespMsg() (interface{}, error) {
 	// make sure connection is active
 	if dut.sock == nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return nil, gofluent10g.ErrorCreate(ErrConnection,
 			"DUT '%s': no connection active", dut.Name)
 	}
 
 	// wait for response from dut
 	data, err := dut.sock.RecvBytes(0)
 	if err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return nil, gofluent10g.ErrorCreate(ErrMessage,
 			"DuT '%s': failed to received response message", dut.Name)
 	}
 
@@ -233,12 +283,10 @@ func (dut *DeviceUnderTest) recvRespMsg() interface{} {
 		var respMsgNack dutMsgNack
 		json.Unmarshal(data, &respMsgNack)
 
-		// raise error reported by the dut
-		gofluent10g.Log(gofluent10g.LOG_ERR, "DuT '%s': DuT reported: '%s'",
-			dut.Name, respMsgNack.Args.Reason)
-
-		// no return data
-		return nil
+		// return error reported by the dut
+		return nil, gofluent10g.ErrorCreate(ErrNack,
+			"DuT '%s': DuT reported: '%s'", dut.Name,
+			respMsgNack.Args.Reason)
 	} else if respMsg.EvtName == "ack" {
 		// message is a ack. In some cases, return data may be provided.
 		// convert message and extract it from JSON data
@@ -252,10 +300,9 @@ func (dut *DeviceUnderTest) recvRespMsg() interface{} {
 		// unmarshal json message
 		var respMsgAck dutMsgAck
 		json.Unmarshal(data, &respMsgAck)
-		return respMsgAck.Args.ReturnData
+		return respMsgAck.Args.ReturnData, nil
 	} else {
-		gofluent10g.Log(gofluent10g.LOG_ERR,
+		return nil, gofluent10g.ErrorCreate(ErrMessage,
 			"DuT '%s': received message with invalid event name", dut.Name)
-		return nil
 	}
 }
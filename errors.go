@@ -0,0 +1,119 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Error handling. Functions of the library return errors of type *Error. Each
+// error has a kind (e.g. ErrInvalidConfig), which allows the application to
+// decide how to handle the error using errors.Is(). For applications that
+// relied on the library to exit on errors, the old behavior can be restored by
+// calling SetExitOnError(true). The setting is applied when an error is
+// returned to the application by an exported function (see ErrorReturn()), so
+// errors that are handled within the library never cause the application to
+// exit.
+
+package gofluent10g
+
+import (
+	"errors"
+	"fmt"
+)
+
+// error kinds
+var (
+	// invalid configuration or function parameter
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// hardware device could not be accessed
+	ErrHardwareAccess = errors.New("hardware access failed")
+
+	// hardware does not match the software (e.g. version mismatch)
+	ErrHardwareMismatch = errors.New("hardware mismatch")
+
+	// hardware core is in an unexpected state
+	ErrHardwareState = errors.New("unexpected hardware state")
+
+	// DMA transfer between host and FPGA board failed
+	ErrDMA = errors.New("dma transfer failed")
+
+	// ring buffer or FIFO overflow, captured data was lost
+	ErrRingBuffOverflow = errors.New("ring buffer overflow")
+
+	// rate control could not enforce the inter-packet times of the trace
+	ErrReplayTiming = errors.New("replay timing error")
+
+	// file could not be read or written
+	ErrFile = errors.New("file access failed")
+)
+
+// if true, the application exits when an exported function returns an error
+var exitOnError bool
+
+// Error is the error type returned by the functions of the library.
+type Error struct {
+	Kind error  // error kind, e.g. ErrInvalidConfig
+	Msg  string // error message
+}
+
+// Error returns the error message.
+func (err *Error) Error() string {
+	return err.Msg
+}
+
+// Unwrap returns the error kind, so that errors.Is(err, ErrInvalidConfig)
+// can be used to check the kind of an error.
+func (err *Error) Unwrap() error {
+	return err.Kind
+}
+
+// ErrorCreate creates a new error of the specified kind. The error message is
+// formatted according to the format specifier.
+func ErrorCreate(kind error, msg string, a ...interface{}) error {
+	return &Error{
+		Kind: kind,
+		Msg:  fmt.Sprintf(msg, a...),
+	}
+}
+
+// ErrorReturn applies the exit-on-error setting (see SetExitOnError) to the
+// error an exported function returns to the application. Exported functions
+// defer it with a pointer on their error result. If the application exits on
+// errors and the error is not nil, the error message is printed and the
+// application exits. Functions within the library that handle the errors of
+// other functions must call their unexported counterparts, which do not defer
+// ErrorReturn.
+func ErrorReturn(err *error) {
+	if exitOnError && *err != nil {
+		Log(LOG_ERR, "%s", (*err).Error())
+	}
+}
+
+// SetExitOnError determines whether the application exits as soon as a
+// function of the library returns an error (the behavior of previous versions
+// of the library) or whether the error is returned to the application. By
+// default, errors are returned.
+func SetExitOnError(exit bool) {
+	exitOnError = exit
+}
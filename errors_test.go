@@ -0,0 +1,161 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests that the library returns typed errors instead of exiting the
+// application.
+
+package gofluent10g
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// testBackendIdent is a Backend wrapping a simulator, whose identification
+// register reports an unknown hardware version.
+type testBackendIdent struct {
+	*Simulator
+}
+
+// testBARIdent wraps the BAR of the simulator.
+type testBARIdent struct {
+	BAR
+}
+
+// GetBAR returns the wrapped BAR.
+func (backend *testBackendIdent) GetBAR() BAR {
+	return &testBARIdent{backend.Simulator.GetBAR()}
+}
+
+// Read returns an invalid hardware version for the identification register.
+func (bar *testBARIdent) Read(addr uint32) uint32 {
+	if addr == ADDR_BASE_NT_IDENT+CPUREG_OFFSET_NT_IDENT_IDENT {
+		return 0x0
+	}
+	return bar.BAR.Read(addr)
+}
+
+// TestErrorKinds checks that invalid parameters result in errors of the
+// expected kind.
+func TestErrorKinds(t *testing.T) {
+	nt, sim := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+
+	_, errGen := nt.GetGenerator(-1)
+	_, errRecv := nt.GetReceiver(N_INTERFACES)
+	_, errTraceData := TraceCreateFromData(make([]byte, 63), 1, 0, 1)
+	_, errTraceFile := TraceCreateFromFile(filepath.Join(t.TempDir(),
+		"missing.bin"), 1)
+
+	errs := []struct {
+		err  error
+		kind error
+	}{
+		{errGen, ErrInvalidConfig},
+		{errRecv, ErrInvalidConfig},
+		{recv.EnableCapture(2000, 0), ErrInvalidConfig},
+		{sim.SetLoopback(N_INTERFACES, 0), ErrInvalidConfig},
+		{nt.SetTimestampMode(42), ErrInvalidConfig},
+		{errTraceData, ErrInvalidConfig},
+		{errTraceFile, ErrFile},
+	}
+	for i, e := range errs {
+		if errors.Is(e.err, e.kind) == false {
+			t.Fatalf("error %d: expected '%v', got: %v", i, e.kind, e.err)
+		}
+
+		var err *Error
+		if errors.As(e.err, &err) == false || err.Msg == "" {
+			t.Fatalf("error %d: not an *Error with message", i)
+		}
+	}
+}
+
+// TestErrorHardwareMismatch checks that a hardware version mismatch is
+// reported to the application.
+func TestErrorHardwareMismatch(t *testing.T) {
+	_, err := NetworkTesterCreateWithBackend(
+		&testBackendIdent{SimulatorCreate()})
+	if errors.Is(err, ErrHardwareMismatch) == false {
+		t.Fatalf("expected hardware mismatch error, got: %v", err)
+	}
+}
+
+// TestErrorReplayTiming replays a trace whose inter-packet times exceed the
+// line rate and checks that the replay timing error is returned.
+func TestErrorReplayTiming(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	trace := testTraceCreate(t, 100, 1, func(i int) (int, int) {
+		return 1514, 64
+	}, func(i int, wirelen int) int {
+		return 1
+	})
+
+	errReplay, errCapture := testReplay(t, nt, trace)
+	if errors.Is(errReplay, ErrReplayTiming) == false {
+		t.Fatalf("expected replay timing error, got: %v", errReplay)
+	}
+	if errCapture != nil {
+		t.Fatal(errCapture)
+	}
+
+	// the error is reported again by CheckErrors()
+	if err := nt.CheckErrors(); errors.Is(err, ErrReplayTiming) == false {
+		t.Fatalf("expected replay timing error, got: %v", err)
+	}
+}
+
+// TestErrorExitHandled enables exiting on errors and checks that errors, which
+// are handled within the library, do not cause the application to exit.
+func TestErrorExitHandled(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	SetExitOnError(true)
+	defer SetExitOnError(false)
+
+	// creating an error does not exit
+	err := ErrorCreate(ErrInvalidConfig, "test")
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid config error, got: %v", err)
+	}
+
+	// the unexported counterpart of StartCapture() returns the error, which
+	// e.g. an experiment handles by stopping the capture on other boards
+	if err := nt.captureStart(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid config error, got: %v", err)
+	}
+
+	// no error is returned, nothing to exit on
+	err = nil
+	ErrorReturn(&err)
+}
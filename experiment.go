@@ -0,0 +1,460 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Experiments spanning multiple network tester boards. An Experiment
+// coordinates the network testers of several boards (see BoardsEnumerate()):
+// configuration is written to all boards, capturing is started and stopped on
+// all boards and trace replay is prepared on all boards before the rate
+// control modules of all boards are started together. Network interfaces are
+// addressed by global port numbers, which number the interfaces of all boards
+// consecutively in the order in which the network testers were passed to
+// ExperimentCreate().
+
+package gofluent10g
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Experiment coordinates replay and capture across multiple network tester
+// boards.
+type Experiment struct {
+	nts []*NetworkTester
+
+	// global port number of the first network interface of each board
+	portBase []int
+	nPorts   int
+
+	// errors that occured during the last replay and capture on each board
+	errReplay, errCapture []error
+}
+
+// ExperimentBoardResult holds the results of an experiment on a single board.
+type ExperimentBoardResult struct {
+	Board    string // board profile name
+	PCIeAddr string // PCI Express address, empty if not opened by address
+	PortBase int    // global port number of the board's first interface
+
+	// errors that occured during replay and capture
+	ErrReplay, ErrCapture error
+
+	// number of packets transmitted and received per network interface
+	NPacketsTX []int
+	NPacketsRX []int
+
+	// DMA transfer statistics
+	DMAStats []DMAChannelStats
+}
+
+// ExperimentCreate creates a new experiment coordinating the provided network
+// testers. Each network tester must belong to a different board, i.e. network
+// testers must not be passed twice and must not share a PCI Express address.
+func ExperimentCreate(nts ...*NetworkTester) (_ *Experiment, err error) {
+	defer ErrorReturn(&err)
+
+	return experimentCreate(nts)
+}
+
+// experimentCreate creates a new experiment coordinating the provided network
+// testers. Unlike ExperimentCreate(), it does not apply the exit-on-error
+// setting.
+func experimentCreate(nts []*NetworkTester) (*Experiment, error) {
+	if len(nts) == 0 {
+		return nil, ErrorCreate(ErrInvalidConfig, "Experiment: no network "+
+			"testers")
+	}
+
+	exp := &Experiment{
+		nts:        nts,
+		portBase:   make([]int, len(nts)),
+		errReplay:  make([]error, len(nts)),
+		errCapture: make([]error, len(nts)),
+	}
+
+	for i, nt := range nts {
+		for j := 0; j < i; j++ {
+			if nts[j] == nt {
+				return nil, ErrorCreate(ErrInvalidConfig, "Experiment: "+
+					"network tester %d is identical to network tester %d",
+					i, j)
+			}
+			if nt.board.PCIeAddr != "" &&
+				nts[j].board.PCIeAddr == nt.board.PCIeAddr {
+				return nil, ErrorCreate(ErrInvalidConfig, "Experiment: "+
+					"network testers %d and %d access the same board %s",
+					j, i, nt.board.PCIeAddr)
+			}
+		}
+
+		exp.portBase[i] = exp.nPorts
+		exp.nPorts += nt.board.NInterfaces
+	}
+
+	return exp, nil
+}
+
+// ExperimentOpen opens the network testers of the boards with the specified
+// indices (see BoardsEnumerate()) and creates an experiment coordinating
+// them. If no indices are specified, all boards matching the board profile
+// are opened. Each index must only be specified once.
+func ExperimentOpen(board BoardProfile, indices ...int) (_ *Experiment, err error) {
+	defer ErrorReturn(&err)
+
+	boards, err := BoardsEnumerate(board)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(indices) == 0 {
+		for i := range boards {
+			indices = append(indices, i)
+		}
+	}
+
+	// make sure all indices are valid and each board is only opened once
+	for i, index := range indices {
+		if index < 0 || index >= len(boards) {
+			return nil, ErrorCreate(ErrHardwareAccess, "Board %s: invalid "+
+				"index %d, found %d board(s)", board.Name, index,
+				len(boards))
+		}
+		for _, indexPrev := range indices[0:i] {
+			if indexPrev == index {
+				return nil, ErrorCreate(ErrInvalidConfig, "Board %s: "+
+					"index %d specified more than once", board.Name, index)
+			}
+		}
+	}
+
+	var nts []*NetworkTester
+	closeAll := func() {
+		for _, nt := range nts {
+			nt.Close()
+		}
+	}
+
+	for _, index := range indices {
+		nt, err := networkTesterOpen(boards[index])
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		nts = append(nts, nt)
+	}
+
+	exp, err := experimentCreate(nts)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	return exp, nil
+}
+
+// Close closes the connections to the hardware of all boards.
+func (exp *Experiment) Close() {
+	for _, nt := range exp.nts {
+		nt.Close()
+	}
+}
+
+// GetNetworkTesters returns the network testers of all boards.
+func (exp *Experiment) GetNetworkTesters() []*NetworkTester {
+	return exp.nts
+}
+
+// GetPortCount returns the total number of network interfaces of all boards.
+func (exp *Experiment) GetPortCount() int {
+	return exp.nPorts
+}
+
+// GetGenerator returns a generator instance by its global port number.
+func (exp *Experiment) GetGenerator(port int) (_ *Generator, err error) {
+	defer ErrorReturn(&err)
+
+	nt, id, err := exp.getPort(port)
+	if err != nil {
+		return nil, err
+	}
+	return nt.GetGenerator(id)
+}
+
+// GetReceiver returns a receiver instance by its global port number.
+func (exp *Experiment) GetReceiver(port int) (_ *Receiver, err error) {
+	defer ErrorReturn(&err)
+
+	nt, id, err := exp.getPort(port)
+	if err != nil {
+		return nil, err
+	}
+	return nt.GetReceiver(id)
+}
+
+// GetInterface returns an interface instance by its global port number.
+func (exp *Experiment) GetInterface(port int) (_ *Interface, err error) {
+	defer ErrorReturn(&err)
+
+	nt, id, err := exp.getPort(port)
+	if err != nil {
+		return nil, err
+	}
+	return nt.GetInterface(id)
+}
+
+// WriteConfig writes the configuration to the hardware of all boards.
+func (exp *Experiment) WriteConfig() (err error) {
+	defer ErrorReturn(&err)
+
+	for i, nt := range exp.nts {
+		if err := nt.writeConfig(); err != nil {
+			return exp.boardError(i, err)
+		}
+	}
+	return nil
+}
+
+// StartCapture starts packet capturing on all boards. Capturing is started on
+// one board after the other, so the boards start capturing at slightly
+// different points in time (the time it takes to start capturing on a board
+// accumulates with each board). Packets arriving before capturing has been
+// started on a board are not captured, so capturing should be started before
+// the replay. If capturing cannot be started on one of the boards, it is
+// stopped on all boards on which it has already been started. An error is
+// returned if capturing is already running on one of the boards.
+func (exp *Experiment) StartCapture() (err error) {
+	defer ErrorReturn(&err)
+
+	for i, nt := range exp.nts {
+		if nt.isCaptureRunning() {
+			return ErrorCreate(ErrInvalidConfig, "Experiment: capture is "+
+				"already running on board %d", i)
+		}
+	}
+
+	for i := range exp.errCapture {
+		exp.errCapture[i] = nil
+	}
+
+	for i, nt := range exp.nts {
+		if err := nt.captureStart(); err != nil {
+			for j := 0; j < i; j++ {
+				exp.errCapture[j] = exp.nts[j].captureStop()
+			}
+			exp.errCapture[i] = err
+			return exp.boardError(i, err)
+		}
+	}
+
+	return nil
+}
+
+// StopCapture stops packet capturing on all boards on which it is running.
+// Capturing is stopped on all boards, even if an error occurs on one of them.
+// The first error is returned, the errors of all boards are part of the
+// results (see GetResults()). An error is returned if capturing is not running
+// on any board.
+func (exp *Experiment) StopCapture() (err error) {
+	defer ErrorReturn(&err)
+
+	var wg sync.WaitGroup
+	var nRunning int
+	for i, nt := range exp.nts {
+		if nt.isCaptureRunning() == false {
+			continue
+		}
+		nRunning++
+		wg.Add(1)
+		go func(i int, nt *NetworkTester) {
+			defer wg.Done()
+			exp.errCapture[i] = nt.captureStop()
+		}(i, nt)
+	}
+	wg.Wait()
+
+	if nRunning == 0 {
+		return ErrorCreate(ErrInvalidConfig, "Experiment: capture is not "+
+			"running (StartCapture() not called?)")
+	}
+
+	return exp.firstError(exp.errCapture)
+}
+
+// StartReplay triggers the start of packet generation on all boards, on which
+// at least one generator has been configured. The function blocks until
+// generation has finished on all boards.
+func (exp *Experiment) StartReplay() (err error) {
+	defer ErrorReturn(&err)
+
+	return exp.StartReplayContext(context.Background())
+}
+
+// StartReplayContext triggers the start of packet generation on all boards
+// like StartReplay. Replay is first prepared on all boards, i.e. their TX
+// ring buffers and transmission fifos are filled. Afterwards the rate control
+// modules of all boards are started together. If the replay fails on one of
+// the boards or if the context is cancelled, the replay is aborted on all
+// boards (see NetworkTester.StartReplayContext()). The first error is
+// returned, the errors of all boards are part of the results (see
+// GetResults()).
+func (exp *Experiment) StartReplayContext(ctx context.Context) (err error) {
+	defer ErrorReturn(&err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i := range exp.errReplay {
+		exp.errReplay[i] = nil
+	}
+
+	// boards on which replay is configured
+	var boards []int
+	for i, nt := range exp.nts {
+		if len(nt.gens.getIfIdsConfigured()) > 0 {
+			boards = append(boards, i)
+		}
+	}
+
+	// prepare replay on all boards
+	gens := make([]Generators, len(exp.nts))
+	replayDurations := make([]time.Duration, len(exp.nts))
+	err = exp.runReplay(boards, cancel, func(i int) error {
+		var err error
+		gens[i], replayDurations[i], err = exp.nts[i].replayPrepare(ctx)
+		return err
+	})
+
+	// abort replay on all boards on which it has been prepared successfully
+	// if it failed on any other board
+	if err != nil {
+		for _, i := range boards {
+			if exp.errReplay[i] == nil {
+				exp.errReplay[i] = exp.nts[i].abortReplay(context.Canceled)
+			}
+		}
+		return err
+	}
+
+	Log(LOG_DEBUG, "Experiment: replay prepared on %d board(s)",
+		len(boards))
+
+	// start the rate control modules of all boards and wait for replay to
+	// finish
+	return exp.runReplay(boards, cancel, func(i int) error {
+		return exp.nts[i].replayRun(ctx, gens[i], replayDurations[i])
+	})
+}
+
+// GetResults returns the results of the experiment on each board. It should
+// be called after replay and capture have finished.
+func (exp *Experiment) GetResults() []ExperimentBoardResult {
+	results := make([]ExperimentBoardResult, len(exp.nts))
+
+	for i, nt := range exp.nts {
+		result := ExperimentBoardResult{
+			Board:      nt.board.Name,
+			PCIeAddr:   nt.board.PCIeAddr,
+			PortBase:   exp.portBase[i],
+			ErrReplay:  exp.errReplay[i],
+			ErrCapture: exp.errCapture[i],
+			NPacketsTX: make([]int, nt.board.NInterfaces),
+			NPacketsRX: make([]int, nt.board.NInterfaces),
+			DMAStats:   nt.GetDMAStats(),
+		}
+
+		for j, iface := range nt.ifaces {
+			result.NPacketsTX[j] = iface.GetPacketCountTX()
+			result.NPacketsRX[j] = iface.GetPacketCountRX()
+		}
+
+		results[i] = result
+	}
+
+	return results
+}
+
+// getPort returns the network tester and the board-local interface ID of a
+// global port number.
+func (exp *Experiment) getPort(port int) (*NetworkTester, int, error) {
+	if port < 0 || port >= exp.nPorts {
+		return nil, 0, ErrorCreate(ErrInvalidConfig, "Experiment: invalid "+
+			"port: %d", port)
+	}
+
+	// find the last board whose first port is not larger than the port
+	i := len(exp.portBase) - 1
+	for exp.portBase[i] > port {
+		i--
+	}
+
+	return exp.nts[i], port - exp.portBase[i], nil
+}
+
+// runReplay concurrently calls the replay function fn for each of the
+// specified boards and waits for all calls to return. Errors are recorded as
+// replay errors of the boards. After the first error, cancel is called to
+// abort the calls on all other boards. The first error is returned, since the
+// errors of the other boards usually are a consequence of the cancellation.
+func (exp *Experiment) runReplay(boards []int, cancel context.CancelFunc, fn func(i int) error) error {
+	var wg sync.WaitGroup
+	var errFirst error
+	var errOnce sync.Once
+
+	for _, i := range boards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(i); err != nil {
+				exp.errReplay[i] = exp.boardError(i, err)
+				errOnce.Do(func() {
+					errFirst = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return errFirst
+}
+
+// firstError returns the first non-nil error of the boards.
+func (exp *Experiment) firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boardError logs an error that occured on a board and returns it.
+func (exp *Experiment) boardError(i int, err error) error {
+	Log(LOG_DEBUG, "Experiment: board %d: %s", i, err.Error())
+	return err
+}
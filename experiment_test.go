@@ -0,0 +1,252 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests experiments spanning multiple simulated network tester boards.
+
+package gofluent10g
+
+import (
+	"errors"
+	"testing"
+)
+
+// testExperimentCreate creates an experiment coordinating network testers
+// accessing n simulators.
+func testExperimentCreate(t *testing.T, n int) *Experiment {
+	var nts []*NetworkTester
+	for i := 0; i < n; i++ {
+		nt, _ := testNetworkTesterCreate(t)
+		nts = append(nts, nt)
+	}
+	exp, err := ExperimentCreate(nts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exp
+}
+
+// TestExperimentPorts checks the mapping of global port numbers to the network
+// interfaces of the boards.
+func TestExperimentPorts(t *testing.T) {
+	exp := testExperimentCreate(t, 2)
+	nts := exp.GetNetworkTesters()
+
+	if n := exp.GetPortCount(); n != 2*N_INTERFACES {
+		t.Fatalf("experiment has %d ports, expected %d", n, 2*N_INTERFACES)
+	}
+
+	for port := 0; port < exp.GetPortCount(); port++ {
+		gen, err := exp.GetGenerator(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		genExp, _ := nts[port/N_INTERFACES].GetGenerator(port % N_INTERFACES)
+		if gen != genExp {
+			t.Fatalf("port %d: wrong generator", port)
+		}
+
+		recv, err := exp.GetReceiver(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recvExp, _ := nts[port/N_INTERFACES].GetReceiver(port % N_INTERFACES)
+		if recv != recvExp {
+			t.Fatalf("port %d: wrong receiver", port)
+		}
+	}
+
+	for _, port := range []int{-1, 2 * N_INTERFACES} {
+		_, err := exp.GetInterface(port)
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("port %d: expected invalid configuration error, got: %v",
+				port, err)
+		}
+	}
+
+	if _, err := ExperimentCreate(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
+
+// TestExperimentDuplicateBoards checks that a board cannot be part of an
+// experiment more than once.
+func TestExperimentDuplicateBoards(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+	_, err := ExperimentCreate(nt, nt)
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	// two network testers accessing the board with the same PCI address
+	board := BoardNetFPGASUME
+	board.PCIeAddr = "0000:01:00.0"
+	var nts []*NetworkTester
+	for i := 0; i < 2; i++ {
+		nt, err := NetworkTesterCreateWithBackendAndBoard(SimulatorCreate(),
+			board)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nts = append(nts, nt)
+	}
+	_, err = ExperimentCreate(nts...)
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	// board indices are checked before any board is opened
+	dir := testSysfsCreate(t)
+	testSysfsDevice(t, dir, "0000:01:00.0", "0x10ee", "0x7032")
+	_, err = ExperimentOpen(BoardNetFPGASUME, 0, 0)
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	_, err = ExperimentOpen(BoardNetFPGASUME, 1)
+	if errors.Is(err, ErrHardwareAccess) == false {
+		t.Fatalf("expected hardware access error, got: %v", err)
+	}
+}
+
+// TestExperimentReplay replays traces on both boards of an experiment and
+// checks that the packets are captured on both boards and reported in the
+// results.
+func TestExperimentReplay(t *testing.T) {
+	exp := testExperimentCreate(t, 2)
+
+	nPkts := []int{100, 200}
+	trace := make([]*Trace, len(nPkts))
+	for i, n := range nPkts {
+		trace[i] = testTraceCreate(t, n, 1, func(i int) (int, int) {
+			return 60, 60
+		}, nil)
+	}
+
+	// first port of each board
+	ports := []int{0, N_INTERFACES}
+	for i, port := range ports {
+		gen, _ := exp.GetGenerator(port)
+		gen.SetTrace(trace[i])
+		recv, _ := exp.GetReceiver(port)
+		err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := exp.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StartCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StartReplay(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, port := range ports {
+		recv, _ := exp.GetReceiver(port)
+		if n := len(testCapturePackets(t, recv)); n != nPkts[i] {
+			t.Fatalf("port %d: captured %d packets, expected %d", port, n,
+				nPkts[i])
+		}
+	}
+
+	results := exp.GetResults()
+	if len(results) != 2 {
+		t.Fatalf("results for %d boards, expected 2", len(results))
+	}
+	for i, result := range results {
+		if result.ErrReplay != nil || result.ErrCapture != nil {
+			t.Fatalf("board %d: unexpected errors: %v, %v", i,
+				result.ErrReplay, result.ErrCapture)
+		}
+		if result.PortBase != ports[i] {
+			t.Fatalf("board %d: first port %d, expected %d", i,
+				result.PortBase, ports[i])
+		}
+		if result.NPacketsTX[0] != nPkts[i] ||
+			result.NPacketsRX[0] != nPkts[i] {
+			t.Fatalf("board %d: %d packets sent, %d received, expected %d",
+				i, result.NPacketsTX[0], result.NPacketsRX[0], nPkts[i])
+		}
+	}
+}
+
+// TestExperimentCaptureState checks that capturing is stopped on the boards
+// on which it has been started, if starting it fails on another board, and
+// that it can only be stopped while it is running.
+func TestExperimentCaptureState(t *testing.T) {
+	exp := testExperimentCreate(t, 2)
+	nts := exp.GetNetworkTesters()
+
+	for _, nt := range nts {
+		recv, _ := nt.GetReceiver(0)
+		err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the configuration is only written to the first board, so capturing
+	// cannot be started on the second board
+	if err := nts[0].WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StartCapture(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if nts[0].isCaptureRunning() || nts[1].isCaptureRunning() {
+		t.Fatal("capture is still running")
+	}
+	if err := exp.StopCapture(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	if err := exp.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StartCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StartCapture(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	// capturing is only stopped on the boards on which it is running
+	if err := nts[1].StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.StopCapture(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
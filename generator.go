@@ -47,10 +47,8 @@
 package gofluent10g
 
 import (
-	"fmt"
+	"sync/atomic"
 	"time"
-
-	"github.com/aoeldemann/gopcie"
 )
 
 // Generator is the struct providing methods for configurating the trace replay
@@ -63,13 +61,19 @@ type Generator struct {
 
 	trace *Trace // trace file assigned to this generator
 
-	// number of trace files that have been transferred to hardware
+	// number of trace bytes that have been transferred to hardware. Accessed
+	// atomically, since replay progress is reported concurrently
 	nBytesTransfered uint64
 
 	// ring buffer memory address, size and write pointer position
 	ringBuffAddr      uint64
 	ringBuffAddrRange uint32 // ring buffer must never be larger than 4 Gbyte
 	ringBuffWrPtr     uint32
+
+	// requested ring buffer size (zero if determined by weight) and weight
+	// (zero if default weight)
+	ringBuffSizeReq uint64
+	ringBuffWeight  float64
 }
 
 // SetTrace assigns a trace file to the generator for replay.
@@ -77,12 +81,75 @@ func (gen *Generator) SetTrace(trace *Trace) {
 	gen.trace = trace
 }
 
+// SetRingBuffSize sets the size of the generator's TX ring buffer in the DRAM
+// of the FPGA board. The size must be a multiple of 16384 bytes and larger
+// than RING_BUFF_WR_TRANSFER_SIZE_MAX. If the size is zero (default), the
+// ring buffer gets a share of the memory that is proportional to its weight
+// (see SetRingBuffWeight()). Memory is assigned when WriteConfig() is called.
+func (gen *Generator) SetRingBuffSize(size uint64) (err error) {
+	defer ErrorReturn(&err)
+
+	if size != 0 {
+		err := memoryRingBuffSizeCheck(
+			memoryName(MemoryRegionGenerator, gen.id), size,
+			RING_BUFF_WR_TRANSFER_SIZE_MAX)
+		if err != nil {
+			return err
+		}
+	}
+	gen.ringBuffSizeReq = size
+	return nil
+}
+
+// SetRingBuffWeight sets the weight of the generator's TX ring buffer. Ring
+// buffers without an explicit size share the free memory of the memory bank
+// they are placed in according to their weights. The default weight is 1.
+func (gen *Generator) SetRingBuffWeight(weight float64) (err error) {
+	defer ErrorReturn(&err)
+
+	if weight <= 0 {
+		return ErrorCreate(ErrInvalidConfig, "Generator %d: ring buffer "+
+			"weight must be larger than zero", gen.id)
+	}
+	gen.ringBuffWeight = weight
+	return nil
+}
+
+// getMemoryRequest returns the memory requirements of the generator's TX ring
+// buffer.
+func (gen *Generator) getMemoryRequest() memoryRequest {
+	weight := gen.ringBuffWeight
+	if weight == 0 {
+		weight = 1
+	}
+	return memoryRequest{
+		typ:          MemoryRegionGenerator,
+		id:           gen.id,
+		size:         gen.ringBuffSizeReq,
+		weight:       weight,
+		transferSize: RING_BUFF_WR_TRANSFER_SIZE_MAX,
+	}
+}
+
 // configHardware initializes the generator configuration and writes the
 // configuration to the hardware.
-func (gen *Generator) configHardware() {
+func (gen *Generator) configHardware() error {
 	// nothing to do here if no trace is configured for this generator
 	if gen.trace == nil {
-		return
+		return nil
+	}
+
+	// inter-packet times of the trace must be specified in cycles of the
+	// board's trace clock
+	board := gen.nt.board
+	if gen.trace.clock.freq != board.FreqTrace ||
+		gen.trace.clock.lineRate != board.LineRate {
+		return ErrorCreate(ErrInvalidConfig,
+			"Generator %d: trace has been created for a %.2f MHz clock and "+
+				"%.0f bit/s line rate, but board '%s' uses %.2f MHz and "+
+				"%.0f bit/s (see Trace.SetClock())", gen.id,
+			gen.trace.clock.freq/1e6, gen.trace.clock.lineRate,
+			board.Name, board.FreqTrace/1e6, board.LineRate)
 	}
 
 	// calculate ring buffer size
@@ -90,21 +157,21 @@ func (gen *Generator) configHardware() {
 
 	// the ring buffer size must be larger than 16384 bytes
 	if ringBuffSize <= 16384 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Generator %d: ring buffer size must be larger than 16384 bytes.",
 			gen.id)
 	}
 
 	// the ring buffer size must be a multiple of 16384 bytes
 	if ringBuffSize%16384 != 0 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Generator %d: ring buffer size must be a multiple of 16384 bytes.",
 			gen.id)
 	}
 
 	// the ring buffer transfer size must be a multiple of 16384 bytes
 	if RING_BUFF_WR_TRANSFER_SIZE_MAX%16384 != 0 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Generator %d: ring buffer transfer size must be a multiple of "+
 				"16384 bytes.", gen.id)
 	}
@@ -112,25 +179,25 @@ func (gen *Generator) configHardware() {
 	// the ring buffer transfer size must be smaller than the size of ring
 	// buffer
 	if ringBuffSize <= RING_BUFF_WR_TRANSFER_SIZE_MAX {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Generator %d: ring buffer transfer size must be smaller than "+
 				"ring buffer size", gen.id)
 	}
 
-	// get pcie bar access module
-	pcieBAR := gen.nt.pcieBAR
+	// get register access module
+	bar := gen.nt.bar
 
 	// write ring buffer memory region start address and range
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_ADDR_HI, uint32(gen.ringBuffAddr>>32))
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_ADDR_LO, uint32(gen.ringBuffAddr&0xFFFFFFFF))
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_RANGE, gen.ringBuffAddrRange)
 
 	// reset ring buffer write pointer
 	gen.ringBuffWrPtr = 0x0
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_WR, gen.ringBuffWrPtr)
 
 	Log(LOG_DEBUG,
@@ -139,41 +206,43 @@ func (gen *Generator) configHardware() {
 
 	// no trace data has been transferred yet, set number of transferred bytes
 	// to zero
-	gen.nBytesTransfered = 0
+	atomic.StoreUint64(&gen.nBytesTransfered, 0)
 
 	// get trace size
 	traceSize := gen.trace.GetSize()
 
 	// write trace size to hardware
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_TRACE_SIZE_HI, uint32(traceSize>>32))
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_TRACE_SIZE_LO, uint32(traceSize&0xFFFFFFFF))
+
+	return nil
 }
 
 // writeRingBuff writes trace data to the generator's TX ring buffer in the DRAM
-// memory of the FPGA board. The PCI Express DMA device must pe provided as an
-// argument.
-func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
+// memory of the FPGA board. The DMA channel must pe provided as an argument.
+func (gen *Generator) writeRingBuff(dma DMA) error {
 	if gen.trace == nil {
 		// nothing to do here
-		return
+		return nil
 	}
 
 	// get the trace size
 	traceSize := gen.trace.GetSize()
 
 	// calculate number of bytes that remain to be transfered
-	traceSizeOutStanding := traceSize - gen.nBytesTransfered
+	traceSizeOutStanding := traceSize - atomic.LoadUint64(&gen.nBytesTransfered)
 
 	// outstanding number of bytes must never become negative
 	if traceSizeOutStanding < 0 {
-		Log(LOG_ERR, "Generator %d: ring buffer write failed", gen.id)
+		return ErrorCreate(ErrHardwareState,
+			"Generator %d: ring buffer write failed", gen.id)
 	}
 
 	if traceSizeOutStanding == 0 {
 		// trace has been completely written, we are done here
-		return
+		return nil
 	}
 
 	// get ring buffer size
@@ -200,14 +269,15 @@ func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
 
 	// transfer size must never be negative
 	if transferSize < 0 {
-		Log(LOG_ERR, "Generator %d: ring buffer transfer size < 0", gen.id)
+		return ErrorCreate(ErrHardwareState,
+			"Generator %d: ring buffer transfer size < 0", gen.id)
 	}
 
-	// get pcie bar
-	pcieBAR := gen.nt.pcieBAR
+	// get register access module
+	bar := gen.nt.bar
 
 	// get current read pointer position
-	ringBuffRdPtr := pcieBAR.Read(ADDR_BASE_NT_GEN_REPLAY[gen.id] +
+	ringBuffRdPtr := bar.Read(gen.nt.board.AddrBaseGenReplay[gen.id] +
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_RD)
 
 	// do a data transfer?
@@ -236,11 +306,14 @@ func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
 
 	if doTransfer == false {
 		// currently we cannot transfer data
-		return
+		return nil
 	}
 
 	// read data from trace file
-	data := gen.trace.read(traceSize-traceSizeOutStanding, transferSize)
+	data, err := gen.trace.read(traceSize-traceSizeOutStanding, transferSize)
+	if err != nil {
+		return err
+	}
 
 	// in case a trace is repeatedly being replayed, it may occur that the data
 	// that can be moved with the DMA transfer is smaller than the requested
@@ -253,10 +326,10 @@ func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
 	transferStartTime := time.Now()
 
 	// write data to the ring buffer
-	err := pcieDMA.Write(gen.ringBuffAddr+uint64(ringBuffWrPtr),
+	err = dma.Write(gen.ringBuffAddr+uint64(ringBuffWrPtr),
 		data)
 	if err != nil {
-		Log(LOG_ERR, err.Error())
+		return ErrorCreate(ErrDMA, "Generator %d: %s", gen.id, err.Error())
 	}
 
 	// evaluate dma transfer time
@@ -274,11 +347,11 @@ func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
 
 	// save write pointer and write to hardware
 	gen.ringBuffWrPtr = ringBuffWrPtr
-	pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_WR, ringBuffWrPtr)
 
 	// increment number of transfered trace bytes
-	gen.nBytesTransfered += uint64(transferSize)
+	atomic.AddUint64(&gen.nBytesTransfered, uint64(transferSize))
 
 	// calculate dma transfer average throughput in Gbps
 	transferThroughput := 8.0 * float64(transferSize) /
@@ -287,6 +360,41 @@ func (gen *Generator) writeRingBuff(pcieDMA *gopcie.PCIeDMA) {
 	// print out performance metrics
 	Log(LOG_DEBUG, "Generator %d: %d bytes in %s (%f Gbps)",
 		gen.id, transferSize, transferDuration, transferThroughput)
+
+	return nil
+}
+
+// getProgress returns the number of trace bytes that have been transferred to
+// the TX ring buffer and the total number of bytes to transfer.
+func (gen *Generator) getProgress() ReplayProgress {
+	return ReplayProgress{
+		GeneratorID:      gen.id,
+		NBytesTransfered: atomic.LoadUint64(&gen.nBytesTransfered),
+		NBytesTotal:      gen.trace.GetSize(),
+	}
+}
+
+// isRingBuffFilled returns true, if the trace has been completely written to
+// the TX ring buffer or if the ring buffer has no space left for further
+// transfers before the hardware starts reading from it.
+func (gen *Generator) isRingBuffFilled() bool {
+	if gen.trace == nil {
+		// nothing to do here
+		return true
+	}
+
+	nBytesTransfered := atomic.LoadUint64(&gen.nBytesTransfered)
+	ringBuffSize := uint64(gen.ringBuffAddrRange) + 1
+
+	return nBytesTransfered == gen.trace.GetSize() ||
+		nBytesTransfered+RING_BUFF_WR_TRANSFER_SIZE_MAX >= ringBuffSize
+}
+
+// getRingBuffRdPtr returns the current position of the TX ring buffer read
+// pointer.
+func (gen *Generator) getRingBuffRdPtr() uint32 {
+	return gen.nt.bar.Read(gen.nt.board.AddrBaseGenReplay[gen.id] +
+		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_RD)
 }
 
 // start triggers the hardware to start reading data from the TX ring buffer
@@ -302,7 +410,7 @@ func (gen *Generator) start() {
 	}
 
 	// trigger start
-	gen.nt.pcieBAR.Write(ADDR_BASE_NT_GEN_REPLAY[gen.id]+
+	gen.nt.bar.Write(gen.nt.board.AddrBaseGenReplay[gen.id]+
 		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_START, 0x1)
 }
 
@@ -313,23 +421,20 @@ func (gen *Generator) start() {
 // to the MAC by the rate control module. Thus, the rate control module should
 // not be disabled immediately.
 func (gen *Generator) isActive() bool {
-	status := gen.nt.pcieBAR.Read(ADDR_BASE_NT_GEN_REPLAY[gen.id] +
+	status := gen.nt.bar.Read(gen.nt.board.AddrBaseGenReplay[gen.id] +
 		CPUREG_OFFSET_NT_GEN_REPLAY_STATUS)
 	return (status & 0x3) > 0
 }
 
-// checkError checks if the hardware flagged an error during replay. If the
-// parameter exit is set to true, the application exits if an error was
-// detected.
-func (gen *Generator) checkError(exit bool) error {
+// checkError checks if the hardware flagged an error during replay and returns
+// an error if one was detected.
+func (gen *Generator) checkError() error {
 	// check rate control module errors
-	status := gen.nt.pcieBAR.Read(ADDR_BASE_NT_GEN_RATE_CTRL[gen.id] +
+	status := gen.nt.bar.Read(gen.nt.board.AddrBaseGenRateCtrl[gen.id] +
 		CPUREG_OFFSET_NT_GEN_RATE_CTRL_STATUS)
 	if (status & 0x1) > 0 {
-		if exit {
-			Log(LOG_ERR, "Generator %d: replay timing error", gen.id)
-		}
-		return fmt.Errorf("Generator %d: replay timing error", gen.id)
+		return ErrorCreate(ErrReplayTiming, "Generator %d: replay timing "+
+			"error", gen.id)
 	}
 
 	// no error!
@@ -32,9 +32,7 @@
 
 package gofluent10g
 
-import (
-	"github.com/aoeldemann/gopcie"
-)
+import "time"
 
 // Generators is a slice type holding pointers on Generator instances. It
 // implements functions that allow easy control of multiple Generator instances
@@ -50,10 +48,13 @@ func (gens *Generators) resetHardware() {
 
 // configHardware initializes the configuration of the generators and writes it
 // to the hardware.
-func (gens *Generators) configHardware() {
+func (gens *Generators) configHardware() error {
 	for _, gen := range *gens {
-		gen.configHardware()
+		if err := gen.configHardware(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // start triggers the hardware to start reading data from the TX ring buffers
@@ -69,17 +70,20 @@ func (gens *Generators) start() {
 }
 
 // writeRingBuffs writes trace data to the TX ring buffer of the generators in
-// the DRAM memory of the FPGA board. The PCI Express DMA device through which
-// the write shall be performed needs to be provided as an argument.
-func (gens *Generators) writeRingBuffs(pcieDMA *gopcie.PCIeDMA) {
+// the DRAM memory of the FPGA board. The DMA channel through which the write
+// shall be performed needs to be provided as an argument.
+func (gens *Generators) writeRingBuffs(dma DMA) error {
 	for _, gen := range *gens {
-		gen.writeRingBuff(pcieDMA)
+		if err := gen.writeRingBuff(dma); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // startRateCtrl activates the rate control modules on all configured
 // generators.
-func (gens *Generators) startRateCtrl(pcieBAR *gopcie.PCIeBAR) {
+func (gens *Generators) startRateCtrl(nt *NetworkTester) {
 	// assemble interface mask
 	ifMask := uint32(0)
 
@@ -93,14 +97,14 @@ func (gens *Generators) startRateCtrl(pcieBAR *gopcie.PCIeBAR) {
 		}
 		ifMask = (ifMask & ^(0x1 << uint(gen.id))) | (enable << uint(gen.id))
 	}
-	pcieBAR.Write(ADDR_BASE_NT_CTRL+
+	nt.bar.Write(nt.board.AddrBaseCtrl+
 		CPUREG_OFFSET_NT_CTRL_RATE_CTRL_ACTIVE, ifMask)
 }
 
 // stopRateCtrl deactivates the rate control modules on all configured
 // generators.
-func (gens *Generators) stopRateCtrl(pcieBAR *gopcie.PCIeBAR) {
-	pcieBAR.Write(ADDR_BASE_NT_CTRL+
+func (gens *Generators) stopRateCtrl(nt *NetworkTester) {
+	nt.bar.Write(nt.board.AddrBaseCtrl+
 		CPUREG_OFFSET_NT_CTRL_RATE_CTRL_ACTIVE, 0x0)
 }
 
@@ -119,12 +123,81 @@ func (gens *Generators) areActive() bool {
 	return false
 }
 
-// checkErrors checks if the hardware flagged an error during replay. If the
-// parameter exit is set to true, the application exits if an error was
-// detected.
-func (gens *Generators) checkErrors(exit bool) error {
+// areRingBuffsFilled returns true, if the initial trace data has been written
+// to the TX ring buffers of all generators (see Generator.isRingBuffFilled()).
+func (gens *Generators) areRingBuffsFilled() bool {
 	for _, gen := range *gens {
-		err := gen.checkError(exit)
+		if gen.isRingBuffFilled() == false {
+			return false
+		}
+	}
+	return true
+}
+
+// getRingBuffRdPtrs returns the current TX ring buffer read pointer positions
+// of all generators.
+func (gens *Generators) getRingBuffRdPtrs() []uint32 {
+	rdPtrs := make([]uint32, len(*gens))
+	for i, gen := range *gens {
+		rdPtrs[i] = gen.getRingBuffRdPtr()
+	}
+	return rdPtrs
+}
+
+// getReplayDuration returns the time it takes to replay the traces of all
+// generators, i.e. the duration of the longest trace including its
+// repetitions. If the trace data cannot be sent within the specified
+// inter-packet times at the board's line rate, the time it takes to transmit
+// the data is returned instead. Traces whose duration is unknown (see
+// Trace.getReplayInfo()) are not considered, the trace data is never parsed.
+func (gens *Generators) getReplayDuration() time.Duration {
+	var durationMax time.Duration
+	for _, gen := range *gens {
+		if gen.trace == nil {
+			continue
+		}
+		if _, duration, ok := gen.trace.getReplayInfo(); ok &&
+			duration > durationMax {
+			durationMax = duration
+		}
+		if duration, ok := gen.trace.getWireDuration(
+			gen.nt.board.LineRate); ok && duration > durationMax {
+			durationMax = duration
+		}
+	}
+	return durationMax
+}
+
+// getPacketCountsTX returns the TX packet counters of the generators' network
+// interfaces.
+func (gens *Generators) getPacketCountsTX() []uint32 {
+	nPkts := make([]uint32, len(*gens))
+	for i, gen := range *gens {
+		nPkts[i] = uint32(gen.nt.ifaces[gen.id].GetPacketCountTX())
+	}
+	return nPkts
+}
+
+// areDrained returns true, if the generators transmitted all packets of their
+// traces. It expects the TX packet counters of the generators' network
+// interfaces at the start of the replay and their current values. The
+// function returns false if the packet count of a trace is unknown.
+func (gens *Generators) areDrained(nPktsStart, nPkts []uint32) bool {
+	for i, gen := range *gens {
+		nPktsTrace, _, ok := gen.trace.getReplayInfo()
+		// counters are 32 bit wide and may wrap around
+		if ok == false || nPkts[i]-nPktsStart[i] != uint32(nPktsTrace) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkErrors checks if the hardware flagged an error during replay and returns
+// an error if one was detected.
+func (gens *Generators) checkErrors() error {
+	for _, gen := range *gens {
+		err := gen.checkError()
 		if err != nil {
 			return err
 		}
@@ -132,6 +205,21 @@ func (gens *Generators) checkErrors(exit bool) error {
 	return nil
 }
 
+// distribute distributes the generators among n DMA channels in a round-robin
+// fashion. It returns one list of generators per DMA channel, DMA channels
+// without generators are omitted at the end of the list.
+func (gens *Generators) distribute(n int) []Generators {
+	if len(*gens) < n {
+		n = len(*gens)
+	}
+
+	gensPerChannel := make([]Generators, n)
+	for i, gen := range *gens {
+		gensPerChannel[i%n] = append(gensPerChannel[i%n], gen)
+	}
+	return gensPerChannel
+}
+
 // getIfIdsConfigured returns a list containing the interface IDs of the
 // generators that are configured to replay a trace file.
 func (gens *Generators) getIfIdsConfigured() []int {
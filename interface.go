@@ -45,15 +45,15 @@ type Interface struct {
 
 // GetPacketCountRX returns the number of packets received on the interface.
 func (iface *Interface) GetPacketCountRX() int {
-	nPkts := iface.nt.pcieBAR.Read(
-		ADDR_BASE_IFACE[iface.id] + CPUREG_OFFSET_IF_N_PKTS_RX)
+	nPkts := iface.nt.bar.Read(
+		iface.nt.board.AddrBaseIface[iface.id] + CPUREG_OFFSET_IF_N_PKTS_RX)
 	return int(nPkts)
 }
 
 // GetPacketCountTX returns the number of packets transmitted on the interface.
 func (iface *Interface) GetPacketCountTX() int {
-	nPkts := iface.nt.pcieBAR.Read(
-		ADDR_BASE_IFACE[iface.id] + CPUREG_OFFSET_IF_N_PKTS_TX)
+	nPkts := iface.nt.bar.Read(
+		iface.nt.board.AddrBaseIface[iface.id] + CPUREG_OFFSET_IF_N_PKTS_TX)
 	return int(nPkts)
 }
 
@@ -64,10 +64,11 @@ func (iface *Interface) SetDatarateSampleInterval(sampleInterval time.Duration)
 	iface.datarateSampleInterval = sampleInterval
 
 	// convert sample interval to number of clock cycles
-	sampleIntervalCycles := uint32(sampleInterval.Seconds() * FREQ_SFP)
+	sampleIntervalCycles := uint32(sampleInterval.Seconds() *
+		iface.nt.board.FreqClock)
 
 	// write sample interval to hardware
-	iface.nt.pcieBAR.Write(ADDR_BASE_NT_DATARATE[iface.id]+
+	iface.nt.bar.Write(iface.nt.board.AddrBaseDatarate[iface.id]+
 		CPUREG_OFFSET_NT_DATARATE_CTRL_SAMPLE_INTERVAL, sampleIntervalCycles)
 }
 
@@ -75,9 +76,9 @@ func (iface *Interface) SetDatarateSampleInterval(sampleInterval time.Duration)
 // interface in the last second (in Gbps).
 func (iface *Interface) GetDatrateTX() (float64, float64) {
 	// get number of bytes transmitted in last sample interval
-	nBytes := iface.nt.pcieBAR.Read(ADDR_BASE_NT_DATARATE[iface.id] +
+	nBytes := iface.nt.bar.Read(iface.nt.board.AddrBaseDatarate[iface.id] +
 		CPUREG_OFFSET_NT_DATARATE_STATUS_TX_N_BYTES)
-	nBytesRaw := iface.nt.pcieBAR.Read(ADDR_BASE_NT_DATARATE[iface.id] +
+	nBytesRaw := iface.nt.bar.Read(iface.nt.board.AddrBaseDatarate[iface.id] +
 		CPUREG_OFFSET_NT_DATARATE_STATUS_TX_N_BYTES_RAW)
 
 	// return nominal and raw datarates
@@ -88,9 +89,9 @@ func (iface *Interface) GetDatrateTX() (float64, float64) {
 // GetDatrateRX returns the nominal and raw RX data rates observed at the
 // interface in the last second (in Gbps).
 func (iface *Interface) GetDatrateRX() (float64, float64) {
-	nBytes := iface.nt.pcieBAR.Read(ADDR_BASE_NT_DATARATE[iface.id] +
+	nBytes := iface.nt.bar.Read(iface.nt.board.AddrBaseDatarate[iface.id] +
 		CPUREG_OFFSET_NT_DATARATE_STATUS_RX_N_BYTES)
-	nBytesRaw := iface.nt.pcieBAR.Read(ADDR_BASE_NT_DATARATE[iface.id] +
+	nBytesRaw := iface.nt.bar.Read(iface.nt.board.AddrBaseDatarate[iface.id] +
 		CPUREG_OFFSET_NT_DATARATE_STATUS_RX_N_BYTES_RAW)
 
 	// return nominal and raw datarates
@@ -0,0 +1,291 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Assignment of the FPGA board's DRAM memory to the ring buffers of the
+// generators and receivers. Each ring buffer is placed in a single memory
+// bank. Ring buffers may be given an explicit size, the remaining memory of a
+// bank is split among the other ring buffers placed in it according to their
+// weights.
+
+package gofluent10g
+
+import "fmt"
+
+// ring buffer types of a memory region
+const (
+	MemoryRegionGenerator = 0
+	MemoryRegionReceiver  = 1
+)
+
+// ring buffers must never be larger than 4 GByte, since their address range is
+// a 32 bit value
+const memoryRingBuffSizeMax = uint64(1) << 32
+
+// ring buffer sizes and memory bank start addresses must be a multiple of
+// 16384 bytes
+const memoryAlignment = 16384
+
+// MemoryBank describes a DRAM memory bank of the FPGA board.
+type MemoryBank struct {
+	Addr uint64 // start address
+	Size uint64 // size in bytes
+}
+
+// MemoryRegion describes the memory region assigned to the ring buffer of a
+// generator or receiver.
+type MemoryRegion struct {
+	Type int // MemoryRegionGenerator or MemoryRegionReceiver
+	ID   int // interface ID of the generator or receiver
+	Bank int // index of the memory bank the region is located in
+	Addr uint64
+	Size uint64
+}
+
+// memoryRequest describes the memory requirements of a ring buffer. If size is
+// zero, the ring buffer gets a share of the memory bank it is placed in, which
+// is proportional to its weight.
+type memoryRequest struct {
+	typ, id      int
+	size         uint64
+	weight       float64
+	transferSize uint64 // ring buffer must be larger than the transfer size
+}
+
+// memoryBanksCheck makes sure the memory banks can be used for ring buffers.
+func memoryBanksCheck(banks []MemoryBank) error {
+	if len(banks) == 0 {
+		return ErrorCreate(ErrInvalidConfig, "Memory: no memory banks")
+	}
+
+	for i, bank := range banks {
+		if bank.Addr%memoryAlignment != 0 || bank.Size%memoryAlignment != 0 {
+			return ErrorCreate(ErrInvalidConfig, "Memory: start address and "+
+				"size of memory bank %d must be a multiple of %d bytes", i,
+				memoryAlignment)
+		}
+		if bank.Size == 0 {
+			return ErrorCreate(ErrInvalidConfig, "Memory: memory bank %d is "+
+				"empty", i)
+		}
+		for j := 0; j < i; j++ {
+			if bank.Addr < banks[j].Addr+banks[j].Size &&
+				banks[j].Addr < bank.Addr+bank.Size {
+				return ErrorCreate(ErrInvalidConfig, "Memory: memory banks "+
+					"%d and %d overlap", j, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// memoryRingBuffSizeCheck makes sure the size of a ring buffer is supported by
+// the hardware. name identifies the ring buffer in the returned error.
+func memoryRingBuffSizeCheck(name string, size, transferSize uint64) error {
+	if size%memoryAlignment != 0 {
+		return ErrorCreate(ErrInvalidConfig, "%s: ring buffer size must be a "+
+			"multiple of %d bytes", name, memoryAlignment)
+	}
+	if size <= memoryAlignment {
+		return ErrorCreate(ErrInvalidConfig, "%s: ring buffer size must be "+
+			"larger than %d bytes", name, memoryAlignment)
+	}
+	if size <= transferSize {
+		return ErrorCreate(ErrInvalidConfig, "%s: ring buffer size must be "+
+			"larger than the transfer size of %d bytes", name, transferSize)
+	}
+	if size > memoryRingBuffSizeMax {
+		return ErrorCreate(ErrInvalidConfig, "%s: ring buffer size must not "+
+			"be larger than 4 GByte", name)
+	}
+	return nil
+}
+
+// memoryAllocate assigns memory regions to the ring buffers. Each ring buffer
+// is placed in the memory bank of the default layout (see
+// memoryLayoutDefault()). Ring buffers with an explicit size are placed first.
+// If one does not fit in its bank, it is placed in the memory bank with the
+// most free memory instead. The remaining ring buffers split the free memory of
+// their bank according to their weights. If the ring buffers with explicit
+// size left no room in the bank, a weighted ring buffer is placed in the bank
+// in which the memory per weight is as large as possible instead.
+func memoryAllocate(banks []MemoryBank, reqs []memoryRequest) ([]MemoryRegion, error) {
+	// free memory and sum of weights of the ring buffers placed in each bank
+	free := make([]uint64, len(banks))
+	weights := make([]float64, len(banks))
+	for i, bank := range banks {
+		free[i] = bank.Size
+	}
+
+	// bank index for each request, initialized with the default layout
+	iBanks := memoryLayoutDefault(len(banks), reqs)
+
+	// place ring buffers with explicit size
+	for i, req := range reqs {
+		if req.size == 0 {
+			continue
+		}
+
+		iBank := iBanks[i]
+		if free[iBank] < req.size {
+			iBank = -1
+			for j := range banks {
+				if free[j] >= req.size && (iBank < 0 || free[j] > free[iBank]) {
+					iBank = j
+				}
+			}
+		}
+		if iBank < 0 {
+			return nil, ErrorCreate(ErrInvalidConfig, "%s: ring buffer of "+
+				"%d bytes does not fit in any memory bank", memoryName(req.typ, req.id),
+				req.size)
+		}
+
+		iBanks[i] = iBank
+		free[iBank] -= req.size
+	}
+
+	// place weighted ring buffers
+	for i, req := range reqs {
+		if req.size != 0 {
+			continue
+		}
+
+		iBank := iBanks[i]
+		if free[iBank] <= req.transferSize {
+			iBank = 0
+			for j := range banks {
+				if float64(free[j])/(weights[j]+req.weight) >
+					float64(free[iBank])/(weights[iBank]+req.weight) {
+					iBank = j
+				}
+			}
+		}
+
+		iBanks[i] = iBank
+		weights[iBank] += req.weight
+	}
+
+	// calculate the size of the weighted ring buffers
+	sizes := make([]uint64, len(reqs))
+	for i, req := range reqs {
+		if req.size != 0 {
+			sizes[i] = req.size
+			continue
+		}
+
+		iBank := iBanks[i]
+		size := uint64(float64(free[iBank]) * req.weight / weights[iBank])
+		size -= size % memoryAlignment
+		if size > memoryRingBuffSizeMax {
+			size = memoryRingBuffSizeMax
+		}
+
+		// the ring buffer must be larger than the transfer size
+		if size <= req.transferSize || size <= memoryAlignment {
+			return nil, ErrorCreate(ErrInvalidConfig, "%s: not enough free "+
+				"memory in memory bank %d for ring buffer (%d bytes "+
+				"available, more than %d bytes required)",
+				memoryName(req.typ, req.id), iBank, size, req.transferSize)
+		}
+
+		sizes[i] = size
+	}
+
+	// assign the addresses. regions are placed contiguously in the order of
+	// the requests
+	addrs := make([]uint64, len(banks))
+	for i, bank := range banks {
+		addrs[i] = bank.Addr
+	}
+
+	regions := make([]MemoryRegion, len(reqs))
+	for i, req := range reqs {
+		iBank := iBanks[i]
+		regions[i] = MemoryRegion{
+			Type: req.typ,
+			ID:   req.id,
+			Bank: iBank,
+			Addr: addrs[iBank],
+			Size: sizes[i],
+		}
+		addrs[iBank] += sizes[i]
+	}
+
+	return regions, nil
+}
+
+// memoryLayoutDefault returns the memory bank index of each ring buffer in the
+// default layout. If ring buffers of generators and receivers are requested,
+// the TX ring buffers are placed in the first half of the memory banks and the
+// RX ring buffers in the second half, so that replay and capture do not
+// contend for the same memory (on the NetFPGA-SUME, TX ring buffers are placed
+// in DDR_A and RX ring buffers in DDR_B). Otherwise all memory banks are used.
+// The ring buffers are spread evenly across their banks in the order of the
+// requests, consecutive ring buffers share a bank (e.g. with four TX ring
+// buffers and two banks, the first two are placed in DDR_A).
+func memoryLayoutDefault(nBanks int, reqs []memoryRequest) []int {
+	// number of TX and RX ring buffers
+	var nGens, nRecvs int
+	for _, req := range reqs {
+		if req.typ == MemoryRegionGenerator {
+			nGens++
+		} else {
+			nRecvs++
+		}
+	}
+
+	// first bank and number of banks for TX and RX ring buffers
+	bankGen, nBanksGen := 0, nBanks
+	bankRecv, nBanksRecv := 0, nBanks
+	if nGens > 0 && nRecvs > 0 && nBanks >= 2 {
+		nBanksGen = nBanks / 2
+		bankRecv, nBanksRecv = nBanksGen, nBanks-nBanksGen
+	}
+
+	iBanks := make([]int, len(reqs))
+	var iGen, iRecv int
+	for i, req := range reqs {
+		if req.typ == MemoryRegionGenerator {
+			iBanks[i] = bankGen + iGen*nBanksGen/nGens
+			iGen++
+		} else {
+			iBanks[i] = bankRecv + iRecv*nBanksRecv/nRecvs
+			iRecv++
+		}
+	}
+	return iBanks
+}
+
+// memoryName returns the name of the generator or receiver a memory region
+// belongs to.
+func memoryName(typ, id int) string {
+	if typ == MemoryRegionGenerator {
+		return fmt.Sprintf("Generator %d", id)
+	}
+	return fmt.Sprintf("Receiver %d", id)
+}
@@ -0,0 +1,202 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the assignment of memory regions to ring buffers.
+
+package gofluent10g
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const (
+	testMiB = uint64(1) << 20
+	testGiB = uint64(1) << 30
+)
+
+// testMemoryAllocate assigns memory regions and compares them with the
+// expected regions.
+func testMemoryAllocate(t *testing.T, banks []MemoryBank, reqs []memoryRequest, regionsExp []MemoryRegion) {
+	regions, err := memoryAllocate(banks, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(regions, regionsExp) == false {
+		t.Fatalf("memory regions %+v, expected %+v", regions, regionsExp)
+	}
+}
+
+// TestMemoryAllocateDefault checks the default layout: TX ring buffers are
+// placed in the first memory bank, RX ring buffers in the second one.
+func TestMemoryAllocateDefault(t *testing.T) {
+	banks := []MemoryBank{{0, 4 * testGiB}, {4 * testGiB, 4 * testGiB}}
+
+	var reqs []memoryRequest
+	var regionsExp []MemoryRegion
+	for _, typ := range []int{MemoryRegionGenerator, MemoryRegionReceiver} {
+		for id := 0; id < 4; id++ {
+			reqs = append(reqs, memoryRequest{typ: typ, id: id, weight: 1,
+				transferSize: 64 * testMiB})
+			regionsExp = append(regionsExp, MemoryRegion{
+				Type: typ,
+				ID:   id,
+				Bank: typ,
+				Addr: uint64(typ)*4*testGiB + uint64(id)*testGiB,
+				Size: testGiB,
+			})
+		}
+	}
+	testMemoryAllocate(t, banks, reqs, regionsExp)
+
+	// without receivers, the TX ring buffers are spread across both banks
+	testMemoryAllocate(t, banks, reqs[0:4], []MemoryRegion{
+		{MemoryRegionGenerator, 0, 0, 0, 2 * testGiB},
+		{MemoryRegionGenerator, 1, 0, 2 * testGiB, 2 * testGiB},
+		{MemoryRegionGenerator, 2, 1, 4 * testGiB, 2 * testGiB},
+		{MemoryRegionGenerator, 3, 1, 6 * testGiB, 2 * testGiB},
+	})
+}
+
+// TestMemoryAllocateWeights checks that the memory of a bank is split according
+// to the weights of the ring buffers and that the sizes are aligned.
+func TestMemoryAllocateWeights(t *testing.T) {
+	banks := []MemoryBank{{0, testGiB}}
+
+	testMemoryAllocate(t, banks, []memoryRequest{
+		{typ: MemoryRegionGenerator, id: 0, weight: 1},
+		{typ: MemoryRegionGenerator, id: 1, weight: 3},
+	}, []MemoryRegion{
+		{MemoryRegionGenerator, 0, 0, 0, 256 * testMiB},
+		{MemoryRegionGenerator, 1, 0, 256 * testMiB, 768 * testMiB},
+	})
+
+	// a third of the bank is not a multiple of 16384 bytes and is rounded
+	// down
+	size := uint64(21845 * memoryAlignment)
+	testMemoryAllocate(t, banks, []memoryRequest{
+		{typ: MemoryRegionGenerator, id: 0, weight: 1},
+		{typ: MemoryRegionGenerator, id: 1, weight: 1},
+		{typ: MemoryRegionGenerator, id: 2, weight: 1},
+	}, []MemoryRegion{
+		{MemoryRegionGenerator, 0, 0, 0, size},
+		{MemoryRegionGenerator, 1, 0, size, size},
+		{MemoryRegionGenerator, 2, 0, 2 * size, size},
+	})
+
+	// ring buffers are not larger than 4 GByte
+	testMemoryAllocate(t, []MemoryBank{{0, 16 * testGiB}}, []memoryRequest{
+		{typ: MemoryRegionReceiver, id: 0, weight: 1},
+	}, []MemoryRegion{
+		{MemoryRegionReceiver, 0, 0, 0, memoryRingBuffSizeMax},
+	})
+}
+
+// TestMemoryAllocateExplicit checks the placement of ring buffers with an
+// explicit size that do not fit in the bank of the default layout.
+func TestMemoryAllocateExplicit(t *testing.T) {
+	// the TX ring buffer does not fit in the first bank and is placed in
+	// the second bank, the RX ring buffer gets the remaining memory
+	testMemoryAllocate(t,
+		[]MemoryBank{{0, testGiB}, {testGiB, 2 * testGiB}},
+		[]memoryRequest{
+			{typ: MemoryRegionGenerator, id: 0, size: 1536 * testMiB},
+			{typ: MemoryRegionReceiver, id: 0, weight: 1,
+				transferSize: 64 * testMiB},
+		}, []MemoryRegion{
+			{MemoryRegionGenerator, 0, 1, testGiB, 1536 * testMiB},
+			{MemoryRegionReceiver, 0, 1, 2560 * testMiB, 512 * testMiB},
+		})
+
+	// the first TX ring buffer fills the first bank, the second one is
+	// placed in the second bank and shares it with the RX ring buffer
+	testMemoryAllocate(t,
+		[]MemoryBank{{0, testGiB}, {testGiB, testGiB}},
+		[]memoryRequest{
+			{typ: MemoryRegionGenerator, id: 0, size: testGiB},
+			{typ: MemoryRegionGenerator, id: 1, weight: 1,
+				transferSize: 64 * testMiB},
+			{typ: MemoryRegionReceiver, id: 0, weight: 1,
+				transferSize: 64 * testMiB},
+		}, []MemoryRegion{
+			{MemoryRegionGenerator, 0, 0, 0, testGiB},
+			{MemoryRegionGenerator, 1, 1, testGiB, 512 * testMiB},
+			{MemoryRegionReceiver, 0, 1, 1536 * testMiB, 512 * testMiB},
+		})
+}
+
+// TestMemoryAllocateInvalid checks that requests that cannot be satisfied
+// are rejected.
+func TestMemoryAllocateInvalid(t *testing.T) {
+	banks := []MemoryBank{{0, testGiB}, {testGiB, testGiB}}
+
+	reqs := [][]memoryRequest{
+		// explicit size does not fit in any bank
+		{
+			{typ: MemoryRegionGenerator, id: 0, size: 2 * testGiB},
+		},
+		// weighted ring buffer is not larger than its transfer size
+		{
+			{typ: MemoryRegionGenerator, id: 0, weight: 1,
+				transferSize: 512 * testMiB},
+			{typ: MemoryRegionGenerator, id: 1, weight: 1,
+				transferSize: 512 * testMiB},
+			{typ: MemoryRegionGenerator, id: 2, weight: 1,
+				transferSize: 512 * testMiB},
+		},
+		// no memory left for the weighted ring buffer
+		{
+			{typ: MemoryRegionGenerator, id: 0, size: testGiB},
+			{typ: MemoryRegionReceiver, id: 0, size: testGiB},
+			{typ: MemoryRegionReceiver, id: 1, weight: 1},
+		},
+	}
+	for i := range reqs {
+		_, err := memoryAllocate(banks, reqs[i])
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("request %d: expected invalid configuration error, got: "+
+				"%v", i, err)
+		}
+	}
+
+	// invalid memory banks
+	bankLists := [][]MemoryBank{
+		nil,
+		{{0, testGiB + 1}},
+		{{1, testGiB}},
+		{{0, 0}},
+		{{0, testGiB}, {testGiB / 2, testGiB}},
+	}
+	for i, banks := range bankLists {
+		if err := memoryBanksCheck(banks); errors.Is(err,
+			ErrInvalidConfig) == false {
+			t.Fatalf("banks %d: expected invalid configuration error, got: "+
+				"%v", i, err)
+		}
+	}
+}
@@ -34,21 +34,22 @@
 package gofluent10g
 
 import (
-	"math"
+	"context"
 	"runtime"
 	"sync"
 	"time"
-
-	"github.com/aoeldemann/gopcie"
 )
 
 // NetworkTester is the toplevel struct providing methods for configuring the
 // network tester. It provides methods that provide access to generator,
 // receiver, interface and timestamp counter submodules.
 type NetworkTester struct {
-	pcieBAR      *gopcie.PCIeBAR
-	pcieDMAWrite []*gopcie.PCIeDMA
-	pcieDMARead  []*gopcie.PCIeDMA
+	backend  Backend
+	bar      BAR
+	dmaWrite []DMA
+	dmaRead  []DMA
+
+	board *BoardProfile // hardware variant
 
 	gens      Generators // slice of *Generator
 	recvs     Receivers  // slice of *Receiver
@@ -56,62 +57,137 @@ type NetworkTester struct {
 	timestamp *timestamp
 
 	syncReplay, syncCapture, syncPrintDatarate sync.WaitGroup // goroutine synchronization
-	stopReplay, stopCapture, stopPrintDatarate chan bool      // goroutine synchronization
+	stopReplay, stopPrintDatarate              chan bool      // goroutine synchronization
+
+	// stops the capture goroutines, nil while capturing is not running
+	stopCapture chan bool
+
+	// first error that occured in the replay and capture goroutines
+	errReplay, errCapture error
+	errMutex              sync.Mutex
+
+	// closed once the replay goroutines have been stopped
+	stopReplayDone chan struct{}
+
+	// replay progress reporting (see SetReplayProgressFunc)
+	replayProgressFunc     func(progress []ReplayProgress)
+	replayProgressInterval time.Duration
+
+	// DRAM memory banks of the FPGA board and the memory regions assigned to
+	// the ring buffers by WriteConfig()
+	memoryBanks  []MemoryBank
+	memoryLayout []MemoryRegion
 
 	checkErrors bool
 }
 
-// NetworkTesterCreate create a new instance of the NetworkTester struct.
-func NetworkTesterCreate() *NetworkTester {
-	// open PCIExpress BAR
-	pcieBAR, err := gopcie.PCIeBAROpen(
-		PCIE_BAR_FUNCTION_ID,
-		PCIE_BAR_VENDOR_ID,
-		PCIE_BAR_DEVICE_ID,
-		PCIE_BAR_ID)
+// NetworkTesterCreate create a new instance of the NetworkTester struct. The
+// network tester hardware is accessed via PCI Express. The hardware is
+// expected to match the NetFPGA-SUME board profile.
+func NetworkTesterCreate() (_ *NetworkTester, err error) {
+	defer ErrorReturn(&err)
+
+	return NetworkTesterCreateWithBoard(BoardNetFPGASUME)
+}
+
+// NetworkTesterCreateWithBoard creates a new instance of the NetworkTester
+// struct for the hardware variant described by the board profile. The network
+// tester hardware is accessed via PCI Express.
+func NetworkTesterCreateWithBoard(board BoardProfile) (_ *NetworkTester, err error) {
+	defer ErrorReturn(&err)
+
+	return networkTesterOpen(board)
+}
+
+// networkTesterOpen creates a new instance of the NetworkTester struct for the
+// hardware variant described by the board profile. Unlike
+// NetworkTesterCreateWithBoard(), it does not apply the exit-on-error setting.
+func networkTesterOpen(board BoardProfile) (*NetworkTester, error) {
+	if err := board.check(); err != nil {
+		return nil, err
+	}
+
+	backend, err := pcieBackendOpen(&board)
 	if err != nil {
-		Log(LOG_ERR, err.Error())
+		return nil, err
 	}
 
-	// open PCIExpress DMA for writing
-	pcieDMAWrite := make([]*gopcie.PCIeDMA, len(PCIE_XDMA_DEV_H2C))
-	for i := 0; i < len(PCIE_XDMA_DEV_H2C); i++ {
-		pcieDMAWrite[i], err = gopcie.PCIeDMAOpen(PCIE_XDMA_DEV_H2C[i],
-			gopcie.PCIE_ACCESS_WRITE)
-		if err != nil {
-			Log(LOG_ERR, err.Error())
-		}
+	nt, err := networkTesterCreate(backend, board)
+	if err != nil {
+		backend.Close()
+		return nil, err
 	}
 
-	// open PCIExpress DMA for reading
-	pcieDMARead := make([]*gopcie.PCIeDMA, len(PCIE_XDMA_DEV_C2H))
-	for i := 0; i < len(PCIE_XDMA_DEV_C2H); i++ {
-		pcieDMARead[i], err = gopcie.PCIeDMAOpen(PCIE_XDMA_DEV_C2H[i],
-			gopcie.PCIE_ACCESS_READ)
-		if err != nil {
-			Log(LOG_ERR, err.Error())
-		}
+	return nt, nil
+}
+
+// NetworkTesterCreateWithBackend creates a new instance of the NetworkTester
+// struct, which accesses the hardware through the provided backend (e.g. a
+// Simulator instance). The hardware is expected to match the NetFPGA-SUME
+// board profile.
+func NetworkTesterCreateWithBackend(backend Backend) (_ *NetworkTester, err error) {
+	defer ErrorReturn(&err)
+
+	return NetworkTesterCreateWithBackendAndBoard(backend, BoardNetFPGASUME)
+}
+
+// NetworkTesterCreateWithBackendAndBoard creates a new instance of the
+// NetworkTester struct for the hardware variant described by the board
+// profile, which accesses the hardware through the provided backend. The
+// backend must provide at least one DMA channel per transfer direction.
+func NetworkTesterCreateWithBackendAndBoard(backend Backend, board BoardProfile) (_ *NetworkTester, err error) {
+	defer ErrorReturn(&err)
+
+	return networkTesterCreate(backend, board)
+}
+
+// networkTesterCreate creates a new instance of the NetworkTester struct, which
+// accesses the hardware through the provided backend. Unlike
+// NetworkTesterCreateWithBackendAndBoard(), it does not apply the
+// exit-on-error setting.
+func networkTesterCreate(backend Backend, board BoardProfile) (*NetworkTester, error) {
+	if err := board.check(); err != nil {
+		return nil, err
+	}
+
+	// ring buffers are written and read through the backend's DMA channels,
+	// at least one channel per direction is required
+	if len(backend.GetDMAWrite()) == 0 || len(backend.GetDMARead()) == 0 {
+		return nil, ErrorCreate(ErrHardwareAccess, "NetworkTester: backend "+
+			"provides no DMA channels for host-to-card or card-to-host "+
+			"transfers")
 	}
 
+	// the network tester keeps a copy of the board profile, which does not
+	// share its slices with the profile passed by the application (e.g. the
+	// base address tables of BoardNetFPGASUME)
+	board = board.copy()
+
 	// create instance of NetworkTester struct
 	nt := NetworkTester{
-		pcieBAR:      pcieBAR,
-		pcieDMAWrite: pcieDMAWrite,
-		pcieDMARead:  pcieDMARead,
+		backend:  backend,
+		bar:      backend.GetBAR(),
+		dmaWrite: dmaChannelsCreate(backend.GetDMAWrite(), DMADirectionH2C),
+		dmaRead:  dmaChannelsCreate(backend.GetDMARead(), DMADirectionC2H),
+		board:    &board,
 		// always enable error checking, can be disabled by the user later
 		checkErrors: true,
+		// ring buffers are placed in the board's memory banks by default
+		memoryBanks: board.MemoryBanks,
 	}
 
 	// make sure hardware version matches software version
-	nt.checkVersion()
+	if err := nt.checkVersion(); err != nil {
+		return nil, err
+	}
 
 	// create generator, receiver, interface and control instances. one per
 	// network interface
-	nt.gens = make(Generators, N_INTERFACES)
-	nt.recvs = make(Receivers, N_INTERFACES)
-	nt.ifaces = make(Interfaces, N_INTERFACES)
+	nt.gens = make(Generators, board.NInterfaces)
+	nt.recvs = make(Receivers, board.NInterfaces)
+	nt.ifaces = make(Interfaces, board.NInterfaces)
 
-	for i := 0; i < N_INTERFACES; i++ {
+	for i := 0; i < board.NInterfaces; i++ {
 		nt.gens[i] = &Generator{
 			nt: &nt,
 			id: i,
@@ -134,27 +210,29 @@ func NetworkTesterCreate() *NetworkTester {
 	}
 
 	// return the created instance
-	return &nt
+	return &nt, nil
 }
 
 // Close closes the connection to the network tester hardware.
 func (nt *NetworkTester) Close() {
-	nt.pcieBAR.Close()
-	for _, pcieDMAWrite := range nt.pcieDMAWrite {
-		pcieDMAWrite.Close()
-	}
-	for _, pcieDMARead := range nt.pcieDMARead {
-		pcieDMARead.Close()
-	}
+	nt.backend.Close()
+}
+
+// GetBoard returns the board profile of the network tester hardware.
+func (nt *NetworkTester) GetBoard() BoardProfile {
+	return nt.board.copy()
 }
 
 // GetGenerator returns a generator instance by its interface ID.
-func (nt *NetworkTester) GetGenerator(id int) *Generator {
-	if id < 0 || id >= N_INTERFACES {
-		Log(LOG_ERR, "Invalid Generator ID: %d", id)
+func (nt *NetworkTester) GetGenerator(id int) (_ *Generator, err error) {
+	defer ErrorReturn(&err)
+
+	if id < 0 || id >= nt.board.NInterfaces {
+		return nil, ErrorCreate(ErrInvalidConfig, "Invalid Generator ID: %d",
+			id)
 	}
 
-	return nt.gens[id]
+	return nt.gens[id], nil
 }
 
 // GetGenerators returns a slice containing all generator instances.
@@ -163,12 +241,15 @@ func (nt *NetworkTester) GetGenerators() Generators {
 }
 
 // GetReceiver returns a receiver instance by its interface ID.
-func (nt *NetworkTester) GetReceiver(id int) *Receiver {
-	if id < 0 || id >= N_INTERFACES {
-		Log(LOG_ERR, "Invalid Receiver ID: %d", id)
+func (nt *NetworkTester) GetReceiver(id int) (_ *Receiver, err error) {
+	defer ErrorReturn(&err)
+
+	if id < 0 || id >= nt.board.NInterfaces {
+		return nil, ErrorCreate(ErrInvalidConfig, "Invalid Receiver ID: %d",
+			id)
 	}
 
-	return nt.recvs[id]
+	return nt.recvs[id], nil
 }
 
 // GetReceivers returns a slice containing all receiver instances.
@@ -177,12 +258,15 @@ func (nt *NetworkTester) GetReceivers() Receivers {
 }
 
 // GetInterface returns an interface instance by its interface ID.
-func (nt *NetworkTester) GetInterface(id int) *Interface {
-	if id < 0 || id >= N_INTERFACES {
-		Log(LOG_ERR, "Invalid Interface ID: %d", id)
+func (nt *NetworkTester) GetInterface(id int) (_ *Interface, err error) {
+	defer ErrorReturn(&err)
+
+	if id < 0 || id >= nt.board.NInterfaces {
+		return nil, ErrorCreate(ErrInvalidConfig, "Invalid Interface ID: %d",
+			id)
 	}
 
-	return nt.ifaces[id]
+	return nt.ifaces[id], nil
 }
 
 // GetInterfaces returns a slice containing all interface instances.
@@ -190,6 +274,42 @@ func (nt *NetworkTester) GetInterfaces() Interfaces {
 	return nt.ifaces
 }
 
+// SetMemoryBanks sets the DRAM memory banks of the FPGA board, in which the
+// ring buffers of the generators and receivers are placed. Start address and
+// size of each bank must be a multiple of 16384 bytes. By default, the memory
+// banks of the board profile are used.
+func (nt *NetworkTester) SetMemoryBanks(banks []MemoryBank) (err error) {
+	defer ErrorReturn(&err)
+
+	if err := memoryBanksCheck(banks); err != nil {
+		return err
+	}
+	nt.memoryBanks = append([]MemoryBank(nil), banks...)
+	return nil
+}
+
+// GetMemoryBanks returns the DRAM memory banks of the FPGA board.
+func (nt *NetworkTester) GetMemoryBanks() []MemoryBank {
+	return append([]MemoryBank(nil), nt.memoryBanks...)
+}
+
+// GetDMAStats returns the transfer statistics of all host to card and card to
+// host DMA channels since the last call of WriteConfig().
+func (nt *NetworkTester) GetDMAStats() []DMAChannelStats {
+	var stats []DMAChannelStats
+	for _, dma := range append(nt.dmaWrite, nt.dmaRead...) {
+		stats = append(stats, dma.(*dmaChannel).getStats())
+	}
+	return stats
+}
+
+// GetMemoryLayout returns the memory regions that have been assigned to the
+// ring buffers of the generators and receivers by the last call of
+// WriteConfig().
+func (nt *NetworkTester) GetMemoryLayout() []MemoryRegion {
+	return append([]MemoryRegion(nil), nt.memoryLayout...)
+}
+
 // FreeHostMemory resets pointers pointing to trace and capture data and then
 // manually triggers garbage collection.
 func (nt *NetworkTester) FreeHostMemory() {
@@ -208,10 +328,23 @@ func (nt *NetworkTester) FreeHostMemory() {
 // WriteConfig writes the network tester configuration down to the hardware.
 // Function must be called before starting replay/capture, if configuration
 // was changed.
-func (nt *NetworkTester) WriteConfig() {
+func (nt *NetworkTester) WriteConfig() (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.writeConfig()
+}
+
+// writeConfig writes the network tester configuration down to the hardware.
+// Unlike WriteConfig(), it does not apply the exit-on-error setting.
+func (nt *NetworkTester) writeConfig() error {
 	// reset hardware
 	nt.resetHardware()
 
+	// reset DMA transfer statistics
+	for _, dma := range append(nt.dmaWrite, nt.dmaRead...) {
+		dma.(*dmaChannel).resetStats()
+	}
+
 	// print out information on which generators and receivers are configured
 	// for replay/capture
 	genIfIds := nt.gens.getIfIdsConfigured()
@@ -220,78 +353,220 @@ func (nt *NetworkTester) WriteConfig() {
 	Log(LOG_DEBUG, "Capturing traffic on interfaces: %d", recvIfIds)
 
 	// assign memory regions
-	nt.assignMemory()
+	if err := nt.assignMemory(); err != nil {
+		return err
+	}
 
 	// configure all cores
-	nt.configHardware()
+	return nt.configHardware()
+}
+
+// ReplayProgress describes the progress of the trace replay of a generator.
+type ReplayProgress struct {
+	GeneratorID int
+
+	// number of trace bytes that have been transferred to the generator's TX
+	// ring buffer and total number of bytes to transfer (see Trace.GetSize())
+	NBytesTransfered uint64
+	NBytesTotal      uint64
+}
+
+// SetReplayProgressFunc registers a function that is periodically called
+// during replay with the replay progress of all configured generators. The
+// function is called in the goroutine that started the replay, the interval
+// specifies the time between two calls. The function is called a last time
+// when replay has finished. A nil function disables progress reporting.
+func (nt *NetworkTester) SetReplayProgressFunc(interval time.Duration, fn func(progress []ReplayProgress)) {
+	nt.replayProgressInterval = interval
+	nt.replayProgressFunc = fn
 }
 
 // StartReplay triggers the start of packet generation on all configured
-// generators. The function blocks until generation has finished.
-func (nt *NetworkTester) StartReplay() {
+// generators. The function blocks until generation has finished. It returns
+// an error if the ring buffers could not be filled or if hardware error
+// checking is enabled and the hardware flagged an error.
+func (nt *NetworkTester) StartReplay() (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.StartReplayContext(context.Background())
+}
+
+// StartReplayContext triggers the start of packet generation on all configured
+// generators like StartReplay. If the context is cancelled before generation
+// has finished, the replay is aborted: the goroutines filling the ring buffers
+// are stopped, the rate control modules are deactivated and the hardware cores
+// are reset (which also aborts a running capture). An error wrapping the
+// context's error is returned in this case. WriteConfig() must be called
+// before the replay can be started again.
+func (nt *NetworkTester) StartReplayContext(ctx context.Context) (err error) {
+	defer ErrorReturn(&err)
+
+	gens, replayDuration, err := nt.replayPrepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nt.replayRun(ctx, gens, replayDuration)
+}
+
+// replayPrepare prepares the replay on all configured generators: the
+// goroutines filling the ring buffers are started and the hardware starts
+// reading trace data into the transmission fifos. It returns the configured
+// generators and the replay duration of their traces. Packet transmission is
+// started by replayRun().
+func (nt *NetworkTester) replayPrepare(ctx context.Context) (Generators, time.Duration, error) {
 	// create a list holding all generators for which traffic replay is
 	// configured, i.e. a trace has been assigned
 	var gens Generators
 	for _, gen := range nt.gens {
 		if gen.trace != nil {
+			// make sure the configuration has been written to the hardware
+			if gen.ringBuffAddrRange == 0 {
+				return nil, 0, ErrorCreate(ErrInvalidConfig, "Generator %d: "+
+					"no ring buffer assigned (WriteConfig() not called?)",
+					gen.id)
+			}
+
+			// trace data of file-backed traces is read during replay, so
+			// they cannot be shared by multiple generators
+			for _, genOther := range gens {
+				if gen.trace.reader != nil && genOther.trace == gen.trace {
+					return nil, 0, ErrorCreate(ErrInvalidConfig,
+						"Generator %d: file-backed trace is already assigned "+
+							"to generator %d", gen.id, genOther.id)
+				}
+			}
+
 			gens = append(gens, gen)
 		}
 	}
 
-	// how many generators will be served by each goroutine at most?
-	nGensPerGoroutine := int(math.Ceil(float64(len(gens)) / float64(len(nt.pcieDMAWrite))))
-
-	// initialize a list, which for each goroutine will hold a list cotaining
-	// the generators it serves
-	gensPerGoroutine := make([]Generators, len(nt.pcieDMAWrite))
+	// the replay duration of the traces determines how long the rate control
+	// modules must remain active at least
+	replayDuration := gens.getReplayDuration()
 
-	// assemble the list
-	iGoroutine := 0
-	for _, gen := range gens {
-		gensPerGoroutine[iGoroutine] = append(gensPerGoroutine[iGoroutine], gen)
+	// distribute the generators among the DMA channels. each DMA channel is
+	// served by its own goroutine
+	gensPerGoroutine := gens.distribute(len(nt.dmaWrite))
 
-		if len(gensPerGoroutine[iGoroutine]) == nGensPerGoroutine {
-			iGoroutine++
-		}
-	}
-
-	// set up goroutine synchronization for stopping later
+	// set up goroutine synchronization for stopping later. the goroutines are
+	// stopped by closing the channel
 	nt.stopReplay = make(chan bool)
-	nt.syncReplay.Add(len(nt.pcieDMAWrite))
+	nt.stopReplayDone = make(chan struct{})
+	nt.errReplay = nil
 
 	// start the goroutines, which will continuously fill the ring buffers
 	for i, gens := range gensPerGoroutine {
-		go nt.replay(gens, nt.pcieDMAWrite[i])
+		Log(LOG_DEBUG, "DMA h2c %d: generators %d", i,
+			gens.getIfIdsConfigured())
+		nt.syncReplay.Add(1)
+		go nt.replay(gens, nt.dmaWrite[i])
 	}
 
-	// wait a little bit
-	time.Sleep(500 * time.Millisecond)
+	// wait until the goroutines have filled the ring buffers (or the whole
+	// trace has been written)
+	filled, err := waitContext(ctx, REPLAY_FILL_TIMEOUT, func() bool {
+		return gens.areRingBuffsFilled() || nt.getError(&nt.errReplay) != nil
+	})
+	if err != nil {
+		return nil, 0, nt.abortReplay(err)
+	}
+	if filled == false {
+		Log(LOG_WARN, "Replay: ring buffers not filled within %s, starting "+
+			"anyway", REPLAY_FILL_TIMEOUT)
+	}
 
 	// trigger generators to start reading from ring buffers
 	nt.gens.start()
 
-	// wait a little bit so transmission fifos can fill up
-	time.Sleep(500 * time.Millisecond)
+	// wait until the transmission fifos have filled up. reading from the ring
+	// buffers pauses when the fifos are full, i.e. the read pointers do not
+	// advance anymore
+	rdPtrs := gens.getRingBuffRdPtrs()
+	filled, err = waitContext(ctx, REPLAY_FILL_TIMEOUT, func() bool {
+		rdPtrsPrev := rdPtrs
+		rdPtrs = gens.getRingBuffRdPtrs()
+		for i := range rdPtrs {
+			if rdPtrs[i] != rdPtrsPrev[i] {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, nt.abortReplay(err)
+	}
+	if filled == false {
+		Log(LOG_WARN, "Replay: transmission fifos not filled within %s, "+
+			"starting anyway", REPLAY_FILL_TIMEOUT)
+	}
+
+	return gens, replayDuration, nil
+}
+
+// replayRun starts the rate control modules of the generators prepared by
+// replayPrepare() and blocks until the replay has finished.
+func (nt *NetworkTester) replayRun(ctx context.Context, gens Generators, replayDuration time.Duration) error {
+	// the number of packets the generators transmitted is used to determine
+	// when the replay has finished
+	nPktsTXStart := gens.getPacketCountsTX()
 
 	// start rate control module to drain fifos and transmit packets with
 	// the timing denoted in the trace
-	nt.gens.startRateCtrl(nt.pcieBAR)
+	nt.gens.startRateCtrl(nt)
+	timeRateCtrlStart := time.Now()
+
+	// periodically report replay progress, if requested
+	var progressTick <-chan time.Time
+	if nt.replayProgressFunc != nil && nt.replayProgressInterval > 0 {
+		progressTicker := time.NewTicker(nt.replayProgressInterval)
+		defer progressTicker.Stop()
+		progressTick = progressTicker.C
+	}
 
 	// wait for generators to become inactive
+	activeTicker := time.NewTicker(STATUS_POLL_INTERVAL)
+	defer activeTicker.Stop()
 	for {
 		if nt.gens.areActive() == false {
 			// all generators finished draining data from the TX ring buffers
 			break
 		}
-		time.Sleep(time.Second)
+		if nt.getError(&nt.errReplay) != nil {
+			// ring buffers can not be refilled anymore
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nt.abortReplay(ctx.Err())
+		case <-progressTick:
+			nt.reportReplayProgress(gens)
+		case <-activeTicker.C:
+		}
 	}
 
 	// trigger the goroutines filling the ring buffers to stop and wait for them
 	// to complete
-	for i := 0; i < len(nt.pcieDMAWrite); i++ {
-		nt.stopReplay <- true
-	}
+	close(nt.stopReplay)
 	nt.syncReplay.Wait()
+	close(nt.stopReplayDone)
+
+	// print out the DMA throughput
+	nt.logDMAStats(nt.dmaWrite)
+
+	// abort replay if the ring buffers could not be filled
+	if err := nt.getError(&nt.errReplay); err != nil {
+		nt.gens.stopRateCtrl(nt)
+		return err
+	}
+
+	// the packet meta data of file-backed traces, which have not been parsed
+	// before, has been scanned while the trace data was read. their duration
+	// is known now
+	if duration := gens.getReplayDuration(); duration > replayDuration {
+		replayDuration = duration
+	}
 
 	//  -----------        -----------        --------------        -----
 	// | DRAM TX   |      | Block RAM |      | Rate Control |      | MAC |
@@ -302,91 +577,157 @@ func (nt *NetworkTester) StartReplay() {
 	// DRAM. however, it may still take some time until the rate control
 	// module actually finished the transmission of all packets, since it
 	// must enforce the inter-packet transmission times specified in the trace.
-	// in the meantime, packet data remains buffered in the block ram fifo. we
-	// wait a little bit to ensure that all packets have been sent to the MAC
-	// and the block ram fifo is empty.
-	//
-	// TODO: waiting only a single second may be too little, if inter-packet
-	// transmission times are larger than a second. choose sleep duration
-	// more more dynamically in the future.
-	time.Sleep(time.Second)
+	// in the meantime, packet data remains buffered in the block ram fifo.
+	// since the rate control modules enforce the inter-packet times of the
+	// traces, the last packet cannot be sent before the replay duration of the
+	// traces has passed since the rate control modules were started. we wait
+	// until then and afterwards poll the TX packet counters until the block
+	// ram fifo is empty.
+	drainDuration := time.Until(timeRateCtrlStart.Add(replayDuration))
+	if drainDuration < 0 {
+		drainDuration = 0
+	}
+	Log(LOG_DEBUG, "Replay: waiting %s for fifos to drain", drainDuration)
+	if err := sleepContext(ctx, drainDuration); err != nil {
+		return nt.abortReplay(err)
+	}
+	if err := nt.waitReplayDrained(ctx, gens, nPktsTXStart); err != nil {
+		return nt.abortReplay(err)
+	}
 
 	// stop the rate control module. at this point no packets will be read
 	// from the block ram fifo anymore
-	nt.gens.stopRateCtrl(nt.pcieBAR)
+	nt.gens.stopRateCtrl(nt)
+
+	// report final replay progress
+	if nt.replayProgressFunc != nil {
+		nt.reportReplayProgress(gens)
+	}
 
 	// if enabled, check the hardware's error registers. the error registers
 	// are set if the rate control was not able to enforce the inter-packet
 	// transmission times specified in the trace. This happens if the TX ring
 	// buffer can not be refilled or read in time, or if the trace specifies
-	// inter-packet transmission times that would exceed the 10 Gbps line rate
-	// of the network interfaces.
+	// inter-packet transmission times that would exceed the line rate of the
+	// network interfaces.
 	if nt.checkErrors {
-		nt.gens.checkErrors(true)
+		if err := nt.gens.checkErrors(); err != nil {
+			return err
+		}
 	}
 
 	Log(LOG_DEBUG, "Replay: done")
+
+	return nil
 }
 
 // StartCapture stats packet capturing on all configured interfaces. The
-// function is non-blocking.
-func (nt *NetworkTester) StartCapture() {
+// function is non-blocking. It returns an error if capturing is already
+// running.
+func (nt *NetworkTester) StartCapture() (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.captureStart()
+}
+
+// captureStart starts packet capturing on all configured interfaces. Unlike
+// StartCapture(), it does not apply the exit-on-error setting.
+func (nt *NetworkTester) captureStart() error {
+	if nt.isCaptureRunning() {
+		return ErrorCreate(ErrInvalidConfig, "NetworkTester: capture is "+
+			"already running")
+	}
+
 	// create a list holding all receivers for which traffic replay is enabled
 	var recvs Receivers
 	for _, recv := range nt.recvs {
 		if recv.captureEnable {
+			// make sure the configuration has been written to the hardware
+			if recv.ringBuffAddrRange == 0 {
+				return ErrorCreate(ErrInvalidConfig, "Receiver %d: no ring "+
+					"buffer assigned (WriteConfig() not called?)", recv.id)
+			}
 			recvs = append(recvs, recv)
 		}
 	}
 
-	// how many receivers will be served by each goroutine at most?
-	nRecvsPerGoroutine := int(math.Ceil(float64(len(recvs)) / float64(len(nt.pcieDMARead))))
-
-	// initialize a list, which for each goroutine will hold a list cotaining
-	// the receivers it serves
-	recvsPerGoroutine := make([]Receivers, len(nt.pcieDMARead))
+	// distribute the receivers among the DMA channels. each DMA channel is
+	// served by its own goroutine
+	recvsPerGoroutine := recvs.distribute(len(nt.dmaRead))
 
-	// assemble the list
-	iGoroutine := 0
-	for _, recv := range recvs {
-		recvsPerGoroutine[iGoroutine] = append(recvsPerGoroutine[iGoroutine], recv)
-
-		if len(recvsPerGoroutine[iGoroutine]) == nRecvsPerGoroutine {
-			iGoroutine++
-		}
+	// trigger hardware to start capturing
+	if err := nt.recvs.start(); err != nil {
+		// stop receivers that have already been started
+		nt.recvs.resetHardware()
+		nt.recvs.closeCaptureFiles()
+		return err
 	}
 
-	// set up goroutine synchronization for stopping later
+	// set up goroutine synchronization for stopping later. the goroutines are
+	// stopped by closing the channel
 	nt.stopCapture = make(chan bool)
-	nt.syncCapture.Add(len(nt.pcieDMARead))
+	nt.errCapture = nil
 
 	// start the goroutines, which will continuously read the ring buffers
 	for i, recvs := range recvsPerGoroutine {
-		go nt.capture(recvs, nt.pcieDMARead[i])
+		Log(LOG_DEBUG, "DMA c2h %d: receivers %d", i,
+			recvs.getIfIdsConfigured())
+		nt.syncCapture.Add(1)
+		go nt.capture(recvs, nt.dmaRead[i])
 	}
 
-	// trigger hardware to start capturing
-	nt.recvs.start()
+	return nil
 }
 
 // StopCapture stops the capturing of packet data and packet latency on all
-// configured receivers.
-func (nt *NetworkTester) StopCapture() {
+// configured receivers. It returns an error if capturing is not running, if
+// capture data could not be read from the ring buffers or if hardware error
+// checking is enabled and the hardware flagged an error.
+func (nt *NetworkTester) StopCapture() (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.captureStop()
+}
+
+// isCaptureRunning returns true, if capturing has been started and not been
+// stopped yet.
+func (nt *NetworkTester) isCaptureRunning() bool {
+	return nt.stopCapture != nil
+}
+
+// captureStop stops the capturing of packet data and packet latency on all
+// configured receivers. Unlike StopCapture(), it does not apply the
+// exit-on-error setting.
+func (nt *NetworkTester) captureStop() error {
+	if nt.isCaptureRunning() == false {
+		return ErrorCreate(ErrInvalidConfig, "NetworkTester: capture is "+
+			"not running (StartCapture() not called?)")
+	}
+
 	// trigger hardware to stop capturing
 	nt.recvs.stop()
 
 	// trigger the goroutines reading the ring buffers to stop and wait for them
 	// to complete
-	for i := 0; i < len(nt.pcieDMARead); i++ {
-		nt.stopCapture <- true
-	}
+	close(nt.stopCapture)
 	nt.syncCapture.Wait()
+	nt.stopCapture = nil
 
-	// drain remaining RX ring buffer contents (all through the same PCI Express
-	// device, as performance is not that critical here anymore)
+	// return error that occured while reading the ring buffers
+	if err := nt.getError(&nt.errCapture); err != nil {
+		nt.recvs.closeCaptureFiles()
+		return err
+	}
+
+	// drain remaining RX ring buffer contents (all through the same DMA
+	// channel, as performance is not that critical here anymore)
 	for _, recv := range nt.recvs {
 		for {
-			nBytesRead := recv.readRingBuff(true, nt.pcieDMARead[0])
+			nBytesRead, err := recv.readRingBuff(true, nt.dmaRead[0])
+			if err != nil {
+				nt.recvs.closeCaptureFiles()
+				return err
+			}
 
 			// no data has been read -> we are done
 			if nBytesRead == 0 {
@@ -395,18 +736,30 @@ func (nt *NetworkTester) StopCapture() {
 		}
 	}
 
+	// write remaining capture data to the capture files
+	if err := nt.recvs.closeCaptureFiles(); err != nil {
+		return err
+	}
+
 	// if enabled, check the hardware's error registers. the error registers
 	// are set if the RX ring buffer became full and the arriving traffic thus
 	// could not be captured.
 	if nt.checkErrors {
-		nt.recvs.checkErrors(true)
+		if err := nt.recvs.checkErrors(); err != nil {
+			return err
+		}
 	}
+
+	// print out the DMA throughput
+	nt.logDMAStats(nt.dmaRead)
+
+	return nil
 }
 
 // SetCheckErrors enables/disables hardware error checking. By default it is
-// enabled and the application aborts when an error occurs. If disabled, the
-// user can utilize the CheckErrors() function to manually check for errors and
-// gracefully handle them.
+// enabled and StartReplay() and StopCapture() return an error if the hardware
+// flagged one. If disabled, the user can utilize the CheckErrors() function to
+// manually check for errors and gracefully handle them.
 func (nt *NetworkTester) SetCheckErrors(checkErrors bool) {
 	nt.checkErrors = checkErrors
 }
@@ -425,31 +778,37 @@ func (nt *NetworkTester) SetTimestampTickPeriod(cyclesPerTick int) {
 // header of IPv4 or IPv6 packets (checksum or flowtlabel field respectively).
 // If the mode is set to 'TimestampModeFixedPos', the timestamp is inserted
 // in the packet data at a configurable byte position.
-func (nt *NetworkTester) SetTimestampMode(mode int) {
-	nt.timestamp.setMode(mode)
+func (nt *NetworkTester) SetTimestampMode(mode int) (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.timestamp.setMode(mode)
 }
 
 // SetTimestampPos specifies the byte position where the timestamp shall be
 // inserted in the packet data. It requires the timestamping mode to be set to
 // 'TimestampModeFixedPos'.
-func (nt *NetworkTester) SetTimestampPos(pos int) {
-	nt.timestamp.setPos(pos)
+func (nt *NetworkTester) SetTimestampPos(pos int) (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.timestamp.setPos(pos)
 }
 
 // SetTimestampWidth sets the width of the timestamp that is inserted in the
 // packet. Currently the values 16 and 24 (bits) are supported.
-func (nt *NetworkTester) SetTimestampWidth(width int) {
-	nt.timestamp.setWidth(width)
+func (nt *NetworkTester) SetTimestampWidth(width int) (err error) {
+	defer ErrorReturn(&err)
+
+	return nt.timestamp.setWidth(width)
 }
 
 // CheckErrors checks if the hardware flagged an error and returns an error
 // if one was detected. The user is responsible to handle the error, the
 // application does not abort. The function returns nil if no error occured.
 func (nt *NetworkTester) CheckErrors() error {
-	if err := nt.gens.checkErrors(false); err != nil {
+	if err := nt.gens.checkErrors(); err != nil {
 		return err
 	}
-	if err := nt.recvs.checkErrors(false); err != nil {
+	if err := nt.recvs.checkErrors(); err != nil {
 		return err
 	}
 
@@ -482,8 +841,8 @@ func (nt *NetworkTester) PrintDataratesStop() {
 
 // replay continuously fills the generator ring buffers. It must be started in
 // a goroutine. Expects the generators, whose ring buffers shall be filled, as
-// well as the PCI Express DMA device as an argument.
-func (nt *NetworkTester) replay(gens Generators, pcieDMA *gopcie.PCIeDMA) {
+// well as the DMA channel as an argument.
+func (nt *NetworkTester) replay(gens Generators, dma DMA) {
 	defer nt.syncReplay.Done()
 
 	var stop bool
@@ -500,14 +859,118 @@ func (nt *NetworkTester) replay(gens Generators, pcieDMA *gopcie.PCIeDMA) {
 		}
 
 		// write ring buffers
-		gens.writeRingBuffs(pcieDMA)
+		if err := gens.writeRingBuffs(dma); err != nil {
+			// record the error and wait until the goroutine is stopped
+			nt.setError(&nt.errReplay, err)
+			<-nt.stopReplay
+			break
+		}
 	}
 }
 
+// abortReplay aborts a running replay. The goroutines filling the ring buffers
+// must have been started before. They are stopped, the rate control modules
+// are deactivated and the hardware cores are reset. The function returns an
+// error wrapping the provided cause of the abort.
+func (nt *NetworkTester) abortReplay(cause error) error {
+	// stop the goroutines filling the ring buffers. they may already be
+	// stopped, if the abort happens while draining the block ram fifos
+	select {
+	case <-nt.stopReplayDone:
+	default:
+		close(nt.stopReplay)
+		nt.syncReplay.Wait()
+	}
+
+	// stop rate control and reset hardware cores
+	nt.gens.stopRateCtrl(nt)
+	nt.resetHardware()
+
+	Log(LOG_DEBUG, "Replay: aborted")
+
+	return ErrorCreate(cause, "Replay: aborted (%s)", cause.Error())
+}
+
+// waitReplayDrained polls the TX packet counters of the generators' network
+// interfaces until the rate control modules transmitted all packets remaining
+// in the block ram fifos, i.e. until the counters advanced by the packet counts
+// of the traces. If the packet count of a trace is unknown, or if the counters
+// stop advancing before, the function waits until the counters did not change
+// for REPLAY_DRAIN_IDLE_TIMEOUT. It returns the context's error if the context
+// is cancelled before.
+func (nt *NetworkTester) waitReplayDrained(ctx context.Context, gens Generators, nPktsStart []uint32) error {
+	nPktsPrev := gens.getPacketCountsTX()
+	timeChange := time.Now()
+
+	for {
+		nPkts := gens.getPacketCountsTX()
+		if gens.areDrained(nPktsStart, nPkts) {
+			return nil
+		}
+
+		for i := range nPkts {
+			if nPkts[i] != nPktsPrev[i] {
+				timeChange = time.Now()
+			}
+		}
+		nPktsPrev = nPkts
+
+		if time.Since(timeChange) >= REPLAY_DRAIN_IDLE_TIMEOUT {
+			Log(LOG_DEBUG, "Replay: TX packet counters idle for %s",
+				REPLAY_DRAIN_IDLE_TIMEOUT)
+			return nil
+		}
+
+		if err := sleepContext(ctx, STATUS_POLL_INTERVAL); err != nil {
+			return err
+		}
+	}
+}
+
+// reportReplayProgress calls the replay progress function with the progress
+// of the provided generators.
+func (nt *NetworkTester) reportReplayProgress(gens Generators) {
+	progress := make([]ReplayProgress, len(gens))
+	for i, gen := range gens {
+		progress[i] = gen.getProgress()
+	}
+	nt.replayProgressFunc(progress)
+}
+
+// sleepContext waits for the specified duration. It returns the context's
+// error if the context is cancelled before.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitContext polls the provided condition function in intervals of
+// STATUS_POLL_INTERVAL until it returns true or the timeout expires. It returns
+// false if the timeout expired before the condition became true, and the
+// context's error if the context is cancelled before.
+func waitContext(ctx context.Context, timeout time.Duration, cond func() bool) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for cond() == false {
+		if time.Now().Before(deadline) == false {
+			return false, nil
+		}
+		if err := sleepContext(ctx, STATUS_POLL_INTERVAL); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // capture continuously reads the receiver ring buffers. It must be started in
 // a goroutine. Expects the receivers, whose ring buffers shall be read, as
-// well as the PCI Express DMA device as an argument.
-func (nt *NetworkTester) capture(recvs Receivers, pcieDMA *gopcie.PCIeDMA) {
+// well as the DMA channel as an argument.
+func (nt *NetworkTester) capture(recvs Receivers, dma DMA) {
 	defer nt.syncCapture.Done()
 
 	var stop bool
@@ -524,150 +987,115 @@ func (nt *NetworkTester) capture(recvs Receivers, pcieDMA *gopcie.PCIeDMA) {
 		}
 
 		// read ring buffers
-		recvs.readRingBuffs(pcieDMA)
+		if err := recvs.readRingBuffs(dma); err != nil {
+			// record the error and wait until the goroutine is stopped
+			nt.setError(&nt.errCapture, err)
+			<-nt.stopCapture
+			break
+		}
 	}
 }
 
-// assignMemory assigns the FPGA board's DDR memory regions in which the
-// generation and capture ring buffers will be placed. Currently this is all
-// hard-coded and needs some improvements to make it more dynamic in the future.
-func (nt *NetworkTester) assignMemory() {
-	// TODO: this function is currently tailored for the NetFPGA-SUME with
-	// 8 GByte of memory (2x 4 GByte). For other memory configuration,
-	// adjuments need to be done here (and possible to hardware as well).
-	if ADDR_DDR_A != 0x0 || ADDR_DDR_B != 0x100000000 ||
-		ADDR_RANGE_DDR_A != 0xFFFFFFFF || ADDR_RANGE_DDR_B != 0xFFFFFFFF {
-		Log(LOG_ERR, "Current implementation only supports 2x 4 GByte "+
-			"NetFPGA-SUME configuration")
+// logDMAStats prints out the throughput of the provided DMA channels.
+func (nt *NetworkTester) logDMAStats(dmas []DMA) {
+	for _, dma := range dmas {
+		stats := dma.(*dmaChannel).getStats()
+
+		direction := "h2c"
+		if stats.Direction == DMADirectionC2H {
+			direction = "c2h"
+		}
+
+		Log(LOG_DEBUG, "DMA %s %d: %d bytes in %d transfers (%f Gbps)",
+			direction, stats.Channel, stats.NBytes, stats.NTransfers,
+			stats.GetThroughput()/1e9)
 	}
+}
 
-	// get the ids of the generators that are configured for traffic generation
-	genIds := nt.gens.getIfIdsConfigured()
-	nGens := len(genIds)
+// setError records an error that occured in a replay or capture goroutine.
+// Only the first error is recorded.
+func (nt *NetworkTester) setError(dst *error, err error) {
+	nt.errMutex.Lock()
+	defer nt.errMutex.Unlock()
 
-	// get the ids of the receivers that are configured for traffic capture
-	recvIds := nt.recvs.getIfIdsConfigured()
-	nRecvs := len(recvIds)
+	if *dst == nil {
+		*dst = err
+	}
+}
 
-	if nGens == 0 && nRecvs == 0 {
-		// nothing to do!
-		return
-	} else if nRecvs == 0 {
-		// we are only generating traffic
-		if nGens == 1 {
-			// only one generator -> assign entire DDR_A
-			nt.gens[genIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.gens[genIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_A
-		} else if nGens == 2 {
-			// one generator gets DDR_A, the other one DDR_B
-			nt.gens[genIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.gens[genIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_A
-			nt.gens[genIds[1]].ringBuffAddr = ADDR_DDR_B
-			nt.gens[genIds[1]].ringBuffAddrRange = ADDR_RANGE_DDR_B
-		} else if nGens == 3 {
-			// first two generators share DDR_A, third one gets DDR_B
-			nt.gens[genIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.gens[genIds[0]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.gens[genIds[1]].ringBuffAddr =
-				ADDR_DDR_A + (uint64(ADDR_RANGE_DDR_A)+1)/2
-			nt.gens[genIds[1]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.gens[genIds[2]].ringBuffAddr = ADDR_DDR_B
-			nt.gens[genIds[2]].ringBuffAddrRange = ADDR_RANGE_DDR_B
-		} else if nGens == 4 {
-			// first two generators share DDR_A, third and fourth share DDR_B
-			nt.gens[genIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.gens[genIds[0]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.gens[genIds[1]].ringBuffAddr =
-				ADDR_DDR_A + (uint64(ADDR_RANGE_DDR_A)+1)/2
-			nt.gens[genIds[1]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.gens[genIds[2]].ringBuffAddr = ADDR_DDR_B
-			nt.gens[genIds[2]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_B)+1)/2 - 1)
-			nt.gens[genIds[3]].ringBuffAddr =
-				ADDR_DDR_B + (uint64(ADDR_RANGE_DDR_B)+1)/2
-			nt.gens[genIds[3]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_B)+1)/2 - 1)
+// getError returns an error recorded by a replay or capture goroutine.
+func (nt *NetworkTester) getError(src *error) error {
+	nt.errMutex.Lock()
+	defer nt.errMutex.Unlock()
+
+	return *src
+}
+
+// assignMemory assigns the memory regions of the FPGA board's memory banks in
+// which the generation and capture ring buffers will be placed (see
+// memoryAllocate()).
+func (nt *NetworkTester) assignMemory() error {
+	nt.memoryLayout = nil
+
+	// collect the memory requirements of the generators that are configured
+	// for traffic generation and the receivers that are configured for
+	// traffic capture
+	var reqs []memoryRequest
+	for _, gen := range nt.gens {
+		if gen.trace != nil {
+			reqs = append(reqs, gen.getMemoryRequest())
 		}
-	} else if nGens == 0 {
-		// we only capture traffic
-		if nRecvs == 1 {
-			// only one receiver -> assign entire DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_A
-		} else if nRecvs == 2 {
-			// one receiver gets DDR_A, the other one DDR_B
-			nt.recvs[recvIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_A
-			nt.recvs[recvIds[1]].ringBuffAddr = ADDR_DDR_B
-			nt.recvs[recvIds[1]].ringBuffAddrRange = ADDR_RANGE_DDR_B
-		} else if nRecvs == 3 {
-			// first two receivers share DDR_A, third one gets DDR_B
-			nt.recvs[recvIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.recvs[recvIds[1]].ringBuffAddr =
-				ADDR_DDR_A + (uint64(ADDR_RANGE_DDR_A)+1)/2
-			nt.recvs[recvIds[1]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.recvs[recvIds[2]].ringBuffAddr = ADDR_DDR_B
-			nt.recvs[recvIds[2]].ringBuffAddrRange = ADDR_RANGE_DDR_B
-		} else if nRecvs == 4 {
-			// first two receivers share DDR_A, third and fourth share DDR_B
-			nt.recvs[recvIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.recvs[recvIds[1]].ringBuffAddr =
-				ADDR_DDR_A + (uint64(ADDR_RANGE_DDR_A)+1)/2
-			nt.recvs[recvIds[1]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_A)+1)/2 - 1)
-			nt.recvs[recvIds[2]].ringBuffAddr = ADDR_DDR_B
-			nt.recvs[recvIds[2]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_B)+1)/2 - 1)
-			nt.recvs[recvIds[3]].ringBuffAddr =
-				ADDR_DDR_B + (uint64(ADDR_RANGE_DDR_B)+1)/2
-			nt.recvs[recvIds[3]].ringBuffAddrRange =
-				uint32((uint64(ADDR_RANGE_DDR_B)+1)/2 - 1)
+	}
+	for _, recv := range nt.recvs {
+		if recv.captureEnable {
+			reqs = append(reqs, recv.getMemoryRequest())
 		}
-	} else {
-		// we are generating and capturing
-		if nGens == 1 && nRecvs == 1 {
-			// generator gets DDR_A, receiver gets DDR_B
-			nt.gens[genIds[0]].ringBuffAddr = ADDR_DDR_A
-			nt.gens[genIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_A
-			nt.recvs[recvIds[0]].ringBuffAddr = ADDR_DDR_B
-			nt.recvs[recvIds[0]].ringBuffAddrRange = ADDR_RANGE_DDR_B
+	}
+
+	if len(reqs) == 0 {
+		// nothing to do!
+		return nil
+	}
+
+	regions, err := memoryAllocate(nt.memoryBanks, reqs)
+	if err != nil {
+		return err
+	}
+
+	// assign the memory regions
+	for _, region := range regions {
+		if region.Type == MemoryRegionGenerator {
+			nt.gens[region.ID].ringBuffAddr = region.Addr
+			nt.gens[region.ID].ringBuffAddrRange = uint32(region.Size - 1)
 		} else {
-			// each generator gets 1 Gbyte for generation in DDR_A, each
-			// receiver gets 1 Gbyte for capture in DDR_B
-			for i := 0; i < nGens; i++ {
-				nt.gens[genIds[i]].ringBuffAddr =
-					ADDR_DDR_A + uint64(i*(1024*1024*1024))
-				nt.gens[genIds[i]].ringBuffAddrRange = (1024 * 1024 * 1024) - 1
-			}
-			for i := 0; i < nRecvs; i++ {
-				nt.recvs[recvIds[i]].ringBuffAddr =
-					ADDR_DDR_B + uint64(i*(1024*1024*1024))
-				nt.recvs[recvIds[i]].ringBuffAddrRange =
-					(1024 * 1024 * 1024) - 1
-			}
+			nt.recvs[region.ID].ringBuffAddr = region.Addr
+			nt.recvs[region.ID].ringBuffAddrRange = uint32(region.Size - 1)
 		}
+
+		Log(LOG_DEBUG, "%s: ring buffer in memory bank %d, addr 0x%016x, "+
+			"size %d bytes", memoryName(region.Type, region.ID), region.Bank,
+			region.Addr, region.Size)
 	}
+
+	nt.memoryLayout = regions
+
+	return nil
 }
 
 // configHardware triggers the hardware core configuration.
-func (nt *NetworkTester) configHardware() {
+func (nt *NetworkTester) configHardware() error {
 	// write generator configuration to hardware
-	nt.gens.configHardware()
+	if err := nt.gens.configHardware(); err != nil {
+		return err
+	}
 
 	// write receiver configuration to hardware
-	nt.recvs.configHardware()
+	if err := nt.recvs.configHardware(); err != nil {
+		return err
+	}
 
 	// write timestamp configuration to hardware
-	nt.timestamp.configHardware()
+	return nt.timestamp.configHardware()
 }
 
 // resetHardware triggers a reset of all hardware cores. The reset does not
@@ -684,33 +1112,36 @@ func (nt *NetworkTester) resetHardware() {
 
 	// disable rate control modules in case they are still active after an
 	// erroneous  measurement
-	nt.gens.stopRateCtrl(nt.pcieBAR)
+	nt.gens.stopRateCtrl(nt)
 
 	// trigger global hardware reset
-	nt.pcieBAR.Write(ADDR_BASE_NT_CTRL+CPUREG_OFFSET_NT_CTRL_RST, 0x1)
-	nt.pcieBAR.Write(ADDR_BASE_NT_CTRL+CPUREG_OFFSET_NT_CTRL_RST, 0x0)
+	nt.bar.Write(nt.board.AddrBaseCtrl+CPUREG_OFFSET_NT_CTRL_RST, 0x1)
+	nt.bar.Write(nt.board.AddrBaseCtrl+CPUREG_OFFSET_NT_CTRL_RST, 0x0)
 }
 
 // checkVersion ensures that the software version matches the hardware version
-// of the network tester. It returns an error and aborts the application if a
-// mismatch was detected.
-func (nt *NetworkTester) checkVersion() {
-	ident := nt.pcieBAR.Read(ADDR_BASE_NT_IDENT + CPUREG_OFFSET_NT_IDENT_IDENT)
+// of the network tester. It returns an error if a mismatch was detected.
+func (nt *NetworkTester) checkVersion() error {
+	ident := nt.bar.Read(nt.board.AddrBaseIdent + CPUREG_OFFSET_NT_IDENT_IDENT)
 
 	hwCRC16 := (ident >> 16) & 0xFFFF
 	hwVersion := ident & 0xFFFF
 
-	if hwCRC16 != HW_CRC16 {
-		Log(LOG_ERR, "Hardware CRC16 is 0x%04x, expected 0x%04x",
-			hwCRC16, HW_CRC16)
+	if hwCRC16 != nt.board.HWCRC16 {
+		return ErrorCreate(ErrHardwareMismatch,
+			"Hardware CRC16 is 0x%04x, expected 0x%04x", hwCRC16,
+			nt.board.HWCRC16)
 	}
 
-	if hwVersion != HW_VERSION {
-		Log(LOG_ERR, "Hardware version is 0x%04x, expected 0x%04x",
-			hwVersion, HW_VERSION)
+	if hwVersion != nt.board.HWVersion {
+		return ErrorCreate(ErrHardwareMismatch,
+			"Hardware version is 0x%04x, expected 0x%04x", hwVersion,
+			nt.board.HWVersion)
 	}
 
 	Log(LOG_DEBUG, "Network tester hardware version: 0x%04x", hwVersion)
+
+	return nil
 }
 
 // printDatarates periodically prints out RX and TX data rates of all network
@@ -0,0 +1,244 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Enumeration of the network tester boards installed in the host via sysfs.
+// Each board is identified by its PCI Express address. Since gopcie always
+// opens the first board matching the vendor and device IDs, the BAR of a
+// board selected by its address is memory-mapped through its sysfs resource
+// file.
+
+package gofluent10g
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// sysfs directory listing the PCI Express devices. It can be replaced by a
+// directory with the same structure for testing
+var pcieSysfsDevices = PCIE_SYSFS_DEVICES
+
+// pcieSysfsBAR provides register access to a BAR that is memory-mapped via
+// its sysfs resource file.
+type pcieSysfsBAR struct {
+	mem []byte
+}
+
+// BoardsEnumerate returns one board profile for each network tester board
+// installed in the host, which matches the PCI Express IDs of the provided
+// profile. The returned profiles are copies of the provided profile, which
+// have the PCI address of the board set. They are ordered by PCI address, so
+// that board indices remain the same as long as the hardware setup does not
+// change.
+func BoardsEnumerate(board BoardProfile) (_ []BoardProfile, err error) {
+	defer ErrorReturn(&err)
+
+	addrs, err := pcieSysfsEnumerate(board.PCIeVendorID, board.PCIeDeviceID,
+		board.PCIeBARFunctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	boards := make([]BoardProfile, len(addrs))
+	for i, addr := range addrs {
+		boards[i] = board
+		boards[i].PCIeAddr = addr
+	}
+
+	Log(LOG_DEBUG, "Board %s: found %d board(s) %s", board.Name, len(addrs),
+		addrs)
+
+	return boards, nil
+}
+
+// BoardGetByIndex returns the profile of the index-th network tester board
+// installed in the host (see BoardsEnumerate()).
+func BoardGetByIndex(board BoardProfile, index int) (_ BoardProfile, err error) {
+	defer ErrorReturn(&err)
+
+	boards, err := BoardsEnumerate(board)
+	if err != nil {
+		return BoardProfile{}, err
+	}
+
+	if index < 0 || index >= len(boards) {
+		return BoardProfile{}, ErrorCreate(ErrHardwareAccess, "Board %s: "+
+			"invalid index %d, found %d board(s)", board.Name, index,
+			len(boards))
+	}
+
+	return boards[index], nil
+}
+
+// pcieSysfsEnumerate returns the sorted PCI addresses of all devices with the
+// specified vendor ID, device ID and function number.
+func pcieSysfsEnumerate(vendorID, deviceID, functionID int) ([]string, error) {
+	entries, err := ioutil.ReadDir(pcieSysfsDevices)
+	if err != nil {
+		return nil, ErrorCreate(ErrHardwareAccess, "could not list PCI "+
+			"devices (%s)", err.Error())
+	}
+
+	var addrs []string
+	for _, entry := range entries {
+		addr := entry.Name()
+
+		// address is formatted as domain:bus:device.function
+		pos := strings.LastIndex(addr, ".")
+		if pos < 0 {
+			continue
+		}
+		function, err := strconv.ParseInt(addr[pos+1:], 16, 32)
+		if err != nil || int(function) != functionID {
+			continue
+		}
+
+		vendor, err := pcieSysfsReadID(addr, "vendor")
+		if err != nil || vendor != vendorID {
+			continue
+		}
+		device, err := pcieSysfsReadID(addr, "device")
+		if err != nil || device != deviceID {
+			continue
+		}
+
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	return addrs, nil
+}
+
+// pcieSysfsReadID reads a hexadecimal ID (e.g. vendor or device ID) of the
+// PCI device with the specified address.
+func pcieSysfsReadID(addr string, name string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(pcieSysfsDevices, addr,
+		name))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(string(data)), 0, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// pcieSysfsXDMAPrefix returns the XDMA device name prefix (e.g. /dev/xdma1) of
+// the PCI device with the specified address. The XDMA driver numbers its
+// devices in the order in which it probed the boards.
+func pcieSysfsXDMAPrefix(addr string) (string, error) {
+	matches, _ := filepath.Glob(filepath.Join(pcieSysfsDevices, addr, "xdma",
+		"xdma*_h2c_0"))
+	if len(matches) == 0 {
+		return "", ErrorCreate(ErrHardwareAccess, "no XDMA device found for "+
+			"PCI device %s", addr)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(matches[0]), "_h2c_0")
+	return filepath.Join("/dev", name), nil
+}
+
+// pcieSysfsBAROpen memory-maps the BAR with the specified ID of the PCI
+// device with the specified address.
+func pcieSysfsBAROpen(addr string, barID int) (*pcieSysfsBAR, error) {
+	filename := filepath.Join(pcieSysfsDevices, addr,
+		"resource"+strconv.Itoa(barID))
+
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_SYNC, 0)
+	if err != nil {
+		return nil, ErrorCreate(ErrHardwareAccess, "could not open BAR %d "+
+			"of PCI device %s (%s)", barID, addr, err.Error())
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, ErrorCreate(ErrHardwareAccess, "could not open BAR %d "+
+			"of PCI device %s (%s)", barID, addr, err.Error())
+	}
+
+	// mapping remains valid after the file has been closed
+	mem, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, ErrorCreate(ErrHardwareAccess, "could not map BAR %d "+
+			"of PCI device %s (%s)", barID, addr, err.Error())
+	}
+
+	Log(LOG_DEBUG, "PCI device %s: mapped BAR %d", addr, barID)
+
+	return &pcieSysfsBAR{mem: mem}, nil
+}
+
+// Read reads a register value. Like a PCI Express read request to an invalid
+// address, reading an address outside of the BAR or not aligned to 4 byte
+// returns 0xFFFFFFFF.
+func (bar *pcieSysfsBAR) Read(addr uint32) uint32 {
+	if bar.checkAddr(addr) == false {
+		return 0xFFFFFFFF
+	}
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(&bar.mem[addr])))
+}
+
+// Write writes a register value. Writes to an address outside of the BAR or
+// not aligned to 4 byte are dropped.
+func (bar *pcieSysfsBAR) Write(addr uint32, data uint32) {
+	if bar.checkAddr(addr) == false {
+		return
+	}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&bar.mem[addr])), data)
+}
+
+// checkAddr returns true, if a register can be accessed at the specified
+// address, i.e. the address is aligned to 4 byte and the register is located
+// within the mapped BAR memory. Otherwise a warning is logged.
+func (bar *pcieSysfsBAR) checkAddr(addr uint32) bool {
+	if addr%4 != 0 || uint64(addr)+4 > uint64(len(bar.mem)) {
+		Log(LOG_WARN, "PCI device: invalid BAR register address 0x%08x "+
+			"(BAR size: %d bytes)", addr, len(bar.mem))
+		return false
+	}
+	return true
+}
+
+// Close unmaps the BAR.
+func (bar *pcieSysfsBAR) Close() {
+	if bar.mem != nil {
+		syscall.Munmap(bar.mem)
+		bar.mem = nil
+	}
+}
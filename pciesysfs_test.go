@@ -0,0 +1,172 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the enumeration of network tester boards and the BAR access via a
+// directory mimicking the sysfs PCI device listing.
+
+package gofluent10g
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSysfsDevice creates the sysfs directory of a PCI device with the
+// specified vendor and device ID.
+func testSysfsDevice(t *testing.T, dir string, addr string, vendor string, device string) {
+	if err := os.MkdirAll(filepath.Join(dir, addr), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"vendor": vendor, "device": device}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, addr, name),
+			[]byte(content+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testSysfsCreate replaces the sysfs directory by a temporary directory for
+// the duration of the test.
+func testSysfsCreate(t *testing.T) string {
+	dir := t.TempDir()
+	pcieSysfsDevicesPrev := pcieSysfsDevices
+	pcieSysfsDevices = dir
+	t.Cleanup(func() {
+		pcieSysfsDevices = pcieSysfsDevicesPrev
+	})
+	return dir
+}
+
+// TestBoardsEnumerate checks that only boards matching the PCI Express IDs of
+// the board profile are found and that they are ordered by their address.
+func TestBoardsEnumerate(t *testing.T) {
+	dir := testSysfsCreate(t)
+	testSysfsDevice(t, dir, "0000:03:00.0", "0x10ee", "0x7032")
+	testSysfsDevice(t, dir, "0000:01:00.0", "0x10ee", "0x7032")
+	testSysfsDevice(t, dir, "0000:02:00.0", "0x10ee", "0x7028")
+	testSysfsDevice(t, dir, "0000:04:00.0", "0x8086", "0x7032")
+	testSysfsDevice(t, dir, "0000:05:00.1", "0x10ee", "0x7032")
+
+	boards, err := BoardsEnumerate(BoardNetFPGASUME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs []string
+	for _, board := range boards {
+		addrs = append(addrs, board.PCIeAddr)
+		if board.Name != BoardNetFPGASUME.Name {
+			t.Fatalf("board %s: profile not copied", board.PCIeAddr)
+		}
+	}
+	addrsExp := []string{"0000:01:00.0", "0000:03:00.0"}
+	if reflect.DeepEqual(addrs, addrsExp) == false {
+		t.Fatalf("found boards %v, expected %v", addrs, addrsExp)
+	}
+
+	board, err := BoardGetByIndex(BoardNetFPGASUME, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board.PCIeAddr != "0000:03:00.0" {
+		t.Fatalf("board 1 has address %s, expected 0000:03:00.0",
+			board.PCIeAddr)
+	}
+	if _, err := BoardGetByIndex(BoardNetFPGASUME, 2); err == nil {
+		t.Fatal("expected error for invalid board index")
+	}
+}
+
+// TestPCIeSysfsXDMAPrefix checks that the XDMA device prefix of a board is
+// looked up in its sysfs directory.
+func TestPCIeSysfsXDMAPrefix(t *testing.T) {
+	dir := testSysfsCreate(t)
+	testSysfsDevice(t, dir, "0000:01:00.0", "0x10ee", "0x7032")
+
+	if _, err := pcieSysfsXDMAPrefix("0000:01:00.0"); err == nil {
+		t.Fatal("expected error for board without XDMA device")
+	}
+
+	err := os.MkdirAll(filepath.Join(dir, "0000:01:00.0", "xdma",
+		"xdma1_h2c_0"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := pcieSysfsXDMAPrefix("0000:01:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "/dev/xdma1" {
+		t.Fatalf("XDMA device prefix %s, expected /dev/xdma1", prefix)
+	}
+}
+
+// TestPCIeSysfsBAR maps the resource file of a board and checks register
+// reads and writes.
+func TestPCIeSysfsBAR(t *testing.T) {
+	dir := testSysfsCreate(t)
+	testSysfsDevice(t, dir, "0000:01:00.0", "0x10ee", "0x7032")
+
+	if _, err := pcieSysfsBAROpen("0000:01:00.0", 0); err == nil {
+		t.Fatal("expected error for missing resource file")
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "0000:01:00.0", "resource0"),
+		make([]byte, 4096), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := pcieSysfsBAROpen("0000:01:00.0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bar.Close()
+
+	bar.Write(0x10, 0xDEADBEEF)
+	if data := bar.Read(0x10); data != 0xDEADBEEF {
+		t.Fatalf("read 0x%08x, expected 0xdeadbeef", data)
+	}
+	if data := bar.Read(0x14); data != 0x0 {
+		t.Fatalf("read 0x%08x, expected 0x0", data)
+	}
+
+	// accesses outside of the BAR or not aligned to 4 byte are dropped
+	for _, addr := range []uint32{0x11, 0xFFE, 0x1000, 0xFFFFFFFC} {
+		bar.Write(addr, 0x12345678)
+		if data := bar.Read(addr); data != 0xFFFFFFFF {
+			t.Fatalf("read 0x%08x at 0x%08x, expected 0xffffffff", data,
+				addr)
+		}
+	}
+	if data := bar.Read(0x10); data != 0xDEADBEEF {
+		t.Fatalf("read 0x%08x, expected 0xdeadbeef", data)
+	}
+}
@@ -37,11 +37,8 @@ package gofluent10g
 
 import (
 	"encoding/binary"
-	"fmt"
 	"net"
 	"time"
-
-	"github.com/aoeldemann/gopcie"
 )
 
 // Receiver is the struct providing methods for configuring the traffic capture
@@ -57,6 +54,11 @@ type Receiver struct {
 	captureLength int  // per-packet packet data capture length
 	hostMemSize   int  // amount of memory to reserve for capturing
 
+	// if set, capture data is streamed to this file instead of being held in
+	// host memory
+	captureFilename   string
+	captureFileFormat int
+
 	capture *Capture // capture instance
 
 	// ring buffer memory address, size and read pointer position
@@ -64,6 +66,11 @@ type Receiver struct {
 	ringBuffAddrRange uint32 // ring buffer must never be larger than 4 GByte
 	ringBuffRdPtr     uint32
 
+	// requested ring buffer size (zero if determined by weight) and weight
+	// (zero if default weight)
+	ringBuffSizeReq uint64
+	ringBuffWeight  float64
+
 	// packet filter destination MAC address and mask
 	filterMACAddrDst     net.HardwareAddr
 	filterMACAddrMaskDst uint64
@@ -76,25 +83,29 @@ type Receiver struct {
 // captured. For caplen > 0, packet data and meta data is captured. hostMemSize
 // determines the size of the memory to be reserved in host memory for capture
 // data.
-func (recv *Receiver) EnableCapture(caplen int, hostMemSize int) {
+func (recv *Receiver) EnableCapture(caplen int, hostMemSize int) (err error) {
+	defer ErrorReturn(&err)
+
 	// make sure capture length is within a reasonable range
 	if caplen < 0 || caplen > 1518 {
-		Log(LOG_ERR, "Receiver %d: capture length must be in the range of "+
-			"0 and 1518 bytes", recv.id)
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: capture length "+
+			"must be in the range of 0 and 1518 bytes", recv.id)
 	}
 
-	// save capture parameters
-	recv.captureEnable = true
-	recv.captureLength = caplen
-
 	// make sure hostMemSize is at least one DMA transfer block size. zero is
 	// fine as well, because in that case captured data is simply discarded
 	// after it has been fetched from the software
 	if hostMemSize != 0 && hostMemSize < RING_BUFF_RD_TRANSFER_SIZE_MIN {
-		Log(LOG_ERR, "Receiver %d: host memory capture size must be at "+
-			"least %d bytes", recv.id, RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: host memory "+
+			"capture size must be at least %d bytes", recv.id,
+			RING_BUFF_RD_TRANSFER_SIZE_MIN)
 	}
 
+	// save capture parameters
+	recv.captureEnable = true
+	recv.captureLength = caplen
+	recv.captureFilename = ""
+
 	// host memory size must be a multiple of 64 bytes
 	if hostMemSize%64 != 0 {
 		hostMemSize = 64 * (hostMemSize/64 + 1)
@@ -102,6 +113,38 @@ func (recv *Receiver) EnableCapture(caplen int, hostMemSize int) {
 
 	// save host memory size
 	recv.hostMemSize = hostMemSize
+
+	return nil
+}
+
+// EnableCaptureToFile enables packet capturing and streams the captured data
+// to a file while capturing is active, instead of holding it in host memory.
+// caplen determines the per-packet capture length (see EnableCapture). format
+// selects the file format, either CaptureFileFormatRaw (same format as written
+// by Capture.WriteToFile) or CaptureFileFormatPcap.
+func (recv *Receiver) EnableCaptureToFile(caplen int, filename string, format int) (err error) {
+	defer ErrorReturn(&err)
+
+	// make sure capture length is within a reasonable range
+	if caplen < 0 || caplen > 1518 {
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: capture length "+
+			"must be in the range of 0 and 1518 bytes", recv.id)
+	}
+
+	// make sure the file format is valid
+	if format != CaptureFileFormatRaw && format != CaptureFileFormatPcap {
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: invalid capture "+
+			"file format", recv.id)
+	}
+
+	// save capture parameters
+	recv.captureEnable = true
+	recv.captureLength = caplen
+	recv.captureFilename = filename
+	recv.captureFileFormat = format
+	recv.hostMemSize = 0
+
+	return nil
 }
 
 // DisableCapture disabled packet capturing.
@@ -110,68 +153,134 @@ func (recv *Receiver) DisableCapture() {
 	recv.capture = nil
 }
 
+// SetRingBuffSize sets the size of the receiver's RX ring buffer in the DRAM
+// of the FPGA board. The size must be a multiple of 16384 bytes and larger
+// than RING_BUFF_RD_TRANSFER_SIZE_MIN. If the size is zero (default), the
+// ring buffer gets a share of the memory that is proportional to its weight
+// (see SetRingBuffWeight()). Memory is assigned when WriteConfig() is called.
+func (recv *Receiver) SetRingBuffSize(size uint64) (err error) {
+	defer ErrorReturn(&err)
+
+	if size != 0 {
+		err := memoryRingBuffSizeCheck(
+			memoryName(MemoryRegionReceiver, recv.id), size,
+			RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		if err != nil {
+			return err
+		}
+	}
+	recv.ringBuffSizeReq = size
+	return nil
+}
+
+// SetRingBuffWeight sets the weight of the receiver's RX ring buffer. Ring
+// buffers without an explicit size share the free memory of the memory bank
+// they are placed in according to their weights. The default weight is 1.
+func (recv *Receiver) SetRingBuffWeight(weight float64) (err error) {
+	defer ErrorReturn(&err)
+
+	if weight <= 0 {
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: ring buffer "+
+			"weight must be larger than zero", recv.id)
+	}
+	recv.ringBuffWeight = weight
+	return nil
+}
+
+// getMemoryRequest returns the memory requirements of the receiver's RX ring
+// buffer.
+func (recv *Receiver) getMemoryRequest() memoryRequest {
+	weight := recv.ringBuffWeight
+	if weight == 0 {
+		weight = 1
+	}
+	return memoryRequest{
+		typ:          MemoryRegionReceiver,
+		id:           recv.id,
+		size:         recv.ringBuffSizeReq,
+		weight:       weight,
+		transferSize: RING_BUFF_RD_TRANSFER_SIZE_MIN,
+	}
+}
+
 // GetCapture returns Capture instance assigned to the receiver.
-func (recv *Receiver) GetCapture() *Capture {
+func (recv *Receiver) GetCapture() (_ *Capture, err error) {
+	defer ErrorReturn(&err)
+
 	if recv.captureEnable == false {
-		Log(LOG_ERR, "Receiver %d: could not get Capture struct, because "+
-			"capturing is disabled", recv.id)
+		return nil, ErrorCreate(ErrInvalidConfig, "Receiver %d: could not "+
+			"get Capture struct, because capturing is disabled", recv.id)
 	}
 
-	return recv.capture
+	return recv.capture, nil
 }
 
 // SetFilterMacAddrDst sets the destination MAC address and mask by which
 // arriving packets shall be filtered.
-func (recv *Receiver) SetFilterMacAddrDst(addr string, addrMask uint64) {
+func (recv *Receiver) SetFilterMacAddrDst(addr string, addrMask uint64) (err error) {
+	defer ErrorReturn(&err)
+
 	if recv.captureEnable == false {
-		Log(LOG_ERR, "Receiver %d: could not set filter destination MAC "+
-			"address, because capturing is disabled", recv.id)
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: could not set "+
+			"filter destination MAC address, because capturing is disabled",
+			recv.id)
 	}
 
 	filterMACAddrDst, err := net.ParseMAC(addr)
 	if err != nil {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Receiver %d: could not parse filter mac destination address",
 			recv.id)
 	}
 
 	if addrMask > 0xFFFFFFFFFFFF {
-		Log(LOG_ERR, "Receiver %d: invalid mac address filter destination mask",
+		return ErrorCreate(ErrInvalidConfig,
+			"Receiver %d: invalid mac address filter destination mask",
 			recv.id)
 	}
 
 	recv.filterMACAddrDst = filterMACAddrDst
 	recv.filterMACAddrMaskDst = addrMask
+
+	return nil
 }
 
 // DisableFilterMacAddrDst disables packet filtering by MAC destination address.
-func (recv *Receiver) DisableFilterMacAddrDst() {
+func (recv *Receiver) DisableFilterMacAddrDst() (err error) {
+	defer ErrorReturn(&err)
+
 	if recv.captureEnable == false {
-		Log(LOG_ERR, "Receiver %d: could not clear filter destination MAC "+
-			"address, because capturing is disabled", recv.id)
+		return ErrorCreate(ErrInvalidConfig, "Receiver %d: could not clear "+
+			"filter destination MAC address, because capturing is disabled",
+			recv.id)
 	}
 
 	recv.filterMACAddrDst = nil
+
+	return nil
 }
 
 // GetPacketCountCaptured returns the number of packets that were captured.
-func (recv *Receiver) GetPacketCountCaptured() int {
+func (recv *Receiver) GetPacketCountCaptured() (_ int, err error) {
+	defer ErrorReturn(&err)
+
 	if recv.captureEnable == false {
-		Log(LOG_ERR, "Receiver %d: could not obtain number of captured "+
-			"packets, because capturing is disabled", recv.id)
+		return 0, ErrorCreate(ErrInvalidConfig, "Receiver %d: could not "+
+			"obtain number of captured packets, because capturing is "+
+			"disabled", recv.id)
 	}
 
-	nPkts := recv.nt.pcieBAR.Read(ADDR_BASE_NT_RECV_CAPTURE[recv.id] +
+	nPkts := recv.nt.bar.Read(recv.nt.board.AddrBaseRecvCapture[recv.id] +
 		CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_PKT_CNT)
-	return int(nPkts)
+	return int(nPkts), nil
 }
 
 // configHardware writes the software configuration of the receiver down to the
 // hardware core.
-func (recv *Receiver) configHardware() {
+func (recv *Receiver) configHardware() error {
 	// nothing to do here if no capturing is activated for this receiver
 	if recv.captureEnable == false {
-		return
+		return nil
 	}
 
 	// calculate ring buffer size
@@ -179,21 +288,21 @@ func (recv *Receiver) configHardware() {
 
 	// the ring buffer size must be larger than 16384 bytes
 	if ringBuffSize <= 16384 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Receiver %d: ring buffer size must be larger than 16384 bytes.",
 			recv.id)
 	}
 
 	// the ring buffer size must be a multiple of (2*8192) bytes
 	if ringBuffSize%16384 != 0 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Receiver %d: ring buffer size must be a multiple of 16384 bytes.",
 			recv.id)
 	}
 
 	// the ring buffer transfer size must be a multiple of 16384 bytes
 	if RING_BUFF_RD_TRANSFER_SIZE_MIN%16384 != 0 {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Receiver %d: ring buffer transfer size must be a multiple of "+
 				"16384 bytes.", recv.id)
 	}
@@ -201,28 +310,28 @@ func (recv *Receiver) configHardware() {
 	// the ring buffer transfer size must be smaller than the ring buffer
 	// size
 	if ringBuffSize <= RING_BUFF_RD_TRANSFER_SIZE_MIN {
-		Log(LOG_ERR,
+		return ErrorCreate(ErrInvalidConfig,
 			"Receiver %d: ring buffer transfer size must be smaller than ring "+
 				"buffer size", recv.id)
 	}
 
-	// get pcie bar access module
-	pcieBAR := recv.nt.pcieBAR
+	// get register access module
+	bar := recv.nt.bar
 
 	// write ring buffer memory region infos to receiver
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_ADDR_HI,
 		uint32(recv.ringBuffAddr>>32))
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_ADDR_LO,
 		uint32(recv.ringBuffAddr&0xFFFFFFFF))
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_RANGE,
 		recv.ringBuffAddrRange)
 
 	// reset ring buffer read pointer
 	recv.ringBuffRdPtr = 0x0
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ADDR_RD, recv.ringBuffRdPtr)
 
 	Log(LOG_DEBUG,
@@ -230,7 +339,7 @@ func (recv *Receiver) configHardware() {
 		recv.id, recv.ringBuffAddr, recv.ringBuffAddrRange)
 
 	// configure maximum capture length
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MAX_LEN_CAPTURE,
 		uint32(recv.captureLength))
 
@@ -249,26 +358,28 @@ func (recv *Receiver) configHardware() {
 		addrMaskHi := binary.LittleEndian.Uint16(addrMaskByte[6:8])
 		addrMaskLo := binary.LittleEndian.Uint32(addrMaskByte[2:6])
 
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_DST_HI, uint32(addrHi))
 
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_DST_LO, addrLo)
 
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_HI,
 			uint32(addrMaskHi))
 
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_LO,
 			addrMaskLo)
 	} else {
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_HI, 0)
 
-		recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_FILTER_MAC[recv.id]+
+		recv.nt.bar.Write(recv.nt.board.AddrBaseRecvFilterMAC[recv.id]+
 			CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_LO, 0)
 	}
+
+	return nil
 }
 
 // readRingBuff reads capture data from the receiver's RX ring buffer in the
@@ -278,11 +389,11 @@ func (recv *Receiver) configHardware() {
 // until the end of the ring buffer are smaller than
 // RING_BUFF_RD_TRANSFER_SIZE_MIN. If the parameter readAll is set to true, the
 // minimum transfer size is ignored and the function reads as many bytes as it
-// can get. Also, the PCI Express DMA device must pe provided as an argument.
-func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32 {
+// can get. Also, the DMA channel must pe provided as an argument.
+func (recv *Receiver) readRingBuff(readAll bool, dma DMA) (uint32, error) {
 	if recv.captureEnable == false {
 		// nothing to do here
-		return 0
+		return 0, nil
 	}
 
 	// get the ring buffer size
@@ -295,11 +406,11 @@ func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32
 	// of the read pointer until the end
 	ringBuffSizeEnd := ringBuffSize - uint64(ringBuffRdPtr)
 
-	// get pcie bar
-	pcieBAR := recv.nt.pcieBAR
+	// get register access module
+	bar := recv.nt.bar
 
 	// get current write pointer position
-	ringBuffWrPtr := pcieBAR.Read(ADDR_BASE_NT_RECV_CAPTURE[recv.id] +
+	ringBuffWrPtr := bar.Read(recv.nt.board.AddrBaseRecvCapture[recv.id] +
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ADDR_WR)
 
 	// calculate target transfer size
@@ -318,11 +429,20 @@ func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32
 		} else if ringBuffRdPtr > ringBuffWrPtr {
 			transferSize = uint32(ringBuffSizeEnd)
 		}
+
+		// never transfer more data than a capture buffer can hold when
+		// capture data is discarded or streamed to a file. the remaining data
+		// is read by the next call
+		if (recv.hostMemSize == 0) &&
+			transferSize > RING_BUFF_RD_TRANSFER_SIZE_MIN {
+			transferSize = RING_BUFF_RD_TRANSFER_SIZE_MIN
+		}
 	}
 
 	// transfer size must never be negative
 	if transferSize < 0 {
-		Log(LOG_ERR, "Receiver %d: ring buffer transfer size < 0", recv.id)
+		return 0, ErrorCreate(ErrHardwareState,
+			"Receiver %d: ring buffer transfer size < 0", recv.id)
 	}
 
 	// do a transfer?
@@ -342,19 +462,28 @@ func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32
 
 	if doTransfer == false {
 		// currently we cannot transfer data
-		return 0
+		return 0, nil
 	}
 
-	// get slice to which capture data shall be recorded to
-	data := recv.capture.getWriteSlice(transferSize)
+	// get slice to which capture data shall be recorded to. when reading all
+	// data, wait until it is available (only relevant if capture data is
+	// streamed to a file and the disk cannot keep up)
+	data, err := recv.capture.getWriteSlice(transferSize, readAll)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		// capture data cannot be written right now, try again later
+		return 0, nil
+	}
 
 	// take time before starting dma transfer
 	transferStartTime := time.Now()
 
 	// read data from the ring buffer
-	err := pcieDMA.Read(recv.ringBuffAddr+uint64(ringBuffRdPtr), data)
+	err = dma.Read(recv.ringBuffAddr+uint64(ringBuffRdPtr), data)
 	if err != nil {
-		Log(LOG_ERR, err.Error())
+		return 0, ErrorCreate(ErrDMA, "Receiver %d: %s", recv.id, err.Error())
 	}
 
 	// evaluate dma transfer time
@@ -372,7 +501,7 @@ func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32
 
 	// save read pointer and write to hardware
 	recv.ringBuffRdPtr = ringBuffRdPtr
-	pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ADDR_RD,
 		ringBuffRdPtr)
 
@@ -385,19 +514,30 @@ func (recv *Receiver) readRingBuff(readAll bool, pcieDMA *gopcie.PCIeDMA) uint32
 		recv.id, transferSize, transferDuration, transferThroughput)
 
 	// return the amount of data that has been transferred
-	return transferSize
+	return transferSize, nil
 }
 
 // start starts the continous reading of data from the ring buffer. The
-// function is non-blocking.
-func (recv *Receiver) start() {
+// function is non-blocking. It returns an error if the capture file could not
+// be created.
+func (recv *Receiver) start() error {
 	if recv.captureEnable == false {
 		// nothing to do here
-		return
+		return nil
 	}
 
 	var captureData []byte
-	if recv.hostMemSize > 0 {
+	var sink *captureSink
+	if recv.captureFilename != "" {
+		// capture data is streamed to a file
+		var err error
+		sink, err = captureSinkOpen(recv.captureFilename,
+			recv.captureFileFormat, recv.captureLength,
+			recv.nt.timestamp.getTickPeriod(), recv.nt.board.FreqClock)
+		if err != nil {
+			return err
+		}
+	} else if recv.hostMemSize > 0 {
 		// reserve host memory to hold capture data
 		captureData = make([]byte, recv.hostMemSize)
 	} else {
@@ -411,13 +551,17 @@ func (recv *Receiver) start() {
 	recv.capture = &Capture{
 		data:              captureData,
 		tickPeriodLatency: recv.nt.timestamp.getTickPeriod(),
+		freqClock:         recv.nt.board.FreqClock,
 		caplen:            recv.captureLength,
-		discard:           recv.hostMemSize == 0,
+		discard:           recv.hostMemSize == 0 && sink == nil,
+		sink:              sink,
 	}
 
 	// start capturing
-	recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	recv.nt.bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ACTIVE, 0x1)
+
+	return nil
 }
 
 // stop stops the reading of data from the ring buffer.
@@ -427,41 +571,48 @@ func (recv *Receiver) stop() {
 		return
 	}
 
-	// stop capturing
-	recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	// stop capturing. the receiver becomes inactive once it flushed its fifo
+	// contents to the memory (see isActive())
+	recv.nt.bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ACTIVE, 0x0)
+}
 
-	// wait a little bit to give receiver time to become inactive and flush
-	// its fifo contents to the memory
-	time.Sleep(time.Second)
+// isActive returns true, if the hardware core is currently capturing or still
+// flushing its fifo contents to the RX ring buffer after capturing has been
+// stopped.
+func (recv *Receiver) isActive() bool {
+	active := recv.nt.bar.Read(recv.nt.board.AddrBaseRecvCapture[recv.id] +
+		CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ACTIVE)
+	return active != 0x0
+}
+
+// closeCaptureFile writes the remaining capture data to the capture file and
+// closes it, if capture data is streamed to a file.
+func (recv *Receiver) closeCaptureFile() error {
+	if recv.capture == nil || recv.capture.sink == nil {
+		// nothing to do here
+		return nil
+	}
+	return recv.capture.sink.close()
 }
 
 // checkError checks if the hardware flagged an error during capturing or if
-// capturing is still active. If the parameter exit is set to true, the
-// application exits if an error was detected.
-func (recv *Receiver) checkError(exit bool) error {
-	errs := recv.nt.pcieBAR.Read(ADDR_BASE_NT_RECV_CAPTURE[recv.id] +
+// capturing is still active. It returns an error if one was detected.
+func (recv *Receiver) checkError() error {
+	errs := recv.nt.bar.Read(recv.nt.board.AddrBaseRecvCapture[recv.id] +
 		CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ERRS)
 	if (errs & 0x1) > 0 {
-		if exit {
-			Log(LOG_ERR, "Receiver %d: meta FIFO full", recv.id)
-		}
-		return fmt.Errorf("Receiver %d: meta FIFO full", recv.id)
+		return ErrorCreate(ErrRingBuffOverflow, "Receiver %d: meta FIFO full",
+			recv.id)
 	}
 	if (errs & 0x2) > 0 {
-		if exit {
-			Log(LOG_ERR, "Receiver %d: data FIFO full", recv.id)
-		}
-		return fmt.Errorf("Receiver %d: data FIFO full", recv.id)
+		return ErrorCreate(ErrRingBuffOverflow, "Receiver %d: data FIFO full",
+			recv.id)
 	}
 
-	active := recv.nt.pcieBAR.Read(ADDR_BASE_NT_RECV_CAPTURE[recv.id] +
-		CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ACTIVE)
-	if active != 0x0 {
-		if exit {
-			Log(LOG_ERR, "Received %d: still active", recv.id)
-		}
-		return fmt.Errorf("Receiver %d: still active", recv.id)
+	if recv.isActive() {
+		return ErrorCreate(ErrHardwareState, "Receiver %d: still active",
+			recv.id)
 	}
 	return nil
 }
@@ -470,7 +621,7 @@ func (recv *Receiver) checkError(exit bool) error {
 func (recv *Receiver) resetHardware() {
 	// disable capturing (just in case it's still active from a previous
 	// errornous measurement)
-	recv.nt.pcieBAR.Write(ADDR_BASE_NT_RECV_CAPTURE[recv.id]+
+	recv.nt.bar.Write(recv.nt.board.AddrBaseRecvCapture[recv.id]+
 		CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ACTIVE, 0x0)
 }
 
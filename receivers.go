@@ -32,7 +32,7 @@
 
 package gofluent10g
 
-import "github.com/aoeldemann/gopcie"
+import "time"
 
 // Receivers is a slice type holding pointers on Receiver instacnes. It
 // implements functions that allow easy control of multiple Receiver instances
@@ -48,43 +48,79 @@ func (recvs *Receivers) resetHardware() {
 
 // configHardware initializes the configuration of the receivers and writes it
 // to the hardware.
-func (recvs *Receivers) configHardware() {
+func (recvs *Receivers) configHardware() error {
 	for _, recv := range *recvs {
-		recv.configHardware()
+		if err := recv.configHardware(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // start starts the continous reading of data from the ring buffers. The
 // function is non-blocking.
-func (recvs *Receivers) start() {
+func (recvs *Receivers) start() error {
 	for _, recv := range *recvs {
-		recv.start()
+		if err := recv.start(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// stop stops the reading of data from the ring buffers.
+// stop stops the reading of data from the ring buffers. It waits until all
+// receivers have become inactive, but at most CAPTURE_STOP_TIMEOUT. Receivers
+// that are still active afterwards are reported by checkErrors().
 func (recvs *Receivers) stop() {
 	for _, recv := range *recvs {
 		recv.stop()
 	}
+
+	deadline := time.Now().Add(CAPTURE_STOP_TIMEOUT)
+	for recvs.areActive() && time.Now().Before(deadline) {
+		time.Sleep(STATUS_POLL_INTERVAL)
+	}
+}
+
+// areActive returns true, if one or more receivers with enabled capturing are
+// still active.
+func (recvs *Receivers) areActive() bool {
+	for _, recv := range *recvs {
+		if recv.captureEnable && recv.isActive() {
+			return true
+		}
+	}
+	return false
 }
 
-// readRingBuff reads data from the ring buffer. The PCI Express DMA device
-// through which the read shall be performed needs to be provided as an
-// argument.
-func (recvs *Receivers) readRingBuffs(pcieDMA *gopcie.PCIeDMA) {
+// closeCaptureFiles writes the remaining capture data to the capture files and
+// closes them. It returns the first error that occured.
+func (recvs *Receivers) closeCaptureFiles() error {
+	var errFirst error
 	for _, recv := range *recvs {
-		recv.readRingBuff(false, pcieDMA)
+		if err := recv.closeCaptureFile(); err != nil && errFirst == nil {
+			errFirst = err
+		}
 	}
+	return errFirst
+}
+
+// readRingBuff reads data from the ring buffer. The DMA channel through which
+// the read shall be performed needs to be provided as an argument.
+func (recvs *Receivers) readRingBuffs(dma DMA) error {
+	for _, recv := range *recvs {
+		if _, err := recv.readRingBuff(false, dma); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // checkError checks if one or more hardware receiver core flagged an error
-// during operation. The function returns and error if one was detected. If the
-// parameter exit is set to true, the function prints an error message and
-// exits the application if an error was detected.
-func (recvs *Receivers) checkErrors(exit bool) error {
+// during operation. The function returns and error if one was detected.
+func (recvs *Receivers) checkErrors() error {
 	for _, recv := range *recvs {
-		err := recv.checkError(exit)
+		err := recv.checkError()
 		if err != nil {
 			return err
 		}
@@ -92,6 +128,21 @@ func (recvs *Receivers) checkErrors(exit bool) error {
 	return nil
 }
 
+// distribute distributes the receivers among n DMA channels in a round-robin
+// fashion. It returns one list of receivers per DMA channel, DMA channels
+// without receivers are omitted at the end of the list.
+func (recvs *Receivers) distribute(n int) []Receivers {
+	if len(*recvs) < n {
+		n = len(*recvs)
+	}
+
+	recvsPerChannel := make([]Receivers, n)
+	for i, recv := range *recvs {
+		recvsPerChannel[i%n] = append(recvsPerChannel[i%n], recv)
+	}
+	return recvsPerChannel
+}
+
 // getIfIdsConfigured returns a list containing the interface IDs of the
 // receivers that configured to capture the arriving packets.
 func (recvs *Receivers) getIfIdsConfigured() []int {
@@ -0,0 +1,285 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements sequence tags that are embedded into the packet data of a trace
+// and the capture-side analysis of the tags to detect lost, duplicated and
+// reordered packets. A sequence tag consists of a magic number (2 bytes), the
+// stream ID (2 bytes), the sequence number of the packet within the trace
+// (4 bytes) and the number of packets in the trace (4 bytes), all in network
+// byte order. When a trace is replayed multiple times, the sequence numbers
+// wrap around after each replay. The number of packets in the trace allows the
+// analysis to restore the original sequence.
+
+package gofluent10g
+
+import (
+	"encoding/binary"
+)
+
+// SequenceTagLen is the length of a sequence tag in bytes.
+const SequenceTagLen int = 12
+
+// magic number identifying a sequence tag
+const sequenceTagMagic uint16 = 0x5351
+
+// SequenceRange is a range of consecutive sequence numbers. The sequence
+// numbers continue across trace replays, i.e. the first packet of the second
+// replay of a trace with N packets has the sequence number N.
+type SequenceRange struct {
+	First uint64
+	Last  uint64
+}
+
+// SequenceStats holds the results of the sequence analysis of a stream.
+type SequenceStats struct {
+	StreamID int
+
+	// number of (non-duplicate) packets received
+	NPackets int
+
+	// number of packets missing between the first sequence number and the
+	// highest sequence number received, as well as the ranges of missing
+	// sequence numbers. Packets lost after the highest sequence number received
+	// can not be detected
+	NLost      uint64
+	LostRanges []SequenceRange
+
+	// number of packets received more than once
+	NDuplicates int
+
+	// number of packets received after a packet with a higher sequence number
+	NReordered int
+
+	// maximum reordering depth, i.e. maximum difference between the highest
+	// sequence number received so far and the sequence number of a reordered
+	// packet
+	ReorderDepthMax uint64
+
+	// number of reordered packets whose reordering depth exceeds the depth
+	// specified for the analysis
+	NLate int
+
+	// highest sequence number received
+	SeqMax uint64
+}
+
+// AddSequenceTags creates a new trace with the same packets as the trace, but
+// with a sequence tag (see SequenceTagLen) written to each packet at the
+// specified byte offset. The tag carries the specified stream ID (0 to 65535)
+// and the sequence number of the packet. If the captured data of a packet does
+// not include the tag, the capture length is increased. The offset should be
+// chosen such that the tag does not overlap with packet headers or the
+// timestamp inserted by the hardware in TimestampModeFixedPos. Traces created
+// by the trace generators of the utils package carry zero bytes after the
+// packet headers, which can be used for the tag. UDP and TCP checksums of the
+// packets are recalculated after writing the tag.
+func (trace *Trace) AddSequenceTags(streamID int, offset int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	if streamID < 0 || streamID > 0xFFFF {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: invalid sequence tag stream ID")
+	}
+	if offset < 0 {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: invalid sequence tag offset")
+	}
+
+	// the number of packets in the trace is written to each tag
+	if !trace.parsed {
+		if err := trace.parse(); err != nil {
+			return nil, err
+		}
+	}
+	nPackets := trace.nPackets
+
+	var seq uint32
+	var errTag error
+	traceNew, err := trace.transform(func(pkt *TracePacket) bool {
+		if pkt.Wirelen < offset+SequenceTagLen {
+			errTag = ErrorCreate(ErrInvalidConfig, "Trace: packet %d too "+
+				"short for sequence tag", seq)
+			return false
+		}
+
+		// increase capture length if necessary and copy the packet data
+		// before modifying it
+		if pkt.Caplen < offset+SequenceTagLen {
+			pkt.Caplen = offset + SequenceTagLen
+		}
+		data := make([]byte, pkt.Caplen)
+		copy(data, pkt.Data)
+
+		// write tag
+		tag := data[offset : offset+SequenceTagLen]
+		binary.BigEndian.PutUint16(tag[0:2], sequenceTagMagic)
+		binary.BigEndian.PutUint16(tag[2:4], uint16(streamID))
+		binary.BigEndian.PutUint32(tag[4:8], seq)
+		binary.BigEndian.PutUint32(tag[8:12], uint32(nPackets))
+
+		// the tag is part of the udp/tcp payload, recalculate checksums
+		PacketChecksumsSet(data)
+
+		pkt.Data = data
+		seq++
+		return true
+	})
+	if errTag != nil {
+		return nil, errTag
+	}
+	return traceNew, err
+}
+
+// GetSequenceStats analyzes the sequence tags (see Trace.AddSequenceTags())
+// at the specified byte offset of the captured packets and returns the
+// analysis results for each stream, indexed by stream ID. Packets without a
+// sequence tag are ignored. A reordered packet is counted as late if its
+// reordering depth exceeds depthLate. The analysis requires the capture length
+// to include the sequence tags.
+func (pkts CapturePackets) GetSequenceStats(offset int, depthLate uint64) map[int]*SequenceStats {
+	streams := make(map[int]*sequenceStream)
+
+	for _, pkt := range pkts {
+		// extract sequence tag
+		if len(pkt.Data) < offset+SequenceTagLen {
+			continue
+		}
+		tag := pkt.Data[offset : offset+SequenceTagLen]
+		if binary.BigEndian.Uint16(tag[0:2]) != sequenceTagMagic {
+			continue
+		}
+		streamID := int(binary.BigEndian.Uint16(tag[2:4]))
+		seq := binary.BigEndian.Uint32(tag[4:8])
+		period := binary.BigEndian.Uint32(tag[8:12])
+
+		stream, ok := streams[streamID]
+		if !ok {
+			stream = &sequenceStream{
+				stats: SequenceStats{StreamID: streamID},
+			}
+			streams[streamID] = stream
+		}
+		stream.add(seq, period, depthLate)
+	}
+
+	stats := make(map[int]*SequenceStats)
+	for streamID, stream := range streams {
+		stats[streamID] = stream.getStats()
+	}
+	return stats
+}
+
+// sequenceStream holds the state of the sequence analysis of a stream.
+type sequenceStream struct {
+	stats    SequenceStats
+	received []uint64 // bitmap of received sequence numbers
+	started  bool
+}
+
+// add adds a received packet to the analysis.
+func (stream *sequenceStream) add(seq, period uint32, depthLate uint64) {
+	// restore the sequence number across trace replays. Selects the replay
+	// resulting in the sequence number closest to the highest sequence number
+	// received so far
+	seqExt := uint64(seq)
+	if stream.started && period > 0 {
+		seqMax := stream.stats.SeqMax
+		replay := seqMax / uint64(period)
+		replays := []uint64{replay, replay + 1}
+		if replay > 0 {
+			replays = append(replays, replay-1)
+		}
+		for _, r := range replays {
+			candidate := r*uint64(period) + uint64(seq)
+			if sequenceDist(candidate, seqMax) < sequenceDist(seqExt, seqMax) {
+				seqExt = candidate
+			}
+		}
+	}
+
+	// grow bitmap if necessary
+	for uint64(len(stream.received))*64 <= seqExt {
+		stream.received = append(stream.received, 0)
+	}
+
+	// duplicate?
+	if stream.received[seqExt/64]&(1<<(seqExt%64)) != 0 {
+		stream.stats.NDuplicates++
+		return
+	}
+	stream.received[seqExt/64] |= 1 << (seqExt % 64)
+	stream.stats.NPackets++
+
+	if !stream.started || seqExt > stream.stats.SeqMax {
+		stream.stats.SeqMax = seqExt
+		stream.started = true
+		return
+	}
+
+	// packet is reordered
+	depth := stream.stats.SeqMax - seqExt
+	stream.stats.NReordered++
+	if depth > stream.stats.ReorderDepthMax {
+		stream.stats.ReorderDepthMax = depth
+	}
+	if depth > depthLate {
+		stream.stats.NLate++
+	}
+}
+
+// getStats determines the lost sequence ranges and returns the analysis
+// results.
+func (stream *sequenceStream) getStats() *SequenceStats {
+	stats := stream.stats
+	stats.NLost = 0
+	stats.LostRanges = nil
+
+	lost := false
+	for seq := uint64(0); seq <= stats.SeqMax; seq++ {
+		if stream.received[seq/64]&(1<<(seq%64)) == 0 {
+			if !lost {
+				stats.LostRanges = append(stats.LostRanges,
+					SequenceRange{First: seq})
+				lost = true
+			}
+			stats.LostRanges[len(stats.LostRanges)-1].Last = seq
+			stats.NLost++
+		} else {
+			lost = false
+		}
+	}
+
+	return &stats
+}
+
+// sequenceDist returns the absolute difference of two sequence numbers.
+func sequenceDist(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,309 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests sequence tags and the analysis of lost, duplicated and reordered
+// packets.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// testSequencePacket creates a captured packet carrying a sequence tag at the
+// specified byte offset.
+func testSequencePacket(offset, streamID int, seq, period uint32) CapturePacket {
+	data := make([]byte, offset+SequenceTagLen)
+	tag := data[offset:]
+	binary.BigEndian.PutUint16(tag[0:2], sequenceTagMagic)
+	binary.BigEndian.PutUint16(tag[2:4], uint16(streamID))
+	binary.BigEndian.PutUint32(tag[4:8], seq)
+	binary.BigEndian.PutUint32(tag[8:12], period)
+	return CapturePacket{Data: data, Wirelen: 64}
+}
+
+// TestSequenceTagsReplay adds sequence tags to a repeatedly replayed trace,
+// replays it on the simulated hardware and checks that the analysis restores
+// the sequence numbers across the replays.
+func TestSequenceTagsReplay(t *testing.T) {
+	nPkts, nRepeats, offset := 10, 3, 42
+	trace := testTraceCreate(t, nPkts, nRepeats, func(i int) (int, int) {
+		return 100, 50
+	}, nil)
+
+	traceTags, err := trace.AddSequenceTags(7, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the capture length is increased to include the tags
+	it := traceTags.Packets()
+	for i := 0; it.Next(); i++ {
+		pkt := it.Packet()
+		if pkt.Caplen != offset+SequenceTagLen {
+			t.Fatalf("packet %d: capture length %d, expected %d", i,
+				pkt.Caplen, offset+SequenceTagLen)
+		}
+		tag := pkt.Data[offset : offset+SequenceTagLen]
+		if binary.BigEndian.Uint16(tag[2:4]) != 7 ||
+			binary.BigEndian.Uint32(tag[4:8]) != uint32(i) ||
+			binary.BigEndian.Uint32(tag[8:12]) != uint32(nPkts) {
+			t.Fatalf("packet %d: invalid sequence tag", i)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(1518, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+	testReplayCheck(t, nt, traceTags)
+
+	stats := testCapturePackets(t, recv).GetSequenceStats(offset, 0)
+	if len(stats) != 1 || stats[7] == nil {
+		t.Fatalf("analysis returned %d streams, expected stream 7",
+			len(stats))
+	}
+
+	statsExp := SequenceStats{
+		StreamID: 7,
+		NPackets: nPkts * nRepeats,
+		SeqMax:   uint64(nPkts*nRepeats - 1),
+	}
+	if reflect.DeepEqual(*stats[7], statsExp) == false {
+		t.Fatalf("sequence stats %+v, expected %+v", *stats[7], statsExp)
+	}
+}
+
+// testPacketUDPTCP creates an IPv4 UDP or TCP packet of the specified length
+// with valid checksums.
+func testPacketUDPTCP(t *testing.T, protocol layers.IPProtocol, n int) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: protocol,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	var l4 gopacket.SerializableLayer
+	if protocol == layers.IPProtocolUDP {
+		udp := &layers.UDP{SrcPort: 1000, DstPort: 2000}
+		udp.SetNetworkLayerForChecksum(ip)
+		l4 = udp
+	} else {
+		tcp := &layers.TCP{SrcPort: 1000, DstPort: 2000, Seq: 1, ACK: true,
+			Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip)
+		l4 = tcp
+	}
+
+	// fill the payload such that the packet has the specified length
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true,
+		ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, l4); err != nil {
+		t.Fatal(err)
+	}
+	payload := make(gopacket.Payload, n-len(buf.Bytes()))
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, l4, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testPacketChecksumValid checks whether the UDP or TCP checksum of the packet
+// is valid by serializing the layer 4 segment again with a recalculated
+// checksum.
+func testPacketChecksumValid(t *testing.T, data []byte) bool {
+	pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if ok == false {
+		t.Fatal("packet has no IPv4 header")
+	}
+
+	var l4 gopacket.SerializableLayer
+	var segment []byte
+	if udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		udp.SetNetworkLayerForChecksum(ip)
+		l4, segment = udp, ip.Payload
+	} else if tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		tcp.SetNetworkLayerForChecksum(ip)
+		l4, segment = tcp, ip.Payload
+	} else {
+		t.Fatal("packet has no UDP or TCP header")
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true}
+	payload := gopacket.Payload(pkt.ApplicationLayer().Payload())
+	if err := gopacket.SerializeLayers(buf, opts, l4, payload); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(buf.Bytes(), segment)
+}
+
+// TestSequenceTagsChecksums checks that the UDP and TCP checksums are valid
+// after adding sequence tags.
+func TestSequenceTagsChecksums(t *testing.T) {
+	protocols := []layers.IPProtocol{layers.IPProtocolUDP,
+		layers.IPProtocolTCP}
+	for _, protocol := range protocols {
+		builder := TraceBuilderCreate()
+		for i := 0; i < 10; i++ {
+			data := testPacketUDPTCP(t, protocol, 80+i*10)
+			err := builder.AddPacket(TracePacket{
+				CyclesInterPacket: testCyclesTransfer(len(data) + 4),
+				Wirelen:           len(data) + 4,
+				Caplen:            len(data),
+				Data:              data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		trace, err := builder.GetTrace(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		traceTags, err := trace.AddSequenceTags(1, 54)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		it := traceTags.Packets()
+		for i := 0; it.Next(); i++ {
+			if testPacketChecksumValid(t, it.Packet().Data) == false {
+				t.Fatalf("%s packet %d: invalid checksum", protocol, i)
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSequenceTagsInvalid checks that invalid sequence tag parameters are
+// rejected.
+func TestSequenceTagsInvalid(t *testing.T) {
+	trace := testTraceCreate(t, 10, 1, func(i int) (int, int) {
+		return 60, 60
+	}, nil)
+
+	params := []struct {
+		streamID int
+		offset   int
+	}{
+		{-1, 42},
+		{0x10000, 42},
+		{0, -1},
+		{0, 50}, // tag exceeds wire length
+	}
+	for i, p := range params {
+		_, err := trace.AddSequenceTags(p.streamID, p.offset)
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("parameters %d: expected invalid configuration error, "+
+				"got: %v", i, err)
+		}
+	}
+}
+
+// TestSequenceStats analyzes captured packets of two streams with lost,
+// duplicated and reordered packets. The sequence numbers of the first stream
+// wrap around after 10 packets.
+func TestSequenceStats(t *testing.T) {
+	offset := 14
+
+	// sequence numbers continued across replays
+	seqs := []uint32{0, 1, 2, 4, 5, 5, 7, 6, 8, 9, 10, 11, 14, 12, 13, 15, 16,
+		17, 18, 19, 21}
+
+	var pkts CapturePackets
+	for i, seq := range seqs {
+		pkts = append(pkts, testSequencePacket(offset, 1, seq%10, 10))
+
+		// second stream without losses, the first packets do not start at
+		// sequence number 0
+		if i < 5 {
+			pkts = append(pkts, testSequencePacket(offset, 2, uint32(i+3), 100))
+		}
+	}
+
+	// packets without sequence tag are ignored
+	pkts = append(pkts, CapturePacket{Data: make([]byte, 20), Wirelen: 64})
+	pkts = append(pkts, CapturePacket{Data: make([]byte, 100), Wirelen: 104})
+
+	stats := pkts.GetSequenceStats(offset, 1)
+	if len(stats) != 2 {
+		t.Fatalf("analysis returned %d streams, expected 2", len(stats))
+	}
+
+	statsExp := SequenceStats{
+		StreamID:        1,
+		NPackets:        20,
+		NLost:           2,
+		LostRanges:      []SequenceRange{{3, 3}, {20, 20}},
+		NDuplicates:     1,
+		NReordered:      3,
+		ReorderDepthMax: 2,
+		NLate:           1,
+		SeqMax:          21,
+	}
+	if reflect.DeepEqual(*stats[1], statsExp) == false {
+		t.Fatalf("stream 1: sequence stats %+v, expected %+v", *stats[1],
+			statsExp)
+	}
+
+	// the first packets of the second stream have been lost
+	statsExp = SequenceStats{
+		StreamID:   2,
+		NPackets:   5,
+		NLost:      3,
+		LostRanges: []SequenceRange{{0, 2}},
+		SeqMax:     7,
+	}
+	if reflect.DeepEqual(*stats[2], statsExp) == false {
+		t.Fatalf("stream 2: sequence stats %+v, expected %+v", *stats[2],
+			statsExp)
+	}
+}
@@ -0,0 +1,842 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// This file implements the Simulator struct, a Backend that models the network
+// tester hardware in software. It allows the library to be used without an
+// FPGA board being present. The simulator models:
+//
+//   - the DRAM of the FPGA board, in which the ring buffers are located
+//   - the replay cores, which read trace data from the TX ring buffers into
+//     (bounded) Block RAM FIFOs
+//   - the rate control modules, which transmit the packets with the
+//     inter-packet times specified in the trace
+//   - the capture cores, which write meta data and packet data of arriving
+//     packets to the RX ring buffers
+//   - the interface packet counters and the identification register
+//
+// Packets transmitted by a generator are looped back to a receiver (by
+// default the receiver on the same interface) with a configurable latency.
+// The simulation does not run in real-time. Instead it advances a virtual
+// clock every time the software accesses the registers. The data rate
+// monitoring registers always read zero.
+
+package gofluent10g
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// size of the pages the simulated DRAM is allocated in
+	simMemPageSize = 1024 * 1024
+
+	// capacity of the simulated Block RAM FIFOs between replay core and rate
+	// control module
+	simFIFOSize = 64 * 1024
+)
+
+// Simulator is a Backend that models the network tester hardware in software.
+// A new Simulator is created by calling the SimulatorCreate() function.
+type Simulator struct {
+	mutex sync.Mutex
+
+	board *BoardProfile // simulated hardware variant
+
+	regs map[uint32]uint32 // register values written by the software
+	mem  map[uint64][]byte // DRAM memory pages, allocated on first access
+
+	gens  []simGenerator
+	recvs []simReceiver
+
+	// per-interface packet counters
+	nPktsTX []uint32
+	nPktsRX []uint32
+
+	// for each generator the id of the receiver its packets are looped back
+	// to (-1 if the generator is not connected to any receiver)
+	loopback []int
+
+	// latency (in clock cycles) added to each looped back packet
+	latencyCycles uint64
+
+	// number of DMA channels per direction
+	nDMAChannels int
+
+	// current virtual time (in picoseconds)
+	time float64
+}
+
+// simGenerator holds the state of a simulated replay core and its rate control
+// module.
+type simGenerator struct {
+	active bool // true while trace data is read from the ring buffer
+
+	ringBuffAddr  uint64
+	ringBuffSize  uint64
+	ringBuffRdPtr uint32
+
+	traceSize  uint64 // total number of bytes to be read from ring buffer
+	nBytesRead uint64 // number of bytes read from ring buffer so far
+
+	fifo []byte // block ram fifo contents
+
+	tNext     float64 // scheduled transmission time of next packet (ps)
+	tLinkIdle float64 // time at which the transmission of the last packet ends
+
+	errTiming bool // replay timing error flag
+}
+
+// simReceiver holds the state of a simulated capture core.
+type simReceiver struct {
+	active bool // true while capturing
+
+	ringBuffAddr  uint64
+	ringBuffSize  uint64
+	ringBuffWrPtr uint32
+
+	nPkts        uint32 // number of captured packets
+	errs         uint32 // error flags
+	tLastArrival uint64 // arrival time of previous packet in clock cycles
+}
+
+// simBAR provides register access to the simulated hardware.
+type simBAR struct {
+	sim *Simulator
+}
+
+// simDMA provides DMA access to the simulated DRAM.
+type simDMA struct {
+	sim *Simulator
+}
+
+// SimulatorCreate creates a new instance of the Simulator struct, which
+// simulates the NetFPGA-SUME hardware. By default, the packets transmitted by
+// each generator are looped back to the receiver on the same network
+// interface without any additional latency.
+func SimulatorCreate() *Simulator {
+	sim, _ := SimulatorCreateWithBoard(BoardNetFPGASUME)
+	return sim
+}
+
+// SimulatorCreateWithBoard creates a new instance of the Simulator struct,
+// which simulates the hardware variant described by the board profile (number
+// of network interfaces, register base addresses, memory banks, clock
+// frequencies, line rate and identification values). The network tester must
+// be created with the same board profile (see
+// NetworkTesterCreateWithBackendAndBoard()).
+func SimulatorCreateWithBoard(board BoardProfile) (_ *Simulator, err error) {
+	defer ErrorReturn(&err)
+
+	if err := board.check(); err != nil {
+		return nil, err
+	}
+
+	// the simulator keeps a copy of the board profile, which does not share
+	// its slices with the profile passed by the application
+	board = board.copy()
+
+	sim := Simulator{
+		board:        &board,
+		nDMAChannels: 1,
+		regs:         make(map[uint32]uint32),
+		mem:          make(map[uint64][]byte),
+		gens:         make([]simGenerator, board.NInterfaces),
+		recvs:        make([]simReceiver, board.NInterfaces),
+		nPktsTX:      make([]uint32, board.NInterfaces),
+		nPktsRX:      make([]uint32, board.NInterfaces),
+		loopback:     make([]int, board.NInterfaces),
+	}
+
+	for i := 0; i < board.NInterfaces; i++ {
+		sim.loopback[i] = i
+	}
+
+	return &sim, nil
+}
+
+// SetLatency sets the latency that is added to each packet between its
+// transmission by a generator and its arrival at a receiver.
+func (sim *Simulator) SetLatency(latency time.Duration) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	sim.latencyCycles = uint64(latency.Seconds() * sim.board.FreqClock)
+}
+
+// SetLoopback connects the output of a generator to a receiver. Packets
+// transmitted by the generator arrive at the receiver. If recvID is set to -1,
+// transmitted packets are dropped.
+func (sim *Simulator) SetLoopback(genID, recvID int) (err error) {
+	defer ErrorReturn(&err)
+
+	if genID < 0 || genID >= sim.board.NInterfaces {
+		return ErrorCreate(ErrInvalidConfig,
+			"Simulator: invalid Generator ID: %d", genID)
+	}
+	if recvID < -1 || recvID >= sim.board.NInterfaces {
+		return ErrorCreate(ErrInvalidConfig,
+			"Simulator: invalid Receiver ID: %d", recvID)
+	}
+
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	sim.loopback[genID] = recvID
+
+	return nil
+}
+
+// GetBAR returns the register access module.
+func (sim *Simulator) GetBAR() BAR {
+	return &simBAR{sim: sim}
+}
+
+// SetDMAChannelCount sets the number of host-to-card and card-to-host DMA
+// channels provided by the simulator (default: 1). It must be called before
+// the network tester is created.
+func (sim *Simulator) SetDMAChannelCount(n int) (err error) {
+	defer ErrorReturn(&err)
+
+	if n <= 0 {
+		return ErrorCreate(ErrInvalidConfig,
+			"Simulator: DMA channel count must be larger than zero")
+	}
+	sim.nDMAChannels = n
+	return nil
+}
+
+// GetDMAWrite returns the host-to-card DMA channels.
+func (sim *Simulator) GetDMAWrite() []DMA {
+	return sim.getDMAChannels()
+}
+
+// GetDMARead returns the card-to-host DMA channels.
+func (sim *Simulator) GetDMARead() []DMA {
+	return sim.getDMAChannels()
+}
+
+// getDMAChannels creates the DMA channels of one direction.
+func (sim *Simulator) getDMAChannels() []DMA {
+	dmas := make([]DMA, sim.nDMAChannels)
+	for i := range dmas {
+		dmas[i] = &simDMA{sim: sim}
+	}
+	return dmas
+}
+
+// Close releases the simulated DRAM memory.
+func (sim *Simulator) Close() {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	sim.mem = make(map[uint64][]byte)
+}
+
+// Read reads a register value.
+func (bar *simBAR) Read(addr uint32) uint32 {
+	return bar.sim.regRead(addr)
+}
+
+// Write writes a register value.
+func (bar *simBAR) Write(addr uint32, data uint32) {
+	bar.sim.regWrite(addr, data)
+}
+
+// Close does nothing.
+func (bar *simBAR) Close() {
+}
+
+// Read reads data from the simulated DRAM.
+func (dma *simDMA) Read(addr uint64, data []byte) error {
+	if err := dma.sim.checkMemRange(addr, len(data)); err != nil {
+		return err
+	}
+
+	dma.sim.mutex.Lock()
+	defer dma.sim.mutex.Unlock()
+
+	dma.sim.memRead(addr, data)
+	return nil
+}
+
+// Write writes data to the simulated DRAM.
+func (dma *simDMA) Write(addr uint64, data []byte) error {
+	if err := dma.sim.checkMemRange(addr, len(data)); err != nil {
+		return err
+	}
+
+	dma.sim.mutex.Lock()
+	defer dma.sim.mutex.Unlock()
+
+	dma.sim.memWrite(addr, data)
+	return nil
+}
+
+// Close does nothing.
+func (dma *simDMA) Close() {
+}
+
+// regRead returns the value of a register. Status registers are derived from
+// the state of the simulated hardware, all other registers return the value
+// that has last been written to them.
+func (sim *Simulator) regRead(addr uint32) uint32 {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	// advance simulation
+	sim.run()
+
+	if id, offset, ok := simDecodeAddr(sim.board.AddrBaseGenReplay, addr); ok {
+		switch offset {
+		case CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_RD:
+			return sim.gens[id].ringBuffRdPtr
+		case CPUREG_OFFSET_NT_GEN_REPLAY_STATUS:
+			if sim.gens[id].active {
+				return 0x1
+			}
+			return 0x0
+		}
+	} else if id, offset, ok := simDecodeAddr(sim.board.AddrBaseGenRateCtrl, addr); ok {
+		if offset == CPUREG_OFFSET_NT_GEN_RATE_CTRL_STATUS {
+			if sim.gens[id].errTiming {
+				return 0x1
+			}
+			return 0x0
+		}
+	} else if id, offset, ok := simDecodeAddr(sim.board.AddrBaseRecvCapture, addr); ok {
+		switch offset {
+		case CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ADDR_WR:
+			return sim.recvs[id].ringBuffWrPtr
+		case CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_PKT_CNT:
+			return sim.recvs[id].nPkts
+		case CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ACTIVE:
+			if sim.recvs[id].active {
+				return 0x1
+			}
+			return 0x0
+		case CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ERRS:
+			return sim.recvs[id].errs
+		}
+	} else if id, offset, ok := simDecodeAddr(sim.board.AddrBaseIface, addr); ok {
+		switch offset {
+		case CPUREG_OFFSET_IF_N_PKTS_TX:
+			return sim.nPktsTX[id]
+		case CPUREG_OFFSET_IF_N_PKTS_RX:
+			return sim.nPktsRX[id]
+		}
+	} else if _, offset, ok := simDecodeAddr(sim.board.AddrBaseDatarate, addr); ok {
+		if offset != CPUREG_OFFSET_NT_DATARATE_CTRL_SAMPLE_INTERVAL {
+			return 0x0
+		}
+	} else if addr == sim.board.AddrBaseIdent+CPUREG_OFFSET_NT_IDENT_IDENT {
+		return (sim.board.HWCRC16 << 16) | sim.board.HWVersion
+	}
+
+	return sim.regs[addr]
+}
+
+// regWrite writes a register value and triggers the actions associated with
+// the register.
+func (sim *Simulator) regWrite(addr uint32, data uint32) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	// save register value
+	dataPrev := sim.regs[addr]
+	sim.regs[addr] = data
+
+	if id, offset, ok := simDecodeAddr(sim.board.AddrBaseGenReplay, addr); ok {
+		if offset == CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_START && data&0x1 > 0 {
+			sim.startGenerator(id)
+		}
+	} else if id, offset, ok := simDecodeAddr(sim.board.AddrBaseRecvCapture, addr); ok {
+		if offset == CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ACTIVE {
+			if data&0x1 > 0 {
+				sim.startReceiver(id)
+			} else {
+				sim.recvs[id].active = false
+			}
+		}
+	} else if addr == sim.board.AddrBaseCtrl+CPUREG_OFFSET_NT_CTRL_RATE_CTRL_ACTIVE {
+		// rate control modules that are activated now start transmitting at
+		// the current point in time
+		enabled := data & ^dataPrev
+		for id := range sim.gens {
+			if (enabled>>uint(id))&0x1 > 0 {
+				sim.gens[id].tNext = sim.time
+				sim.gens[id].tLinkIdle = sim.time
+			}
+		}
+	} else if addr == sim.board.AddrBaseCtrl+CPUREG_OFFSET_NT_CTRL_RST && data&0x1 > 0 {
+		sim.reset()
+	}
+
+	// advance simulation
+	sim.run()
+}
+
+// startGenerator starts reading trace data from a generator's ring buffer.
+func (sim *Simulator) startGenerator(id int) {
+	base := sim.board.AddrBaseGenReplay[id]
+
+	gen := &sim.gens[id]
+	gen.ringBuffAddr =
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_ADDR_HI])<<32 |
+			uint64(sim.regs[base+CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_ADDR_LO])
+	gen.ringBuffSize =
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_MEM_RANGE]) + 1
+	gen.ringBuffRdPtr = 0x0
+	gen.traceSize =
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_TRACE_SIZE_HI])<<32 |
+			uint64(sim.regs[base+CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_TRACE_SIZE_LO])
+	gen.nBytesRead = 0
+	gen.fifo = nil
+	gen.active = gen.traceSize > 0
+}
+
+// startReceiver starts capturing packets to a receiver's ring buffer.
+func (sim *Simulator) startReceiver(id int) {
+	base := sim.board.AddrBaseRecvCapture[id]
+
+	recv := &sim.recvs[id]
+	recv.ringBuffAddr =
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_ADDR_HI])<<32 |
+			uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_ADDR_LO])
+	recv.ringBuffSize =
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MEM_RANGE]) + 1
+	recv.active = true
+}
+
+// reset resets the state of all simulated hardware cores. Configuration
+// registers are not affected.
+func (sim *Simulator) reset() {
+	for id := range sim.gens {
+		sim.gens[id] = simGenerator{}
+	}
+	for id := range sim.recvs {
+		sim.recvs[id] = simReceiver{}
+	}
+	for id := 0; id < len(sim.nPktsTX); id++ {
+		sim.nPktsTX[id] = 0
+		sim.nPktsRX[id] = 0
+	}
+}
+
+// run advances the simulation until no further progress can be made, i.e.
+// until the replay cores are waiting for new data to be written to the ring
+// buffers or the rate control modules are disabled.
+func (sim *Simulator) run() {
+	for {
+		progress := false
+
+		// move data from the ring buffers to the fifos
+		for id := range sim.gens {
+			if sim.fillFIFO(id) {
+				progress = true
+			}
+		}
+
+		// transmit the next packet
+		if sim.transmit() {
+			progress = true
+		}
+
+		if progress == false {
+			return
+		}
+	}
+}
+
+// fillFIFO moves data from a generator's TX ring buffer to its Block RAM FIFO.
+// It returns true if data has been moved.
+func (sim *Simulator) fillFIFO(id int) bool {
+	gen := &sim.gens[id]
+	if gen.active == false {
+		return false
+	}
+
+	// get the current write pointer position
+	ringBuffWrPtr := sim.regs[sim.board.AddrBaseGenReplay[id]+
+		CPUREG_OFFSET_NT_GEN_REPLAY_CTRL_ADDR_WR]
+
+	// determine number of bytes that can be read at once (until end of ring
+	// buffer memory)
+	var size uint64
+	if ringBuffWrPtr >= gen.ringBuffRdPtr {
+		size = uint64(ringBuffWrPtr - gen.ringBuffRdPtr)
+	} else {
+		size = gen.ringBuffSize - uint64(gen.ringBuffRdPtr)
+	}
+	if size > uint64(simFIFOSize-len(gen.fifo)) {
+		size = uint64(simFIFOSize - len(gen.fifo))
+	}
+	if size > gen.traceSize-gen.nBytesRead {
+		size = gen.traceSize - gen.nBytesRead
+	}
+
+	if size == 0 {
+		// ring buffer empty or fifo full
+		return false
+	}
+
+	// read data from ring buffer
+	data := make([]byte, size)
+	sim.memRead(gen.ringBuffAddr+uint64(gen.ringBuffRdPtr), data)
+	gen.fifo = append(gen.fifo, data...)
+
+	// update the read pointer
+	if uint64(gen.ringBuffRdPtr)+size == gen.ringBuffSize {
+		gen.ringBuffRdPtr = 0x0
+	} else {
+		gen.ringBuffRdPtr += uint32(size)
+	}
+
+	// all trace data read?
+	gen.nBytesRead += size
+	if gen.nBytesRead == gen.traceSize {
+		gen.active = false
+	}
+
+	return true
+}
+
+// transmit transmits the packet that is scheduled next among all generators
+// whose rate control module is enabled. It returns true if a packet has been
+// transmitted.
+func (sim *Simulator) transmit() bool {
+	rateCtrlActive := sim.regs[sim.board.AddrBaseCtrl+
+		CPUREG_OFFSET_NT_CTRL_RATE_CTRL_ACTIVE]
+
+	// find generator whose next packet is scheduled first
+	id := -1
+	for i := range sim.gens {
+		if (rateCtrlActive>>uint(i))&0x1 == 0 {
+			continue
+		}
+		if _, ok := sim.gens[i].peek(); ok == false {
+			continue
+		}
+		if id < 0 || sim.gens[i].tNext < sim.gens[id].tNext {
+			id = i
+		}
+	}
+
+	if id < 0 {
+		// no packet available
+		return false
+	}
+
+	gen := &sim.gens[id]
+
+	// decode meta data
+	size, _ := gen.peek()
+	meta := binary.LittleEndian.Uint64(gen.fifo[0:8])
+	cycles := meta & 0xFFFFFFFF
+	caplen := int((meta >> 32) & 0xFFFF)
+	wirelen := int((meta >> 48) & 0xFFFF)
+
+	// restore packet data. hardware appends zero bytes to restore the wire
+	// length of the packet
+	data := make([]byte, wirelen)
+	if caplen > wirelen {
+		caplen = wirelen
+	}
+	copy(data, gen.fifo[8:8+caplen])
+
+	// remove packet from fifo
+	gen.fifo = gen.fifo[size:]
+
+	// duration of a trace clock cycle and of the packet transmission at line
+	// rate (+8 byte for preamble + SOD, +12 byte for inter-frame gap, +4 byte
+	// for FCS) in picoseconds
+	tCycle := 1e12 / sim.board.FreqTrace
+	tTransfer := 1e12 * float64(8*(wirelen+24)) / sim.board.LineRate
+
+	// the packet can be sent at its scheduled time or as soon as the
+	// transmission of the previous one has ended. if it is delayed by more
+	// than one clock cycle, the trace exceeds the line rate
+	tTx := gen.tNext
+	if gen.tLinkIdle > tTx {
+		if gen.tLinkIdle-tTx > tCycle {
+			gen.errTiming = true
+		}
+		tTx = gen.tLinkIdle
+	}
+	gen.tLinkIdle = tTx + tTransfer
+	gen.tNext += float64(cycles) * tCycle
+
+	if tTx > sim.time {
+		sim.time = tTx
+	}
+
+	sim.nPktsTX[id]++
+
+	// deliver packet to receiver
+	if recvID := sim.loopback[id]; recvID >= 0 {
+		// arrival times are recorded in cycles of the hardware clock. round
+		// to the nearest cycle to avoid floating-point truncation errors
+		tArrival := uint64(math.Round(tTx * 1e-12 * sim.board.FreqClock))
+		sim.receive(recvID, data, tArrival+sim.latencyCycles)
+	}
+
+	return true
+}
+
+// peek returns the size of the packet at the head of the fifo including its
+// meta data word. Padding words are removed from the fifo. The function
+// returns false if no complete packet is available.
+func (gen *simGenerator) peek() (int, bool) {
+	for len(gen.fifo) >= 8 {
+		meta := binary.LittleEndian.Uint64(gen.fifo[0:8])
+
+		if meta == 0xFFFFFFFFFFFFFFFF {
+			// padding
+			gen.fifo = gen.fifo[8:]
+			continue
+		}
+
+		// packet data is aligned to 8 byte
+		caplen := int((meta >> 32) & 0xFFFF)
+		size := 8 + 8*((caplen+7)/8)
+
+		if len(gen.fifo) < size {
+			return 0, false
+		}
+		return size, true
+	}
+	return 0, false
+}
+
+// receive is called when a packet arrives at a receiver. The parameter
+// tArrival specifies the arrival time in clock cycles.
+func (sim *Simulator) receive(id int, data []byte, tArrival uint64) {
+	sim.nPktsRX[id]++
+
+	recv := &sim.recvs[id]
+	if recv.active == false {
+		return
+	}
+
+	// apply mac address filter
+	if sim.filterMatch(id, data) == false {
+		return
+	}
+
+	base := sim.board.AddrBaseRecvCapture[id]
+
+	// determine capture length
+	wirelen := len(data)
+	caplen := int(sim.regs[base+CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_MAX_LEN_CAPTURE])
+	if caplen > wirelen {
+		caplen = wirelen
+	}
+
+	// meta data word is followed by capture data aligned to 8 byte
+	size := 8 + 8*((caplen+7)/8)
+
+	// make sure the ring buffer does not overflow. read and write pointer
+	// must never become equal when the ring buffer contains data
+	ringBuffRdPtr := sim.regs[base+CPUREG_OFFSET_NT_RECV_CAPTURE_CTRL_ADDR_RD]
+	var used uint64
+	if recv.ringBuffWrPtr >= ringBuffRdPtr {
+		used = uint64(recv.ringBuffWrPtr - ringBuffRdPtr)
+	} else {
+		used = recv.ringBuffSize - uint64(ringBuffRdPtr-recv.ringBuffWrPtr)
+	}
+	if used+uint64(size) >= recv.ringBuffSize {
+		// flag data fifo full error and drop packet
+		recv.errs |= 0x2
+		return
+	}
+
+	// calculate the time since the previous packet arrived
+	var arrivalTime uint64
+	if recv.nPkts > 0 && tArrival > recv.tLastArrival {
+		arrivalTime = tArrival - recv.tLastArrival
+		if arrivalTime > 0xFFFFFFF {
+			arrivalTime = 0xFFFFFFF
+		}
+	}
+	recv.tLastArrival = tArrival
+
+	// assemble meta data
+	meta := arrivalTime << 25
+	meta |= uint64(wirelen&0x7FF) << 53
+	if sim.hasTimestamp(data) {
+		cyclesPerTick := uint64(sim.regs[sim.board.AddrBaseTimestamp+
+			CPUREG_OFFSET_NT_TIMESTAMP_CYCLES_PER_TICK])
+		if cyclesPerTick == 0 {
+			cyclesPerTick = 1
+		}
+
+		// the latency includes the latency of the network tester's MACs and
+		// PHYs
+		ticks := (sim.latencyCycles + LATENCY_ERR_CORRECTION_CYCLES) /
+			cyclesPerTick
+		if ticks > 0xFFFFFF {
+			ticks = 0xFFFFFF
+		}
+		meta |= ticks | (0x1 << 24)
+	}
+
+	// assemble capture record
+	record := make([]byte, size)
+	binary.LittleEndian.PutUint64(record[0:8], meta)
+	copy(record[8:], data[0:caplen])
+
+	// write record to ring buffer, wrap around if necessary
+	sizeEnd := recv.ringBuffSize - uint64(recv.ringBuffWrPtr)
+	if uint64(size) < sizeEnd {
+		sim.memWrite(recv.ringBuffAddr+uint64(recv.ringBuffWrPtr), record)
+		recv.ringBuffWrPtr += uint32(size)
+	} else {
+		sim.memWrite(recv.ringBuffAddr+uint64(recv.ringBuffWrPtr),
+			record[0:sizeEnd])
+		sim.memWrite(recv.ringBuffAddr, record[sizeEnd:])
+		recv.ringBuffWrPtr = uint32(uint64(size) - sizeEnd)
+	}
+
+	recv.nPkts++
+}
+
+// filterMatch returns true if the destination MAC address of the packet
+// matches the receiver's MAC address filter.
+func (sim *Simulator) filterMatch(id int, data []byte) bool {
+	base := sim.board.AddrBaseRecvFilterMAC[id]
+
+	addr := uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_DST_HI])<<32 |
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_DST_LO])
+	mask := uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_HI])<<32 |
+		uint64(sim.regs[base+CPUREG_OFFSET_NT_RECV_FILTER_MAC_CTRL_ADDR_MASK_DST_LO])
+
+	if mask == 0 {
+		// filter disabled
+		return true
+	}
+
+	if len(data) < 6 {
+		return false
+	}
+
+	// registers hold the address bytes in little endian order
+	dst := uint64(binary.LittleEndian.Uint16(data[4:6]))<<32 |
+		uint64(binary.LittleEndian.Uint32(data[0:4]))
+
+	return (dst^addr)&mask == 0
+}
+
+// hasTimestamp returns true if the hardware would have inserted a latency
+// timestamp into the packet.
+func (sim *Simulator) hasTimestamp(data []byte) bool {
+	mode := int(sim.regs[sim.board.AddrBaseTimestamp+CPUREG_OFFSET_NT_TIMESTAMP_MODE])
+
+	if mode == TimestampModeFixedPos {
+		return true
+	} else if mode == TimestampModeHeader {
+		// timestamps are only inserted into IPv4 and IPv6 packets
+		if len(data) < 14 {
+			return false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		return etherType == 0x0800 || etherType == 0x86DD
+	}
+	return false
+}
+
+// memRead reads data from the simulated DRAM.
+func (sim *Simulator) memRead(addr uint64, data []byte) {
+	for pos := 0; pos < len(data); {
+		page, offset := (addr+uint64(pos))/simMemPageSize,
+			(addr+uint64(pos))%simMemPageSize
+
+		n := len(data) - pos
+		if uint64(n) > simMemPageSize-offset {
+			n = int(simMemPageSize - offset)
+		}
+
+		if mem, ok := sim.mem[page]; ok {
+			copy(data[pos:pos+n], mem[offset:offset+uint64(n)])
+		} else {
+			// memory has never been written
+			for i := pos; i < pos+n; i++ {
+				data[i] = 0
+			}
+		}
+
+		pos += n
+	}
+}
+
+// memWrite writes data to the simulated DRAM.
+func (sim *Simulator) memWrite(addr uint64, data []byte) {
+	for pos := 0; pos < len(data); {
+		page, offset := (addr+uint64(pos))/simMemPageSize,
+			(addr+uint64(pos))%simMemPageSize
+
+		n := len(data) - pos
+		if uint64(n) > simMemPageSize-offset {
+			n = int(simMemPageSize - offset)
+		}
+
+		mem, ok := sim.mem[page]
+		if ok == false {
+			mem = make([]byte, simMemPageSize)
+			sim.mem[page] = mem
+		}
+		copy(mem[offset:offset+uint64(n)], data[pos:pos+n])
+
+		pos += n
+	}
+}
+
+// checkMemRange returns an error if a DMA transfer does not lie within one of
+// the DRAM memory banks of the FPGA board.
+func (sim *Simulator) checkMemRange(addr uint64, size int) error {
+	for _, bank := range sim.board.MemoryBanks {
+		if addr >= bank.Addr && addr+uint64(size) <= bank.Addr+bank.Size {
+			return nil
+		}
+	}
+	return ErrorCreate(ErrDMA, "Simulator: DMA transfer at address "+
+		"0x%016x exceeds memory range", addr)
+}
+
+// simDecodeAddr determines to which hardware core a register address belongs.
+// It expects the base addresses of the cores and returns the core id and the
+// register offset. The function returns false if the address does not belong
+// to any of the cores.
+func simDecodeAddr(bases []uint32, addr uint32) (int, uint32, bool) {
+	for id, base := range bases {
+		if addr >= base && addr-base < 0x1000 {
+			return id, addr - base, true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,860 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests replaying traces and capturing the looped back packets on the
+// simulated hardware.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPacketData returns the data of the i-th packet of a test trace. The
+// first bytes hold the packet index, the remaining bytes a pattern derived
+// from it.
+func testPacketData(i int, n int) []byte {
+	data := make([]byte, n)
+	for j := range data {
+		data[j] = byte(i*7 + j)
+	}
+	if n >= 4 {
+		data[0], data[1], data[2], data[3] =
+			byte(i>>24), byte(i>>16), byte(i>>8), byte(i)
+	}
+	return data
+}
+
+// testCyclesTransfer returns the number of clock cycles (rounded up) it takes
+// to transmit a packet with the specified wire length at 10 Gbps.
+func testCyclesTransfer(wirelen int) int {
+	return int(math.Ceil(float64(wirelen+24) / 8))
+}
+
+// testTraceCreate creates a trace with nPkts packets. pktlen returns the wire
+// and capture length of the i-th packet, cycles its inter-packet time. If
+// cycles is nil, packets are sent back-to-back at line rate.
+func testTraceCreate(t *testing.T, nPkts int, nRepeats int, pktlen func(i int) (int, int), cycles func(i int, wirelen int) int) *Trace {
+	builder := TraceBuilderCreate()
+	for i := 0; i < nPkts; i++ {
+		wirelen, caplen := pktlen(i)
+		cyclesInterPacket := testCyclesTransfer(wirelen)
+		if cycles != nil {
+			cyclesInterPacket = cycles(i, wirelen)
+		}
+
+		err := builder.AddPacket(TracePacket{
+			CyclesInterPacket: cyclesInterPacket,
+			Wirelen:           wirelen,
+			Caplen:            caplen,
+			Data:              testPacketData(i, caplen),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trace, err := builder.GetTrace(nRepeats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace
+}
+
+// testNetworkTesterCreate creates a network tester accessing a simulator.
+func testNetworkTesterCreate(t *testing.T) (*NetworkTester, *Simulator) {
+	sim := SimulatorCreate()
+	nt, err := NetworkTesterCreateWithBackend(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nt, sim
+}
+
+// testReplay replays the trace on generator 0 and captures the looped back
+// packets on receiver 0. It returns the errors of StartReplay() and
+// StopCapture().
+func testReplay(t *testing.T, nt *NetworkTester, trace *Trace) (error, error) {
+	gen, _ := nt.GetGenerator(0)
+	gen.SetTrace(trace)
+
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartCapture(); err != nil {
+		t.Fatal(err)
+	}
+	errReplay := nt.StartReplay()
+	errCapture := nt.StopCapture()
+	return errReplay, errCapture
+}
+
+// testReplayCheck calls testReplay and fails the test if an error occurred.
+func testReplayCheck(t *testing.T, nt *NetworkTester, trace *Trace) {
+	errReplay, errCapture := testReplay(t, nt, trace)
+	if errReplay != nil {
+		t.Fatal(errReplay)
+	}
+	if errCapture != nil {
+		t.Fatal(errCapture)
+	}
+}
+
+// testCapturePackets returns the packets captured by a receiver.
+func testCapturePackets(t *testing.T, recv *Receiver) CapturePackets {
+	capture, err := recv.GetCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return capture.GetPackets()
+}
+
+// testCheckPacket checks that a captured packet matches the i-th packet of a
+// trace created by testTraceCreate.
+func testCheckPacket(t *testing.T, pkt CapturePacket, i int, wirelen int, caplen int, caplenCapture int) {
+	// MAC appends the FCS
+	if pkt.Wirelen != wirelen+4 {
+		t.Fatalf("packet %d: wire length %d, expected %d", i, pkt.Wirelen,
+			wirelen+4)
+	}
+
+	// hardware restores the wire length by appending zero bytes
+	dataExp := make([]byte, wirelen)
+	copy(dataExp, testPacketData(i, caplen))
+	if caplenCapture < wirelen {
+		dataExp = dataExp[0:caplenCapture]
+	}
+	if bytes.Equal(pkt.Data, dataExp) == false {
+		t.Fatalf("packet %d: captured data does not match", i)
+	}
+}
+
+// testBoard25G returns the profile of a fictional 8-port 25 Gbps variant of
+// the network tester with register base addresses and clock frequencies that
+// differ from the NetFPGA-SUME.
+func testBoard25G() BoardProfile {
+	board := BoardNetFPGASUME
+	board.Name = "test-25G"
+	board.NInterfaces = 8
+	board.FreqClock = 390.625e6
+	board.FreqTrace = 390.625e6
+	board.LineRate = 25e9
+
+	addrBases := make([][]uint32, 6)
+	for i := range addrBases {
+		addrBases[i] = make([]uint32, board.NInterfaces)
+		for j := range addrBases[i] {
+			addrBases[i][j] = uint32(i*0x10000 + j*0x1000)
+		}
+	}
+	board.AddrBaseGenReplay = addrBases[0]
+	board.AddrBaseGenRateCtrl = addrBases[1]
+	board.AddrBaseRecvCapture = addrBases[2]
+	board.AddrBaseRecvFilterMAC = addrBases[3]
+	board.AddrBaseIface = addrBases[4]
+	board.AddrBaseDatarate = addrBases[5]
+	board.AddrBaseCtrl = 0x60000
+	board.AddrBaseTimestamp = 0x61000
+	board.AddrBaseIdent = 0x62000
+
+	return board
+}
+
+// TestSimulatorLoopback replays a trace with varying packet lengths and
+// checks that all packets and bytes arrive at the receiver unmodified and
+// with the inter-packet times specified in the trace.
+func TestSimulatorLoopback(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	nPkts := 1000
+	pktlen := func(i int) (int, int) {
+		wirelen := 60 + (i*37)%1455
+		return wirelen, wirelen
+	}
+	trace := testTraceCreate(t, nPkts, 1, pktlen, nil)
+
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(1518, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	testReplayCheck(t, nt, trace)
+
+	pkts := testCapturePackets(t, recv)
+	if len(pkts) != nPkts {
+		t.Fatalf("captured %d packets, expected %d", len(pkts), nPkts)
+	}
+
+	var nBytesTX, nBytesRX int
+	for i, pkt := range pkts {
+		wirelen, caplen := pktlen(i)
+		testCheckPacket(t, pkt, i, wirelen, caplen, 1518)
+		nBytesTX += wirelen + 4
+		nBytesRX += pkt.Wirelen
+
+		// the arrival time is the time since the previous packet arrived
+		if i > 0 {
+			wirelenPrev, _ := pktlen(i - 1)
+			arrivalTime := float64(testCyclesTransfer(wirelenPrev)) / FREQ_SFP
+			if math.Abs(pkt.ArrivalTime-arrivalTime) > 1e-12 {
+				t.Fatalf("packet %d: arrival time %g, expected %g", i,
+					pkt.ArrivalTime, arrivalTime)
+			}
+		}
+	}
+	if nBytesRX != nBytesTX {
+		t.Fatalf("received %d bytes, expected %d", nBytesRX, nBytesTX)
+	}
+
+	iface, _ := nt.GetInterface(0)
+	if iface.GetPacketCountTX() != nPkts || iface.GetPacketCountRX() != nPkts {
+		t.Fatalf("interface counted %d TX and %d RX packets, expected %d",
+			iface.GetPacketCountTX(), iface.GetPacketCountRX(), nPkts)
+	}
+}
+
+// TestSimulatorLatency loops the packets of generator 0 back to receiver 1
+// with an additional latency and checks the latency values calculated from
+// the timestamps.
+func TestSimulatorLatency(t *testing.T) {
+	nt, sim := testNetworkTesterCreate(t)
+	if err := sim.SetLoopback(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetLatency(time.Microsecond)
+
+	if err := nt.SetTimestampMode(TimestampModeFixedPos); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.SetTimestampPos(16); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.SetTimestampWidth(24); err != nil {
+		t.Fatal(err)
+	}
+
+	nPkts := 100
+	trace := testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 60, 60
+	}, nil)
+
+	recv0, _ := nt.GetReceiver(0)
+	recv1, _ := nt.GetReceiver(1)
+	for _, recv := range []*Receiver{recv0, recv1} {
+		if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testReplayCheck(t, nt, trace)
+
+	if n := len(testCapturePackets(t, recv0)); n != 0 {
+		t.Fatalf("receiver 0 captured %d packets, expected 0", n)
+	}
+
+	pkts := testCapturePackets(t, recv1)
+	if len(pkts) != nPkts {
+		t.Fatalf("receiver 1 captured %d packets, expected %d", len(pkts),
+			nPkts)
+	}
+
+	// latency is a multiple of the clock period
+	latency := math.Floor(1e-6*FREQ_SFP) / FREQ_SFP
+	for i, pkt := range pkts {
+		testCheckPacket(t, pkt, i, 60, 60, 64)
+		if pkt.HasLatency == false ||
+			math.Abs(pkt.Latency-latency) > 1e-12 {
+			t.Fatalf("packet %d: latency %g, expected %g", i, pkt.Latency,
+				latency)
+		}
+	}
+}
+
+// TestSimulatorBoard25G replays a trace on the last network interface of an
+// 8-port 25 Gbps board and checks that the packets arrive back-to-back at the
+// board's line rate. Traces created for the default clock are rejected.
+func TestSimulatorBoard25G(t *testing.T) {
+	board := testBoard25G()
+	sim, err := SimulatorCreateWithBoard(board)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt, err := NetworkTesterCreateWithBackendAndBoard(sim, board)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 104 bytes + 24 bytes of overhead take 16 clock cycles at 25 Gbps
+	nPkts := 100
+	builder, err := TraceBuilderCreateWithClock(board.FreqTrace, board.LineRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nPkts; i++ {
+		err := builder.AddPacket(TracePacket{
+			CyclesInterPacket: 16,
+			Wirelen:           104,
+			Caplen:            104,
+			Data:              testPacketData(i, 104),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	trace, err := builder.GetTrace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := nt.GetGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv, err := nt.GetReceiver(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.EnableCapture(128, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	// trace created for the NetFPGA-SUME clock
+	gen.SetTrace(testTraceCreate(t, 1, 1, func(i int) (int, int) {
+		return 60, 60
+	}, nil))
+	if err := nt.WriteConfig(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	gen.SetTrace(trace)
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartReplay(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts := testCapturePackets(t, recv)
+	if len(pkts) != nPkts {
+		t.Fatalf("captured %d packets, expected %d", len(pkts), nPkts)
+	}
+	arrivalTime := 16 / board.FreqClock
+	for i, pkt := range pkts {
+		testCheckPacket(t, pkt, i, 104, 104, 128)
+		if i > 0 && math.Abs(pkt.ArrivalTime-arrivalTime) > 1e-12 {
+			t.Fatalf("packet %d: arrival time %g, expected %g", i,
+				pkt.ArrivalTime, arrivalTime)
+		}
+	}
+}
+
+// testBackendNoDMA is a Backend wrapping a simulator, which provides no
+// card-to-host DMA channels.
+type testBackendNoDMA struct {
+	*Simulator
+}
+
+// GetDMARead returns no DMA channels.
+func (backend *testBackendNoDMA) GetDMARead() []DMA {
+	return nil
+}
+
+// TestSimulatorBoardCopy checks that the network tester does not share the
+// slices of its board profile with the application.
+func TestSimulatorBoardCopy(t *testing.T) {
+	board := testBoard25G()
+	sim, err := SimulatorCreateWithBoard(board)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt, err := NetworkTesterCreateWithBackendAndBoard(sim, board)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := board.AddrBaseIface[0]
+	board.AddrBaseIface[0] = 0xFFFFFFFF
+	nt.GetBoard().AddrBaseIface[0] = 0xFFFFFFFF
+	if a := nt.GetBoard().AddrBaseIface[0]; a != addr {
+		t.Fatalf("interface base address 0x%08x, expected 0x%08x", a, addr)
+	}
+
+	// the profile of the NetFPGA-SUME shares its slices with the register
+	// address tables
+	nt, _ = testNetworkTesterCreate(t)
+	nt.GetBoard().AddrBaseIface[0] = 0xFFFFFFFF
+	if ADDR_BASE_IFACE[0] == 0xFFFFFFFF ||
+		BoardNetFPGASUME.AddrBaseIface[0] == 0xFFFFFFFF {
+		t.Fatal("interface base address of the NetFPGA-SUME modified")
+	}
+}
+
+// TestSimulatorDMAChannels replays traces on all generators using three DMA
+// channels per direction and checks that the TX ring buffer transfers are
+// distributed across all channels.
+func TestSimulatorDMAChannels(t *testing.T) {
+	sim := SimulatorCreate()
+	if err := sim.SetDMAChannelCount(0); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if err := sim.SetDMAChannelCount(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// at least one DMA channel per direction is required
+	_, err := NetworkTesterCreateWithBackend(&testBackendNoDMA{sim})
+	if errors.Is(err, ErrHardwareAccess) == false {
+		t.Fatalf("expected hardware access error, got: %v", err)
+	}
+
+	nt, err := NetworkTesterCreateWithBackend(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// generators are assigned to the channels in a round-robin fashion
+	gensPerChannel := nt.gens.distribute(3)
+	if len(gensPerChannel) != 3 || len(gensPerChannel[0]) != 2 ||
+		gensPerChannel[0][1].id != 3 {
+		t.Fatalf("invalid distribution of generators: %v", gensPerChannel)
+	}
+
+	nPkts := 1000
+	trace := testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 1514, 1514
+	}, nil)
+	for i := 0; i < N_INTERFACES; i++ {
+		gen, _ := nt.GetGenerator(i)
+		gen.SetTrace(trace)
+		recv, _ := nt.GetReceiver(i)
+		err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartCapture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartReplay(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < N_INTERFACES; i++ {
+		recv, _ := nt.GetReceiver(i)
+		if n := len(testCapturePackets(t, recv)); n != nPkts {
+			t.Fatalf("receiver %d: captured %d packets, expected %d", i, n,
+				nPkts)
+		}
+	}
+
+	stats := nt.GetDMAStats()
+	if len(stats) != 6 {
+		t.Fatalf("statistics for %d DMA channels, expected 6", len(stats))
+	}
+	var nBytesH2C, nBytesC2H uint64
+	for _, stat := range stats {
+		if stat.Direction == DMADirectionC2H {
+			// remaining capture data is read after the capture goroutines
+			// have stopped, so not every channel may be used
+			nBytesC2H += stat.NBytes
+			continue
+		}
+		if stat.NTransfers == 0 {
+			t.Fatalf("DMA h2c %d did not transfer data", stat.Channel)
+		}
+		nBytesH2C += stat.NBytes
+	}
+	if nBytesC2H == 0 {
+		t.Fatal("no data transferred from the card")
+	}
+	if nBytesH2C != N_INTERFACES*trace.GetSize() {
+		t.Fatalf("transferred %d bytes to the card, expected %d", nBytesH2C,
+			N_INTERFACES*trace.GetSize())
+	}
+}
+
+// TestSimulatorCaptureState checks that capturing can only be stopped while
+// it is running and that it can be started again afterwards.
+func TestSimulatorCaptureState(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nt.StopCapture(); errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid config error, got: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := nt.StartCapture(); err != nil {
+			t.Fatal(err)
+		}
+		err := nt.StartCapture()
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("expected invalid config error, got: %v", err)
+		}
+		if err := nt.StopCapture(); err != nil {
+			t.Fatal(err)
+		}
+		err = nt.StopCapture()
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("expected invalid config error, got: %v", err)
+		}
+	}
+}
+
+// TestSimulatorRingBuffTXWrapAround replays a repeated trace, whose total size
+// exceeds the TX ring buffer size, and checks that all packets arrive in
+// order.
+func TestSimulatorRingBuffTXWrapAround(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	// 42 packets of 1528 bytes (incl. meta data) result in 64 KByte of trace
+	// data. 1100 repetitions exceed the ring buffer size
+	nPkts, nRepeats := 42, 1100
+	ringBuffSize := uint64(RING_BUFF_WR_TRANSFER_SIZE_MAX + 16384)
+	trace := testTraceCreate(t, nPkts, nRepeats, func(i int) (int, int) {
+		return 1514, 1514
+	}, nil)
+	if trace.GetSize()*uint64(nRepeats) <= ringBuffSize {
+		t.Fatal("trace does not wrap around the ring buffer")
+	}
+
+	gen, _ := nt.GetGenerator(0)
+	if err := gen.SetRingBuffSize(ringBuffSize); err != nil {
+		t.Fatal(err)
+	}
+
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	testReplayCheck(t, nt, trace)
+
+	pkts := testCapturePackets(t, recv)
+	if len(pkts) != nPkts*nRepeats {
+		t.Fatalf("captured %d packets, expected %d", len(pkts),
+			nPkts*nRepeats)
+	}
+	for i, pkt := range pkts {
+		testCheckPacket(t, pkt, i%nPkts, 1514, 1514, 64)
+	}
+}
+
+// TestSimulatorRingBuffRXWrapAround captures more data than fits into the RX
+// ring buffer and checks that all packets are written to the capture file in
+// order.
+func TestSimulatorRingBuffRXWrapAround(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	// the TX ring buffer is filled before the replay starts and transmitted
+	// at once, so it must be smaller than the RX ring buffer. afterwards the
+	// trace is transmitted in blocks of 64 KByte (42 packets of 1528 bytes
+	// incl. meta data). 92400 captured packets exceed the RX ring buffer size
+	nPkts, nRepeats := 42, 2200
+	ringBuffSizeTX := uint64(RING_BUFF_WR_TRANSFER_SIZE_MAX + 16384)
+	ringBuffSizeRX := uint64(2 * RING_BUFF_RD_TRANSFER_SIZE_MIN)
+	trace := testTraceCreate(t, nPkts, nRepeats, func(i int) (int, int) {
+		return 1514, 1514
+	}, nil)
+	if uint64(nPkts*nRepeats*1528) <= ringBuffSizeRX {
+		t.Fatal("capture does not wrap around the ring buffer")
+	}
+
+	gen, _ := nt.GetGenerator(0)
+	if err := gen.SetRingBuffSize(ringBuffSizeTX); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "capture.raw")
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.SetRingBuffSize(ringBuffSizeRX); err != nil {
+		t.Fatal(err)
+	}
+	err := recv.EnableCaptureToFile(1518, filename, CaptureFileFormatRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testReplayCheck(t, nt, trace)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for len(data) > 0 {
+		pkt, size, valid := captureRecordParse(data, 1518, 0, FREQ_SFP)
+		if size == 0 || valid == false {
+			break
+		}
+		testCheckPacket(t, pkt, n%nPkts, 1514, 1514, 1518)
+		data = data[size:]
+		n++
+	}
+	if n != nPkts*nRepeats {
+		t.Fatalf("captured %d packets, expected %d", n, nPkts*nRepeats)
+	}
+}
+
+// TestSimulatorCaptureOverflow sends more data than fits into the RX ring
+// buffer at once and checks that the data FIFO full error is reported.
+func TestSimulatorCaptureOverflow(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	// the trace is written to the TX ring buffer with a single transfer, so
+	// the looped back packets arrive before the RX ring buffer can be read
+	ringBuffSize := uint64(RING_BUFF_RD_TRANSFER_SIZE_MIN + 16384)
+	nPkts := int(ringBuffSize/1528) + 1000
+	trace := testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 1514, 64
+	}, nil)
+
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.SetRingBuffSize(ringBuffSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.EnableCapture(1518, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	errReplay, errCapture := testReplay(t, nt, trace)
+	if errReplay != nil {
+		t.Fatal(errReplay)
+	}
+	if errors.Is(errCapture, ErrRingBuffOverflow) == false {
+		t.Fatalf("expected ring buffer overflow error, got: %v", errCapture)
+	}
+
+	errs := nt.bar.Read(ADDR_BASE_NT_RECV_CAPTURE[0] +
+		CPUREG_OFFSET_NT_RECV_CAPTURE_STATUS_ERRS)
+	if errs&0x2 == 0 {
+		t.Fatalf("data FIFO full error bit not set (errors: 0x%x)", errs)
+	}
+
+	nCaptured, err := recv.GetPacketCountCaptured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nCaptured >= nPkts {
+		t.Fatalf("captured %d packets, expected packets to be dropped",
+			nCaptured)
+	}
+}
+
+// TestSimulatorReplayCancel cancels a long-running replay and checks that
+
+// TestSimulatorReplayCancel cancels a long-running replay and checks that
+// StartReplayContext() returns early with the context's error. Afterwards a
+// new replay must succeed.
+func TestSimulatorReplayCancel(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	// trace with a duration of several minutes
+	trace := testTraceCreate(t, 10, 1, func(i int) (int, int) {
+		return 60, 60
+	}, func(i int, wirelen int) int {
+		return 0xFFFFFFFF
+	})
+
+	gen, _ := nt.GetGenerator(0)
+	gen.SetTrace(trace)
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	tStart := time.Now()
+	err := nt.StartReplayContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatalf("expected context deadline error, got: %v", err)
+	}
+	if d := time.Since(tStart); d > 5*time.Second {
+		t.Fatalf("replay returned %s after the context was cancelled", d)
+	}
+
+	// replay can be started again after the configuration has been written
+	nPkts := 100
+	trace = testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 60, 60
+	}, nil)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+	testReplayCheck(t, nt, trace)
+
+	if n := len(testCapturePackets(t, recv)); n != nPkts {
+		t.Fatalf("captured %d packets, expected %d", n, nPkts)
+	}
+}
+
+// TestSimulatorReplayProgress checks that the replay progress is reported
+// periodically and that the final report covers the entire trace.
+func TestSimulatorReplayProgress(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+
+	trace := testTraceCreate(t, 1000, 20, func(i int) (int, int) {
+		return 1514, 1514
+	}, nil)
+
+	var reports [][]ReplayProgress
+	nt.SetReplayProgressFunc(10*time.Millisecond,
+		func(progress []ReplayProgress) {
+			reports = append(reports, progress)
+		})
+
+	gen, _ := nt.GetGenerator(0)
+	gen.SetTrace(trace)
+	if err := nt.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.StartReplay(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("replay progress has not been reported")
+	}
+	nBytesPrev := uint64(0)
+	for i, progress := range reports {
+		if len(progress) != 1 || progress[0].GeneratorID != 0 ||
+			progress[0].NBytesTotal != trace.GetSize() {
+			t.Fatalf("report %d: invalid progress %+v", i, progress)
+		}
+		if progress[0].NBytesTransfered < nBytesPrev {
+			t.Fatalf("report %d: transferred bytes decreased", i)
+		}
+		nBytesPrev = progress[0].NBytesTransfered
+	}
+	if nBytesPrev != trace.GetSize() {
+		t.Fatalf("final report: %d of %d bytes transferred", nBytesPrev,
+			trace.GetSize())
+	}
+}
+
+// TestSimulatorReplayDrain checks that the replay waits for the duration of
+// the trace before stopping the rate control module, but does not wait longer
+// than necessary for short traces.
+func TestSimulatorReplayDrain(t *testing.T) {
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	// short trace: replay returns well before the fixed delays of previous
+	// versions (2 seconds in total) would have passed
+	trace := testTraceCreate(t, 100, 1, func(i int) (int, int) {
+		return 60, 60
+	}, nil)
+	tStart := time.Now()
+	testReplayCheck(t, nt, trace)
+	if d := time.Since(tStart); d >= 2*time.Second {
+		t.Fatalf("replay of short trace took %s", d)
+	}
+
+	// trace with large inter-packet times: replay lasts at least as long as
+	// the trace and all packets are captured
+	nPkts := 4
+	trace = testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 60, 60
+	}, func(i int, wirelen int) int {
+		return int(0.1 * FREQ_SFP)
+	})
+	duration, err := trace.GetDuration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tStart = time.Now()
+	testReplayCheck(t, nt, trace)
+	if d := time.Since(tStart); d < duration {
+		t.Fatalf("replay took %s, trace duration is %s", d, duration)
+	}
+	if n := len(testCapturePackets(t, recv)); n != nPkts {
+		t.Fatalf("captured %d packets, expected %d", n, nPkts)
+	}
+}
+
+// TestWaitContext checks that polling a condition reports whether the
+// condition became true before the timeout expired.
+func TestWaitContext(t *testing.T) {
+	n := 0
+	ok, err := waitContext(context.Background(), time.Second, func() bool {
+		n++
+		return n == 3
+	})
+	if ok == false || err != nil {
+		t.Fatalf("expected condition to become true, got: %t, %v", ok, err)
+	}
+
+	ok, err = waitContext(context.Background(), 10*time.Millisecond,
+		func() bool {
+			return false
+		})
+	if ok || err != nil {
+		t.Fatalf("expected timeout, got: %t, %v", ok, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ok, err = waitContext(ctx, time.Second, func() bool {
+		return false
+	})
+	if ok || errors.Is(err, context.Canceled) == false {
+		t.Fatalf("expected context error, got: %t, %v", ok, err)
+	}
+}
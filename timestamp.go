@@ -61,7 +61,7 @@ func (timestamp *timestamp) setCyclesPerTick(cycles int) {
 // getTickPeriod returns the time period (in seconds) that passes between
 // two counter increments.
 func (timestamp *timestamp) getTickPeriod() float64 {
-	return float64(timestamp.cyclesPerTick) / FREQ_SFP
+	return float64(timestamp.cyclesPerTick) / timestamp.nt.board.FreqClock
 }
 
 // setMode selects the timestamp insertion/extraction mode. If the mode is set
@@ -70,92 +70,102 @@ func (timestamp *timestamp) getTickPeriod() float64 {
 // set to 'TimestampModeFixedPos', the timestamp is inserted in the packet data
 // at a configurable byte position. If the mode is set to
 // 'TimestampModeDisabled', no timestamp is inserted at all.
-func (timestamp *timestamp) setMode(mode int) {
+func (timestamp *timestamp) setMode(mode int) error {
 	if mode != TimestampModeFixedPos && mode != TimestampModeHeader {
-		Log(LOG_ERR, "Timestamp: invalid timestamping mode")
+		return ErrorCreate(ErrInvalidConfig,
+			"Timestamp: invalid timestamping mode")
 	}
 	timestamp.mode = mode
+	return nil
 }
 
 // setPos specifies the byte position where the timestamp shall be inserted in
 // the packet data. It requires the timestamping mode to be set to
 // 'TimestampModeFixedPos'.
-func (timestamp *timestamp) setPos(pos int) {
+func (timestamp *timestamp) setPos(pos int) error {
 	if timestamp.mode != TimestampModeFixedPos {
-		Log(LOG_ERR, "Timestamp: cannot set timestamp position when mode is "+
-			"not set to 'TimestampModeFixedPos'")
+		return ErrorCreate(ErrInvalidConfig, "Timestamp: cannot set "+
+			"timestamp position when mode is not set to "+
+			"'TimestampModeFixedPos'")
 	}
 	if pos < 0 || pos > 1518 {
-		Log(LOG_ERR, "Timestamp: invalid timestamp position")
+		return ErrorCreate(ErrInvalidConfig,
+			"Timestamp: invalid timestamp position")
 	}
 	timestamp.pos = pos
+	return nil
 }
 
 // setWidth sets the width of the timestamp that is inserted in the packet.
 // Currently the values 16 and 24 (bits) are supported.
-func (timestamp *timestamp) setWidth(width int) {
+func (timestamp *timestamp) setWidth(width int) error {
 	if timestamp.mode != TimestampModeFixedPos {
-		Log(LOG_ERR, "Timestamp: cannot set timestamp when when mode is "+
-			"not set to 'TimestampModeFixedPos'")
+		return ErrorCreate(ErrInvalidConfig, "Timestamp: cannot set "+
+			"timestamp when when mode is not set to 'TimestampModeFixedPos'")
 	}
 	if width != 16 && width != 24 {
-		Log(LOG_ERR, "Timestamp: timestamp width must be either 16 or 24 bit")
+		return ErrorCreate(ErrInvalidConfig,
+			"Timestamp: timestamp width must be either 16 or 24 bit")
 	}
 	timestamp.width = width
+	return nil
 }
 
 // configHardware writes the configuration to the hardware.
-func (timestamp *timestamp) configHardware() {
+func (timestamp *timestamp) configHardware() error {
 	if timestamp.mode == TimestampModeFixedPos {
 		if timestamp.width == 16 {
 			// timestamp position valid? currently timestamps may not spread
 			// across two 8 byte data words
 			if timestamp.pos%8 > 6 {
-				Log(LOG_ERR, "Timestamp: invalid timestamp position")
+				return ErrorCreate(ErrInvalidConfig,
+					"Timestamp: invalid timestamp position")
 			}
 
 			// write timestamp width
-			timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+			timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 				CPUREG_OFFSET_NT_TIMESTAMP_WIDTH, 0x0)
 		} else if timestamp.width == 24 {
 			// timestamp position valid? currently timestamps may not spread
 			// across two 8 byte data words
 			if timestamp.pos%8 > 5 {
-				Log(LOG_ERR, "Timestamp: invalid timestamp position")
+				return ErrorCreate(ErrInvalidConfig,
+					"Timestamp: invalid timestamp position")
 			}
 
 			// write timestamp width
-			timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+			timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 				CPUREG_OFFSET_NT_TIMESTAMP_WIDTH, 0x1)
 		} else {
-			Log(LOG_ERR, "Timestamp: timestamp width not configured")
+			return ErrorCreate(ErrInvalidConfig,
+				"Timestamp: timestamp width not configured")
 		}
 
 		// write timestamp position
-		timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+		timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 			CPUREG_OFFSET_NT_TIMESTAMP_POS, uint32(timestamp.pos))
 	} else if timestamp.mode == TimestampModeHeader {
 		// reset timestamp position and width to zero
-		timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+		timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 			CPUREG_OFFSET_NT_TIMESTAMP_POS, 0x0)
-		timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+		timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 			CPUREG_OFFSET_NT_TIMESTAMP_WIDTH, 0x0)
 	} else if timestamp.mode == TimestampModeDisabled {
 		// reset timestamp position and width to zero
-		timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+		timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 			CPUREG_OFFSET_NT_TIMESTAMP_POS, 0x0)
-		timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+		timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 			CPUREG_OFFSET_NT_TIMESTAMP_WIDTH, 0x0)
 	} else {
-		Log(LOG_ERR, "Timestamp: invalid mode")
+		return ErrorCreate(ErrInvalidConfig, "Timestamp: invalid mode")
 	}
 
 	// write timestamp mode
-	timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+	timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 		CPUREG_OFFSET_NT_TIMESTAMP_MODE, uint32(timestamp.mode))
 
 	// set timestamp tick interval
-	timestamp.nt.pcieBAR.Write(ADDR_BASE_NT_TIMESTAMP+
+	timestamp.nt.bar.Write(timestamp.nt.board.AddrBaseTimestamp+
 		CPUREG_OFFSET_NT_TIMESTAMP_CYCLES_PER_TICK,
 		uint32(timestamp.cyclesPerTick))
 
@@ -171,4 +181,6 @@ func (timestamp *timestamp) configHardware() {
 	} else if timestamp.mode == TimestampModeDisabled {
 		Log(LOG_DEBUG, "Timestamp: mode 'TimestampModeDisabled'")
 	}
+
+	return nil
 }
@@ -45,30 +45,86 @@ type Trace struct {
 
 	fromFile bool // flag indicating whether the trace has been read from a file
 
-	// number of packets in the trace. currently only set for synthetically
-	// generated traces, not for traces read from a file
+	// if not nil, trace data is not held in host memory, but read from the
+	// trace file during replay
+	reader *traceFileReader
+
+	// flag indicating whether the trace data has been parsed to obtain the
+	// packet count, duration and amount of data sent on the wire. file-backed
+	// traces are parsed on demand
+	parsed bool
+
+	// number of packets in the trace
 	nPackets int
 
-	// duration of the trace. currently only set for synthetically generated
-	// traces, not for traces read from a file
+	// duration of the trace
 	duration time.Duration
+
+	// number of bytes sent on the wire, including Ethernet preamble, SOD, FCS
+	// and inter-frame gap
+	nBytesWire uint64
+
+	// seed of the random number generator used to generate the trace
+	seed    int64
+	hasSeed bool
+
+	// clock the inter-packet times are specified in and line rate
+	clock traceClock
+}
+
+// traceClock describes the clock the inter-packet times of a trace are
+// specified in and the line rate of the network interfaces the trace is
+// replayed on.
+type traceClock struct {
+	freq     float64 // clock frequency (Hz)
+	lineRate float64 // line rate (bits per second)
+}
+
+// clock of the NetFPGA-SUME, traces are created for it by default
+var traceClockDefault = traceClock{freq: FREQ_SFP, lineRate: LINE_RATE}
+
+// traceClockCreate creates a trace clock with the specified frequency and line
+// rate.
+func traceClockCreate(freq, lineRate float64) (traceClock, error) {
+	if freq <= 0 || lineRate <= 0 {
+		return traceClock{}, ErrorCreate(ErrInvalidConfig, "Trace: clock "+
+			"frequency and line rate must be larger than zero")
+	}
+	return traceClock{freq: freq, lineRate: lineRate}, nil
+}
+
+// cyclesTransfer returns the number of clock cycles it takes to transmit a
+// packet with the specified wire length (excluding FCS) at line rate. 24 bytes
+// are added for FCS, preamble, SOD and inter-frame gap.
+func (clock traceClock) cyclesTransfer(wirelen int) float64 {
+	return clock.freq * float64(8*(wirelen+24)) / clock.lineRate
+}
+
+// duration converts a number of clock cycles to a duration.
+func (clock traceClock) duration(cycles uint64) time.Duration {
+	return time.Duration(float64(cycles)/clock.freq*1e9) * time.Nanosecond
 }
 
 // TraceCreateFromFile creates a trace instance for a trace specified by its
 // filename. The function also expects a parameter specifying the number of
-// times the trace shall be replayed.
-func TraceCreateFromFile(filename string, nRepeats int) *Trace {
+// times the trace shall be replayed. The trace data is parsed once after
+// reading to record its packet count and duration.
+func TraceCreateFromFile(filename string, nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
 	// open the trace file
 	traceFile, err := os.Open(filename)
 	if err != nil {
-		Log(LOG_ERR, "Trace '%s': could not open file", filename)
+		return nil, ErrorCreate(ErrFile, "Trace '%s': could not open file",
+			filename)
 	}
 	defer traceFile.Close()
 
 	// get file info
 	traceFileInfo, err := traceFile.Stat()
 	if err != nil {
-		Log(LOG_ERR, "Trace '%s': could not stat file", filename)
+		return nil, ErrorCreate(ErrFile, "Trace '%s': could not stat file",
+			filename)
 	}
 
 	// get the file size
@@ -76,8 +132,8 @@ func TraceCreateFromFile(filename string, nRepeats int) *Trace {
 
 	// file size must always be a multiple of 64 bytes
 	if traceFileSize%64 != 0 {
-		Log(LOG_ERR, "Trace '%s': invalid file size (must be a multiple of "+
-			"64 bytes)", filename)
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace '%s': invalid file "+
+			"size (must be a multiple of 64 bytes)", filename)
 	}
 
 	// create a trace struct and store information
@@ -85,6 +141,7 @@ func TraceCreateFromFile(filename string, nRepeats int) *Trace {
 		size:     uint64(traceFileSize),
 		nRepeats: nRepeats,
 		fromFile: true,
+		clock:    traceClockDefault,
 	}
 
 	// allocate data slice memory
@@ -99,23 +156,52 @@ func TraceCreateFromFile(filename string, nRepeats int) *Trace {
 	for i := int64(0); i < traceFileSize/64; i++ {
 		_, err := r.Read(trace.data[i*64 : (i+1)*64])
 		if err != nil {
-			Log(LOG_ERR, "Trace '%s': could not read file", filename)
+			return nil, ErrorCreate(ErrFile, "Trace '%s': could not read file",
+				filename)
 		}
 	}
 
 	Log(LOG_DEBUG, "Trace '%s': reading file done", filename)
 
-	return &trace
+	// record packet count, duration and amount of data sent on the wire
+	if err := trace.parse(); err != nil {
+		return nil, err
+	}
+
+	return &trace, nil
 }
 
 // TraceCreateFromData creates a trace instance for a trace specified by its
 // data in form of a byte slice. The function also expects parameters
 // specifying the number of packets the trace includes, the duration and the
-// number of times the trace shall be replayed.
-func TraceCreateFromData(data []byte, nPackets int, duration time.Duration, nRepeats int) *Trace {
+// number of times the trace shall be replayed. If the packet count or the
+// duration is zero, both are determined by parsing the trace data.
+func TraceCreateFromData(data []byte, nPackets int, duration time.Duration, nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	trace, err := traceCreateFromData(data, nRepeats)
+	if err != nil {
+		return nil, err
+	}
+
+	if nPackets > 0 && duration > 0 {
+		trace.nPackets = nPackets
+		trace.duration = duration
+	} else if err := trace.parse(); err != nil {
+		// packet count and duration are unknown, record them now
+		return nil, err
+	}
+
+	return trace, nil
+}
+
+// traceCreateFromData creates a trace instance for the trace data without
+// setting its packet count and duration.
+func traceCreateFromData(data []byte, nRepeats int) (*Trace, error) {
 	// trace size must be a multiple of 64 bytes
 	if len(data)%64 != 0 {
-		Log(LOG_ERR, "Trace: invalid size (must be a multiple of 64 bytes)")
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: invalid size (must be a multiple of 64 bytes)")
 	}
 
 	// create Trace
@@ -123,19 +209,33 @@ func TraceCreateFromData(data []byte, nPackets int, duration time.Duration, nRep
 		size:     uint64(len(data)),
 		data:     data,
 		fromFile: false,
-		nPackets: nPackets,
 		nRepeats: nRepeats,
-		duration: duration,
+		clock:    traceClockDefault,
 	}
-	return &trace
+	return &trace, nil
 }
 
 // WriteFile writes the trace data to an output file.
-func (trace *Trace) WriteFile(filename string) {
-	err := ioutil.WriteFile(filename, trace.data, 0644)
+func (trace *Trace) WriteFile(filename string) (err error) {
+	defer ErrorReturn(&err)
+
+	if trace.reader != nil {
+		// copy data from the trace file
+		var f *os.File
+		if f, err = os.Create(filename); err == nil {
+			err = trace.reader.writeTo(f)
+			if errClose := f.Close(); err == nil {
+				err = errClose
+			}
+		}
+	} else {
+		err = ioutil.WriteFile(filename, trace.data, 0644)
+	}
 	if err != nil {
-		Log(LOG_ERR, "Trace '%s': could not write file")
+		return ErrorCreate(ErrFile, "Trace '%s': could not write file",
+			filename)
 	}
+	return nil
 }
 
 // GetSize returns the size of the trace in bytes. If the trace is repeatedly
@@ -145,33 +245,215 @@ func (trace *Trace) GetSize() uint64 {
 	return uint64(trace.nRepeats) * trace.size
 }
 
+// GetRepeatCount returns the number of times the trace is replayed.
+func (trace *Trace) GetRepeatCount() int {
+	return trace.nRepeats
+}
+
 // GetPacketCount returns the number of packets the trace includes. If the
 // trace is repeatedly replayed, the number of packets is multiplied by the
-// number of replays. The packet count currently cannot be obtained for packets
-// that have been read from a file.
-func (trace *Trace) GetPacketCount() int {
-	if trace.fromFile {
-		Log(LOG_ERR, "Cannot obtain packet count from trace that has been "+
-			"read from file")
+// number of replays. For traces that have been read from a file, the trace
+// data is parsed when the function is called for the first time.
+func (trace *Trace) GetPacketCount() (_ int, err error) {
+	defer ErrorReturn(&err)
+
+	if trace.fromFile && !trace.parsed {
+		if err := trace.parse(); err != nil {
+			return 0, err
+		}
 	}
 
-	return trace.nPackets * trace.nRepeats
+	return trace.nPackets * trace.nRepeats, nil
 }
 
 // GetDuration returns the trace duration. If the trace is repeatedly replayed,
-// the duration is multiplied by the number of replays. The duration currently
-// cannot be obtained for packets that have been read from a file.
-func (trace *Trace) GetDuration() time.Duration {
-	if trace.fromFile {
-		Log(LOG_ERR, "Cannot obtain duration from trace that has been "+
-			"read from file")
+// the duration is multiplied by the number of replays. For traces that have
+// been read from a file, the trace data is parsed when the function is called
+// for the first time.
+func (trace *Trace) GetDuration() (_ time.Duration, err error) {
+	defer ErrorReturn(&err)
+
+	if trace.fromFile && !trace.parsed {
+		if err := trace.parse(); err != nil {
+			return 0, err
+		}
+	}
+
+	return trace.duration * time.Duration(trace.nRepeats), nil
+}
+
+// getReplayInfo returns the number of packets and the duration of the trace
+// replay (including repetitions), if they have been recorded when the trace
+// was created or parsed. Unlike GetPacketCount() and GetDuration(), the
+// function never parses the trace data. It returns false if the values are
+// unknown, which is the case for file-backed traces that have neither been
+// parsed nor read completely during replay.
+func (trace *Trace) getReplayInfo() (int, time.Duration, bool) {
+	if trace.fromFile && !trace.parsed {
+		return 0, 0, false
+	}
+
+	return trace.nPackets * trace.nRepeats,
+		trace.duration * time.Duration(trace.nRepeats), true
+}
+
+// getWireDuration returns the time it takes to transmit the data of the trace
+// replay (including repetitions) at the specified line rate. It returns false
+// if the amount of data sent on the wire is unknown, since the trace has not
+// been parsed.
+func (trace *Trace) getWireDuration(lineRate float64) (time.Duration, bool) {
+	if trace.parsed == false {
+		return 0, false
+	}
+
+	nBits := 8 * float64(trace.nBytesWire) * float64(trace.nRepeats)
+	return time.Duration(nBits / lineRate * 1e9), true
+}
+
+// GetDatarate returns the average data rate of the trace in bits per second.
+// Like the data rate parameter of the trace generation functions in the utils
+// package, the data rate includes Ethernet preamble, SOD, FCS and inter-frame
+// gap. The trace data is parsed when the function is called for the first
+// time.
+func (trace *Trace) GetDatarate() (_ float64, err error) {
+	defer ErrorReturn(&err)
+
+	if !trace.parsed {
+		if err := trace.parse(); err != nil {
+			return 0, err
+		}
+	}
+
+	if trace.duration == 0 {
+		return 0, nil
+	}
+
+	return 8 * float64(trace.nBytesWire) / trace.duration.Seconds(), nil
+}
+
+// GetPeakDatarate returns the maximum data rate of the trace in bits per
+// second within any time window of the specified length. Like GetDatarate, the
+// data rate includes Ethernet preamble, SOD, FCS and inter-frame gap. A packet
+// is accounted to the window in which its transmission starts, so the window
+// should span the transmission time of several packets. If the trace is
+// repeatedly replayed, only a single replay is considered.
+func (trace *Trace) GetPeakDatarate(window time.Duration) (_ float64, err error) {
+	defer ErrorReturn(&err)
+
+	// window must span at least one clock cycle
+	if window.Seconds()*trace.clock.freq < 1 {
+		return 0, ErrorCreate(ErrInvalidConfig,
+			"Trace: window must span at least one clock cycle")
+	}
+	cyclesWindow := uint64(window.Seconds() * trace.clock.freq)
+
+	// ring buffer holding the start times (in clock cycles) and number of
+	// bytes on the wire of the packets within the current window. it grows
+	// if the window contains more packets than it can hold
+	type windowPacket struct {
+		cyclesStart uint64
+		nBytes      uint64
+	}
+	ring := make([]windowPacket, 1024)
+	first, n := 0, 0
+
+	// slide the window across the packet start times
+	var nBytesWindow, nBytesWindowMax uint64
+	cycles := uint64(0)
+
+	it := trace.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+
+		// remove packets that started a full window before this one
+		for n > 0 && cycles-ring[first].cyclesStart >= cyclesWindow {
+			nBytesWindow -= ring[first].nBytes
+			first = (first + 1) % len(ring)
+			n--
+		}
+
+		// grow the ring buffer if it is full
+		if n == len(ring) {
+			ringNew := make([]windowPacket, 2*len(ring))
+			for i := 0; i < n; i++ {
+				ringNew[i] = ring[(first+i)%len(ring)]
+			}
+			ring, first = ringNew, 0
+		}
+
+		// add the packet to the window
+		wp := windowPacket{
+			cyclesStart: cycles,
+			nBytes:      uint64(pkt.Wirelen + 24),
+		}
+		ring[(first+n)%len(ring)] = wp
+		n++
+		nBytesWindow += wp.nBytes
+
+		if nBytesWindow > nBytesWindowMax {
+			nBytesWindowMax = nBytesWindow
+		}
+
+		cycles += uint64(pkt.CyclesInterPacket)
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+
+	return 8 * float64(nBytesWindowMax) / window.Seconds(), nil
+}
+
+// SetSeed records the seed of the random number generator that has been used
+// to generate the trace.
+func (trace *Trace) SetSeed(seed int64) {
+	trace.seed = seed
+	trace.hasSeed = true
+}
+
+// GetSeed returns the seed of the random number generator that has been used
+// to generate the trace. The second return value is false if no seed has been
+// recorded for the trace, e.g. because it has been read from a file.
+func (trace *Trace) GetSeed() (int64, bool) {
+	return trace.seed, trace.hasSeed
+}
+
+// SetClock sets the frequency of the clock the inter-packet times of the trace
+// are specified in and the line rate of the network interfaces the trace has
+// been created for. Traces are created for the clock and line rate of the
+// NetFPGA-SUME by default. The function must be called for trace files that
+// have been created for a different board (see BoardProfile.FreqTrace and
+// BoardProfile.LineRate). The inter-packet times are not modified.
+func (trace *Trace) SetClock(freqClock, lineRate float64) (err error) {
+	defer ErrorReturn(&err)
+
+	clock, err := traceClockCreate(freqClock, lineRate)
+	if err != nil {
+		return err
 	}
 
-	return trace.duration * time.Duration(trace.nRepeats)
+	// the duration depends on the clock frequency
+	trace.duration = time.Duration(float64(trace.duration) *
+		trace.clock.freq / clock.freq)
+	trace.clock = clock
+
+	return nil
+}
+
+// GetClock returns the frequency of the clock the inter-packet times of the
+// trace are specified in.
+func (trace *Trace) GetClock() float64 {
+	return trace.clock.freq
+}
+
+// GetLineRate returns the line rate of the network interfaces the trace has
+// been created for.
+func (trace *Trace) GetLineRate() float64 {
+	return trace.clock.lineRate
 }
 
 // GetData returns the trace data. If the trace is repeatedly replayed, only
-// the data for the first replay is returned.
+// the data for the first replay is returned. For file-backed traces the trace
+// data is not held in host memory and nil is returned.
 func (trace *Trace) GetData() []byte {
 	return trace.data
 }
@@ -182,22 +464,54 @@ func (trace *Trace) GetData() []byte {
 // than the trace file. The function may return less than the number of bytes
 // specified to be read, if a host memory wrap-around occurs when a trace is
 // replayed multiple times.
-func (trace *Trace) read(addr uint64, size uint32) []byte {
+func (trace *Trace) read(addr uint64, size uint32) ([]byte, error) {
 	// make sure the provided address is within the valid range
 	if addr > uint64(trace.nRepeats)*trace.size {
-		Log(LOG_ERR, "Trace read address exceeds trace size")
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace read address exceeds trace size")
+	}
+
+	// address within the trace data
+	offset := addr % trace.size
+
+	if offset+uint64(size) > trace.size {
+		// read extends across wrap-around memory boundary, return smaller
+		// data block until end of trace
+		size = uint32(trace.size - offset)
 	}
 
-	if addr/trace.size != (addr+uint64(size)-1)/trace.size {
-		// file read extends across wrap-around memory boundary
+	if trace.reader != nil {
+		// file-backed trace, read data from file
+		data, err := trace.reader.read(offset, size)
+		if err != nil {
+			return nil, err
+		}
 
-		// number of bytes to read until end of trace
-		sizeRemaining := uint32(trace.size - addr%trace.size)
+		// once the trace data has been read completely, the packet meta data
+		// scanned by the reader replaces parsing the trace
+		if trace.parsed == false {
+			nPackets, cycles, nBytesWire, ok := trace.reader.getScanResult()
+			if ok {
+				trace.nPackets = nPackets
+				trace.duration = trace.clock.duration(cycles)
+				trace.nBytesWire = nBytesWire
+				trace.parsed = true
+			}
+		}
 
-		// return smaller data block
-		return trace.data[addr%trace.size : addr%trace.size+uint64(sizeRemaining)]
+		return data, nil
 	}
 
-	// file read does not extend across wrap-around memory boundary
-	return trace.data[addr%trace.size : addr%trace.size+uint64(size)]
+	return trace.data[offset : offset+uint64(size)], nil
+}
+
+// Close closes the trace file of file-backed traces. For all other traces the
+// function does nothing.
+func (trace *Trace) Close() (err error) {
+	defer ErrorReturn(&err)
+
+	if trace.reader == nil {
+		return nil
+	}
+	return trace.reader.close()
 }
@@ -0,0 +1,189 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Trace assembly. The TraceBuilder struct creates the trace data replayed by
+// the generators from a sequence of packets.
+
+package gofluent10g
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TraceBuilder assembles a trace packet by packet.
+type TraceBuilder struct {
+	data []byte // trace data (without padding)
+
+	// true if the trace data has been handed out by GetData(). the data is
+	// then copied before further packets are added
+	shared bool
+
+	nPackets   int    // number of packets added
+	cycles     uint64 // accumulated inter-packet clock cycles
+	nBytesWire uint64 // number of bytes sent on the wire
+
+	clock traceClock // clock and line rate the trace is built for
+}
+
+// TraceBuilderCreate creates a new TraceBuilder instance. Inter-packet times
+// are specified in clock cycles of the NetFPGA-SUME's network interfaces.
+func TraceBuilderCreate() *TraceBuilder {
+	return &TraceBuilder{
+		clock: traceClockDefault,
+	}
+}
+
+// TraceBuilderCreateWithClock creates a new TraceBuilder instance for a board
+// whose network interfaces are clocked at freqClock and transmit data at the
+// line rate lineRate (see BoardProfile.FreqTrace and BoardProfile.LineRate).
+// Inter-packet times are specified in cycles of that clock.
+func TraceBuilderCreateWithClock(freqClock, lineRate float64) (_ *TraceBuilder, err error) {
+	defer ErrorReturn(&err)
+
+	clock, err := traceClockCreate(freqClock, lineRate)
+	if err != nil {
+		return nil, err
+	}
+	return &TraceBuilder{
+		clock: clock,
+	}, nil
+}
+
+// GetClock returns the frequency of the clock the inter-packet times are
+// specified in.
+func (builder *TraceBuilder) GetClock() float64 {
+	return builder.clock.freq
+}
+
+// GetLineRate returns the line rate the trace is built for.
+func (builder *TraceBuilder) GetLineRate() float64 {
+	return builder.clock.lineRate
+}
+
+// GetCyclesTransfer returns the number of clock cycles it takes to transmit a
+// packet with the specified wire length (excluding FCS) at the line rate,
+// including the time spent on FCS, preamble, SOD and inter-frame gap.
+func (builder *TraceBuilder) GetCyclesTransfer(wirelen int) float64 {
+	return builder.clock.cyclesTransfer(wirelen)
+}
+
+// AddPacket appends a packet to the trace. If the packet contains less than
+// Caplen bytes of data, the remaining bytes are set to zero. If it contains
+// more, the data is cut off after Caplen bytes. The wire length must not
+// exceed PKTLEN_WIRE_MAX bytes, the maximum length supported by the hardware.
+func (builder *TraceBuilder) AddPacket(pkt TracePacket) (err error) {
+	defer ErrorReturn(&err)
+
+	// make sure the packet can be represented in the meta data word and its
+	// length is supported by the hardware
+	if pkt.CyclesInterPacket < 0 || pkt.CyclesInterPacket > 0xFFFFFFFF {
+		return ErrorCreate(ErrInvalidConfig, "TraceBuilder: packet %d: "+
+			"invalid inter-packet cycles", builder.nPackets)
+	}
+	if pkt.Wirelen < 0 || pkt.Wirelen > PKTLEN_WIRE_MAX {
+		return ErrorCreate(ErrInvalidConfig, "TraceBuilder: packet %d: "+
+			"invalid wire length", builder.nPackets)
+	}
+	if pkt.Caplen < 0 || pkt.Caplen > pkt.Wirelen {
+		return ErrorCreate(ErrInvalidConfig, "TraceBuilder: packet %d: "+
+			"invalid capture length", builder.nPackets)
+	}
+
+	// do not modify trace data that has already been handed out
+	if builder.shared {
+		builder.data = append([]byte(nil), builder.data...)
+		builder.shared = false
+	}
+
+	// assemble and write meta data word
+	meta := uint64(pkt.CyclesInterPacket)
+	meta |= uint64(pkt.Caplen) << 32
+	meta |= uint64(pkt.Wirelen) << 48
+	builder.data = append(builder.data, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(builder.data[len(builder.data)-8:], meta)
+
+	// write packet data, aligned to 8 byte
+	pos := len(builder.data)
+	builder.data = append(builder.data, make([]byte, 8*((pkt.Caplen+7)/8))...)
+	if len(pkt.Data) > pkt.Caplen {
+		copy(builder.data[pos:], pkt.Data[0:pkt.Caplen])
+	} else {
+		copy(builder.data[pos:], pkt.Data)
+	}
+
+	builder.nPackets++
+	builder.cycles += uint64(pkt.CyclesInterPacket)
+
+	// add 24 bytes for preamble, SOD, FCS and inter-frame gap
+	builder.nBytesWire += uint64(pkt.Wirelen + 24)
+
+	return nil
+}
+
+// GetPacketCount returns the number of packets added to the trace.
+func (builder *TraceBuilder) GetPacketCount() int {
+	return builder.nPackets
+}
+
+// GetDuration returns the duration of the trace.
+func (builder *TraceBuilder) GetDuration() time.Duration {
+	return builder.clock.duration(builder.cycles)
+}
+
+// GetData returns the trace data, padded to a multiple of 64 bytes.
+func (builder *TraceBuilder) GetData() []byte {
+	data := builder.data
+
+	// add padding for 64 byte alignment
+	for len(data)%64 != 0 {
+		data = append(data, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	}
+	builder.shared = true
+
+	return data
+}
+
+// GetTrace creates a trace instance from the packets added to the trace. The
+// parameter nRepeats determines how often the trace shall be replayed.
+func (builder *TraceBuilder) GetTrace(nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	trace, err := traceCreateFromData(builder.GetData(), nRepeats)
+	if err != nil {
+		return nil, err
+	}
+
+	// packet count, duration and wire bytes are known, no need to parse
+	trace.nPackets = builder.nPackets
+	trace.duration = builder.GetDuration()
+	trace.nBytesWire = builder.nBytesWire
+	trace.clock = builder.clock
+	trace.parsed = true
+
+	return trace, nil
+}
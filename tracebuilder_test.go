@@ -0,0 +1,246 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the assembly of traces with the TraceBuilder.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTraceBuilderAlignment adds packets with various capture lengths and
+// checks the layout of the trace data: meta data words and packet data are
+// aligned to 8 byte, the trace is padded to a multiple of 64 bytes.
+func TestTraceBuilderAlignment(t *testing.T) {
+	caplens := []int{0, 1, 7, 8, 9, 60, 63, 64, 65, 1514}
+
+	builder := TraceBuilderCreate()
+	for i, caplen := range caplens {
+		// packet data is longer than the capture length for odd packets and
+		// shorter for even packets
+		var data []byte
+		if i%2 == 1 {
+			data = testPacketData(i, caplen+5)
+		} else if caplen > 0 {
+			data = testPacketData(i, caplen-1)
+		}
+
+		err := builder.AddPacket(TracePacket{
+			CyclesInterPacket: 100 + i,
+			Wirelen:           caplen + 60,
+			Caplen:            caplen,
+			Data:              data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := builder.GetData()
+	if len(data)%64 != 0 {
+		t.Fatalf("trace size %d is not a multiple of 64 bytes", len(data))
+	}
+
+	pos := 0
+	for i, caplen := range caplens {
+		meta := binary.LittleEndian.Uint64(data[pos : pos+8])
+		if int(meta&0xFFFFFFFF) != 100+i ||
+			int((meta>>32)&0xFFFF) != caplen ||
+			int((meta>>48)&0xFFFF) != caplen+60 {
+			t.Fatalf("packet %d: invalid meta data word 0x%016x", i, meta)
+		}
+		pos += 8
+
+		// data is cut off after the capture length or filled up with zeros,
+		// as well as the alignment bytes
+		dataExp := make([]byte, 8*((caplen+7)/8))
+		if i%2 == 1 {
+			copy(dataExp, testPacketData(i, caplen+5)[0:caplen])
+		} else if caplen > 0 {
+			copy(dataExp, testPacketData(i, caplen-1))
+		}
+		if bytes.Equal(data[pos:pos+len(dataExp)], dataExp) == false {
+			t.Fatalf("packet %d: invalid packet data", i)
+		}
+		pos += len(dataExp)
+	}
+
+	// remaining words are padding
+	for ; pos < len(data); pos += 8 {
+		if binary.LittleEndian.Uint64(data[pos:pos+8]) != 0xFFFFFFFFFFFFFFFF {
+			t.Fatalf("invalid padding word at offset %d", pos)
+		}
+	}
+
+	// the trace is parsed to the same packets
+	trace, err := builder.GetTrace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := trace.Packets()
+	n := 0
+	for ; it.Next(); n++ {
+		if it.Packet().Caplen != caplens[n] {
+			t.Fatalf("packet %d: capture length %d, expected %d", n,
+				it.Packet().Caplen, caplens[n])
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(caplens) {
+		t.Fatalf("trace contains %d packets, expected %d", n, len(caplens))
+	}
+}
+
+// TestTraceBuilderPadding checks that no padding is added if the trace data
+// already is a multiple of 64 bytes.
+func TestTraceBuilderPadding(t *testing.T) {
+	builder := TraceBuilderCreate()
+
+	// meta data word + 56 bytes of data
+	err := builder.AddPacket(TracePacket{Wirelen: 60, Caplen: 56})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(builder.GetData()); n != 64 {
+		t.Fatalf("trace size %d, expected 64", n)
+	}
+
+	// meta data word + 8 bytes of data, padded with six words
+	err = builder.AddPacket(TracePacket{Wirelen: 60, Caplen: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(builder.GetData()); n != 128 {
+		t.Fatalf("trace size %d, expected 128", n)
+	}
+}
+
+// TestTraceBuilderShared checks that trace data handed out by GetData() is not
+// modified when further packets are added.
+func TestTraceBuilderShared(t *testing.T) {
+	builder := TraceBuilderCreate()
+	builder.AddPacket(TracePacket{Wirelen: 60, Caplen: 8,
+		Data: testPacketData(0, 8)})
+
+	data := builder.GetData()
+	dataCopy := append([]byte(nil), data...)
+
+	builder.AddPacket(TracePacket{Wirelen: 60, Caplen: 16,
+		Data: testPacketData(1, 16)})
+	if bytes.Equal(data, dataCopy) == false {
+		t.Fatal("trace data has been modified")
+	}
+	if n := len(builder.GetData()); n != 64 {
+		t.Fatalf("trace size %d, expected 64", n)
+	}
+}
+
+// TestTraceBuilderInvalid adds packets that cannot be represented in the meta
+// data word.
+func TestTraceBuilderInvalid(t *testing.T) {
+	pkts := []TracePacket{
+		{CyclesInterPacket: -1, Wirelen: 60, Caplen: 60},
+		{CyclesInterPacket: 0x100000000, Wirelen: 60, Caplen: 60},
+		{Wirelen: -1},
+		{Wirelen: 0x800},
+		{Wirelen: 0x10000},
+		{Wirelen: 60, Caplen: 61},
+		{Wirelen: 60, Caplen: -1},
+	}
+
+	builder := TraceBuilderCreate()
+	for i, pkt := range pkts {
+		if err := builder.AddPacket(pkt); errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("packet %d: expected invalid configuration error, got: %v",
+				i, err)
+		}
+	}
+	if builder.GetPacketCount() != 0 {
+		t.Fatalf("builder contains %d packets, expected 0",
+			builder.GetPacketCount())
+	}
+}
+
+// TestTraceBuilderDuration checks the duration of traces built for the
+// default and a custom clock.
+func TestTraceBuilderDuration(t *testing.T) {
+	if _, err := TraceBuilderCreateWithClock(0, 10e9); err == nil {
+		t.Fatal("expected error for invalid clock frequency")
+	}
+	if _, err := TraceBuilderCreateWithClock(200e6, 0); err == nil {
+		t.Fatal("expected error for invalid line rate")
+	}
+
+	builderDefault := TraceBuilderCreate()
+	builderClock, err := TraceBuilderCreateWithClock(200e6, 25e9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, builder := range []*TraceBuilder{builderDefault, builderClock} {
+		for i := 0; i < 10; i++ {
+			err := builder.AddPacket(TracePacket{
+				CyclesInterPacket: 1000,
+				Wirelen:           60,
+				Caplen:            60,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 10000 clock cycles
+	if d := builderDefault.GetDuration(); d != 64*time.Microsecond {
+		t.Fatalf("duration %s, expected 64us", d)
+	}
+	if d := builderClock.GetDuration(); d != 50*time.Microsecond {
+		t.Fatalf("duration %s, expected 50us", d)
+	}
+
+	trace, err := builderClock.GetTrace(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.GetClock() != 200e6 || trace.GetLineRate() != 25e9 {
+		t.Fatalf("trace uses %f Hz clock and %f bit/s line rate",
+			trace.GetClock(), trace.GetLineRate())
+	}
+	if d, _ := trace.GetDuration(); d != 150*time.Microsecond {
+		t.Fatalf("trace duration %s, expected 150us", d)
+	}
+	if n, _ := trace.GetPacketCount(); n != 30 {
+		t.Fatalf("trace contains %d packets, expected 30", n)
+	}
+}
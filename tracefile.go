@@ -0,0 +1,280 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// File-backed traces. Instead of reading the entire trace file into host
+// memory when the trace is created, trace data is read from disk while the
+// trace is replayed. Each read is followed by a read-ahead of the subsequent
+// data block in the background, so the next ring buffer transfer usually does
+// not have to wait for the disk. Host memory consumption is limited to two
+// blocks of at most RING_BUFF_WR_TRANSFER_SIZE_MAX bytes. While the trace data
+// is read for the first time, the packet meta data is scanned, so packet count
+// and duration of the trace are known by the end of the replay.
+
+package gofluent10g
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// traceFileReader reads trace data from a file on demand.
+type traceFileReader struct {
+	filename string
+	file     *os.File
+	size     uint64 // file size
+
+	bufs [2][]byte // data buffers, one is returned, the other one read ahead
+	iBuf int       // index of the buffer that is filled next
+
+	// read-ahead state
+	readAheadActive bool
+	readAheadOffset uint64
+	readAheadSize   uint32
+	readAheadDone   chan error
+
+	// scan state of the packet meta data
+	scan traceFileScan
+}
+
+// traceFileScan holds the packet meta data collected while the trace data is
+// read for the first time.
+type traceFileScan struct {
+	offset uint64 // offset up to which the trace data has been scanned
+	nSkip  uint64 // number of packet data bytes remaining in the next block
+	failed bool   // the trace data is invalid, results are not available
+
+	nPackets   int
+	cycles     uint64
+	nBytesWire uint64
+}
+
+// TraceCreateFromFileBacked creates a trace instance for a trace specified by
+// its filename. Other than TraceCreateFromFile, the trace data is not read
+// into host memory, but read from the file when it is transferred to the
+// hardware. This allows the replay of traces that are larger than the host
+// memory. The function also expects a parameter specifying the number of times
+// the trace shall be replayed. Since the trace data is read during replay, a
+// file-backed trace can only be assigned to a single generator. The trace file
+// is not parsed when it is opened, packet count and duration are determined on
+// demand (see GetDuration()) or while the trace data is read during replay.
+// The trace file must be closed with Close() once it is no longer needed.
+func TraceCreateFromFileBacked(filename string, nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	// open the trace file
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, ErrorCreate(ErrFile, "Trace '%s': could not open file",
+			filename)
+	}
+
+	// get file info
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ErrorCreate(ErrFile, "Trace '%s': could not stat file",
+			filename)
+	}
+
+	// get the file size
+	fileSize := fileInfo.Size()
+
+	// file size must always be a non-zero multiple of 64 bytes
+	if fileSize == 0 || fileSize%64 != 0 {
+		file.Close()
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace '%s': invalid file "+
+			"size (must be a multiple of 64 bytes)", filename)
+	}
+
+	// create a trace struct and store information
+	trace := Trace{
+		size:     uint64(fileSize),
+		nRepeats: nRepeats,
+		fromFile: true,
+		clock:    traceClockDefault,
+		reader: &traceFileReader{
+			filename:      filename,
+			file:          file,
+			size:          uint64(fileSize),
+			readAheadDone: make(chan error, 1),
+		},
+	}
+
+	Log(LOG_DEBUG, "Trace '%s': opened file", filename)
+
+	return &trace, nil
+}
+
+// read reads a block of trace data starting at the specified offset from the
+// file. The block must not extend beyond the end of the file. The returned
+// slice remains valid until the next call of the function.
+func (r *traceFileReader) read(offset uint64, size uint32) ([]byte, error) {
+	var data []byte
+
+	if r.readAheadActive {
+		// wait for read-ahead to complete
+		err := <-r.readAheadDone
+		r.readAheadActive = false
+
+		// the buffer filled by the read-ahead precedes the one filled next
+		bufReadAhead := r.bufs[1-r.iBuf]
+
+		if err == nil && r.readAheadOffset == offset &&
+			r.readAheadSize >= size {
+			// requested data has been read ahead
+			data = bufReadAhead[0:size]
+		}
+	}
+
+	if data == nil {
+		// requested data has not been read ahead, read it now
+		buf := r.getBuf(size)
+		if err := r.readAt(buf, offset); err != nil {
+			return nil, err
+		}
+		r.iBuf = 1 - r.iBuf
+		data = buf
+	}
+
+	// scan the packet meta data, if the block continues the data read so far
+	if r.scan.offset == offset && offset < r.size {
+		r.scan.update(data)
+	}
+
+	// read ahead the subsequent block of the same size (wrapping around at
+	// the end of the file, since the trace may be replayed repeatedly)
+	offsetNext := (offset + uint64(size)) % r.size
+	sizeNext := size
+	if offsetNext+uint64(sizeNext) > r.size {
+		sizeNext = uint32(r.size - offsetNext)
+	}
+
+	buf := r.getBuf(sizeNext)
+	r.iBuf = 1 - r.iBuf
+	r.readAheadActive = true
+	r.readAheadOffset = offsetNext
+	r.readAheadSize = sizeNext
+	go func() {
+		r.readAheadDone <- r.readAt(buf, offsetNext)
+	}()
+
+	return data, nil
+}
+
+// update scans the packet meta data of the next block of trace data. Packets
+// and padding words are aligned to 8 byte, each meta data word contains the
+// inter-packet cycles, the capture length and the wire length of a packet (see
+// TracePacketIterator).
+func (scan *traceFileScan) update(data []byte) {
+	for pos := uint64(0); pos < uint64(len(data)); {
+		if scan.nSkip > 0 {
+			// skip packet data
+			n := scan.nSkip
+			if n > uint64(len(data))-pos {
+				n = uint64(len(data)) - pos
+			}
+			scan.nSkip -= n
+			pos += n
+			continue
+		}
+
+		if pos+8 > uint64(len(data)) {
+			// meta data words are never split across blocks of valid traces
+			scan.failed = true
+			break
+		}
+
+		metaWord := binary.LittleEndian.Uint64(data[pos : pos+8])
+		pos += 8
+
+		// skip padding
+		if metaWord == 0xFFFFFFFFFFFFFFFF {
+			continue
+		}
+
+		caplen := (metaWord >> 32) & 0xFFFF
+		wirelen := (metaWord >> 48) & 0xFFFF
+
+		scan.nPackets++
+		scan.cycles += metaWord & 0xFFFFFFFF
+
+		// add 24 bytes for preamble, SOD, FCS and inter-frame gap
+		scan.nBytesWire += wirelen + 24
+
+		// packet data is aligned to 8 byte
+		scan.nSkip = 8 * ((caplen + 7) / 8)
+	}
+	scan.offset += uint64(len(data))
+}
+
+// getScanResult returns the packet count, the number of clock cycles and the
+// number of bytes sent on the wire of the trace data. It returns false if the
+// trace data has not been read completely yet or if it is invalid.
+func (r *traceFileReader) getScanResult() (int, uint64, uint64, bool) {
+	if r.scan.offset != r.size || r.scan.nSkip > 0 || r.scan.failed {
+		return 0, 0, 0, false
+	}
+	return r.scan.nPackets, r.scan.cycles, r.scan.nBytesWire, true
+}
+
+// getBuf returns the buffer that is filled next, resized to the specified
+// size.
+func (r *traceFileReader) getBuf(size uint32) []byte {
+	if uint32(cap(r.bufs[r.iBuf])) < size {
+		r.bufs[r.iBuf] = make([]byte, size)
+	}
+	return r.bufs[r.iBuf][0:size]
+}
+
+// readAt fills the buffer with file data starting at the specified offset.
+func (r *traceFileReader) readAt(buf []byte, offset uint64) error {
+	if n, _ := r.file.ReadAt(buf, int64(offset)); n != len(buf) {
+		return ErrorCreate(ErrFile, "Trace '%s': could not read file",
+			r.filename)
+	}
+	return nil
+}
+
+// writeTo copies the entire trace file to the writer.
+func (r *traceFileReader) writeTo(w io.Writer) error {
+	_, err := io.Copy(w, io.NewSectionReader(r.file, 0, int64(r.size)))
+	return err
+}
+
+// close waits for an outstanding read-ahead to complete and closes the file.
+func (r *traceFileReader) close() error {
+	if r.readAheadActive {
+		<-r.readAheadDone
+		r.readAheadActive = false
+	}
+	if err := r.file.Close(); err != nil {
+		return ErrorCreate(ErrFile, "Trace '%s': could not close file",
+			r.filename)
+	}
+	return nil
+}
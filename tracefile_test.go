@@ -0,0 +1,276 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests file-backed traces.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTraceFiles creates a trace with varying packet lengths, writes it to a
+// file and opens the file both as in-memory and as file-backed trace.
+func testTraceFiles(t *testing.T, nRepeats int) (*Trace, *Trace) {
+	trace := testTraceCreate(t, 500, nRepeats, func(i int) (int, int) {
+		wirelen := 60 + (i*53)%1455
+		return wirelen, wirelen
+	}, nil)
+
+	filename := filepath.Join(t.TempDir(), "trace.bin")
+	if err := trace.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	traceMem, err := TraceCreateFromFile(filename, nRepeats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceFile, err := TraceCreateFromFileBacked(filename, nRepeats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		traceFile.Close()
+	})
+
+	return traceMem, traceFile
+}
+
+// TestTraceFileBackedRead reads blocks of trace data from a file-backed trace
+// and compares them with the data of the in-memory trace. Sequential reads
+// are served by the read-ahead, all other reads from the file.
+func TestTraceFileBackedRead(t *testing.T) {
+	nRepeats := 3
+	traceMem, traceFile := testTraceFiles(t, nRepeats)
+
+	if traceFile.GetSize() != traceMem.GetSize() {
+		t.Fatalf("trace size %d, expected %d", traceFile.GetSize(),
+			traceMem.GetSize())
+	}
+
+	check := func(addr uint64, size uint32) uint64 {
+		dataMem, err := traceMem.read(addr, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataFile, err := traceFile.read(addr, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(dataFile, dataMem) == false {
+			t.Fatalf("data read at address %d (%d bytes) does not match",
+				addr, size)
+		}
+		return uint64(len(dataFile))
+	}
+
+	// sequential reads across the repetitions of the trace. the last block
+	// of each repetition is shorter
+	for addr := uint64(0); addr < traceMem.GetSize(); {
+		addr += check(addr, 16384)
+	}
+
+	// sequential reads with changing block sizes, the read-ahead block may
+	// be too small
+	addr := uint64(0)
+	for _, size := range []uint32{64, 128, 16384, 64, 192, 65536, 64} {
+		addr += check(addr, size)
+	}
+
+	// random accesses missing the read-ahead
+	for _, addr := range []uint64{4096, 0, traceMem.size - 64,
+		traceMem.size + 128, 64, 2 * traceMem.size} {
+		check(addr, 1024)
+	}
+}
+
+// TestTraceFileBackedParse checks that packet iteration, packet count and
+// duration of a file-backed trace match the in-memory trace.
+func TestTraceFileBackedParse(t *testing.T) {
+	traceMem, traceFile := testTraceFiles(t, 2)
+
+	// file-backed traces are parsed on demand
+	if _, _, ok := traceFile.getReplayInfo(); ok {
+		t.Fatal("file-backed trace has been parsed on creation")
+	}
+
+	itMem, itFile := traceMem.Packets(), traceFile.Packets()
+	for itMem.Next() {
+		if itFile.Next() == false {
+			t.Fatal("file-backed trace contains less packets")
+		}
+		pktMem, pktFile := itMem.Packet(), itFile.Packet()
+		if pktFile.CyclesInterPacket != pktMem.CyclesInterPacket ||
+			pktFile.Wirelen != pktMem.Wirelen ||
+			pktFile.Caplen != pktMem.Caplen ||
+			bytes.Equal(pktFile.Data, pktMem.Data) == false {
+			t.Fatal("packets do not match")
+		}
+	}
+	if itFile.Next() {
+		t.Fatal("file-backed trace contains more packets")
+	}
+	if itMem.Err() != nil || itFile.Err() != nil {
+		t.Fatal(itMem.Err(), itFile.Err())
+	}
+
+	nPktsMem, _ := traceMem.GetPacketCount()
+	nPktsFile, err := traceFile.GetPacketCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nPktsFile != nPktsMem {
+		t.Fatalf("packet count %d, expected %d", nPktsFile, nPktsMem)
+	}
+	if nPkts, _, ok := traceFile.getReplayInfo(); ok == false ||
+		nPkts != nPktsMem {
+		t.Fatal("replay info not recorded after parsing")
+	}
+
+	durationMem, _ := traceMem.GetDuration()
+	durationFile, err := traceFile.GetDuration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if durationFile != durationMem {
+		t.Fatalf("duration %s, expected %s", durationFile, durationMem)
+	}
+}
+
+// TestTraceFileBackedReplay replays a file-backed trace on the simulated
+// hardware and checks that the same packets are captured as for the in-memory
+// trace.
+func TestTraceFileBackedReplay(t *testing.T) {
+	nRepeats := 4
+	traceMem, traceFile := testTraceFiles(t, nRepeats)
+
+	var pkts [2]CapturePackets
+	for i, trace := range []*Trace{traceMem, traceFile} {
+		nt, _ := testNetworkTesterCreate(t)
+		recv, _ := nt.GetReceiver(0)
+		err := recv.EnableCapture(1518, RING_BUFF_RD_TRANSFER_SIZE_MIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testReplayCheck(t, nt, trace)
+		pkts[i] = testCapturePackets(t, recv)
+	}
+
+	// the packet meta data of the file-backed trace has been scanned during
+	// replay
+	nPktsMem, durationMem, _ := traceMem.getReplayInfo()
+	nPktsFile, durationFile, ok := traceFile.getReplayInfo()
+	if ok == false || nPktsFile != nPktsMem || durationFile != durationMem {
+		t.Fatalf("replay info %d/%s/%t, expected %d/%s", nPktsFile,
+			durationFile, ok, nPktsMem, durationMem)
+	}
+
+	if n := 500 * nRepeats; len(pkts[0]) != n {
+		t.Fatalf("captured %d packets, expected %d", len(pkts[0]), n)
+	}
+	if len(pkts[1]) != len(pkts[0]) {
+		t.Fatalf("captured %d packets, expected %d", len(pkts[1]),
+			len(pkts[0]))
+	}
+	for i := range pkts[0] {
+		if pkts[1][i].Wirelen != pkts[0][i].Wirelen ||
+			bytes.Equal(pkts[1][i].Data, pkts[0][i].Data) == false {
+			t.Fatalf("packet %d does not match", i)
+		}
+	}
+}
+
+// TestTraceFileBackedReplayDrain replays a file-backed trace with large
+// inter-packet times, which has not been parsed before. The replay lasts at
+// least as long as the trace and all packets are captured.
+func TestTraceFileBackedReplayDrain(t *testing.T) {
+	nPkts := 4
+	trace := testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 60, 60
+	}, func(i int, wirelen int) int {
+		return int(0.1 * FREQ_SFP)
+	})
+	duration, err := trace.GetDuration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "trace.bin")
+	if err := trace.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	traceFile, err := TraceCreateFromFileBacked(filename, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traceFile.Close()
+
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(64, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+
+	tStart := time.Now()
+	testReplayCheck(t, nt, traceFile)
+	if d := time.Since(tStart); d < duration {
+		t.Fatalf("replay took %s, trace duration is %s", d, duration)
+	}
+	if n := len(testCapturePackets(t, recv)); n != nPkts {
+		t.Fatalf("captured %d packets, expected %d", n, nPkts)
+	}
+}
+
+// TestTraceFileBackedInvalid checks that missing files and files of invalid
+// size are rejected.
+func TestTraceFileBackedInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trace.bin")
+
+	if _, err := TraceCreateFromFileBacked(filename, 1); errors.Is(err,
+		ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+
+	for _, size := range []int{0, 100} {
+		if err := ioutil.WriteFile(filename, make([]byte, size),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := TraceCreateFromFileBacked(filename, 1); errors.Is(err,
+			ErrInvalidConfig) == false {
+			t.Fatalf("%d bytes: expected invalid configuration error, got: %v",
+				size, err)
+		}
+	}
+}
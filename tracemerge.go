@@ -0,0 +1,225 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Merging of traces. Multiple traces (e.g. background traffic and a probe
+// flow) are interleaved by the time at which their packets are sent, so that
+// they can be replayed by a single generator.
+
+package gofluent10g
+
+import (
+	"math"
+)
+
+// collision handling modes of TraceMerge
+const (
+	// return an error if packets of the merged traces overlap on the wire
+	TraceMergeCollisionsFail int = 0
+
+	// delay packets that overlap with preceding packets on the wire until the
+	// link is idle again
+	TraceMergeCollisionsDelay int = 1
+)
+
+// traceMergeSource holds the state of a trace while it is being merged.
+type traceMergeSource struct {
+	trace   *Trace
+	it      *TracePacketIterator
+	repeat  int         // current replay of the trace
+	pkt     TracePacket // next packet
+	cycles  uint64      // send time of the next packet in clock cycles
+	pending bool        // true if a packet is pending
+}
+
+// next advances to the next packet of the trace. If the trace is repeatedly
+// replayed, the iteration continues with the first packet once the last
+// packet has been reached.
+func (src *traceMergeSource) next() error {
+	if src.pending {
+		// the next packet is sent after the inter-packet time of the current
+		// one has passed
+		src.cycles += uint64(src.pkt.CyclesInterPacket)
+	}
+
+	for !src.it.Next() {
+		if err := src.it.Err(); err != nil {
+			return err
+		}
+
+		// end of trace reached, start next replay
+		src.repeat++
+		if src.repeat >= src.trace.nRepeats {
+			src.pending = false
+			return nil
+		}
+		src.it = src.trace.Packets()
+	}
+
+	src.pkt = src.it.Packet()
+	src.pending = true
+
+	return nil
+}
+
+// TraceMerge merges the specified traces into a single trace by interleaving
+// their packets according to their send times. Packets of all traces with the
+// same send time are ordered by the position of the trace in the list. If a
+// trace is repeatedly replayed, all of its replays are merged, the merged
+// trace itself is replayed once. The collisionMode parameter determines how
+// packets are handled that would overlap on the wire at the line rate of the
+// traces (TraceMergeCollisionsFail or TraceMergeCollisionsDelay). All traces
+// must use the same clock and line rate (see Trace.SetClock()). The function
+// returns the merged trace and the number of colliding packets.
+func TraceMerge(traces []*Trace, collisionMode int) (_ *Trace, _ int, err error) {
+	defer ErrorReturn(&err)
+
+	if len(traces) == 0 {
+		return nil, 0, ErrorCreate(ErrInvalidConfig,
+			"TraceMerge: no traces specified")
+	}
+	if collisionMode != TraceMergeCollisionsFail &&
+		collisionMode != TraceMergeCollisionsDelay {
+		return nil, 0, ErrorCreate(ErrInvalidConfig,
+			"TraceMerge: invalid collision mode")
+	}
+	for _, trace := range traces {
+		if trace.clock != traces[0].clock {
+			return nil, 0, ErrorCreate(ErrInvalidConfig,
+				"TraceMerge: traces use different clocks or line rates")
+		}
+	}
+
+	// get the first packet of each trace
+	srcs := make([]*traceMergeSource, len(traces))
+	for i, trace := range traces {
+		srcs[i] = &traceMergeSource{
+			trace: trace,
+			it:    trace.Packets(),
+		}
+		if err := srcs[i].next(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	builder := &TraceBuilder{
+		clock: traces[0].clock,
+	}
+
+	// packet that has been selected last, but not yet added to the trace
+	// (its inter-packet time depends on the send time of the next packet)
+	var pkt TracePacket
+	var pktCycles uint64
+	var pktPending bool
+
+	// time (in clock cycles) until which the link is busy transmitting the
+	// previous packet
+	var cyclesLinkBusy float64
+
+	// number of colliding packets
+	nCollisions := 0
+
+	for {
+		// select the pending packet with the earliest send time
+		var src *traceMergeSource
+		for _, s := range srcs {
+			if s.pending && (src == nil || s.cycles < src.cycles) {
+				src = s
+			}
+		}
+		if src == nil {
+			// all packets merged
+			break
+		}
+
+		cycles := src.cycles
+
+		// the packet collides, if it is sent before the previous packet has
+		// been transmitted. rounding of inter-packet times may cause packets
+		// to be sent up to one clock cycle too early, this is tolerated
+		if cyclesLinkBusy-float64(cycles) >= 1.0 {
+			nCollisions++
+
+			if collisionMode == TraceMergeCollisionsFail {
+				return nil, nCollisions, ErrorCreate(ErrInvalidConfig,
+					"TraceMerge: packet at clock cycle %d collides with "+
+						"previous packet", cycles)
+			}
+
+			// delay packet until link is idle
+			cycles = uint64(math.Ceil(cyclesLinkBusy))
+		}
+		cyclesLinkBusy = math.Max(cyclesLinkBusy, float64(cycles)) +
+			builder.clock.cyclesTransfer(src.pkt.Wirelen)
+
+		// now that the send time of the packet is known, the inter-packet
+		// time of the previous packet can be set
+		if pktPending {
+			if err := traceMergeAddPacket(builder, pkt,
+				cycles-pktCycles); err != nil {
+				return nil, nCollisions, err
+			}
+		}
+		pkt = src.pkt
+		pktCycles = cycles
+		pktPending = true
+
+		if err := src.next(); err != nil {
+			return nil, nCollisions, err
+		}
+	}
+
+	// the last packet keeps its inter-packet time
+	if pktPending {
+		err := traceMergeAddPacket(builder, pkt, uint64(pkt.CyclesInterPacket))
+		if err != nil {
+			return nil, nCollisions, err
+		}
+	}
+
+	if builder.GetPacketCount() == 0 {
+		return nil, 0, ErrorCreate(ErrInvalidConfig,
+			"TraceMerge: traces do not contain any packets")
+	}
+
+	if nCollisions > 0 {
+		Log(LOG_WARN, "TraceMerge: delayed %d colliding packets", nCollisions)
+	}
+
+	trace, err := builder.GetTrace(1)
+	return trace, nCollisions, err
+}
+
+// traceMergeAddPacket adds a packet with the specified inter-packet time to
+// the merged trace.
+func traceMergeAddPacket(builder *TraceBuilder, pkt TracePacket, cycles uint64) error {
+	if cycles > 0xFFFFFFFF {
+		return ErrorCreate(ErrInvalidConfig, "TraceMerge: inter-packet time "+
+			"exceeds maximum value supported by the hardware")
+	}
+	pkt.CyclesInterPacket = int(cycles)
+	return builder.AddPacket(pkt)
+}
@@ -0,0 +1,181 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the merging of traces.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testTraceMergeCreate creates a trace with packets of the same wire length,
+// which are sent with a constant inter-packet time.
+func testTraceMergeCreate(t *testing.T, nPkts, nRepeats, wirelen, cycles int) *Trace {
+	return testTraceCreate(t, nPkts, nRepeats,
+		func(i int) (int, int) { return wirelen, 60 },
+		func(i int, wirelen int) int { return cycles })
+}
+
+// TestTraceMergeDelay merges a repeatedly replayed trace with a second trace
+// and checks the order and send times of the packets. Colliding packets are
+// delayed until the link is idle.
+func TestTraceMergeDelay(t *testing.T) {
+	traceA := testTraceMergeCreate(t, 4, 2, 60, 1000)
+	traceB := testTraceMergeCreate(t, 3, 1, 100, 1500)
+
+	trace, nCollisions, err := TraceMerge([]*Trace{traceA, traceB},
+		TraceMergeCollisionsDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first packets of both traces are sent at clock cycle 0, the third
+	// packet of trace B is sent together with the fourth packet of trace A.
+	// the packets of trace B are delayed until the 60 byte packets of trace
+	// A have been transmitted (10.5 clock cycles)
+	if nCollisions != 2 {
+		t.Fatalf("%d colliding packets, expected 2", nCollisions)
+	}
+
+	// packets of trace A have a wire length of 60 bytes, the ones of trace B
+	// a wire length of 100 bytes
+	pktsExp := []struct {
+		wirelen int
+		i       int
+	}{
+		{60, 0}, {100, 0}, {60, 1}, {100, 1}, {60, 2}, {60, 3}, {100, 2},
+		{60, 0}, {60, 1}, {60, 2}, {60, 3},
+	}
+	cyclesExp := []uint64{0, 11, 1000, 1500, 2000, 3000, 3011, 4000, 5000,
+		6000, 7000}
+
+	it := trace.Packets()
+	cycles := uint64(0)
+	n := 0
+	for ; it.Next(); n++ {
+		if n >= len(pktsExp) {
+			t.Fatal("merged trace contains too many packets")
+		}
+
+		pkt := it.Packet()
+		if pkt.Wirelen != pktsExp[n].wirelen || bytes.Equal(pkt.Data,
+			testPacketData(pktsExp[n].i, 60)) == false {
+			t.Fatalf("packet %d: unexpected packet", n)
+		}
+		if cycles != cyclesExp[n] {
+			t.Fatalf("packet %d: sent at clock cycle %d, expected %d", n,
+				cycles, cyclesExp[n])
+		}
+		cycles += uint64(pkt.CyclesInterPacket)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(pktsExp) {
+		t.Fatalf("merged trace contains %d packets, expected %d", n,
+			len(pktsExp))
+	}
+
+	// the last packet keeps its inter-packet time
+	if cycles != 8000 {
+		t.Fatalf("merged trace takes %d clock cycles, expected 8000", cycles)
+	}
+	if trace.nRepeats != 1 {
+		t.Fatalf("merged trace is replayed %d times, expected 1",
+			trace.nRepeats)
+	}
+}
+
+// TestTraceMergeFail checks that colliding packets are rejected in the
+// TraceMergeCollisionsFail mode, while traces without collisions are merged.
+func TestTraceMergeFail(t *testing.T) {
+	traceA := testTraceMergeCreate(t, 4, 1, 60, 1000)
+	traceB := testTraceMergeCreate(t, 3, 1, 100, 1500)
+
+	_, nCollisions, err := TraceMerge([]*Trace{traceA, traceB},
+		TraceMergeCollisionsFail)
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if nCollisions != 1 {
+		t.Fatalf("%d colliding packets, expected 1", nCollisions)
+	}
+
+	// a trace with back-to-back packets does not collide with itself (the
+	// transmission time of 10.5 clock cycles is rounded up)
+	traceC := testTraceMergeCreate(t, 10, 3, 60, 11)
+	trace, nCollisions, err := TraceMerge([]*Trace{traceC},
+		TraceMergeCollisionsFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nCollisions != 0 {
+		t.Fatalf("%d colliding packets, expected 0", nCollisions)
+	}
+	if n, _ := trace.GetPacketCount(); n != 30 {
+		t.Fatalf("merged trace contains %d packets, expected 30", n)
+	}
+}
+
+// TestTraceMergeInvalid checks that invalid merges are rejected.
+func TestTraceMergeInvalid(t *testing.T) {
+	trace := testTraceMergeCreate(t, 4, 1, 60, 1000)
+
+	// traces created for different clocks cannot be merged
+	builder, err := TraceBuilderCreateWithClock(200e6, 10e9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = builder.AddPacket(TracePacket{CyclesInterPacket: 1000, Wirelen: 60,
+		Caplen: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceClock, err := builder.GetTrace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merges := []struct {
+		traces        []*Trace
+		collisionMode int
+	}{
+		{nil, TraceMergeCollisionsDelay},
+		{[]*Trace{trace}, 2},
+		{[]*Trace{trace, traceClock}, TraceMergeCollisionsDelay},
+	}
+	for i, merge := range merges {
+		_, _, err := TraceMerge(merge.traces, merge.collisionMode)
+		if errors.Is(err, ErrInvalidConfig) == false {
+			t.Fatalf("merge %d: expected invalid configuration error, got: %v",
+				i, err)
+		}
+	}
+}
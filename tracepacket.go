@@ -0,0 +1,175 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Parsing of trace data. Each packet in the trace consists of an 8 byte meta
+// data word followed by the packet data, which is aligned to 8 byte
+// boundaries. The meta data word contains the number of clock cycles until the
+// next packet is sent (bits 0-31), the capture length (bits 32-47) and the wire
+// length (bits 48-63) of the packet. The trace is padded to a multiple of 64
+// bytes with 0xFFFFFFFFFFFFFFFF words.
+
+package gofluent10g
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// TracePacket is a struct containing the (meta-) data of a packet in a trace.
+type TracePacket struct {
+	// number of clock cycles between the start of the transmission of this
+	// packet and the next one
+	CyclesInterPacket int
+
+	// length of the packet on the wire, excluding the FCS (which is appended
+	// by the MAC)
+	Wirelen int
+
+	// number of packet data bytes stored in the trace. the hardware appends
+	// Wirelen - Caplen zero bytes to restore the original packet length
+	Caplen int
+
+	// packet data (Caplen bytes)
+	Data []byte
+}
+
+// TracePacketIterator iterates over the packets of a trace. Next() advances
+// the iterator to the next packet, which is then returned by Packet(). Once
+// Next() returns false, Err() returns the error that stopped the iteration (or
+// nil if the end of the trace has been reached). If the trace is repeatedly
+// replayed, only the packets of the first replay are returned.
+type TracePacketIterator struct {
+	r   *bufio.Reader
+	pkt TracePacket
+	err error
+	id  int // index of the current packet
+}
+
+// Packets returns an iterator over the packets of the trace. For file-backed
+// traces, the packets are read from the trace file.
+func (trace *Trace) Packets() *TracePacketIterator {
+	var r io.Reader
+	if trace.reader != nil {
+		r = io.NewSectionReader(trace.reader.file, 0, int64(trace.size))
+	} else {
+		r = bytes.NewReader(trace.data)
+	}
+
+	return &TracePacketIterator{
+		r:  bufio.NewReaderSize(r, 1024*1024),
+		id: -1,
+	}
+}
+
+// Next advances the iterator to the next packet. It returns false when the end
+// of the trace has been reached or an error occured.
+func (it *TracePacketIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	meta := make([]byte, 8)
+	for {
+		// read meta data word
+		if _, err := io.ReadFull(it.r, meta); err != nil {
+			if err != io.EOF {
+				it.err = ErrorCreate(ErrFile, "Trace: could not read packet "+
+					"%d", it.id+1)
+			}
+			return false
+		}
+
+		// skip padding
+		if binary.LittleEndian.Uint64(meta) != 0xFFFFFFFFFFFFFFFF {
+			break
+		}
+	}
+
+	it.id++
+
+	// decode meta data word
+	metaWord := binary.LittleEndian.Uint64(meta)
+	pkt := TracePacket{
+		CyclesInterPacket: int(metaWord & 0xFFFFFFFF),
+		Caplen:            int((metaWord >> 32) & 0xFFFF),
+		Wirelen:           int((metaWord >> 48) & 0xFFFF),
+	}
+
+	// read packet data, which is aligned to 8 byte
+	data := make([]byte, 8*((pkt.Caplen+7)/8))
+	if _, err := io.ReadFull(it.r, data); err != nil {
+		it.err = ErrorCreate(ErrInvalidConfig, "Trace: packet %d is "+
+			"truncated", it.id)
+		return false
+	}
+	pkt.Data = data[0:pkt.Caplen]
+
+	it.pkt = pkt
+	return true
+}
+
+// Packet returns the current packet.
+func (it *TracePacketIterator) Packet() TracePacket {
+	return it.pkt
+}
+
+// Err returns the error that occured during iteration, if any.
+func (it *TracePacketIterator) Err() (err error) {
+	defer ErrorReturn(&err)
+
+	return it.err
+}
+
+// parse parses the trace data and determines the number of packets, the
+// duration and the number of bytes sent on the wire.
+func (trace *Trace) parse() error {
+	var nPackets int
+	var cycles uint64
+	var nBytesWire uint64
+
+	it := trace.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+		nPackets++
+		cycles += uint64(pkt.CyclesInterPacket)
+
+		// add 24 bytes for preamble, SOD, FCS and inter-frame gap
+		nBytesWire += uint64(pkt.Wirelen + 24)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	trace.nPackets = nPackets
+	trace.duration = trace.clock.duration(cycles)
+	trace.nBytesWire = nBytesWire
+	trace.parsed = true
+
+	return nil
+}
@@ -0,0 +1,229 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the parsing of trace data.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTracePackets iterates over the packets of a trace and checks packet
+// count, duration and data rate derived from the trace data.
+func TestTracePackets(t *testing.T) {
+	nPkts, nRepeats := 300, 3
+	pktlen := func(i int) (int, int) {
+		wirelen := 60 + (i*53)%1455
+		return wirelen, wirelen / 2
+	}
+	cycles := func(i int, wirelen int) int {
+		return testCyclesTransfer(wirelen) + i
+	}
+	trace := testTraceCreate(t, nPkts, nRepeats, pktlen, cycles)
+
+	// only the packets of the first replay are returned
+	var cyclesTotal, nBytesWire int
+	it := trace.Packets()
+	n := 0
+	for ; it.Next(); n++ {
+		pkt := it.Packet()
+		wirelen, caplen := pktlen(n)
+		if pkt.Wirelen != wirelen || pkt.Caplen != caplen ||
+			pkt.CyclesInterPacket != cycles(n, wirelen) {
+			t.Fatalf("packet %d: invalid meta data", n)
+		}
+		if bytes.Equal(pkt.Data, testPacketData(n, caplen)) == false {
+			t.Fatalf("packet %d: data does not match", n)
+		}
+		cyclesTotal += pkt.CyclesInterPacket
+		nBytesWire += wirelen + 24
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != nPkts {
+		t.Fatalf("trace contains %d packets, expected %d", n, nPkts)
+	}
+
+	if n, _ := trace.GetPacketCount(); n != nPkts*nRepeats {
+		t.Fatalf("packet count %d, expected %d", n, nPkts*nRepeats)
+	}
+
+	duration := time.Duration(float64(cyclesTotal) / FREQ_SFP * 1e9)
+	if d, _ := trace.GetDuration(); d != time.Duration(nRepeats)*duration {
+		t.Fatalf("duration %s, expected %s", d,
+			time.Duration(nRepeats)*duration)
+	}
+
+	datarate, err := trace.GetDatarate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	datarateExp := 8 * float64(nBytesWire) / duration.Seconds()
+	if math.Abs(datarate-datarateExp)/datarateExp > 1e-6 {
+		t.Fatalf("data rate %f, expected %f", datarate, datarateExp)
+	}
+}
+
+// TestTracePacketsFile checks that a trace read from a file is parsed to the
+// same packet count and duration as the trace it has been written from.
+func TestTracePacketsFile(t *testing.T) {
+	nRepeats := 2
+	trace := testTraceCreate(t, 200, nRepeats, func(i int) (int, int) {
+		return 60 + i, 60
+	}, nil)
+
+	filename := filepath.Join(t.TempDir(), "trace.bin")
+	if err := trace.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	traceFile, err := TraceCreateFromFile(filename, nRepeats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceFileBacked, err := TraceCreateFromFileBacked(filename, nRepeats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traceFileBacked.Close()
+
+	nPkts, _ := trace.GetPacketCount()
+	duration, _ := trace.GetDuration()
+	for _, tr := range []*Trace{traceFile, traceFileBacked} {
+		n, err := tr.GetPacketCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := tr.GetDuration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != nPkts || d != duration {
+			t.Fatalf("trace contains %d packets of %s, expected %d packets "+
+				"of %s", n, d, nPkts, duration)
+		}
+	}
+}
+
+// TestTracePacketsTruncated checks that a packet whose data is cut off by the
+// end of the trace results in an error.
+func TestTracePacketsTruncated(t *testing.T) {
+	// meta data word of a packet with 100 bytes of capture data, followed by
+	// only 56 bytes of data
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint64(data[0:8], 100|100<<32|100<<48)
+
+	// without packet count and duration, the trace data is parsed on creation
+	if _, err := TraceCreateFromData(data, 0, 0, 1); errors.Is(err,
+		ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	trace, err := TraceCreateFromData(data, 1, time.Microsecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := trace.Packets()
+	if it.Next() {
+		t.Fatal("truncated packet returned")
+	}
+	if errors.Is(it.Err(), ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", it.Err())
+	}
+	if _, err := trace.GetDatarate(); errors.Is(err,
+		ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
+
+// TestTracePeakDatarate checks the peak data rate of a trace whose first half
+// is sent at 10 Gbps and second half at 1 Gbps.
+func TestTracePeakDatarate(t *testing.T) {
+	// 1000 byte packets occupy 1024 bytes on the wire, i.e. 128 clock cycles
+	// at 10 Gbps
+	trace := testTraceCreate(t, 100, 2, func(i int) (int, int) {
+		return 1000, 64
+	}, func(i int, wirelen int) int {
+		if i < 50 {
+			return 128
+		}
+		return 1280
+	})
+
+	// ten back-to-back packets fit into the window
+	peak, err := trace.GetPeakDatarate(time.Duration(1280 / FREQ_SFP * 1e9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(peak-10e9)/10e9 > 1e-6 {
+		t.Fatalf("peak data rate %f, expected 10e9", peak)
+	}
+
+	// the window spans the entire trace
+	peak, err = trace.GetPeakDatarate(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(peak-8*100*1024) > 1e-6 {
+		t.Fatalf("peak data rate %f, expected %d", peak, 8*100*1024)
+	}
+
+	// the window must span at least one clock cycle
+	for _, window := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		if _, err := trace.GetPeakDatarate(window); errors.Is(err,
+			ErrInvalidConfig) == false {
+			t.Fatalf("window %s: expected invalid configuration error, "+
+				"got: %v", window, err)
+		}
+	}
+}
+
+// TestTracePeakDatarateLargeWindow checks the peak data rate for a window
+// containing several thousand packets.
+func TestTracePeakDatarateLargeWindow(t *testing.T) {
+	nPkts := 5000
+	trace := testTraceCreate(t, nPkts, 1, func(i int) (int, int) {
+		return 1000, 64
+	}, nil)
+
+	peak, err := trace.GetPeakDatarate(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(peak-float64(8*nPkts*1024)) > 1e-6 {
+		t.Fatalf("peak data rate %f, expected %d", peak, 8*nPkts*1024)
+	}
+}
@@ -0,0 +1,316 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Import of network traces recorded with standard tools (e.g. tcpdump) in
+// pcap or pcapng format. Packet timestamps are converted to inter-packet times
+// in clock cycles, packet data is converted to the trace format replayed by
+// the generators.
+
+package gofluent10g
+
+import (
+	"bufio"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// PcapReader is implemented by both the pcap and the pcapng file reader.
+type PcapReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// PcapOpen opens a pcap or pcapng file and returns the file and a buffered
+// reader for its packets. The file format is determined by the magic number at
+// the beginning of the file. The name parameter is prepended to error
+// messages. The file must be closed by the caller.
+func PcapOpen(name string, filename string) (_ *os.File, _ PcapReader, err error) {
+	defer ErrorReturn(&err)
+
+	return pcapOpen(name, filename)
+}
+
+// pcapOpen opens a pcap or pcapng file (see PcapOpen()). Unlike PcapOpen(), it
+// does not apply the exit-on-error setting.
+func pcapOpen(name string, filename string) (*os.File, PcapReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, ErrorCreate(ErrFile, "%s '%s': could not open file",
+			name, filename)
+	}
+
+	// pcapng files start with the block type of the section header block
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		f.Close()
+		return nil, nil, ErrorCreate(ErrFile, "%s '%s': could not read file",
+			name, filename)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, ErrorCreate(ErrFile, "%s '%s': could not read file",
+			name, filename)
+	}
+
+	var r PcapReader
+	if string(magic) == "\x0a\x0d\x0d\x0a" {
+		r, err = pcapgo.NewNgReader(bufio.NewReader(f),
+			pcapgo.DefaultNgReaderOptions)
+	} else {
+		r, err = pcapgo.NewReader(bufio.NewReader(f))
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, ErrorCreate(ErrFile, "%s '%s': %s", name, filename,
+			err.Error())
+	}
+
+	return f, r, nil
+}
+
+// TraceCreateFromPcap creates a trace instance from a pcap file. The
+// inter-packet times of the trace are derived from the packet timestamps. The
+// parameter pktlenCaptureMax defines the maximum number of data bytes per
+// packet that are written to the hardware, the hardware appends zero bytes to
+// restore the original packet length. The parameter nRepeats determines how
+// often the trace shall be replayed. The trace is created for the clock and
+// line rate of the NetFPGA-SUME's network interfaces. The file format is
+// determined by its magic number (see PcapOpen()), so pcapng files are
+// accepted as well.
+func TraceCreateFromPcap(filename string, pktlenCaptureMax int, nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	return TraceCreateFromPcapWithClock(filename, pktlenCaptureMax, nRepeats,
+		traceClockDefault.freq, traceClockDefault.lineRate)
+}
+
+// TraceCreateFromPcapWithClock creates a trace instance from a pcap file for
+// a board whose network interfaces are clocked at freqClock and transmit data
+// at the line rate lineRate (see BoardProfile.FreqTrace and
+// BoardProfile.LineRate). See TraceCreateFromPcap for a description of the
+// other parameters.
+func TraceCreateFromPcapWithClock(filename string, pktlenCaptureMax int, nRepeats int, freqClock, lineRate float64) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	clock, err := traceClockCreate(freqClock, lineRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// open the pcap file
+	file, r, err := pcapOpen("Trace", filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return traceCreateFromPcapReader(filename, r, clock, pktlenCaptureMax,
+		nRepeats)
+}
+
+// TraceCreateFromPcapng creates a trace instance from a pcapng file. See
+// TraceCreateFromPcap for a description of the parameters.
+func TraceCreateFromPcapng(filename string, pktlenCaptureMax int, nRepeats int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	return TraceCreateFromPcapngWithClock(filename, pktlenCaptureMax,
+		nRepeats, traceClockDefault.freq, traceClockDefault.lineRate)
+}
+
+// TraceCreateFromPcapngWithClock creates a trace instance from a pcapng file
+// for a board with the specified clock frequency and line rate. See
+// TraceCreateFromPcapWithClock for a description of the parameters.
+func TraceCreateFromPcapngWithClock(filename string, pktlenCaptureMax int, nRepeats int, freqClock, lineRate float64) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	clock, err := traceClockCreate(freqClock, lineRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// open the pcapng file
+	file, r, err := pcapOpen("Trace", filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return traceCreateFromPcapReader(filename, r, clock, pktlenCaptureMax,
+		nRepeats)
+}
+
+// traceCreateFromPcapReader reads all packets from a pcap or pcapng reader and
+// assembles the trace for the specified clock.
+func traceCreateFromPcapReader(filename string, r PcapReader, clock traceClock, pktlenCaptureMax int, nRepeats int) (*Trace, error) {
+	// only ethernet frames can be replayed
+	if r.LinkType() != layers.LinkTypeEthernet {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace '%s': unsupported "+
+			"link type %s (must be Ethernet)", filename, r.LinkType())
+	}
+
+	// capture length is stored as 16 bit value in the meta data word
+	if pktlenCaptureMax < 0 || pktlenCaptureMax > 0xFFFF {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace '%s': invalid "+
+			"capture length", filename)
+	}
+
+	// create data structures for packet and meta data
+	var data [][]byte
+	var lensWire []int
+	var lensCapture []int
+	var timestamps []time.Time
+
+	// number of packets exceeding the maximum wire length
+	nTruncated := 0
+
+	Log(LOG_DEBUG, "Trace '%s': reading file", filename)
+
+	for {
+		pktData, ci, err := r.ReadPacketData()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, ErrorCreate(ErrFile, "Trace '%s': %s", filename,
+				err.Error())
+		}
+
+		// wire length as recorded in the file. the MAC appends the FCS, so
+		// it is not included. packets shorter than the minimum Ethernet frame
+		// size are padded by the hardware. packets exceeding the maximum
+		// length supported by the hardware (e.g. recorded with GRO/TSO
+		// enabled) are truncated
+		lenWire := ci.Length
+		if lenWire < 60 {
+			lenWire = 60
+		} else if lenWire > PKTLEN_WIRE_MAX {
+			lenWire = PKTLEN_WIRE_MAX
+			nTruncated++
+		}
+
+		// capture length is limited by the available packet data, the wire
+		// length and the maximum capture length
+		lenCapture := len(pktData)
+		if lenCapture > lenWire {
+			lenCapture = lenWire
+		}
+		if lenCapture > pktlenCaptureMax {
+			lenCapture = pktlenCaptureMax
+		}
+
+		data = append(data, pktData[0:lenCapture])
+		lensWire = append(lensWire, lenWire)
+		lensCapture = append(lensCapture, lenCapture)
+		timestamps = append(timestamps, ci.Timestamp)
+	}
+
+	if len(data) == 0 {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace '%s': file does not "+
+			"contain any packets", filename)
+	}
+
+	Log(LOG_DEBUG, "Trace '%s': read %d packets", filename, len(data))
+
+	if nTruncated > 0 {
+		Log(LOG_WARN, "Trace '%s': truncated %d packets exceeding the "+
+			"maximum wire length of %d bytes", filename, nTruncated,
+			PKTLEN_WIRE_MAX)
+	}
+
+	// accumulated inter-packet clock cycle rounding error
+	accCyclesInterPacketRoundErr := 0.0
+
+	// assemble the trace
+	builder := &TraceBuilder{
+		clock: clock,
+	}
+
+	for i := 0; i < len(data); i++ {
+		// calculate the number of cycles it takes to transmit the packet
+		cyclesTransfer := clock.cyclesTransfer(lensWire[i])
+
+		// the inter-packet time is the time until the next packet has been
+		// recorded. the last packet only occupies its transmission time
+		cyclesTotal := cyclesTransfer
+		if i < len(data)-1 {
+			cyclesTotal =
+				clock.freq * timestamps[i+1].Sub(timestamps[i]).Seconds()
+		}
+
+		// packets may have been recorded at a higher data rate than the
+		// line rate (or timestamps may be out of order). such packets are
+		// sent back-to-back
+		if cyclesTotal < cyclesTransfer {
+			cyclesTotal = cyclesTransfer
+		}
+
+		// hardware does not support inter-packet cycle numbers larger than
+		// 32 bit, so cut if necessary
+		if cyclesTotal > 4294967295 {
+			cyclesTotal = 4294967295
+		}
+
+		// the inter-packet time in clock cycles is a floating-point number,
+		// but clock cycles must always be integer values. We start by
+		// rounding up and accumulate the resulting rounding error. If the
+		// error becomes larger than 1 full clock cycle, we round down and
+		// decrease the accumulated error for the next packet (see
+		// utils.GenTraceCBR).
+		var cyclesInterPacket int
+		if accCyclesInterPacketRoundErr < 1.0 {
+			// not enough rounding error accumulated yet -> round up
+			cyclesInterPacket = int(math.Ceil(cyclesTotal))
+			accCyclesInterPacketRoundErr +=
+				math.Ceil(cyclesTotal) - cyclesTotal
+		} else {
+			// enough rounding error accumulated -> round down
+			cyclesInterPacket = int(math.Floor(cyclesTotal))
+			accCyclesInterPacketRoundErr -=
+				cyclesTotal - math.Floor(cyclesTotal)
+		}
+
+		// add packet to the trace
+		err := builder.AddPacket(TracePacket{
+			CyclesInterPacket: cyclesInterPacket,
+			Wirelen:           lensWire[i],
+			Caplen:            lensCapture[i],
+			Data:              data[i],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	Log(LOG_DEBUG, "Trace '%s': duration %s", filename, builder.GetDuration())
+
+	return builder.GetTrace(nRepeats)
+}
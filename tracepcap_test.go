@@ -0,0 +1,320 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the import of pcap and pcapng files.
+
+package gofluent10g
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPcapPackets returns packets with varying lengths, which are sent with
+// varying gaps.
+func testPcapPackets(nPkts int) ([]gopacket.CaptureInfo, [][]byte) {
+	cis := make([]gopacket.CaptureInfo, nPkts)
+	data := make([][]byte, nPkts)
+	ts := time.Unix(1500000000, 0)
+	for i := 0; i < nPkts; i++ {
+		pktlen := 60 + (i*97)%1455
+		data[i] = testPacketData(i, pktlen)
+		cis[i] = gopacket.CaptureInfo{
+			Timestamp:     ts,
+			CaptureLength: pktlen,
+			Length:        pktlen,
+		}
+
+		// transmission time at 10 Gbps (0.8 ns per byte) plus a gap
+		ts = ts.Add(time.Duration(float64(pktlen+24)*0.8) +
+			time.Duration(i%10)*100*time.Nanosecond + time.Nanosecond)
+	}
+	return cis, data
+}
+
+// testPcapWrite writes the packets to a pcap file with nanosecond timestamp
+// resolution.
+func testPcapWrite(t *testing.T, filename string, linkType layers.LinkType, cis []gopacket.CaptureInfo, data [][]byte) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriterNanos(f)
+	if err := w.WriteFileHeader(65535, linkType); err != nil {
+		t.Fatal(err)
+	}
+	for i := range cis {
+		if err := w.WritePacket(cis[i], data[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testPcapngWrite writes the packets to a pcapng file.
+func testPcapngWrite(t *testing.T, filename string, cis []gopacket.CaptureInfo, data [][]byte) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cis {
+		if err := w.WritePacket(cis[i], data[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTraceCreateFromPcapReplay imports a pcap file, replays the trace on the
+// simulated hardware and checks that packet data, lengths and inter-packet
+// times of the captured packets match the pcap file.
+func TestTraceCreateFromPcapReplay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "in.pcap")
+
+	nPkts := 200
+	cis, data := testPcapPackets(nPkts)
+	testPcapWrite(t, filename, layers.LinkTypeEthernet, cis, data)
+
+	trace, err := TraceCreateFromPcap(filename, 1514, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := trace.GetPacketCount(); n != nPkts {
+		t.Fatalf("trace contains %d packets, expected %d", n, nPkts)
+	}
+
+	nt, _ := testNetworkTesterCreate(t)
+	recv, _ := nt.GetReceiver(0)
+	if err := recv.EnableCapture(1518, RING_BUFF_RD_TRANSFER_SIZE_MIN); err != nil {
+		t.Fatal(err)
+	}
+	testReplayCheck(t, nt, trace)
+
+	pkts := testCapturePackets(t, recv)
+	if len(pkts) != nPkts {
+		t.Fatalf("captured %d packets, expected %d", len(pkts), nPkts)
+	}
+
+	// inter-packet times are rounded to clock cycles. the rounding error
+	// does not accumulate
+	tolerance := 3 / FREQ_SFP
+
+	var arrivalTime float64
+	for i, pkt := range pkts {
+		if pkt.Wirelen != cis[i].Length+4 {
+			t.Fatalf("packet %d: length %d, expected %d", i, pkt.Wirelen,
+				cis[i].Length+4)
+		}
+		if bytes.Equal(pkt.Data, data[i]) == false {
+			t.Fatalf("packet %d: data does not match", i)
+		}
+
+		if i > 0 {
+			arrivalTime += pkt.ArrivalTime
+		}
+		d := arrivalTime - cis[i].Timestamp.Sub(cis[0].Timestamp).Seconds()
+		if d < -tolerance || d > tolerance {
+			t.Fatalf("packet %d: arrival time differs by %gs", i, d)
+		}
+	}
+}
+
+// TestTraceCreateFromPcapng checks that a pcapng file is imported to the same
+// trace as a pcap file containing the same packets.
+func TestTraceCreateFromPcapng(t *testing.T) {
+	dir := t.TempDir()
+
+	cis, data := testPcapPackets(100)
+	testPcapWrite(t, filepath.Join(dir, "in.pcap"), layers.LinkTypeEthernet,
+		cis, data)
+	testPcapngWrite(t, filepath.Join(dir, "in.pcapng"), cis, data)
+
+	tracePcap, err := TraceCreateFromPcap(filepath.Join(dir, "in.pcap"), 128,
+		2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracePcapng, err := TraceCreateFromPcapng(filepath.Join(dir,
+		"in.pcapng"), 128, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(tracePcapng.GetData(), tracePcap.GetData()) == false {
+		t.Fatal("trace data does not match")
+	}
+	durationPcap, _ := tracePcap.GetDuration()
+	durationPcapng, _ := tracePcapng.GetDuration()
+	if durationPcapng != durationPcap {
+		t.Fatalf("duration %s, expected %s", durationPcapng, durationPcap)
+	}
+}
+
+// TestPcapOpen reads the packets of a pcap and a pcapng file, whose format is
+// determined by the magic number, and checks that files which cannot be opened
+// or read are rejected.
+func TestPcapOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	cis, data := testPcapPackets(100)
+	testPcapWrite(t, filepath.Join(dir, "in.pcap"), layers.LinkTypeEthernet,
+		cis, data)
+	testPcapngWrite(t, filepath.Join(dir, "in.pcapng"), cis, data)
+
+	for _, filename := range []string{"in.pcap", "in.pcapng"} {
+		f, r, err := PcapOpen("Test", filepath.Join(dir, filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for ; ; n++ {
+			pktData, ci, err := r.ReadPacketData()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(pktData, data[n]) == false ||
+				ci.Timestamp.Equal(cis[n].Timestamp) == false {
+				t.Fatalf("%s: packet %d does not match", filename, n)
+			}
+		}
+		f.Close()
+		if n != len(data) {
+			t.Fatalf("%s: read %d packets, expected %d", filename, n,
+				len(data))
+		}
+	}
+
+	// missing file, empty file and file without a valid header
+	if err := os.WriteFile(filepath.Join(dir, "empty"), nil,
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid"),
+		make([]byte, 64), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"missing", "empty", "invalid"} {
+		_, _, err := PcapOpen("Test", filepath.Join(dir, filename))
+		if errors.Is(err, ErrFile) == false {
+			t.Fatalf("%s: expected file error, got: %v", filename, err)
+		}
+	}
+}
+
+// TestTraceCreateFromPcapOversized imports a pcap file containing frames
+// that are shorter than the minimum and longer than the maximum wire length
+// supported by the hardware.
+func TestTraceCreateFromPcapOversized(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "in.pcap")
+
+	// a short frame, a frame recorded with segmentation offloading enabled
+	// (only the first bytes are captured) and a regular frame
+	lens := []int{42, 9000, 1000}
+	lensCapture := []int{42, 200, 1000}
+	lensWireExp := []int{60, PKTLEN_WIRE_MAX, 1000}
+
+	cis := make([]gopacket.CaptureInfo, len(lens))
+	data := make([][]byte, len(lens))
+	for i := range lens {
+		data[i] = testPacketData(i, lensCapture[i])
+		cis[i] = gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1500000000, int64(i)*100000),
+			CaptureLength: lensCapture[i],
+			Length:        lens[i],
+		}
+	}
+	testPcapWrite(t, filename, layers.LinkTypeEthernet, cis, data)
+
+	trace, err := TraceCreateFromPcap(filename, 1514, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := trace.Packets()
+	for i := 0; it.Next(); i++ {
+		pkt := it.Packet()
+		if pkt.Wirelen != lensWireExp[i] {
+			t.Fatalf("packet %d: wire length %d, expected %d", i,
+				pkt.Wirelen, lensWireExp[i])
+		}
+		if pkt.Caplen != lensCapture[i] {
+			t.Fatalf("packet %d: capture length %d, expected %d", i,
+				pkt.Caplen, lensCapture[i])
+		}
+		if bytes.Equal(pkt.Data[0:pkt.Caplen], data[i]) == false {
+			t.Fatalf("packet %d: data does not match", i)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := trace.GetPacketCount(); n != len(lens) {
+		t.Fatalf("trace contains %d packets, expected %d", n, len(lens))
+	}
+}
+
+// TestTraceCreateFromPcapInvalid checks that files that cannot be replayed
+// are rejected.
+func TestTraceCreateFromPcapInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	// packets are not Ethernet frames
+	cis, data := testPcapPackets(10)
+	testPcapWrite(t, filepath.Join(dir, "raw.pcap"), layers.LinkTypeRaw, cis,
+		data)
+	_, err := TraceCreateFromPcap(filepath.Join(dir, "raw.pcap"), 1514, 1)
+	if errors.Is(err, ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+
+	// file does not exist
+	_, err = TraceCreateFromPcapng(filepath.Join(dir, "missing.pcapng"),
+		1514, 1)
+	if errors.Is(err, ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Trace transformations. The functions create a new trace from an existing
+// one, e.g. to replay a recorded trace at a different data rate or to only
+// replay a part of it. The original trace is not modified.
+
+package gofluent10g
+
+import (
+	"math"
+	"time"
+)
+
+// ScaleDatarate creates a new trace with the same packets as the trace, but
+// with inter-packet times scaled such that the trace is replayed with the
+// specified average data rate (in bits per second, including Ethernet
+// preamble, SOD, FCS and inter-frame gap). Inter-packet times never fall below
+// the time it takes to transmit a packet at the line rate of the trace (see
+// GetLineRate()). Packets whose
+// inter-packet times are limited by the line rate are compensated by scaling
+// all other inter-packet times more strongly.
+func (trace *Trace) ScaleDatarate(datarate float64) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	if datarate <= 0 {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: data rate must be larger than zero")
+	}
+
+	// get inter-packet cycles and transmission cycles of all packets
+	cycles, cyclesTransfer, nBytesWire, err := trace.getCycles()
+	if err != nil {
+		return nil, err
+	}
+
+	// target number of clock cycles of the trace
+	cyclesTarget := trace.clock.freq * float64(8*nBytesWire) / datarate
+
+	// cyclesScaled returns the number of clock cycles of the trace when inter-packet
+	// times are scaled by the specified factor
+	cyclesScaled := func(factor float64) float64 {
+		var sum float64
+		for i := range cycles {
+			sum += traceScaleCycles(cycles[i], cyclesTransfer[i], factor)
+		}
+		return sum
+	}
+
+	// the target data rate cannot be reached if it exceeds the line rate or
+	// if the inter-packet times would exceed the maximum value supported by
+	// the hardware
+	if cyclesTarget < cyclesScaled(0) {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace: data rate exceeds "+
+			"line rate")
+	}
+	if cyclesTarget > float64(len(cycles))*4294967295 {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace: data rate too low")
+	}
+
+	// inter-packet times can only be scaled if there are any
+	var cyclesSum uint64
+	for i := range cycles {
+		cyclesSum += uint64(cycles[i])
+	}
+	if cyclesSum == 0 {
+		return nil, ErrorCreate(ErrInvalidConfig, "Trace: inter-packet "+
+			"times are all zero, cannot scale data rate")
+	}
+
+	// find an upper bound for the scaling factor. inter-packet times are
+	// limited to 32 bit, so the number of clock cycles stops increasing at
+	// some point
+	factorHi := 1.0
+	for i := 0; cyclesScaled(factorHi) < cyclesTarget; i++ {
+		if i == 64 {
+			return nil, ErrorCreate(ErrInvalidConfig,
+				"Trace: data rate too low")
+		}
+		factorHi *= 2
+	}
+
+	// the number of clock cycles increases monotonically with the scaling
+	// factor, so find the scaling factor hitting the target by bisection
+	factorLo := 0.0
+	for i := 0; i < 64; i++ {
+		factor := (factorLo + factorHi) / 2
+		if cyclesScaled(factor) < cyclesTarget {
+			factorLo = factor
+		} else {
+			factorHi = factor
+		}
+	}
+
+	Log(LOG_DEBUG, "Trace: scaling inter-packet times by %f", factorHi)
+
+	return trace.scale(factorHi)
+}
+
+// ScaleTime creates a new trace with the same packets as the trace, but with
+// all inter-packet times multiplied by the specified factor. Factors larger
+// than 1 slow down the replay, factors smaller than 1 speed it up.
+// Inter-packet times never fall below the time it takes to transmit a packet
+// at the line rate of the trace.
+func (trace *Trace) ScaleTime(factor float64) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	if factor <= 0 {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: time scaling factor must be larger than zero")
+	}
+
+	return trace.scale(factor)
+}
+
+// TruncateDuration creates a new trace containing the packets of the trace,
+// whose transmission starts within the specified duration.
+func (trace *Trace) TruncateDuration(duration time.Duration) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	// duration in clock cycles
+	cyclesMax := uint64(duration.Seconds() * trace.clock.freq)
+
+	// start time of the current packet in clock cycles
+	var cyclesStart uint64
+
+	return trace.transform(func(pkt *TracePacket) bool {
+		if cyclesStart >= cyclesMax {
+			return false
+		}
+		cyclesStart += uint64(pkt.CyclesInterPacket)
+		return true
+	})
+}
+
+// TruncatePacketCount creates a new trace containing the first nPackets
+// packets of the trace.
+func (trace *Trace) TruncatePacketCount(nPackets int) (_ *Trace, err error) {
+	defer ErrorReturn(&err)
+
+	if nPackets < 0 {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: packet count must not be negative")
+	}
+
+	n := 0
+	return trace.transform(func(pkt *TracePacket) bool {
+		n++
+		return n <= nPackets
+	})
+}
+
+// scale creates a new trace with inter-packet times scaled by the specified
+// factor. Scaled inter-packet times are floating-point numbers, but clock
+// cycles must always be integer values. Like in the trace generation functions
+// of the utils package, we start by rounding up and accumulate the resulting
+// rounding error. If the error becomes larger than 1 full clock cycle, we
+// round down and decrease the accumulated error for the next packet.
+func (trace *Trace) scale(factor float64) (*Trace, error) {
+	// accumulated inter-packet clock cycle rounding error
+	accCyclesInterPacketRoundErr := 0.0
+
+	return trace.transform(func(pkt *TracePacket) bool {
+		cycles := traceScaleCycles(pkt.CyclesInterPacket,
+			trace.clock.cyclesTransfer(pkt.Wirelen), factor)
+
+		if accCyclesInterPacketRoundErr < 1.0 {
+			// not enough rounding error accumulated yet -> round up
+			pkt.CyclesInterPacket = int(math.Ceil(cycles))
+			accCyclesInterPacketRoundErr += math.Ceil(cycles) - cycles
+		} else {
+			// enough rounding error accumulated -> round down
+			pkt.CyclesInterPacket = int(math.Floor(cycles))
+			accCyclesInterPacketRoundErr -= cycles - math.Floor(cycles)
+		}
+
+		return true
+	})
+}
+
+// traceScaleCycles scales the inter-packet time (in clock cycles) of a packet.
+// The scaled value is limited by the packet's transmission time at line rate
+// and the maximum value supported by the hardware.
+func traceScaleCycles(cycles int, cyclesTransfer float64, factor float64) float64 {
+	cyclesScaled := float64(cycles) * factor
+	if cyclesScaled < cyclesTransfer {
+		cyclesScaled = cyclesTransfer
+	}
+
+	// hardware does not support inter-packet cycle numbers larger than 32
+	// bit, so cut if necessary
+	if cyclesScaled > 4294967295 {
+		cyclesScaled = 4294967295
+	}
+
+	return cyclesScaled
+}
+
+// getCycles returns the inter-packet cycles and the number of cycles it takes
+// to transmit the packet at line rate for each packet of the trace, as well as
+// the number of bytes the trace sends on the wire.
+func (trace *Trace) getCycles() ([]int, []float64, uint64, error) {
+	var cycles []int
+	var cyclesTransfer []float64
+	var nBytesWire uint64
+
+	it := trace.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+		cycles = append(cycles, pkt.CyclesInterPacket)
+		cyclesTransfer = append(cyclesTransfer,
+			trace.clock.cyclesTransfer(pkt.Wirelen))
+
+		// add 24 bytes for preamble, SOD, FCS and inter-frame gap
+		nBytesWire += uint64(pkt.Wirelen + 24)
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	if len(cycles) == 0 {
+		return nil, nil, 0, ErrorCreate(ErrInvalidConfig,
+			"Trace: trace does not contain any packets")
+	}
+
+	return cycles, cyclesTransfer, nBytesWire, nil
+}
+
+// transform creates a new trace by calling the specified function for each
+// packet of the trace. The function may modify the packet. The packet is added
+// to the new trace if the function returns true, otherwise the transformation
+// stops. The new trace is replayed as many times as the original trace and
+// uses its clock.
+func (trace *Trace) transform(fn func(pkt *TracePacket) bool) (*Trace, error) {
+	builder := &TraceBuilder{
+		clock: trace.clock,
+	}
+
+	it := trace.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+		if !fn(&pkt) {
+			break
+		}
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	if builder.GetPacketCount() == 0 {
+		return nil, ErrorCreate(ErrInvalidConfig,
+			"Trace: resulting trace does not contain any packets")
+	}
+
+	traceNew, err := builder.GetTrace(trace.nRepeats)
+	if err != nil {
+		return nil, err
+	}
+
+	// the new trace is derived from the packets of the original trace, keep
+	// the seed of the random number generator that generated them
+	if trace.hasSeed {
+		traceNew.SetSeed(trace.seed)
+	}
+
+	return traceNew, nil
+}
@@ -0,0 +1,215 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the transformation of traces.
+
+package gofluent10g
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// testTransformPktlen returns varying wire lengths and a capture length of 60
+// bytes.
+func testTransformPktlen(i int) (int, int) {
+	return 60 + (i*131)%1455, 60
+}
+
+// TestTraceScaleDatarate scales a trace with irregular inter-packet times to
+// several target data rates and checks that the data rate is reached without
+// exceeding the line rate.
+func TestTraceScaleDatarate(t *testing.T) {
+	// every fourth packet is sent back-to-back, all others with gaps of
+	// varying length
+	trace := testTraceCreate(t, 2000, 2, testTransformPktlen,
+		func(i int, wirelen int) int {
+			if i%4 == 0 {
+				return testCyclesTransfer(wirelen)
+			}
+			return testCyclesTransfer(wirelen) + (i*17)%1000
+		})
+	trace.SetSeed(42)
+
+	for _, datarate := range []float64{1e6, 1e9, 5e9, 9.5e9} {
+		traceScaled, err := trace.ScaleDatarate(datarate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		datarateScaled, err := traceScaled.GetDatarate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(datarateScaled-datarate)/datarate > 1e-3 {
+			t.Fatalf("data rate %f, expected %f", datarateScaled, datarate)
+		}
+
+		// inter-packet times never fall below the transmission time (minus
+		// one clock cycle of rounding error)
+		it := traceScaled.Packets()
+		for it.Next() {
+			pkt := it.Packet()
+			cyclesTransfer := trace.clock.cyclesTransfer(pkt.Wirelen)
+			if float64(pkt.CyclesInterPacket) < cyclesTransfer-1 {
+				t.Fatalf("%f bit/s: inter-packet time %d below transmission "+
+					"time %f", datarate, pkt.CyclesInterPacket,
+					cyclesTransfer)
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		// packets, repetitions and seed are kept
+		nPkts, _ := trace.GetPacketCount()
+		nPktsScaled, _ := traceScaled.GetPacketCount()
+		if nPktsScaled != nPkts {
+			t.Fatalf("scaled trace contains %d packets, expected %d",
+				nPktsScaled, nPkts)
+		}
+		if seed, ok := traceScaled.GetSeed(); ok == false || seed != 42 {
+			t.Fatal("seed of the trace has not been kept")
+		}
+	}
+}
+
+// TestTraceScaleDatarateInvalid checks that data rates that cannot be reached
+// are rejected.
+func TestTraceScaleDatarateInvalid(t *testing.T) {
+	trace := testTraceCreate(t, 100, 2, testTransformPktlen,
+		func(i int, wirelen int) int {
+			return 2 * testCyclesTransfer(wirelen)
+		})
+
+	for _, datarate := range []float64{0, -1e9, 10.5e9, 1} {
+		if _, err := trace.ScaleDatarate(datarate); errors.Is(err,
+			ErrInvalidConfig) == false {
+			t.Fatalf("%f bit/s: expected invalid configuration error, got: "+
+				"%v", datarate, err)
+		}
+	}
+
+	// inter-packet times of zero cannot be scaled
+	trace = testTraceCreate(t, 100, 1, testTransformPktlen,
+		func(i int, wirelen int) int {
+			return 0
+		})
+	if _, err := trace.ScaleDatarate(1e9); errors.Is(err,
+		ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
+
+// TestTraceScaleTime scales the inter-packet times of a trace and checks that
+// they are limited by the transmission time.
+func TestTraceScaleTime(t *testing.T) {
+	trace := testTraceCreate(t, 100, 2, testTransformPktlen,
+		func(i int, wirelen int) int {
+			return 1000
+		})
+
+	traceScaled, err := trace.ScaleTime(2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := traceScaled.GetDuration(); d != 2*100*time.Duration(
+		2500/FREQ_SFP*1e9) {
+		t.Fatalf("duration %s, expected %s", d,
+			2*100*time.Duration(2500/FREQ_SFP*1e9))
+	}
+
+	// speeding up by a large factor results in back-to-back transmission
+	traceScaled, err = trace.ScaleTime(1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := traceScaled.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+		if float64(pkt.CyclesInterPacket) < trace.clock.cyclesTransfer(pkt.Wirelen)-1 {
+			t.Fatalf("inter-packet time %d below transmission time",
+				pkt.CyclesInterPacket)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := trace.ScaleTime(0); errors.Is(err,
+		ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
+
+// TestTraceTruncate truncates a trace by duration and packet count.
+func TestTraceTruncate(t *testing.T) {
+	trace := testTraceCreate(t, 100, 3, testTransformPktlen,
+		func(i int, wirelen int) int {
+			return 1000
+		})
+
+	// packets starting within the first 10.5 packet times
+	traceTrunc, err := trace.TruncateDuration(time.Duration(10.5 * 1000 /
+		FREQ_SFP * 1e9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := traceTrunc.GetPacketCount(); n != 3*11 {
+		t.Fatalf("trace contains %d packets, expected %d", n, 3*11)
+	}
+
+	traceTrunc, err = trace.TruncatePacketCount(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := traceTrunc.GetPacketCount(); n != 3*42 {
+		t.Fatalf("trace contains %d packets, expected %d", n, 3*42)
+	}
+
+	// packet data is kept
+	it := traceTrunc.Packets()
+	for i := 0; it.Next(); i++ {
+		if it.Packet().Wirelen != 60+(i*131)%1455 ||
+			it.Packet().Data[3] != byte(i) {
+			t.Fatalf("packet %d: invalid packet", i)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, -1} {
+		if _, err := trace.TruncatePacketCount(n); errors.Is(err,
+			ErrInvalidConfig) == false {
+			t.Fatalf("%d packets: expected invalid configuration error, "+
+				"got: %v", n, err)
+		}
+	}
+}
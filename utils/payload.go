@@ -0,0 +1,262 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements payload patterns for the packets of a trace.
+
+package utils
+
+import (
+	"github.com/aoeldemann/gofluent10g"
+	"io"
+)
+
+// payload modes
+const (
+	// payload bytes are set to zero
+	PayloadZero int = 0
+
+	// payload bytes are incremented (0, 1, ..., 255, 0, ...), starting at
+	// zero in each packet
+	PayloadIncrementing int = 1
+
+	// payload bytes are taken from a PRBS-31 pseudo-random bit sequence,
+	// which continues across packets
+	PayloadPRBS int = 2
+
+	// payload bytes are random
+	PayloadRandom int = 3
+
+	// payload bytes repeat a user-defined pattern, starting at the beginning
+	// of the pattern in each packet
+	PayloadPattern int = 4
+
+	// payloads are taken from the packets of a pcap or pcapng file in
+	// round-robin fashion
+	PayloadPcap int = 5
+)
+
+// maximum number of payloads read from a pcap file
+const payloadPcapMax = 65536
+
+// Payload defines the payload of the packets of a trace.
+//
+// Payload bytes are only written to the trace up to the capture length of a
+// packet, the hardware pads the packet with zero bytes up to its wire length.
+// If Full is false, the capture length of the packets is kept. The trace
+// size and the DMA bandwidth required for replay do not change, but only the
+// first payload bytes within the capture length carry the pattern. If Full is
+// true, the capture length is increased to the wire length, so that the
+// pattern spans the entire payload. This results in maximum payload realism,
+// but also in larger traces and a higher DMA bandwidth required for replay,
+// which may exceed the bandwidth available at high data rates.
+type Payload struct {
+	Mode         int    // payload mode (PayloadZero, PayloadIncrementing, ...)
+	Pattern      []byte // payload pattern (PayloadPattern)
+	PcapFilename string // pcap or pcapng file (PayloadPcap)
+	Full         bool   // if true, capture length is set to wire length
+}
+
+// ApplyPayload creates a new trace with the same packets as the provided
+// trace, but with payloads defined by payload. The payload of a packet starts
+// after its last Ethernet, VLAN, MPLS, IP, UDP, TCP, VXLAN or GRE header. The
+// checksums of UDP and TCP headers are recalculated (UDP checksums are only
+// recalculated if they are not zero). Packets whose capture length ends within
+// a header are not modified. Payloads should be applied before sequence tags
+// are added to the trace (see Trace.AddSequenceTags()). The seed
+// parameter initializes the random number generator for PayloadRandom and the
+// initial state of the PRBS sequence. If seed is zero, a random seed is
+// selected.
+func ApplyPayload(trace *gofluent10g.Trace, payload Payload, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// create random number generator
+	rng, _ := rngCreate(seed)
+
+	// read payloads from pcap file
+	var pcapPayloads [][]byte
+	if payload.Mode == PayloadPcap {
+		var err error
+		if pcapPayloads, err = payloadPcapRead(payload.PcapFilename); err != nil {
+			return nil, err
+		}
+	} else if payload.Mode == PayloadPattern && len(payload.Pattern) == 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"ApplyPayload: empty payload pattern")
+	} else if payload.Mode < PayloadZero || payload.Mode > PayloadPcap {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"ApplyPayload: invalid payload mode")
+	}
+
+	// initial state of the PRBS-31 sequence must not be zero
+	prbs := uint32(rng.Int31n(0x7FFFFFFF)) + 1
+
+	// the new trace keeps the clock of the original trace
+	builder, err := gofluent10g.TraceBuilderCreateWithClock(trace.GetClock(),
+		trace.GetLineRate())
+	if err != nil {
+		return nil, err
+	}
+
+	// number of packets whose headers are truncated by the capture length
+	var nTruncated int
+
+	it := trace.Packets()
+	for i := 0; it.Next(); i++ {
+		pkt := it.Packet()
+
+		// locate packet headers. The headers must be located in the
+		// captured data, the data appended by the hardware is zero
+		offset, complete := gofluent10g.PacketHeadersLen(pkt.Data)
+
+		// increase capture length if requested and copy the packet data
+		// before modifying it
+		if payload.Full {
+			pkt.Caplen = pkt.Wirelen
+		}
+		data := make([]byte, pkt.Caplen)
+		copy(data, pkt.Data)
+
+		// the capture length cuts off the last header. The payload would
+		// overwrite the remaining header bytes and checksums cannot be
+		// calculated, so the packet is not modified
+		if complete == false {
+			nTruncated++
+			pkt.Data = data
+			if err := builder.AddPacket(pkt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// write payload
+		if offset < len(data) {
+			p := data[offset:]
+			switch payload.Mode {
+			case PayloadZero:
+				for j := range p {
+					p[j] = 0
+				}
+			case PayloadIncrementing:
+				for j := range p {
+					p[j] = byte(j)
+				}
+			case PayloadPRBS:
+				for j := range p {
+					var b byte
+					for k := 0; k < 8; k++ {
+						// x^31 + x^28 + 1
+						bit := ((prbs >> 30) ^ (prbs >> 27)) & 1
+						prbs = ((prbs << 1) | bit) & 0x7FFFFFFF
+						b = (b << 1) | byte(bit)
+					}
+					p[j] = b
+				}
+			case PayloadRandom:
+				rng.Read(p)
+			case PayloadPattern:
+				for j := range p {
+					p[j] = payload.Pattern[j%len(payload.Pattern)]
+				}
+			case PayloadPcap:
+				n := copy(p, pcapPayloads[i%len(pcapPayloads)])
+				for j := n; j < len(p); j++ {
+					p[j] = 0
+				}
+			}
+		}
+
+		// recalculate layer 4 checksums
+		gofluent10g.PacketChecksumsSet(data)
+
+		pkt.Data = data
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	if nTruncated > 0 {
+		gofluent10g.Log(gofluent10g.LOG_WARN, "ApplyPayload: headers of %d "+
+			"packet(s) truncated by the capture length, no payload applied",
+			nTruncated)
+	}
+
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "ApplyPayload: trace size %d bytes",
+		len(builder.GetData()))
+
+	traceNew, err := builder.GetTrace(trace.GetRepeatCount())
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the seed of the random number generator that generated the packets
+	if seedTrace, ok := trace.GetSeed(); ok {
+		traceNew.SetSeed(seedTrace)
+	}
+
+	return traceNew, nil
+}
+
+// payloadPcapRead reads the payloads of the packets of a pcap or pcapng file.
+// Packets without payload and packets whose data ends within a header are
+// skipped.
+func payloadPcapRead(filename string) ([][]byte, error) {
+	f, r, err := gofluent10g.PcapOpen("ApplyPayload", filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var payloads [][]byte
+	for len(payloads) < payloadPcapMax {
+		data, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrFile,
+				"ApplyPayload '%s': %s", filename, err.Error())
+		}
+
+		offset, complete := gofluent10g.PacketHeadersLen(data)
+		if complete == false {
+			// packet data ends within a header, no payload
+			continue
+		}
+		if offset < len(data) {
+			payloads = append(payloads, append([]byte(nil), data[offset:]...))
+		}
+	}
+
+	if len(payloads) == 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"ApplyPayload '%s': file does not contain any payloads", filename)
+	}
+
+	return payloads, nil
+}
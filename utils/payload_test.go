@@ -0,0 +1,312 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the payload patterns applied to the packets of a trace.
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPayloadTrace generates a trace of UDP or TCP flows whose packets are
+// captured entirely.
+func testPayloadTrace(t *testing.T, protocol layers.IPProtocol) *gofluent10g.Trace {
+	trace, err := GenTraceFlows(5e9, 200, 196, testFlowConfig(4, protocol),
+		10*time.Microsecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace
+}
+
+// testPayloads applies the payload to the trace and returns the payloads of
+// the packets. The checksums of all packets are checked.
+func testPayloads(t *testing.T, trace *gofluent10g.Trace, payload Payload, seed int64) [][]byte {
+	traceNew, err := ApplyPayload(trace, payload, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payloads [][]byte
+	it := traceNew.Packets()
+	for n := 0; it.Next(); n++ {
+		data := it.Packet().Data
+		testCheckChecksums(t, n, data)
+
+		pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet,
+			gopacket.Default)
+		payloads = append(payloads, pkt.TransportLayer().LayerPayload())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) == 0 {
+		t.Fatal("trace does not contain any packets")
+	}
+	return payloads
+}
+
+// TestApplyPayloadModes applies deterministic payload patterns to UDP and TCP
+// packets and checks the payloads and checksums.
+func TestApplyPayloadModes(t *testing.T) {
+	protocols := []layers.IPProtocol{layers.IPProtocolUDP,
+		layers.IPProtocolTCP}
+	for _, protocol := range protocols {
+		trace := testPayloadTrace(t, protocol)
+
+		payloads := testPayloads(t, trace,
+			Payload{Mode: PayloadIncrementing}, 42)
+		for n, p := range payloads {
+			for j := range p {
+				if p[j] != byte(j) {
+					t.Fatalf("%s: packet %d: invalid incrementing payload",
+						protocol, n)
+				}
+			}
+		}
+
+		pattern := []byte{0xde, 0xad, 0xbe, 0xef, 0x42}
+		payloads = testPayloads(t, trace,
+			Payload{Mode: PayloadPattern, Pattern: pattern}, 42)
+		for n, p := range payloads {
+			for j := range p {
+				if p[j] != pattern[j%len(pattern)] {
+					t.Fatalf("%s: packet %d: invalid pattern payload",
+						protocol, n)
+				}
+			}
+		}
+
+		payloads = testPayloads(t, trace, Payload{Mode: PayloadZero}, 42)
+		for n, p := range payloads {
+			if bytes.Equal(p, make([]byte, len(p))) == false {
+				t.Fatalf("%s: packet %d: invalid zero payload", protocol, n)
+			}
+		}
+	}
+}
+
+// TestApplyPayloadPRBS checks that the payload bits follow the PRBS-31
+// sequence across packet boundaries and that the same seed results in the
+// same payloads.
+func TestApplyPayloadPRBS(t *testing.T) {
+	trace := testPayloadTrace(t, layers.IPProtocolUDP)
+
+	payloads := testPayloads(t, trace, Payload{Mode: PayloadPRBS}, 42)
+
+	// collect the bits of all payloads, most significant bit first
+	var bits []byte
+	for _, p := range payloads {
+		for _, b := range p {
+			for k := 7; k >= 0; k-- {
+				bits = append(bits, (b>>uint(k))&1)
+			}
+		}
+	}
+
+	// x^31 + x^28 + 1
+	for n := 31; n < len(bits); n++ {
+		if bits[n] != bits[n-31]^bits[n-28] {
+			t.Fatalf("bit %d does not follow the PRBS-31 sequence", n)
+		}
+	}
+
+	// same seed results in the same payloads, different seeds and random
+	// payloads in different ones
+	if bytes.Equal(bytes.Join(payloads, nil), bytes.Join(testPayloads(t,
+		trace, Payload{Mode: PayloadPRBS}, 42), nil)) == false {
+		t.Fatal("same seed results in different PRBS payloads")
+	}
+	if bytes.Equal(bytes.Join(payloads, nil), bytes.Join(testPayloads(t,
+		trace, Payload{Mode: PayloadPRBS}, 43), nil)) {
+		t.Fatal("different seeds result in the same PRBS payloads")
+	}
+	random := bytes.Join(testPayloads(t, trace, Payload{Mode: PayloadRandom},
+		42), nil)
+	if bytes.Equal(random, bytes.Join(testPayloads(t, trace,
+		Payload{Mode: PayloadRandom}, 42), nil)) == false {
+		t.Fatal("same seed results in different random payloads")
+	}
+}
+
+// TestApplyPayloadFull checks that the capture length is increased to the
+// wire length if the payload shall span the entire packet.
+func TestApplyPayloadFull(t *testing.T) {
+	trace, err := GenTraceFlows(5e9, 200, 64,
+		testFlowConfig(4, layers.IPProtocolTCP), 10*time.Microsecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace.SetSeed(7)
+
+	traceFull, err := ApplyPayload(trace, Payload{Mode: PayloadIncrementing,
+		Full: true}, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := traceFull.Packets()
+	for n := 0; it.Next(); n++ {
+		pkt := it.Packet()
+		if pkt.Caplen != pkt.Wirelen {
+			t.Fatalf("packet %d: capture length %d, expected %d", n,
+				pkt.Caplen, pkt.Wirelen)
+		}
+		testCheckChecksums(t, n, pkt.Data)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if seed, ok := traceFull.GetSeed(); ok == false || seed != 7 {
+		t.Fatal("seed of the trace has not been kept")
+	}
+}
+
+// TestApplyPayloadTruncated checks that packets whose capture length ends
+// within the TCP header are not modified.
+func TestApplyPayloadTruncated(t *testing.T) {
+	// Ethernet and IPv4 header followed by 10 bytes of the TCP header
+	trace, err := GenTraceFlows(5e9, 200, 44,
+		testFlowConfig(4, layers.IPProtocolTCP), 10*time.Microsecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceNew, err := ApplyPayload(trace, Payload{Mode: PayloadPattern,
+		Pattern: []byte{0xFF}, Full: true}, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, itNew := trace.Packets(), traceNew.Packets()
+	var n int
+	for ; it.Next() && itNew.Next(); n++ {
+		pkt, pktNew := it.Packet(), itNew.Packet()
+		if pktNew.Caplen != pktNew.Wirelen {
+			t.Fatalf("packet %d: capture length %d, expected %d", n,
+				pktNew.Caplen, pktNew.Wirelen)
+		}
+		if bytes.Equal(pktNew.Data[0:pkt.Caplen], pkt.Data) == false ||
+			bytes.Equal(pktNew.Data[pkt.Caplen:],
+				make([]byte, pktNew.Caplen-pkt.Caplen)) == false {
+			t.Fatalf("packet %d: truncated header has been modified", n)
+		}
+	}
+	nPkts, err := trace.GetPacketCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n != nPkts {
+		t.Fatalf("compared %d packets, expected %d", n, nPkts)
+	}
+}
+
+// TestApplyPayloadPcap takes payloads from the packets of a pcap file.
+func TestApplyPayloadPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofluent10g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// write two UDP packets with different payloads
+	payloadsPcap := [][]byte{[]byte("first payload"), []byte("second")}
+	filename := filepath.Join(dir, "payload.pcap")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := pcapgo.NewWriter(f)
+	w.WriteFileHeader(65535, layers.LinkTypeEthernet)
+	for _, p := range payloadsPcap {
+		hdrIp := &layers.IPv4{Version: 4, IHL: 5, TTL: 64,
+			Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, 1},
+			DstIP: []byte{10, 0, 0, 2}}
+		hdrUdp := &layers.UDP{SrcPort: 1, DstPort: 2}
+		hdrUdp.SetNetworkLayerForChecksum(hdrIp)
+		buf := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{
+			FixLengths: true, ComputeChecksums: true},
+			&layers.Ethernet{SrcMAC: make([]byte, 6), DstMAC: make([]byte, 6),
+				EthernetType: layers.EthernetTypeIPv4}, hdrIp, hdrUdp,
+			gopacket.Payload(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(),
+			CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	trace := testPayloadTrace(t, layers.IPProtocolUDP)
+	payloads := testPayloads(t, trace, Payload{Mode: PayloadPcap,
+		PcapFilename: filename}, 42)
+	for n, p := range payloads {
+		// payloads are padded with zero bytes
+		exp := make([]byte, len(p))
+		copy(exp, payloadsPcap[n%2])
+		if bytes.Equal(p, exp) == false {
+			t.Fatalf("packet %d: invalid payload", n)
+		}
+	}
+
+	_, err = ApplyPayload(trace, Payload{Mode: PayloadPcap,
+		PcapFilename: filepath.Join(dir, "missing.pcap")}, 42)
+	if errors.Is(err, gofluent10g.ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+}
+
+// TestApplyPayloadInvalid checks that invalid payloads are rejected.
+func TestApplyPayloadInvalid(t *testing.T) {
+	trace := testPayloadTrace(t, layers.IPProtocolUDP)
+	payloads := []Payload{
+		{Mode: -1},
+		{Mode: 42},
+		{Mode: PayloadPattern},
+	}
+	for i, payload := range payloads {
+		_, err := ApplyPayload(trace, payload, 42)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("payload %d: expected invalid configuration error, "+
+				"got: %v", i, err)
+		}
+	}
+}
@@ -30,17 +30,66 @@
 package utils
 
 import (
-	"encoding/binary"
+	"errors"
 	"github.com/aoeldemann/gofluent10g"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"math"
 	"math/rand"
 	"net"
-	"runtime"
 	"time"
 )
 
+// ip versions of the generated packets
+const (
+	IPVersion4     int = 0 // IPv4 packets only
+	IPVersion6     int = 1 // IPv6 packets only
+	IPVersionMixed int = 2 // IPv4 and IPv6 packets
+)
+
+// TraceGenerator generates traces for a board with a specific clock frequency
+// and line rate (see gofluent10g.BoardProfile.FreqTrace and
+// gofluent10g.BoardProfile.LineRate). Inter-packet times are specified in
+// cycles of this clock, data rates must not exceed the line rate. Its methods
+// correspond to the trace generation functions of the package, which generate
+// traces for the NetFPGA-SUME. A TraceGenerator is not modified after it has
+// been created and can be used by multiple goroutines at once.
+type TraceGenerator struct {
+	freqClock float64 // clock frequency in Hz
+	lineRate  float64 // line rate in bits per second
+}
+
+// trace generator of the package functions, generating traces for the
+// NetFPGA-SUME
+var traceGenDefault = &TraceGenerator{
+	freqClock: gofluent10g.FREQ_SFP,
+	lineRate:  gofluent10g.LINE_RATE,
+}
+
+// TraceGeneratorCreate creates a trace generator for a board with the
+// specified clock frequency (in Hz) and line rate (in bits per second).
+func TraceGeneratorCreate(freqClock, lineRate float64) (_ *TraceGenerator, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	if freqClock <= 0 || lineRate <= 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"TraceGenerator: clock frequency and line rate must be larger "+
+				"than zero")
+	}
+	return &TraceGenerator{
+		freqClock: freqClock,
+		lineRate:  lineRate,
+	}, nil
+}
+
+// TraceGeneratorCreateWithBoard creates a trace generator for the board
+// described by the board profile.
+func TraceGeneratorCreateWithBoard(board gofluent10g.BoardProfile) (_ *TraceGenerator, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return TraceGeneratorCreate(board.FreqTrace, board.LineRate)
+}
+
 // GenTraceCBR generates traffic with constant bit rate and packet lenghts.
 // Only Ethernet and IPv4 headers are generated, payload bits are set to zero.
 // datarate defines the target data rate, pktlenWire the length of the
@@ -52,13 +101,90 @@ import (
 // the total duration of the generated trace. The parameter nRepeats determins
 // how often the generated trace shall be replayed. Data is only generated for
 // the first replay, the generator wraps around for all further replays.
-func GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int) *gofluent10g.Trace {
+// The seed parameter initializes the random number generator, the same seed
+// and parameters always result in identical trace data. If seed is zero, a
+// random seed is selected. The seed is recorded in the trace (see
+// Trace.GetSeed()).
+func GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceCBR(datarate, pktlenWire, pktlenCapture,
+		duration, nRepeats, seed)
+}
+
+// GenTraceCBR generates a trace like the package function GenTraceCBR(), but
+// for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceCBREncap(nil, IPVersion4, datarate, pktlenWire,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceCBRIPv6 generates traffic with constant bit rate and packet lengths
+// like GenTraceCBR, but generates Ethernet and IPv6 headers. The payload length
+// field of the IPv6 header is set according to the packet length.
+func GenTraceCBRIPv6(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceCBRIPv6(datarate, pktlenWire, pktlenCapture,
+		duration, nRepeats, seed)
+}
+
+// GenTraceCBRIPv6 generates a trace like the package function
+// GenTraceCBRIPv6(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceCBRIPv6(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceCBREncap(nil, IPVersion6, datarate, pktlenWire,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceCBRMixed generates traffic with constant bit rate and packet lengths
+// like GenTraceCBR, but alternately generates IPv4 and IPv6 packets.
+func GenTraceCBRMixed(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceCBRMixed(datarate, pktlenWire, pktlenCapture,
+		duration, nRepeats, seed)
+}
+
+// GenTraceCBRMixed generates a trace like the package function
+// GenTraceCBRMixed(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceCBRMixed(datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceCBREncap(nil, IPVersionMixed, datarate, pktlenWire,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceCBREncap generates traffic with constant bit rate and packet lengths
+// like GenTraceCBR. The encap parameter specifies the encapsulation headers
+// that are inserted between the Ethernet and the IP header (may be nil). The
+// ipVersion parameter selects whether IPv4 (IPVersion4), IPv6 (IPVersion6) or
+// alternately IPv4 and IPv6 packets (IPVersionMixed) are generated.
+func GenTraceCBREncap(encap EncapStack, ipVersion int, datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceCBREncap(encap, ipVersion, datarate,
+		pktlenWire, pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceCBREncap generates a trace like the package function
+// GenTraceCBREncap(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceCBREncap(encap EncapStack, ipVersion int, datarate float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
 	// MAC will append FCS, so substract 4 bytes from wire length
 	pktlenWire -= 4
 
 	// check if capture length is valid
 	if pktlenCapture > pktlenWire {
-		gofluent10g.Log(gofluent10g.LOG_ERR, "GenTraceCBR: invalid capture length")
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceCBR: invalid capture length")
+	}
+
+	// check encapsulation headers
+	if err := encap.check(); err != nil {
+		return nil, err
 	}
 
 	// calculate the number of packets we will generate
@@ -83,53 +209,42 @@ func GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.
 	// determine the mean inter-packet time in clock cycles. Again, packet
 	// length does not include Ethernet preamble, SOD and FCS, as well as
 	// inter-frame gap => add 24 bytes
-	cyclesInterPacketMean := gofluent10g.FREQ_SFP * float64(8*(pktlenWire+24)) /
+	cyclesInterPacketMean := tracegen.freqClock * float64(8*(pktlenWire+24)) /
 		datarate
 
-	// generate random source and destination ipv4 addresses, which will be
-	// reused for all packets
-	ipSrc := make([]byte, 4)
-	ipDst := make([]byte, 4)
-	rand.Read(ipSrc)
-	rand.Read(ipDst)
-
-	// create ip header (substract 14 bytes for ethernet header, i.e. mac
-	// addresses and ethertype) from ip packet length
-	hdrIp := &layers.IPv4{
-		Version: 4,
-		IHL:     5,
-		Length:  uint16(pktlenWire - 14),
-		SrcIP:   ipSrc,
-		DstIP:   ipDst,
+	// create ip headers with random source and destination addresses, which
+	// will be reused for all packets. In mixed mode, packets alternately
+	// carry the IPv4 and the IPv6 header
+	hdrsIp, err := hdrsIpCreate(rng, ipVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	// serialize packet data
-	bufPkt := gopacket.NewSerializeBuffer()
-	err := gopacket.SerializeLayers(bufPkt, gopacket.SerializeOptions{},
-		hdrEth, hdrIp)
-	if err != nil {
-		gofluent10g.Log(gofluent10g.LOG_ERR, "%s", err.Error())
+	var dataPkts [][]byte
+	for _, hdrIp := range hdrsIp {
+		data, err := hdrsSerialize(hdrEth, encap, hdrIp, pktlenWire)
+		if err != nil {
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceCBR: %s", err.Error())
+		}
+		dataPkts = append(dataPkts, data)
 	}
 
 	// accumulated inter-packet clock cycle rounding error
 	accCyclesInterPacketRoundErr := 0.0
 
-	// accumulated number of clock cycles between packets
-	accCyclesInterPacket := uint64(0)
-
-	// create data structures for packet and meta data
-	data := make([][]byte, nPkts)
-	lensWire := make([]int, nPkts)
-	lensCapture := make([]int, nPkts)
-	cyclesInterPacket := make([]int, nPkts)
+	// assemble the trace
+	builder := tracegen.traceBuilderCreate()
 
 	for i := 0; i < nPkts; i++ {
-		// store packet data
-		data[i] = bufPkt.Bytes()
-
-		// all packets have the same length
-		lensWire[i] = pktlenWire
-		lensCapture[i] = pktlenCapture
+		// all packets have the same data (except for the ip version in mixed
+		// mode) and length
+		pkt := gofluent10g.TracePacket{
+			Data:    dataPkts[i%len(dataPkts)],
+			Wirelen: pktlenWire,
+			Caplen:  pktlenCapture,
+		}
 
 		// the average number of clock cycles between two packets is a
 		// floating-point number, but clock cycles must always be integer
@@ -142,38 +257,28 @@ func GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.
 		// cycle value.
 		if accCyclesInterPacketRoundErr < 1.0 {
 			// not enough rounding error accumulated yet -> round up
-			cyclesInterPacket[i] = int(math.Ceil(cyclesInterPacketMean))
+			pkt.CyclesInterPacket = int(math.Ceil(cyclesInterPacketMean))
 			accCyclesInterPacketRoundErr +=
 				math.Ceil(cyclesInterPacketMean) - cyclesInterPacketMean
 		} else {
 			// enough rounding error accumulated -> round down
-			cyclesInterPacket[i] = int(math.Floor(cyclesInterPacketMean))
+			pkt.CyclesInterPacket = int(math.Floor(cyclesInterPacketMean))
 			accCyclesInterPacketRoundErr -=
 				cyclesInterPacketMean - math.Floor(cyclesInterPacketMean)
 		}
 
-		// accumulate clock cycles between packets
-		accCyclesInterPacket += uint64(cyclesInterPacket[i])
+		// add packet to trace
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
 	}
 
-	// calculate actual replay duration after rounding and print it
-	actualDuration :=
-		time.Duration(float64(accCyclesInterPacket)/gofluent10g.FREQ_SFP*1e9) *
-			time.Nanosecond
+	// print actual replay duration after rounding
 	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Actual trace duration: %s (Target was %s)",
-		actualDuration, duration)
-
-	// manually call garbage collector
-	runtime.GC()
-
-	// create trace buffer
-	bufTrace := bufTraceAssemble(data, lensWire, lensCapture, cyclesInterPacket)
-
-	// manually call garbage collector again
-	runtime.GC()
+		builder.GetDuration(), duration)
 
 	// create and return trace struct
-	return gofluent10g.TraceCreateFromData(bufTrace, nPkts, actualDuration, nRepeats)
+	return traceCreate(builder, nRepeats, seed)
 }
 
 // GenTraceRandom generates random traffic with a mean data rate specified by
@@ -188,17 +293,101 @@ func GenTraceCBR(datarate float64, pktlenWire, pktlenCapture int, duration time.
 // trace. The parameter nRepeats determins how often the generated trace shall
 // be replayed. Data is only generated for the first replay, the generator
 // wraps around for all further replays.
-func GenTraceRandom(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int) *gofluent10g.Trace {
+// The seed parameter initializes the random number generator, the same seed
+// and parameters always result in identical trace data. If seed is zero, a
+// random seed is selected. The seed is recorded in the trace (see
+// Trace.GetSeed()).
+func GenTraceRandom(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceRandom(datarateMean, pktlenCaptureMax,
+		duration, nRepeats, seed)
+}
+
+// GenTraceRandom generates a trace like the package function GenTraceRandom(),
+// but for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceRandom(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceRandomEncap(nil, IPVersion4, datarateMean,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceRandomIPv6 generates random traffic like GenTraceRandom, but
+// generates Ethernet and IPv6 headers. The payload length field of the IPv6
+// header is set according to the packet length.
+func GenTraceRandomIPv6(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceRandomIPv6(datarateMean, pktlenCaptureMax,
+		duration, nRepeats, seed)
+}
+
+// GenTraceRandomIPv6 generates a trace like the package function
+// GenTraceRandomIPv6(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceRandomIPv6(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceRandomEncap(nil, IPVersion6, datarateMean,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceRandomMixed generates random traffic like GenTraceRandom, but each
+// packet is randomly selected to be an IPv4 or IPv6 packet with equal
+// probability.
+func GenTraceRandomMixed(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceRandomMixed(datarateMean, pktlenCaptureMax,
+		duration, nRepeats, seed)
+}
+
+// GenTraceRandomMixed generates a trace like the package function
+// GenTraceRandomMixed(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceRandomMixed(datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceRandomEncap(nil, IPVersionMixed, datarateMean,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceRandomEncap generates random traffic like GenTraceRandom. The encap
+// parameter specifies the encapsulation headers that are inserted between the
+// Ethernet and the IP header (may be nil). If the headers do not fit into a
+// 64 byte frame, the minimum packet length is increased accordingly. The
+// ipVersion parameter selects whether IPv4 (IPVersion4), IPv6 (IPVersion6) or
+// randomly IPv4 and IPv6 packets (IPVersionMixed) are generated.
+func GenTraceRandomEncap(encap EncapStack, ipVersion int, datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceRandomEncap(encap, ipVersion, datarateMean,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceRandomEncap generates a trace like the package function
+// GenTraceRandomEncap(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceRandomEncap(encap EncapStack, ipVersion int, datarateMean float64, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
 	// packet length is uniformly distributed between 64 and 1518 bytes. Since
 	// MAC will append FCS, the packets we generate here are 4 bytes shorter
 	pktlenMin := 60
 	pktlenMax := 1514
+
+	// check encapsulation headers
+	if err := encap.check(); err != nil {
+		return nil, err
+	}
+
+	// packets must be large enough to hold all headers
+	if pktlenHdrs := 14 + encap.getLen() + 40; pktlenHdrs > pktlenMin {
+		pktlenMin = pktlenHdrs
+	}
+
 	pktlenMean := (pktlenMin + pktlenMax) / 2
 
 	// calculate the average time of the gap between two packets (add 24 bytes
 	// for FCS, preamble, SOD and inter-frame gap
 	tGapMean := float64(8*(pktlenMean+24))/datarateMean -
-		float64(8*(pktlenMean+24))/10e9
+		float64(8*(pktlenMean+24))/tracegen.lineRate
 
 	// calculate the number of packets we will generate. add 24 bytes to the
 	// packet length to account for Ethernet preamble + SOD, inter-frame gap
@@ -219,49 +408,38 @@ func GenTraceRandom(datarateMean float64, pktlenCaptureMax int, duration time.Du
 		EthernetType: layers.EthernetTypeIPv4,
 	}
 
-	// generate random source and destination ipv4 address, which will be
-	// reused for all packets
-	ipSrc := make([]byte, 4)
-	ipDst := make([]byte, 4)
-	rand.Read(ipSrc)
-	rand.Read(ipDst)
-
-	// create ip header
-	hdrIp := &layers.IPv4{
-		Version: 4,
-		IHL:     5,
-		SrcIP:   ipSrc,
-		DstIP:   ipDst,
+	// create ip headers with random source and destination addresses, which
+	// will be reused for all packets
+	hdrsIp, err := hdrsIpCreate(rng, ipVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	// accumulated inter-packet clock cycle rounding error
 	accCyclesInterPacketRoundErr := 0.0
 
-	// accumulated number of clock cycles between packets
-	accCyclesInterPacket := uint64(0)
-
-	// create data structures for packet and meta data
-	data := make([][]byte, nPkts)
-	lensWire := make([]int, nPkts)
-	lensCapture := make([]int, nPkts)
-	cyclesInterPacket := make([]int, nPkts)
+	// assemble the trace
+	builder := tracegen.traceBuilderCreate()
 
 	for i := 0; i < nPkts; i++ {
+		var pkt gofluent10g.TracePacket
+
 		// determine packet length according to uniform distribution
-		lensWire[i] = rand.Intn(pktlenMax-pktlenMin+1) + pktlenMin
+		pkt.Wirelen = rng.Intn(pktlenMax-pktlenMin+1) + pktlenMin
 
 		// set capture length
-		if lensWire[i] < pktlenCaptureMax {
-			lensCapture[i] = lensWire[i]
+		if pkt.Wirelen < pktlenCaptureMax {
+			pkt.Caplen = pkt.Wirelen
 		} else {
-			lensCapture[i] = pktlenCaptureMax
+			pkt.Caplen = pktlenCaptureMax
 		}
 
 		// calculate the number of cycles it takes to transmit the packet
-		cyclesTransfer := gofluent10g.FREQ_SFP * float64(8*(lensWire[i]+24)) / 10e9
+		cyclesTransfer := tracegen.freqClock * float64(8*(pkt.Wirelen+24)) /
+			tracegen.lineRate
 
 		// random gap between packets
-		cyclesGap := gofluent10g.FREQ_SFP * tGapMean * rand.ExpFloat64()
+		cyclesGap := tracegen.freqClock * tGapMean * rng.ExpFloat64()
 
 		// add both cycle numbers up
 		cyclesTotal := cyclesTransfer + cyclesGap
@@ -283,118 +461,145 @@ func GenTraceRandom(datarateMean float64, pktlenCaptureMax int, duration time.Du
 		// cycle value.
 		if accCyclesInterPacketRoundErr < 1.0 {
 			// not enough rounding error accumulated yet -> round up
-			cyclesInterPacket[i] = int(math.Ceil(cyclesTotal))
+			pkt.CyclesInterPacket = int(math.Ceil(cyclesTotal))
 			accCyclesInterPacketRoundErr +=
 				math.Ceil(cyclesTotal) - cyclesTotal
 		} else {
 			// enough rounding error accumulated -> round down
-			cyclesInterPacket[i] = int(math.Floor(cyclesTotal))
+			pkt.CyclesInterPacket = int(math.Floor(cyclesTotal))
 			accCyclesInterPacketRoundErr -=
 				cyclesTotal - math.Floor(cyclesTotal)
 		}
 
-		// accumulate clock cycles between packets
-		accCyclesInterPacket += uint64(cyclesInterPacket[i])
-
-		// set IPv4 packet length in header (substract 14 bytes for ethernet
-		// header, i.e. mac addresses and ethertype)
-		hdrIp.Length = uint16(lensWire[i] - 14)
+		// select ip header. In mixed mode, ip version is selected randomly
+		hdrIp := hdrsIp[0]
+		if len(hdrsIp) > 1 {
+			hdrIp = hdrsIp[rng.Intn(len(hdrsIp))]
+		}
 
 		// serialize packet data
-		bufPkt := gopacket.NewSerializeBuffer()
-		err := gopacket.SerializeLayers(bufPkt, gopacket.SerializeOptions{},
-			hdrEth, hdrIp)
+		pkt.Data, err = hdrsSerialize(hdrEth, encap, hdrIp, pkt.Wirelen)
 		if err != nil {
-			gofluent10g.Log(gofluent10g.LOG_ERR, "%s", err.Error())
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceRandom: %s", err.Error())
 		}
 
-		// add packet to list
-		data[i] = bufPkt.Bytes()
+		// add packet to trace
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
 	}
 
-	// calculate actual replay duration after rounding and print it
-	actualDuration :=
-		time.Duration(float64(accCyclesInterPacket)/gofluent10g.FREQ_SFP*1e9) *
-			time.Nanosecond
+	// print actual replay duration after rounding
 	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Actual trace duration: %s (Target was %s)",
-		actualDuration, duration)
-
-	// manually call garbage collector
-	runtime.GC()
-
-	// create trace buffer
-	bufTrace := bufTraceAssemble(data, lensWire, lensCapture, cyclesInterPacket)
-
-	// manually call garbage collector again
-	runtime.GC()
+		builder.GetDuration(), duration)
 
 	// create and return trace
-	return gofluent10g.TraceCreateFromData(bufTrace, nPkts, actualDuration, nRepeats)
+	return traceCreate(builder, nRepeats, seed)
 }
 
-// bufTraceAssemble creates the content of a trace file that can be replayed
-// by a generator of the network tester. It expects the packet data. Also, for
-// each packet the packet wire and capture length, as well as the inter-packet
-// time in clock cycles must be specified.
-func bufTraceAssemble(data [][]byte, lensWire, lensCapture, cyclesInterPacket []int) []byte {
-	// calculate total amount of trace data we need to write to the hardware
-	bufTraceSize := 8 * int64(len(data)) // 8 byte meta information per packet
-
-	for i := 0; i < len(data); i++ {
-		// capture data aligned to 8 byte
-		if lensCapture[i]%8 == 0 {
-			bufTraceSize += int64(lensCapture[i])
-		} else {
-			bufTraceSize += int64(8 * (lensCapture[i]/8 + 1))
-		}
-	}
+// hdrsIpCreate creates the ip headers for the specified ip version with random
+// source and destination addresses. For IPVersionMixed an IPv4 and an IPv6
+// header are returned.
+func hdrsIpCreate(rng *rand.Rand, ipVersion int) ([]gopacket.SerializableLayer, error) {
+	var hdrs []gopacket.SerializableLayer
 
-	// allign to 32 byte
-	if bufTraceSize%64 != 0 {
-		bufTraceSize = 64 * (bufTraceSize/64 + 1)
+	if ipVersion != IPVersion4 && ipVersion != IPVersion6 &&
+		ipVersion != IPVersionMixed {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"invalid ip version")
 	}
 
-	// allocate trace buffer memory
-	bufTrace := make([]byte, bufTraceSize)
+	if ipVersion == IPVersion4 || ipVersion == IPVersionMixed {
+		// generate random source and destination ipv4 addresses
+		ipSrc := make([]byte, net.IPv4len)
+		ipDst := make([]byte, net.IPv4len)
+		rng.Read(ipSrc)
+		rng.Read(ipDst)
+
+		hdrs = append(hdrs, &layers.IPv4{
+			Version: 4,
+			IHL:     5,
+			SrcIP:   ipSrc,
+			DstIP:   ipDst,
+		})
+	}
 
-	// initialize buffer write address
-	bufTraceAddr := int64(0)
+	if ipVersion == IPVersion6 || ipVersion == IPVersionMixed {
+		// generate random source and destination ipv6 addresses
+		ipSrc := make([]byte, net.IPv6len)
+		ipDst := make([]byte, net.IPv6len)
+		rng.Read(ipSrc)
+		rng.Read(ipDst)
+
+		// payload bits are zero, so there is no next header
+		hdrs = append(hdrs, &layers.IPv6{
+			Version:    6,
+			NextHeader: layers.IPProtocolNoNextHeader,
+			HopLimit:   64,
+			SrcIP:      ipSrc,
+			DstIP:      ipDst,
+		})
+	}
 
-	for i := 0; i < len(data); i++ {
-		// assemble meta data
-		meta := uint64(cyclesInterPacket[i])
-		meta |= uint64(lensCapture[i]) << 32
-		meta |= uint64(lensWire[i]) << 48
+	return hdrs, nil
+}
 
-		// write meta data word
-		binary.LittleEndian.PutUint64(bufTrace[bufTraceAddr:bufTraceAddr+8],
-			meta)
-		bufTraceAddr += 8
+// hdrsSerialize serializes the ethernet, encapsulation and ip headers of a
+// packet with the specified length (excluding FCS). The length fields of the
+// ip headers (and of the udp header of VXLAN tunnels), the checksums of the
+// outer ipv4 headers of tunnels and the ethertype/protocol fields are set
+// accordingly.
+func hdrsSerialize(hdrEth *layers.Ethernet, encap EncapStack, hdrIp gopacket.SerializableLayer, pktlen int) ([]byte, error) {
+	ethType := layers.EthernetTypeIPv4
+	if _, ok := hdrIp.(*layers.IPv6); ok {
+		ethType = layers.EthernetTypeIPv6
+	}
 
-		// write packet data
-		if len(data[i]) > lensCapture[i] {
-			copy(bufTrace[bufTraceAddr:bufTraceAddr+int64(lensCapture[i])],
-				data[i][0:lensCapture[i]])
-		} else {
-			copy(bufTrace[bufTraceAddr:bufTraceAddr+int64(len(data[i]))],
-				data[i])
+	hdrs, hdrsIpTunnel := encap.headers(hdrEth, ethType)
+	hdrs = append(hdrs, hdrIp)
+
+	// set length fields. Lengths are calculated from the offset of the
+	// header within the packet
+	offset := 0
+	offsetsIpTunnel := make([]int, 0, len(hdrsIpTunnel))
+	for _, hdr := range hdrs {
+		if pktlen < offset+hdrLen(hdr) {
+			return nil, errors.New("packet length too small to hold all " +
+				"headers")
 		}
 
-		if lensCapture[i]%8 == 0 {
-			bufTraceAddr += int64(lensCapture[i])
-		} else {
-			bufTraceAddr += int64(8 * (lensCapture[i]/8 + 1))
+		switch h := hdr.(type) {
+		case *layers.IPv4:
+			h.Length = uint16(pktlen - offset)
+			for _, hdrIpTunnel := range hdrsIpTunnel {
+				if h == hdrIpTunnel {
+					offsetsIpTunnel = append(offsetsIpTunnel, offset)
+				}
+			}
+		case *layers.IPv6:
+			// ipv6 payload length does not include the 40 byte ipv6 header
+			h.Length = uint16(pktlen - offset - 40)
+		case *layers.UDP:
+			h.Length = uint16(pktlen - offset)
 		}
+		offset += hdrLen(hdr)
+	}
+
+	bufPkt := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(bufPkt, gopacket.SerializeOptions{},
+		hdrs...)
+	if err != nil {
+		return nil, err
 	}
+	data := bufPkt.Bytes()
 
-	// add padding for 32 byte alignment
-	for bufTraceAddr%64 != 0 {
-		binary.LittleEndian.PutUint64(bufTrace[bufTraceAddr:bufTraceAddr+8],
-			0xFFFFFFFFFFFFFFFF)
-		bufTraceAddr += 8
+	// tunnel endpoints drop packets with invalid outer ipv4 checksums
+	for _, offset := range offsetsIpTunnel {
+		ipv4ChecksumSet(data[offset:])
 	}
 
-	return bufTrace
+	return data, nil
 }
 
 // round rounds a floating-point number to the next integer value
@@ -406,3 +611,37 @@ func round(x float64) int {
 func ceil(x float64) int {
 	return int(math.Ceil(x))
 }
+
+// rngCreate creates a random number generator initialized with the specified
+// seed. If the seed is zero, a random seed is selected. The function returns
+// the random number generator and the seed.
+func rngCreate(seed int64) (*rand.Rand, int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Random number generator seed: %d",
+		seed)
+
+	return rand.New(rand.NewSource(seed)), seed
+}
+
+// traceBuilderCreate creates a trace builder for the clock frequency and line
+// rate of the trace generator.
+func (tracegen *TraceGenerator) traceBuilderCreate() *gofluent10g.TraceBuilder {
+	// clock frequency and line rate have been checked by TraceGeneratorCreate()
+	builder, _ := gofluent10g.TraceBuilderCreateWithClock(tracegen.freqClock,
+		tracegen.lineRate)
+	return builder
+}
+
+// traceCreate creates the trace from the packets assembled by the builder and
+// records the seed of the random number generator used to generate it.
+func traceCreate(builder *gofluent10g.TraceBuilder, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	trace, err := builder.GetTrace(nRepeats)
+	if err != nil {
+		return nil, err
+	}
+	trace.SetSeed(seed)
+	return trace, nil
+}
@@ -0,0 +1,248 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the synthetic trace generation functions.
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testGenFuncs contains the trace generation functions that are checked for
+// reproducibility.
+var testGenFuncs = map[string]func(seed int64) (*gofluent10g.Trace, error){
+	"CBR": func(seed int64) (*gofluent10g.Trace, error) {
+		return GenTraceCBR(5e9, 512, 64, 100*time.Microsecond, 1, seed)
+	},
+	"Random": func(seed int64) (*gofluent10g.Trace, error) {
+		return GenTraceRandom(5e9, 64, 100*time.Microsecond, 1, seed)
+	},
+	"IMIX": func(seed int64) (*gofluent10g.Trace, error) {
+		return GenTraceIMIX(5e9, IMIXSimple, 64, 100*time.Microsecond, 1,
+			seed)
+	},
+}
+
+// TestGenTraceSeed checks that trace generation with the same seed results in
+// identical trace data, while different seeds result in different data.
+func TestGenTraceSeed(t *testing.T) {
+	for name, gen := range testGenFuncs {
+		traceA, err := gen(42)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		traceB, err := gen(42)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		traceC, err := gen(43)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if bytes.Equal(traceA.GetData(), traceB.GetData()) == false {
+			t.Fatalf("%s: same seed results in different trace data", name)
+		}
+		if bytes.Equal(traceA.GetData(), traceC.GetData()) {
+			t.Fatalf("%s: different seeds result in identical trace data",
+				name)
+		}
+		if seed, ok := traceA.GetSeed(); ok == false || seed != 42 {
+			t.Fatalf("%s: seed has not been recorded", name)
+		}
+
+		// a random seed is selected and recorded if the seed is zero
+		traceD, err := gen(0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		seed, ok := traceD.GetSeed()
+		if ok == false || seed == 0 {
+			t.Fatalf("%s: random seed has not been recorded", name)
+		}
+		traceE, err := gen(seed)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if bytes.Equal(traceD.GetData(), traceE.GetData()) == false {
+			t.Fatalf("%s: recorded seed does not reproduce the trace", name)
+		}
+	}
+}
+
+// testIPVersion decodes the packet and returns its ip version. The function
+// fails if the length field of the ip header does not match the wire length
+// of the packet.
+func testIPVersion(t *testing.T, i int, pkt gofluent10g.TracePacket) int {
+	p := gopacket.NewPacket(pkt.Data, layers.LayerTypeEthernet,
+		gopacket.Default)
+	if ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		if int(ip.Length) != pkt.Wirelen-14 {
+			t.Fatalf("packet %d: IPv4 length %d, wire length %d", i,
+				ip.Length, pkt.Wirelen)
+		}
+		return 4
+	}
+	if ip, ok := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		if int(ip.Length) != pkt.Wirelen-14-40 {
+			t.Fatalf("packet %d: IPv6 payload length %d, wire length %d", i,
+				ip.Length, pkt.Wirelen)
+		}
+		return 6
+	}
+	t.Fatalf("packet %d: no ip header", i)
+	return 0
+}
+
+// TestGenTraceIPVersion generates constant bit rate and random traces for all
+// ip versions and checks the ip headers of the packets.
+func TestGenTraceIPVersion(t *testing.T) {
+	gens := []struct {
+		name      string
+		ipVersion int
+		gen       func() (*gofluent10g.Trace, error)
+	}{
+		{"CBRIPv6", IPVersion6, func() (*gofluent10g.Trace, error) {
+			return GenTraceCBRIPv6(5e9, 128, 64, 100*time.Microsecond, 1, 42)
+		}},
+		{"CBRMixed", IPVersionMixed, func() (*gofluent10g.Trace, error) {
+			return GenTraceCBRMixed(5e9, 128, 64, 100*time.Microsecond, 1, 42)
+		}},
+		{"RandomIPv6", IPVersion6, func() (*gofluent10g.Trace, error) {
+			return GenTraceRandomIPv6(5e9, 1514, 100*time.Microsecond, 1, 42)
+		}},
+		{"RandomMixed", IPVersionMixed, func() (*gofluent10g.Trace, error) {
+			return GenTraceRandomMixed(5e9, 1514, 100*time.Microsecond, 1,
+				42)
+		}},
+	}
+
+	for _, gen := range gens {
+		trace, err := gen.gen()
+		if err != nil {
+			t.Fatalf("%s: %v", gen.name, err)
+		}
+
+		count := make(map[int]int)
+		versionPrev := 0
+		n := 0
+		it := trace.Packets()
+		for ; it.Next(); n++ {
+			version := testIPVersion(t, n, it.Packet())
+			count[version]++
+
+			if gen.ipVersion == IPVersion6 && version != 6 {
+				t.Fatalf("%s: packet %d is not an IPv6 packet", gen.name, n)
+			}
+
+			// constant bit rate traces alternate between the ip versions
+			if gen.name == "CBRMixed" && version == versionPrev {
+				t.Fatalf("%s: packet %d has the same ip version as the "+
+					"previous packet", gen.name, n)
+			}
+			versionPrev = version
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatalf("%s: trace does not contain any packets", gen.name)
+		}
+		// in mixed mode, random traces select the ip version randomly for
+		// each packet
+		if gen.ipVersion == IPVersionMixed &&
+			(count[4] < n/4 || count[6] < n/4) {
+			t.Fatalf("%s: %d IPv4 and %d IPv6 packets", gen.name, count[4],
+				count[6])
+		}
+	}
+}
+
+// TestTraceGenerator generates traces for a board with a different clock
+// frequency and line rate concurrently with traces for the NetFPGA-SUME and
+// checks their durations and the data rate limits.
+func TestTraceGenerator(t *testing.T) {
+	board := gofluent10g.BoardNetFPGASUME
+	board.FreqTrace = 390.625e6
+	board.LineRate = 25e9
+	tracegen, err := TraceGeneratorCreateWithBoard(board)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duration := 100 * time.Microsecond
+	tracegens := []*TraceGenerator{traceGenDefault, tracegen}
+	traces := make([]*gofluent10g.Trace, len(tracegens))
+	errs := make([]error, len(tracegens))
+	var wg sync.WaitGroup
+	for i := range tracegens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			traces[i], errs[i] = tracegens[i].GenTraceCBR(5e9, 512, 508,
+				duration, 1, 42)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, trace := range traces {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		d, err := trace.GetDuration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(d.Seconds()-duration.Seconds()) > 0.01*duration.Seconds() {
+			t.Fatalf("trace %d: duration %s, expected %s", i, d, duration)
+		}
+	}
+
+	// data rates above 10 Gbps are only valid for the 25G board
+	_, err = GenTraceIMIX(20e9, IMIXSimple, 64, duration, 1, 42)
+	if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid config error, got: %v", err)
+	}
+	_, err = tracegen.GenTraceIMIX(20e9, IMIXSimple, 64, duration, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = TraceGeneratorCreate(0, 25e9)
+	if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid config error, got: %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements the generation of bursty traffic: on/off bursts, Markov-modulated
+// Poisson processes and Pareto on/off sources.
+
+package utils
+
+import (
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket/layers"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"time"
+)
+
+// length of the time window used to determine the peak data rate of the
+// generated traces
+const windowDataratePeak = 10 * time.Microsecond
+
+// MMPPState is a state of a Markov-modulated Poisson process.
+type MMPPState struct {
+	// mean data rate (bits per second, including Ethernet preamble, SOD, FCS
+	// and inter-frame gap) of the poisson process while in this state. May be
+	// zero for idle states
+	Datarate float64
+
+	// mean time the process stays in the state (exponentially distributed)
+	MeanDuration time.Duration
+
+	// probabilities of switching to each state when leaving the state. If
+	// nil, all other states are selected with equal probability
+	Next []float64
+}
+
+// GenTraceOnOff generates on/off traffic with constant packet lengths. In each
+// burst, burstLen packets are sent with the data rate datarateBurst (bits per
+// second, including Ethernet preamble, SOD, FCS and inter-frame gap). Bursts
+// are separated by the idle time tIdle. pktlenWire and pktlenCapture define
+// the length of the packets and the number of data bytes written to the
+// hardware (see GenTraceCBR). The duration parameter specifies the total
+// duration of the generated trace. The parameter nRepeats determins how often
+// the generated trace shall be replayed. The seed parameter initializes the
+// random number generator used to select the ip addresses of the packets. If
+// seed is zero, a random seed is selected. The mean and peak data rates of the
+// generated trace are logged, they can also be determined with
+// Trace.GetDatarate() and Trace.GetPeakDatarate().
+func GenTraceOnOff(datarateBurst float64, pktlenWire, pktlenCapture int, burstLen int, tIdle time.Duration, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceOnOff(datarateBurst, pktlenWire,
+		pktlenCapture, burstLen, tIdle, duration, nRepeats, seed)
+}
+
+// GenTraceOnOff generates a trace like the package function GenTraceOnOff(),
+// but for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceOnOff(datarateBurst float64, pktlenWire, pktlenCapture int, burstLen int, tIdle time.Duration, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	if datarateBurst <= 0 || burstLen <= 0 || tIdle < 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceOnOff: invalid burst configuration")
+	}
+
+	// MAC will append FCS, so substract 4 bytes from wire length
+	pktlenWire -= 4
+
+	// time between two packets within a burst (add 24 bytes for Ethernet
+	// preamble + SOD, inter-frame gap and FCS)
+	tInterPacket := float64(8*(pktlenWire+24)) / datarateBurst
+
+	// determine send times of the packets
+	var tSend []float64
+	t := 0.0
+	for t < duration.Seconds() {
+		for i := 0; i < burstLen && t < duration.Seconds(); i++ {
+			tSend = append(tSend, t)
+			t += tInterPacket
+		}
+		t += tIdle.Seconds()
+	}
+
+	// check if capture length is valid
+	if pktlenCapture > pktlenWire {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceOnOff: invalid capture length")
+	}
+
+	// all packets have the same length
+	pktlens := make([]int, len(tSend))
+	for i := range pktlens {
+		pktlens[i] = pktlenWire
+	}
+
+	return tracegen.genTraceSendTimes("GenTraceOnOff", tSend, pktlens,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceMMPP generates traffic with constant packet lengths following a
+// Markov-modulated Poisson process. The process starts in the first of the
+// provided states. While in a state, packets arrive according to a poisson
+// process with the state's mean data rate. The time the process stays in a
+// state is exponentially distributed. If packets arrive faster than they can
+// be transmitted at line rate, they are queued. pktlenWire and
+// pktlenCapture define the length of the packets and the number of data bytes
+// written to the hardware (see GenTraceCBR). The duration parameter specifies
+// the total duration of the generated trace. The parameter nRepeats determins
+// how often the generated trace shall be replayed. The seed parameter
+// initializes the random number generator, the same seed and parameters always
+// result in identical trace data. If seed is zero, a random seed is selected.
+// The mean and peak data rates of the generated trace are logged, they can
+// also be determined with Trace.GetDatarate() and Trace.GetPeakDatarate().
+func GenTraceMMPP(states []MMPPState, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceMMPP(states, pktlenWire, pktlenCapture,
+		duration, nRepeats, seed)
+}
+
+// GenTraceMMPP generates a trace like the package function GenTraceMMPP(), but
+// for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceMMPP(states []MMPPState, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// check states
+	if len(states) == 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceMMPP: no states specified")
+	}
+	for i, state := range states {
+		if state.Datarate < 0 || state.MeanDuration <= 0 ||
+			(state.Next != nil && len(state.Next) != len(states)) {
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceMMPP: invalid state %d", i)
+		}
+	}
+
+	// create random number generator. The seed is passed on to the trace
+	// creation below, which uses its own random number generator for the ip
+	// addresses
+	rng, seed := rngCreate(seed)
+
+	// MAC will append FCS, so substract 4 bytes from wire length
+	pktlenWire -= 4
+
+	// determine send times of the packets
+	var tSend []float64
+	t := 0.0
+	iState := 0
+	for t < duration.Seconds() {
+		state := states[iState]
+
+		// time when the process leaves the state
+		tStateEnd := math.Min(t+state.MeanDuration.Seconds()*rng.ExpFloat64(),
+			duration.Seconds())
+
+		// generate packet arrivals (add 24 bytes for Ethernet preamble + SOD,
+		// inter-frame gap and FCS)
+		if state.Datarate > 0 {
+			tInterPacketMean := float64(8*(pktlenWire+24)) / state.Datarate
+			for {
+				t += tInterPacketMean * rng.ExpFloat64()
+				if t >= tStateEnd {
+					break
+				}
+				tSend = append(tSend, t)
+			}
+		}
+		t = tStateEnd
+
+		// select next state
+		iState = mmppStateNext(rng, states, iState)
+	}
+
+	// check if capture length is valid
+	if pktlenCapture > pktlenWire {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceMMPP: invalid capture length")
+	}
+
+	// all packets have the same length
+	pktlens := make([]int, len(tSend))
+	for i := range pktlens {
+		pktlens[i] = pktlenWire
+	}
+
+	return tracegen.genTraceSendTimes("GenTraceMMPP", tSend, pktlens,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceParetoOnOff generates self-similar traffic with constant packet
+// lengths by aggregating nSources on/off sources. The durations of on and off
+// periods of each source follow pareto distributions with the means meanOn and
+// meanOff and the specified shape. Shapes between 1 and 2 result in
+// heavy-tailed period durations and self-similar traffic. During an on period,
+// a source sends packets with the data rate datarateOn (bits per second,
+// including Ethernet preamble, SOD, FCS and inter-frame gap). If the sources
+// together send faster than the line rate, packets are queued.
+// pktlenWire and pktlenCapture define the length of the packets and the number
+// of data bytes written to the hardware (see GenTraceCBR). The duration
+// parameter specifies the total duration of the generated trace. The parameter
+// nRepeats determins how often the generated trace shall be replayed. The seed
+// parameter initializes the random number generator, the same seed and
+// parameters always result in identical trace data. If seed is zero, a random
+// seed is selected. The mean and peak data rates of the generated trace are
+// logged, they can also be determined with Trace.GetDatarate() and
+// Trace.GetPeakDatarate().
+func GenTraceParetoOnOff(nSources int, datarateOn float64, meanOn, meanOff time.Duration, shape float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceParetoOnOff(nSources, datarateOn, meanOn,
+		meanOff, shape, pktlenWire, pktlenCapture, duration, nRepeats, seed)
+}
+
+// GenTraceParetoOnOff generates a trace like the package function
+// GenTraceParetoOnOff(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceParetoOnOff(nSources int, datarateOn float64, meanOn, meanOff time.Duration, shape float64, pktlenWire, pktlenCapture int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	if nSources <= 0 || datarateOn <= 0 || meanOn <= 0 || meanOff < 0 ||
+		shape <= 1 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceParetoOnOff: invalid source configuration")
+	}
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
+	// MAC will append FCS, so substract 4 bytes from wire length
+	pktlenWire -= 4
+
+	// time between two packets of a source during an on period (add 24 bytes
+	// for Ethernet preamble + SOD, inter-frame gap and FCS)
+	tInterPacket := float64(8*(pktlenWire+24)) / datarateOn
+
+	// probability of a source being in an on period
+	pOn := meanOn.Seconds() / (meanOn.Seconds() + meanOff.Seconds())
+
+	// determine send times of the packets of all sources
+	var tSend []float64
+	for i := 0; i < nSources; i++ {
+		// sources start in a random period at a random offset
+		on := rng.Float64() < pOn
+		t := 0.0
+		if on {
+			t -= paretoRand(rng, meanOn.Seconds(), shape) * rng.Float64()
+		} else if meanOff > 0 {
+			t -= paretoRand(rng, meanOff.Seconds(), shape) * rng.Float64()
+		}
+
+		for t < duration.Seconds() {
+			if on {
+				tEnd := t + paretoRand(rng, meanOn.Seconds(), shape)
+				for ; t < tEnd && t < duration.Seconds(); t += tInterPacket {
+					if t >= 0 {
+						tSend = append(tSend, t)
+					}
+				}
+				t = tEnd
+			} else if meanOff > 0 {
+				t += paretoRand(rng, meanOff.Seconds(), shape)
+			}
+			on = !on
+		}
+	}
+
+	// sort send times of all sources
+	sort.Float64s(tSend)
+
+	// check if capture length is valid
+	if pktlenCapture > pktlenWire {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceParetoOnOff: invalid capture length")
+	}
+
+	// all packets have the same length
+	pktlens := make([]int, len(tSend))
+	for i := range pktlens {
+		pktlens[i] = pktlenWire
+	}
+
+	return tracegen.genTraceSendTimes("GenTraceParetoOnOff", tSend, pktlens,
+		pktlenCapture, duration, nRepeats, seed)
+}
+
+// genTraceSendTimes generates a trace with packets sent at the specified times
+// (in seconds, ascending order). pktlens contains the length of each packet
+// excluding FCS, pktlenCaptureMax the maximum number of data bytes that are
+// written to the hardware. Packets that would be sent before the transmission
+// of the previous packet has been completed at line rate are delayed.
+// The name parameter is the name of the calling generator function used for
+// logging and error messages.
+func (tracegen *TraceGenerator) genTraceSendTimes(name string, tSend []float64, pktlens []int, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	if len(tSend) == 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"%s: no packets generated", name)
+	}
+
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Generating %d packets", len(tSend))
+
+	// create random number generator for the ip addresses
+	rng, seed := rngCreate(seed)
+
+	// we will reuse the same ethernet and ip headers for all packets
+	macSrc, _ := net.ParseMAC("53:00:00:00:00:01")
+	macDst, _ := net.ParseMAC("53:00:00:00:00:02")
+	hdrEth := &layers.Ethernet{
+		SrcMAC: macSrc,
+		DstMAC: macDst,
+	}
+	hdrsIp, err := hdrsIpCreate(rng, IPVersion4)
+	if err != nil {
+		return nil, err
+	}
+
+	// serialized headers for each packet length
+	pktsData := make(map[int][]byte)
+
+	// determine send times in clock cycles. Packets are delayed if the link
+	// is still busy transmitting the previous packet at line rate (add 24
+	// bytes for Ethernet preamble + SOD, inter-frame gap and FCS)
+	cyclesSend := make([]float64, len(tSend))
+	cyclesLinkFree := 0.0
+	for i := range tSend {
+		cyclesSend[i] = math.Max(tracegen.freqClock*tSend[i], cyclesLinkFree)
+		cyclesLinkFree = cyclesSend[i] +
+			tracegen.freqClock*float64(8*(pktlens[i]+24))/tracegen.lineRate
+	}
+
+	// the last packet is followed by a gap until the end of the trace
+	cyclesEnd := math.Max(tracegen.freqClock*duration.Seconds(),
+		cyclesLinkFree)
+
+	// assemble the trace
+	builder := tracegen.traceBuilderCreate()
+
+	for i := range cyclesSend {
+		// send times are rounded up to full clock cycles. Since the rounded
+		// send time never lies before the exact send time, packets are never
+		// sent too fast on average
+		cyclesNext := cyclesEnd
+		if i+1 < len(cyclesSend) {
+			cyclesNext = cyclesSend[i+1]
+		}
+		cyclesInterPacket := math.Ceil(cyclesNext) - math.Ceil(cyclesSend[i])
+
+		// hardware does not support inter-packet cycle numbers larger than
+		// 32 bit, so cut if necessary
+		if cyclesInterPacket > 4294967295 {
+			cyclesInterPacket = 4294967295
+		}
+
+		pkt := gofluent10g.TracePacket{
+			CyclesInterPacket: int(cyclesInterPacket),
+			Wirelen:           pktlens[i],
+		}
+
+		// set capture length
+		if pkt.Wirelen < pktlenCaptureMax {
+			pkt.Caplen = pkt.Wirelen
+		} else {
+			pkt.Caplen = pktlenCaptureMax
+		}
+
+		// serialize packet data
+		data, ok := pktsData[pkt.Wirelen]
+		if !ok {
+			data, err = hdrsSerialize(hdrEth, nil, hdrsIp[0], pkt.Wirelen)
+			if err != nil {
+				return nil, gofluent10g.ErrorCreate(
+					gofluent10g.ErrInvalidConfig, "%s: %s", name,
+					err.Error())
+			}
+			pktsData[pkt.Wirelen] = data
+		}
+		pkt.Data = data
+
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+
+	// print actual replay duration after rounding
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Actual trace duration: %s (Target was %s)",
+		builder.GetDuration(), duration)
+
+	trace, err := traceCreate(builder, nRepeats, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// print realized mean and peak data rates
+	datarateMean, err := trace.GetDatarate()
+	if err != nil {
+		return nil, err
+	}
+	dataratePeak, err := trace.GetPeakDatarate(windowDataratePeak)
+	if err != nil {
+		return nil, err
+	}
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Mean data rate: %.3f Gbps, peak "+
+		"data rate: %.3f Gbps (%s window)", datarateMean/1e9,
+		dataratePeak/1e9, windowDataratePeak)
+
+	return trace, nil
+}
+
+// mmppStateNext randomly selects the state the Markov-modulated Poisson process
+// switches to when leaving the current state.
+func mmppStateNext(rng *rand.Rand, states []MMPPState, iState int) int {
+	if states[iState].Next == nil {
+		// select one of the other states with equal probability
+		if len(states) == 1 {
+			return iState
+		}
+		iNext := rng.Intn(len(states) - 1)
+		if iNext >= iState {
+			iNext++
+		}
+		return iNext
+	}
+
+	// select state according to the transition probabilities
+	var sum float64
+	for _, p := range states[iState].Next {
+		sum += p
+	}
+	r := rng.Float64() * sum
+	for i, p := range states[iState].Next {
+		if r < p {
+			return i
+		}
+		r -= p
+	}
+	return iState
+}
+
+// paretoRand returns a random value following a pareto distribution with the
+// specified mean and shape (> 1).
+func paretoRand(rng *rand.Rand, mean, shape float64) float64 {
+	// scale parameter of the distribution is chosen such that the mean is
+	// reached
+	scale := mean * (shape - 1) / shape
+	return scale / math.Pow(1-rng.Float64(), 1/shape)
+}
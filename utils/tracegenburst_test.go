@@ -0,0 +1,192 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the generation of bursty traffic.
+
+package utils
+
+import (
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"math"
+	"testing"
+	"time"
+)
+
+// testCheckLineRate fails the test if a packet of the trace is sent before the
+// transmission of the previous packet has been completed.
+func testCheckLineRate(t *testing.T, name string, trace *gofluent10g.Trace) {
+	it := trace.Packets()
+	for n := 0; it.Next(); n++ {
+		pkt := it.Packet()
+		cyclesTransfer := gofluent10g.FREQ_SFP *
+			float64(8*(pkt.Wirelen+24)) / 10e9
+		if float64(pkt.CyclesInterPacket) < cyclesTransfer-1 {
+			t.Fatalf("%s: packet %d: inter-packet time %d below "+
+				"transmission time %f", name, n, pkt.CyclesInterPacket,
+				cyclesTransfer)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDatarate returns the mean and peak data rate of a trace.
+func testDatarate(t *testing.T, trace *gofluent10g.Trace, window time.Duration) (float64, float64) {
+	mean, err := trace.GetDatarate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peak, err := trace.GetPeakDatarate(window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mean, peak
+}
+
+// TestGenTraceOnOff generates on/off bursts and checks the burst pattern, as
+// well as the mean and peak data rates.
+func TestGenTraceOnOff(t *testing.T) {
+	// bursts of 10 packets at 8 Gbps take 10.24 us (1000 byte packets occupy
+	// 1024 bytes on the wire), followed by 10.24 us idle time
+	trace, err := GenTraceOnOff(8e9, 1004, 64, 10, 10240*time.Nanosecond,
+		time.Millisecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cycles []int
+	it := trace.Packets()
+	for it.Next() {
+		cycles = append(cycles, it.Packet().CyclesInterPacket)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// within a burst packets are sent every 1.024 us (160 clock cycles), the
+	// last packet of a burst is followed by the idle time. send times are
+	// rounded up to full clock cycles. the last packet of the trace is
+	// followed by a gap until the end of the trace
+	for n := 0; n < len(cycles)-1; n++ {
+		cyclesExp := 160
+		if n%10 == 9 {
+			cyclesExp = 1760
+		}
+		if cycles[n] < cyclesExp-1 || cycles[n] > cyclesExp+1 {
+			t.Fatalf("packet %d: inter-packet time %d, expected %d", n,
+				cycles[n], cyclesExp)
+		}
+	}
+
+	mean, peak := testDatarate(t, trace, 5*time.Microsecond)
+	if math.Abs(mean-4e9)/4e9 > 1e-2 {
+		t.Fatalf("mean data rate %f, expected 4e9", mean)
+	}
+	if math.Abs(peak-8e9)/8e9 > 0.25 {
+		t.Fatalf("peak data rate %f, expected 8e9", peak)
+	}
+}
+
+// TestGenTraceMMPP generates traffic of a Markov-modulated Poisson process
+// and checks the mean data rate.
+func TestGenTraceMMPP(t *testing.T) {
+	// single state: poisson process
+	states := []MMPPState{
+		{Datarate: 2e9, MeanDuration: time.Millisecond},
+	}
+	trace, err := GenTraceMMPP(states, 128, 64, 10*time.Millisecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckLineRate(t, "poisson", trace)
+	mean, _ := testDatarate(t, trace, 10*time.Microsecond)
+	if math.Abs(mean-2e9)/2e9 > 0.02 {
+		t.Fatalf("poisson: mean data rate %f, expected 2e9", mean)
+	}
+
+	// a busy and an idle state, the process spends on average twice as much
+	// time in the busy state
+	states = []MMPPState{
+		{Datarate: 6e9, MeanDuration: 20 * time.Microsecond},
+		{Datarate: 0, MeanDuration: 10 * time.Microsecond},
+	}
+	trace, err = GenTraceMMPP(states, 128, 64, 20*time.Millisecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckLineRate(t, "mmpp", trace)
+	mean, peak := testDatarate(t, trace, 10*time.Microsecond)
+	if math.Abs(mean-4e9)/4e9 > 0.05 {
+		t.Fatalf("mmpp: mean data rate %f, expected 4e9", mean)
+	}
+	if peak <= mean {
+		t.Fatalf("mmpp: peak data rate %f does not exceed mean data rate "+
+			"%f", peak, mean)
+	}
+
+	// invalid states
+	statesInvalid := [][]MMPPState{
+		nil,
+		{{Datarate: -1, MeanDuration: time.Millisecond}},
+		{{Datarate: 1e9, MeanDuration: 0}},
+		{{Datarate: 1e9, MeanDuration: time.Millisecond,
+			Next: []float64{0.5, 0.5}}},
+	}
+	for i, states := range statesInvalid {
+		_, err := GenTraceMMPP(states, 128, 64, time.Millisecond, 1, 42)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("states %d: expected invalid configuration error, got: "+
+				"%v", i, err)
+		}
+	}
+}
+
+// TestGenTraceParetoOnOff aggregates pareto on/off sources and checks that
+// the mean data rate approaches the expected rate and the line rate is not
+// exceeded.
+func TestGenTraceParetoOnOff(t *testing.T) {
+	// each of the 20 sources is active 25% of the time with 1 Gbps, i.e. 5
+	// Gbps on average. with heavy-tailed periods the rate converges slowly
+	trace, err := GenTraceParetoOnOff(20, 1e9, 10*time.Microsecond,
+		30*time.Microsecond, 1.5, 256, 64, 50*time.Millisecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckLineRate(t, "pareto", trace)
+	mean, _ := testDatarate(t, trace, 10*time.Microsecond)
+	if math.Abs(mean-5e9)/5e9 > 0.2 {
+		t.Fatalf("mean data rate %f, expected 5e9", mean)
+	}
+
+	if _, err := GenTraceParetoOnOff(20, 1e9, 10*time.Microsecond,
+		30*time.Microsecond, 1, 256, 64, time.Millisecond, 1,
+		42); errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements the encapsulation of generated packets (VLAN, QinQ, MPLS, VXLAN
+// and GRE).
+
+package utils
+
+import (
+	"encoding/binary"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+)
+
+// encapsulation header types
+const (
+	// IEEE 802.1Q VLAN tag
+	EncapVLAN int = 0
+
+	// IEEE 802.1ad service VLAN tag. Followed by an EncapVLAN header, it
+	// forms a QinQ double tag
+	EncapQinQ int = 1
+
+	// MPLS label. Consecutive MPLS headers form a label stack, the bottom of
+	// stack flag is set automatically
+	EncapMPLS int = 2
+
+	// VXLAN tunnel: outer IPv4, UDP (destination port 4789) and VXLAN headers,
+	// followed by the inner Ethernet header
+	EncapVXLAN int = 3
+
+	// GRE tunnel: outer IPv4 and GRE headers
+	EncapGRE int = 4
+)
+
+// EncapHeader defines an encapsulation header. Only the fields relevant for
+// the selected header type are evaluated.
+type EncapHeader struct {
+	Type int // header type (EncapVLAN, EncapQinQ, ...)
+
+	// VLAN ID and priority (EncapVLAN, EncapQinQ)
+	VLANID       uint16
+	VLANPriority uint8
+
+	// MPLS label and TTL (EncapMPLS). If the TTL is zero, it is set to 64
+	MPLSLabel uint32
+	MPLSTTL   uint8
+
+	// VXLAN network identifier (EncapVXLAN)
+	VNI uint32
+
+	// GRE key (EncapGRE). If zero, no key is included in the GRE header
+	GREKey uint32
+
+	// IPv4 source and destination addresses of the tunnel endpoints
+	// (EncapVXLAN, EncapGRE). If not set, 192.0.2.1 and 192.0.2.2 are used
+	TunnelSrc, TunnelDst net.IP
+}
+
+// EncapStack is a stack of encapsulation headers. The headers are inserted
+// between the Ethernet header and the IP header of the generated packets, the
+// first header of the stack directly follows the Ethernet header. An empty
+// stack results in plain Ethernet/IP packets.
+type EncapStack []EncapHeader
+
+// GetTimestampPos returns the byte position where the hardware shall insert
+// the latency timestamp in TimestampModeFixedPos (see
+// NetworkTester.SetTimestampPos()) for packets carrying the encapsulation
+// stack. The position is the first byte after the packet headers (for
+// IPVersionMixed after the larger IPv6 header) where a timestamp of the
+// specified width (16 or 24 bit) does not spread across two 8 byte data words.
+// The protocol parameter specifies the layer 4 header following the IP header
+// (layers.IPProtocolUDP or layers.IPProtocolTCP for packets generated by
+// GenTraceFlows, layers.IPProtocolNoNextHeader for all other generators).
+// Generated packets must be long enough to hold the timestamp.
+func (encap EncapStack) GetTimestampPos(ipVersion int, protocol layers.IPProtocol, width int) (_ int, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	if err := encap.check(); err != nil {
+		return 0, err
+	}
+
+	// ethernet and encapsulation headers
+	pos := 14 + encap.getLen()
+
+	// ip header
+	switch ipVersion {
+	case IPVersion4:
+		pos += 20
+	case IPVersion6, IPVersionMixed:
+		pos += 40
+	default:
+		return 0, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"EncapStack: invalid ip version")
+	}
+
+	// layer 4 header
+	switch protocol {
+	case layers.IPProtocolUDP:
+		pos += 8
+	case layers.IPProtocolTCP:
+		pos += 20
+	case layers.IPProtocolNoNextHeader:
+	default:
+		return 0, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"EncapStack: invalid protocol")
+	}
+
+	if width != 16 && width != 24 {
+		return 0, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"EncapStack: timestamp width must be either 16 or 24 bit")
+	}
+
+	// timestamps may not spread across two 8 byte data words
+	for pos%8 > 8-width/8 {
+		pos++
+	}
+
+	return pos, nil
+}
+
+// check checks whether the encapsulation stack is valid.
+func (encap EncapStack) check() error {
+	for i, hdr := range encap {
+		switch hdr.Type {
+		case EncapVLAN, EncapQinQ:
+			if hdr.VLANID > 4095 || hdr.VLANPriority > 7 {
+				return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+					"EncapStack: header %d: invalid VLAN tag", i)
+			}
+		case EncapMPLS:
+			if hdr.MPLSLabel >= 1<<20 {
+				return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+					"EncapStack: header %d: invalid MPLS label", i)
+			}
+		case EncapVXLAN, EncapGRE:
+			if hdr.Type == EncapVXLAN && hdr.VNI >= 1<<24 {
+				return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+					"EncapStack: header %d: invalid VXLAN network "+
+						"identifier", i)
+			}
+			if (hdr.TunnelSrc != nil && hdr.TunnelSrc.To4() == nil) ||
+				(hdr.TunnelDst != nil && hdr.TunnelDst.To4() == nil) {
+				return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+					"EncapStack: header %d: tunnel endpoint addresses must "+
+						"be IPv4 addresses", i)
+			}
+		default:
+			return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"EncapStack: header %d: invalid header type", i)
+		}
+
+		// MPLS labels may only be followed by further labels or the ip header
+		if hdr.Type == EncapMPLS && i+1 < len(encap) &&
+			encap[i+1].Type != EncapMPLS {
+			return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"EncapStack: header %d: MPLS label may only be followed by "+
+					"another MPLS label", i)
+		}
+	}
+	return nil
+}
+
+// getLen returns the number of bytes the encapsulation headers add to a
+// packet.
+func (encap EncapStack) getLen() int {
+	n := 0
+	for _, hdr := range encap {
+		switch hdr.Type {
+		case EncapVLAN, EncapQinQ, EncapMPLS:
+			n += 4
+		case EncapVXLAN:
+			// outer ipv4, udp, vxlan and inner ethernet header
+			n += 20 + 8 + 8 + 14
+		case EncapGRE:
+			// outer ipv4 and gre header
+			n += 20 + 4
+			if hdr.GREKey != 0 {
+				n += 4
+			}
+		}
+	}
+	return n
+}
+
+// headers creates the outer Ethernet header and the encapsulation headers of
+// a packet. ethType specifies the type of the ip header following the
+// encapsulation headers. The ethertype/protocol fields of all headers are set
+// accordingly. The function also returns the outer ipv4 headers of tunnels.
+func (encap EncapStack) headers(hdrEth *layers.Ethernet, ethType layers.EthernetType) ([]gopacket.SerializableLayer, []*layers.IPv4) {
+	hdrs := []gopacket.SerializableLayer{hdrEth}
+	var hdrsIpTunnel []*layers.IPv4
+
+	// setType sets the type field of the previous header
+	setType := func(t layers.EthernetType) {
+		hdrEth.EthernetType = t
+	}
+
+	for _, hdr := range encap {
+		switch hdr.Type {
+		case EncapVLAN, EncapQinQ:
+			if hdr.Type == EncapVLAN {
+				setType(layers.EthernetTypeDot1Q)
+			} else {
+				setType(layers.EthernetTypeQinQ)
+			}
+			hdrDot1Q := &layers.Dot1Q{
+				Priority:       hdr.VLANPriority,
+				VLANIdentifier: hdr.VLANID,
+			}
+			hdrs = append(hdrs, hdrDot1Q)
+			setType = func(t layers.EthernetType) {
+				hdrDot1Q.Type = t
+			}
+		case EncapMPLS:
+			setType(layers.EthernetTypeMPLSUnicast)
+			hdrMPLS := &layers.MPLS{
+				Label: hdr.MPLSLabel,
+				TTL:   hdr.MPLSTTL,
+			}
+			if hdrMPLS.TTL == 0 {
+				hdrMPLS.TTL = 64
+			}
+			hdrs = append(hdrs, hdrMPLS)
+			setType = func(t layers.EthernetType) {
+				// label is the bottom of the stack if no label follows
+				hdrMPLS.StackBottom = t != layers.EthernetTypeMPLSUnicast
+			}
+		case EncapVXLAN, EncapGRE:
+			setType(layers.EthernetTypeIPv4)
+			hdrIp := &layers.IPv4{
+				Version: 4,
+				IHL:     5,
+				TTL:     64,
+				SrcIP:   net.IPv4(192, 0, 2, 1).To4(),
+				DstIP:   net.IPv4(192, 0, 2, 2).To4(),
+			}
+			if hdr.TunnelSrc != nil {
+				hdrIp.SrcIP = hdr.TunnelSrc.To4()
+			}
+			if hdr.TunnelDst != nil {
+				hdrIp.DstIP = hdr.TunnelDst.To4()
+			}
+			hdrs = append(hdrs, hdrIp)
+			hdrsIpTunnel = append(hdrsIpTunnel, hdrIp)
+
+			if hdr.Type == EncapVXLAN {
+				hdrIp.Protocol = layers.IPProtocolUDP
+				hdrUdp := &layers.UDP{
+					SrcPort: 49152,
+					DstPort: 4789,
+				}
+				hdrUdp.SetNetworkLayerForChecksum(hdrIp)
+
+				// the inner ethernet header uses the same MAC addresses as
+				// the outer ethernet header
+				hdrEthInner := &layers.Ethernet{
+					SrcMAC: hdrEth.SrcMAC,
+					DstMAC: hdrEth.DstMAC,
+				}
+				hdrs = append(hdrs, hdrUdp, &layers.VXLAN{
+					ValidIDFlag: true,
+					VNI:         hdr.VNI,
+				}, hdrEthInner)
+				setType = func(t layers.EthernetType) {
+					hdrEthInner.EthernetType = t
+				}
+			} else {
+				hdrIp.Protocol = layers.IPProtocolGRE
+				hdrGre := &layers.GRE{
+					KeyPresent: hdr.GREKey != 0,
+					Key:        hdr.GREKey,
+				}
+				hdrs = append(hdrs, hdrGre)
+				setType = func(t layers.EthernetType) {
+					hdrGre.Protocol = t
+				}
+			}
+		}
+	}
+
+	setType(ethType)
+
+	return hdrs, hdrsIpTunnel
+}
+
+// hdrLen returns the length of a header created by EncapStack.headers() or
+// hdrsIpCreate().
+func hdrLen(hdr gopacket.SerializableLayer) int {
+	switch h := hdr.(type) {
+	case *layers.Ethernet:
+		return 14
+	case *layers.IPv4:
+		return 20
+	case *layers.IPv6:
+		return 40
+	case *layers.UDP, *layers.VXLAN:
+		return 8
+	case *layers.GRE:
+		if h.KeyPresent {
+			return 8
+		}
+		return 4
+	default:
+		// dot1q and mpls
+		return 4
+	}
+}
+
+// ipv4ChecksumSet calculates the checksum of the ipv4 header at the beginning
+// of the provided data and writes it to the header.
+func ipv4ChecksumSet(hdr []byte) {
+	hdr[10] = 0
+	hdr[11] = 0
+	sum := uint32(0)
+	for i := 0; i < 20; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i : i+2]))
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	binary.BigEndian.PutUint16(hdr[10:12], ^uint16(sum))
+}
@@ -0,0 +1,207 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the encapsulation of generated packets.
+
+package utils
+
+import (
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"testing"
+	"time"
+)
+
+// TestEncapTimestampPos checks the timestamp positions returned for several
+// encapsulation stacks.
+func TestEncapTimestampPos(t *testing.T) {
+	positions := []struct {
+		encap     EncapStack
+		ipVersion int
+		protocol  layers.IPProtocol
+		width     int
+		pos       int
+	}{
+		// 14 + 20 = 34 byte headers
+		{nil, IPVersion4, layers.IPProtocolNoNextHeader, 24, 34},
+		// 14 + 4 + 40 + 8 = 66 byte headers
+		{EncapStack{{Type: EncapVLAN}}, IPVersion6, layers.IPProtocolUDP,
+			24, 66},
+		// 14 + 4 + 20 = 38 byte headers, a 24 bit timestamp would spread
+		// across two data words
+		{EncapStack{{Type: EncapMPLS}}, IPVersion4,
+			layers.IPProtocolNoNextHeader, 16, 38},
+		{EncapStack{{Type: EncapMPLS}}, IPVersion4,
+			layers.IPProtocolNoNextHeader, 24, 40},
+		// 14 + 8 + 40 + 20 = 82 byte headers, mixed mode uses the IPv6
+		// header length
+		{EncapStack{{Type: EncapQinQ}, {Type: EncapVLAN}}, IPVersionMixed,
+			layers.IPProtocolTCP, 24, 82},
+		// 14 + 50 + 20 + 20 = 104 byte headers
+		{EncapStack{{Type: EncapVXLAN}}, IPVersion4, layers.IPProtocolTCP,
+			24, 104},
+		// 14 + 28 + 40 + 20 = 102 byte headers
+		{EncapStack{{Type: EncapGRE, GREKey: 1}}, IPVersion6,
+			layers.IPProtocolTCP, 16, 102},
+		{EncapStack{{Type: EncapGRE, GREKey: 1}}, IPVersion6,
+			layers.IPProtocolTCP, 24, 104},
+	}
+	for i, p := range positions {
+		pos, err := p.encap.GetTimestampPos(p.ipVersion, p.protocol, p.width)
+		if err != nil {
+			t.Fatalf("stack %d: %v", i, err)
+		}
+		if pos != p.pos {
+			t.Fatalf("stack %d: timestamp position %d, expected %d", i, pos,
+				p.pos)
+		}
+	}
+
+	// invalid parameters
+	encap := EncapStack{{Type: EncapVLAN}}
+	if _, err := encap.GetTimestampPos(3, layers.IPProtocolUDP,
+		24); errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if _, err := encap.GetTimestampPos(IPVersion4, layers.IPProtocolICMPv4,
+		24); errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if _, err := encap.GetTimestampPos(IPVersion4, layers.IPProtocolUDP,
+		8); errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+}
+
+// TestEncapInvalid checks that invalid encapsulation stacks are rejected.
+func TestEncapInvalid(t *testing.T) {
+	stacks := []EncapStack{
+		{{Type: 42}},
+		{{Type: EncapVLAN, VLANID: 4096}},
+		{{Type: EncapQinQ, VLANPriority: 8}},
+		{{Type: EncapMPLS, MPLSLabel: 1 << 20}},
+		{{Type: EncapMPLS}, {Type: EncapVLAN}},
+		{{Type: EncapVXLAN, VNI: 1 << 24}},
+		{{Type: EncapGRE, TunnelSrc: []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 1}}},
+	}
+	for i, encap := range stacks {
+		_, err := GenTraceCBREncap(encap, IPVersion4, 1e9, 256, 64,
+			10*time.Microsecond, 1, 42)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("stack %d: expected invalid configuration error, got: "+
+				"%v", i, err)
+		}
+	}
+}
+
+// TestEncapPackets generates packets with encapsulation headers and checks
+// the decoded header sequence, as well as the length fields and checksums of
+// tunnel headers.
+func TestEncapPackets(t *testing.T) {
+	encap := EncapStack{
+		{Type: EncapQinQ, VLANID: 100},
+		{Type: EncapVLAN, VLANID: 200},
+		{Type: EncapMPLS, MPLSLabel: 16},
+		{Type: EncapMPLS, MPLSLabel: 17},
+	}
+	trace, err := GenTraceCBREncap(encap, IPVersion4, 5e9, 256, 252,
+		10*time.Microsecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := trace.Packets()
+	for n := 0; it.Next(); n++ {
+		pkt := gopacket.NewPacket(it.Packet().Data, layers.LayerTypeEthernet,
+			gopacket.Default)
+		var types []gopacket.LayerType
+		for _, layer := range pkt.Layers() {
+			types = append(types, layer.LayerType())
+		}
+		typesExp := []gopacket.LayerType{layers.LayerTypeEthernet,
+			layers.LayerTypeDot1Q, layers.LayerTypeDot1Q,
+			layers.LayerTypeMPLS, layers.LayerTypeMPLS,
+			layers.LayerTypeIPv4}
+		if len(types) < len(typesExp) {
+			t.Fatalf("packet %d: layers %v", n, types)
+		}
+		for i := range typesExp {
+			if types[i] != typesExp[i] {
+				t.Fatalf("packet %d: layers %v", n, types)
+			}
+		}
+
+		// only the second label is the bottom of the stack
+		mpls := pkt.Layers()[3].(*layers.MPLS)
+		if mpls.Label != 16 || mpls.StackBottom {
+			t.Fatalf("packet %d: invalid first MPLS label", n)
+		}
+		mpls = pkt.Layers()[4].(*layers.MPLS)
+		if mpls.Label != 17 || mpls.StackBottom == false {
+			t.Fatalf("packet %d: invalid second MPLS label", n)
+		}
+
+		ip := pkt.Layers()[5].(*layers.IPv4)
+		if int(ip.Length) != 252-14-16 {
+			t.Fatalf("packet %d: IPv4 length %d", n, ip.Length)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// VXLAN tunnel with inner IPv6 header
+	encap = EncapStack{{Type: EncapVXLAN, VNI: 42}}
+	trace, err = GenTraceCBREncap(encap, IPVersion6, 5e9, 256, 252,
+		10*time.Microsecond, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it = trace.Packets()
+	for n := 0; it.Next(); n++ {
+		pkt := gopacket.NewPacket(it.Packet().Data, layers.LayerTypeEthernet,
+			gopacket.Default)
+		ipOuter, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if ok == false || int(ipOuter.Length) != 252-14 ||
+			testChecksum(0, ipOuter.Contents) != 0xFFFF {
+			t.Fatalf("packet %d: invalid outer IPv4 header", n)
+		}
+		vxlan, ok := pkt.Layer(layers.LayerTypeVXLAN).(*layers.VXLAN)
+		if ok == false || vxlan.VNI != 42 {
+			t.Fatalf("packet %d: invalid VXLAN header", n)
+		}
+		ipInner, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if ok == false || int(ipInner.Length) != 252-14-50-40 {
+			t.Fatalf("packet %d: invalid inner IPv6 header", n)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
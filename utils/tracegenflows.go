@@ -0,0 +1,683 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements the generation of traffic consisting of multiple UDP or TCP flows.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// flow size distributions
+const (
+	// all flows consist of FlowSizeMean packets
+	FlowSizeFixed int = 0
+
+	// flow sizes are uniformly distributed between 1 and 2*FlowSizeMean-1
+	// packets
+	FlowSizeUniform int = 1
+
+	// flow sizes follow a (heavy-tailed) pareto distribution with a mean of
+	// FlowSizeMean packets and a shape of FlowSizeParetoShape
+	FlowSizePareto int = 2
+)
+
+// flow arrival processes
+const (
+	// NFlows flows start at the beginning of the trace. Whenever a flow ends,
+	// a new flow with the same 5-tuple starts
+	FlowArrivalAllAtStart int = 0
+
+	// flows start in constant intervals of 1/FlowArrivalRate seconds
+	FlowArrivalConstant int = 1
+
+	// flows start according to a poisson process with FlowArrivalRate flows
+	// per second on average
+	FlowArrivalPoisson int = 2
+)
+
+// FlowConfig defines the flows generated by GenTraceFlows. The 5-tuples of the
+// flows are randomly selected from the configured address and port ranges. If
+// more flows arrive than NFlows, 5-tuples of flows that have ended are reused.
+// A flow arriving while all NFlows 5-tuples are in use is not started.
+type FlowConfig struct {
+	NFlows int // number of distinct flow 5-tuples
+
+	// ip version of the flows (IPVersion4, IPVersion6, IPVersionMixed). For
+	// IPVersionMixed, each flow is randomly selected to be an IPv4 or IPv6
+	// flow
+	IPVersion int
+
+	// source and destination IPv4 address ranges (first and last address)
+	IPSrcFirst, IPSrcLast net.IP
+	IPDstFirst, IPDstLast net.IP
+
+	// source and destination IPv6 address ranges (first and last address).
+	// Addresses of a range may only differ in the lower 64 bits
+	IPv6SrcFirst, IPv6SrcLast net.IP
+	IPv6DstFirst, IPv6DstLast net.IP
+
+	// source and destination port ranges
+	PortSrcMin, PortSrcMax int
+	PortDstMin, PortDstMax int
+
+	// layer 4 protocol (layers.IPProtocolUDP or layers.IPProtocolTCP)
+	Protocol layers.IPProtocol
+
+	// encapsulation headers inserted between the Ethernet and the IP header
+	// (may be nil)
+	Encap EncapStack
+
+	// flow size distribution (FlowSizeFixed, FlowSizeUniform, FlowSizePareto)
+	FlowSizeDist        int
+	FlowSizeMean        float64 // mean flow size in packets
+	FlowSizeParetoShape float64 // shape of pareto distribution (> 1)
+
+	// flow arrival process (FlowArrivalAllAtStart, FlowArrivalConstant,
+	// FlowArrivalPoisson) and rate (flows per second)
+	FlowArrival     int
+	FlowArrivalRate float64
+}
+
+// flowTuple is the 5-tuple of a flow.
+type flowTuple struct {
+	ipSrc, ipDst     net.IP
+	portSrc, portDst uint16
+}
+
+// flow holds the state of an active flow.
+type flow struct {
+	tuple    *flowTuple
+	nPkts    int    // number of packets remaining
+	seq      uint32 // next TCP sequence number
+	idTuple  int    // index of the flow's 5-tuple
+	infinite bool   // if true, flow is restarted when it ends
+}
+
+// GenTraceFlows generates traffic consisting of multiple UDP or TCP flows. The
+// flows are defined by the flows parameter. Packets are generated with
+// constant bit rate and packet length (see GenTraceCBR). Each packet is
+// assigned to one of the currently active flows in a round-robin fashion. If
+// no flow is active, no packet is sent. Thus, datarate defines the data rate
+// while at least one flow is active. The headers of each packet are valid
+// (including length fields and checksums), payload bits are set to zero.
+// pktlenWire defines the length of the generated packets, pktlenCapture the
+// number of data bytes that are written to the hardware (see GenTraceCBR). The
+// duration parameter specifies the total duration of the generated trace. The
+// parameter nRepeats determins how often the generated trace shall be
+// replayed. The seed parameter initializes the random number generator, the
+// same seed and parameters always result in identical trace data. If seed is
+// zero, a random seed is selected. The seed is recorded in the trace (see
+// Trace.GetSeed()).
+func GenTraceFlows(datarate float64, pktlenWire, pktlenCapture int, flows FlowConfig, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceFlows(datarate, pktlenWire, pktlenCapture,
+		flows, duration, nRepeats, seed)
+}
+
+// GenTraceFlows generates a trace like the package function GenTraceFlows(),
+// but for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceFlows(datarate float64, pktlenWire, pktlenCapture int, flows FlowConfig, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
+	// MAC will append FCS, so substract 4 bytes from wire length
+	pktlenWire -= 4
+
+	// check if capture length is valid
+	if pktlenCapture > pktlenWire {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid capture length")
+	}
+
+	// check flow configuration
+	if err := flows.check(); err != nil {
+		return nil, err
+	}
+
+	// generate the flow 5-tuples
+	tuples, err := flows.genTuples(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	// calculate the number of packet slots. a packet is sent in a slot if at
+	// least one flow is active (add 24 bytes for Ethernet preamble + SOD,
+	// inter-frame gap and FCS)
+	slotRate := datarate / float64(8*(pktlenWire+24))
+	nSlots := round(duration.Seconds() * slotRate)
+
+	// flows must on average end as fast as they arrive, otherwise the number
+	// of active flows grows without bound and the packets of each flow are
+	// spread across an increasing number of slots
+	if flows.FlowArrival != FlowArrivalAllAtStart &&
+		flows.FlowArrivalRate*flows.FlowSizeMean > slotRate {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: flow arrival rate times mean flow size exceeds "+
+				"packet rate")
+	}
+
+	// determine the mean inter-packet time in clock cycles
+	cyclesInterPacketMean := tracegen.freqClock * float64(8*(pktlenWire+24)) /
+		datarate
+
+	// accumulated inter-packet clock cycle rounding error
+	accCyclesInterPacketRoundErr := 0.0
+
+	// accumulated number of clock cycles (i.e. start time of current slot)
+	accCycles := uint64(0)
+
+	// list of active flows and the index of the flow sending the next packet
+	var flowsActive []*flow
+	iFlow := 0
+
+	// number of flows started so far and number of arriving flows that were
+	// not started, because all 5-tuples were in use
+	nFlowsStarted := 0
+	nFlowsDropped := 0
+
+	// 5-tuples used by active flows and the index of the 5-tuple assigned to
+	// the next arriving flow
+	tuplesActive := make([]bool, len(tuples))
+	iTuple := 0
+
+	// arrival time of the next flow in clock cycles
+	cyclesFlowArrival := 0.0
+
+	// packets are only added to the trace once the inter-packet time to the
+	// next packet is known (slots without packets extend the inter-packet
+	// time of the previous packet)
+	var pkt gofluent10g.TracePacket
+	var pktPending bool
+
+	// assemble the trace
+	builder := tracegen.traceBuilderCreate()
+
+	for i := 0; i < nSlots; i++ {
+		// determine the number of clock cycles until the next slot (see
+		// GenTraceCBR)
+		var cyclesSlot int
+		if accCyclesInterPacketRoundErr < 1.0 {
+			// not enough rounding error accumulated yet -> round up
+			cyclesSlot = int(math.Ceil(cyclesInterPacketMean))
+			accCyclesInterPacketRoundErr +=
+				math.Ceil(cyclesInterPacketMean) - cyclesInterPacketMean
+		} else {
+			// enough rounding error accumulated -> round down
+			cyclesSlot = int(math.Floor(cyclesInterPacketMean))
+			accCyclesInterPacketRoundErr -=
+				cyclesInterPacketMean - math.Floor(cyclesInterPacketMean)
+		}
+
+		// start flows that arrive before the current slot
+		if flows.FlowArrival == FlowArrivalAllAtStart {
+			for ; nFlowsStarted < flows.NFlows; nFlowsStarted++ {
+				flowsActive = append(flowsActive, &flow{
+					tuple:    tuples[nFlowsStarted],
+					nPkts:    flows.genFlowSize(rng),
+					seq:      rng.Uint32(),
+					idTuple:  nFlowsStarted,
+					infinite: true,
+				})
+			}
+		} else {
+			for cyclesFlowArrival <= float64(accCycles) {
+				// find the next 5-tuple that is not in use
+				for j := 0; j < len(tuples) && tuplesActive[iTuple]; j++ {
+					iTuple = (iTuple + 1) % len(tuples)
+				}
+				if tuplesActive[iTuple] {
+					nFlowsDropped++
+				} else {
+					flowsActive = append(flowsActive, &flow{
+						tuple:   tuples[iTuple],
+						nPkts:   flows.genFlowSize(rng),
+						seq:     rng.Uint32(),
+						idTuple: iTuple,
+					})
+					tuplesActive[iTuple] = true
+					iTuple = (iTuple + 1) % len(tuples)
+					nFlowsStarted++
+				}
+
+				// determine arrival time of the next flow
+				tInterArrival := 1.0 / flows.FlowArrivalRate
+				if flows.FlowArrival == FlowArrivalPoisson {
+					tInterArrival *= rng.ExpFloat64()
+				}
+				cyclesFlowArrival += tracegen.freqClock * tInterArrival
+			}
+		}
+
+		accCycles += uint64(cyclesSlot)
+
+		if len(flowsActive) == 0 {
+			// no flow active, slot remains empty
+			if pktPending {
+				pkt.CyclesInterPacket += cyclesSlot
+			}
+			continue
+		}
+
+		// select the flow sending the packet in round-robin fashion
+		if iFlow >= len(flowsActive) {
+			iFlow = 0
+		}
+		f := flowsActive[iFlow]
+
+		// add previous packet to trace
+		if pktPending {
+			if pkt.CyclesInterPacket > 0xFFFFFFFF {
+				pkt.CyclesInterPacket = 0xFFFFFFFF
+			}
+			if err := builder.AddPacket(pkt); err != nil {
+				return nil, err
+			}
+		}
+
+		// create packet
+		data, err := flows.serializePacket(f, pktlenWire)
+		if err != nil {
+			return nil, err
+		}
+		pkt = gofluent10g.TracePacket{
+			CyclesInterPacket: cyclesSlot,
+			Wirelen:           pktlenWire,
+			Caplen:            pktlenCapture,
+			Data:              data,
+		}
+		pktPending = true
+
+		// update flow state
+		f.nPkts--
+		if f.nPkts == 0 {
+			if f.infinite {
+				// restart flow with same 5-tuple
+				f.nPkts = flows.genFlowSize(rng)
+				f.seq = rng.Uint32()
+				iFlow++
+			} else {
+				// remove flow from the list of active flows
+				tuplesActive[f.idTuple] = false
+				flowsActive = append(flowsActive[:iFlow],
+					flowsActive[iFlow+1:]...)
+			}
+		} else {
+			iFlow++
+		}
+	}
+
+	// add last packet to trace
+	if pktPending {
+		if pkt.CyclesInterPacket > 0xFFFFFFFF {
+			pkt.CyclesInterPacket = 0xFFFFFFFF
+		}
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Generated %d packets of %d flows",
+		builder.GetPacketCount(), nFlowsStarted)
+	if nFlowsDropped > 0 {
+		gofluent10g.Log(gofluent10g.LOG_WARN, "GenTraceFlows: %d flows not "+
+			"started, all 5-tuples were in use", nFlowsDropped)
+	}
+
+	// print actual replay duration after rounding
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Actual trace duration: %s (Target was %s)",
+		builder.GetDuration(), duration)
+
+	// create and return trace
+	return traceCreate(builder, nRepeats, seed)
+}
+
+// check checks whether the flow configuration is valid.
+func (flows *FlowConfig) check() error {
+	if flows.NFlows <= 0 {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: number of flows must be larger than zero")
+	}
+	if flows.IPVersion != IPVersion4 && flows.IPVersion != IPVersion6 &&
+		flows.IPVersion != IPVersionMixed {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid ip version")
+	}
+	if err := flows.Encap.check(); err != nil {
+		return err
+	}
+	if flows.Protocol != layers.IPProtocolUDP &&
+		flows.Protocol != layers.IPProtocolTCP {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: protocol must be UDP or TCP")
+	}
+	if flows.PortSrcMin < 0 || flows.PortSrcMax > 65535 ||
+		flows.PortSrcMin > flows.PortSrcMax ||
+		flows.PortDstMin < 0 || flows.PortDstMax > 65535 ||
+		flows.PortDstMin > flows.PortDstMax {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid port range")
+	}
+	if flows.FlowSizeMean < 1 {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: mean flow size must be at least one packet")
+	}
+	if flows.FlowSizeDist != FlowSizeFixed &&
+		flows.FlowSizeDist != FlowSizeUniform &&
+		(flows.FlowSizeDist != FlowSizePareto ||
+			flows.FlowSizeParetoShape <= 1) {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid flow size distribution")
+	}
+	if flows.FlowArrival != FlowArrivalAllAtStart &&
+		((flows.FlowArrival != FlowArrivalConstant &&
+			flows.FlowArrival != FlowArrivalPoisson) ||
+			flows.FlowArrivalRate <= 0) {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid flow arrival process")
+	}
+	return nil
+}
+
+// genTuples randomly selects NFlows distinct 5-tuples from the configured
+// address and port ranges. For IPVersionMixed, each flow is randomly selected
+// to be an IPv4 or IPv6 flow with equal probability.
+func (flows *FlowConfig) genTuples(rng *rand.Rand) ([]*flowTuple, error) {
+	var ipv4Src, ipv4Dst, ipv6Src, ipv6Dst *ipAddrRange
+	var err error
+
+	// number of distinct 5-tuples for each ip version
+	nTuplesV4, nTuplesV6 := 0.0, 0.0
+
+	portSrcN := uint64(flows.PortSrcMax - flows.PortSrcMin + 1)
+	portDstN := uint64(flows.PortDstMax - flows.PortDstMin + 1)
+
+	if flows.IPVersion == IPVersion4 || flows.IPVersion == IPVersionMixed {
+		ipv4Src, err = ipAddrRangeCreate(flows.IPSrcFirst, flows.IPSrcLast,
+			false)
+		if err != nil {
+			return nil, err
+		}
+		ipv4Dst, err = ipAddrRangeCreate(flows.IPDstFirst, flows.IPDstLast,
+			false)
+		if err != nil {
+			return nil, err
+		}
+		nTuplesV4 = ipv4Src.getSize() * ipv4Dst.getSize() *
+			float64(portSrcN) * float64(portDstN)
+	}
+
+	if flows.IPVersion == IPVersion6 || flows.IPVersion == IPVersionMixed {
+		ipv6Src, err = ipAddrRangeCreate(flows.IPv6SrcFirst, flows.IPv6SrcLast,
+			true)
+		if err != nil {
+			return nil, err
+		}
+		ipv6Dst, err = ipAddrRangeCreate(flows.IPv6DstFirst, flows.IPv6DstLast,
+			true)
+		if err != nil {
+			return nil, err
+		}
+		nTuplesV6 = ipv6Src.getSize() * ipv6Dst.getSize() *
+			float64(portSrcN) * float64(portDstN)
+	}
+
+	// make sure there are enough distinct 5-tuples. In mixed mode, each ip
+	// version must provide enough 5-tuples on its own
+	nTuples := nTuplesV4
+	if flows.IPVersion == IPVersion6 ||
+		(flows.IPVersion == IPVersionMixed && nTuplesV6 < nTuplesV4) {
+		nTuples = nTuplesV6
+	}
+	if float64(flows.NFlows) > nTuples {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: address and port ranges allow only %.0f flows",
+			nTuples)
+	}
+
+	tuples := make([]*flowTuple, 0, flows.NFlows)
+	tuplesUsed := make(map[string]bool)
+
+	for len(tuples) < flows.NFlows {
+		// select ip version and addresses
+		ipSrcRange, ipDstRange := ipv4Src, ipv4Dst
+		if flows.IPVersion == IPVersion6 ||
+			(flows.IPVersion == IPVersionMixed && rng.Intn(2) == 1) {
+			ipSrcRange, ipDstRange = ipv6Src, ipv6Dst
+		}
+
+		tuple := &flowTuple{
+			ipSrc:   ipSrcRange.random(rng),
+			ipDst:   ipDstRange.random(rng),
+			portSrc: uint16(flows.PortSrcMin + rng.Intn(int(portSrcN))),
+			portDst: uint16(flows.PortDstMin + rng.Intn(int(portDstN))),
+		}
+
+		// skip 5-tuples that have already been selected
+		key := string(tuple.ipSrc) + string(tuple.ipDst) +
+			string([]byte{byte(tuple.portSrc >> 8), byte(tuple.portSrc),
+				byte(tuple.portDst >> 8), byte(tuple.portDst)})
+		if tuplesUsed[key] {
+			continue
+		}
+		tuplesUsed[key] = true
+
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, nil
+}
+
+// genFlowSize returns a random flow size (in packets) according to the
+// configured flow size distribution.
+func (flows *FlowConfig) genFlowSize(rng *rand.Rand) int {
+	switch flows.FlowSizeDist {
+	case FlowSizeUniform:
+		return 1 + rng.Intn(2*int(math.Round(flows.FlowSizeMean))-1)
+	case FlowSizePareto:
+		size := paretoRand(rng, flows.FlowSizeMean, flows.FlowSizeParetoShape)
+		if size > math.MaxInt32 {
+			return math.MaxInt32
+		}
+		return ceil(size)
+	default:
+		return round(flows.FlowSizeMean)
+	}
+}
+
+// serializePacket creates the data of the flow's next packet.
+func (flows *FlowConfig) serializePacket(f *flow, pktlen int) ([]byte, error) {
+	ipSrc, ipDst := f.tuple.ipSrc, f.tuple.ipDst
+
+	// use the last four bytes of the flow's ip addresses to derive locally
+	// administered MAC addresses
+	macSrc := append(net.HardwareAddr{0x52, 0x00}, ipSrc[len(ipSrc)-4:]...)
+	macDst := append(net.HardwareAddr{0x52, 0x00}, ipDst[len(ipDst)-4:]...)
+
+	hdrEth := &layers.Ethernet{
+		SrcMAC: macSrc,
+		DstMAC: macDst,
+	}
+
+	// create ip header
+	var hdrIp gopacket.NetworkLayer
+	var hdrIpLen int
+	var ethType layers.EthernetType
+	if len(ipSrc) == net.IPv4len {
+		ethType = layers.EthernetTypeIPv4
+		hdrIp = &layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: flows.Protocol,
+			SrcIP:    ipSrc,
+			DstIP:    ipDst,
+		}
+		hdrIpLen = 20
+	} else {
+		ethType = layers.EthernetTypeIPv6
+		hdrIp = &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: flows.Protocol,
+			SrcIP:      ipSrc,
+			DstIP:      ipDst,
+		}
+		hdrIpLen = 40
+	}
+
+	// create layer 4 header. payload fills the packet up to its length
+	var hdrL4 gopacket.SerializableLayer
+	var payloadLen int
+	if flows.Protocol == layers.IPProtocolTCP {
+		hdrTcp := &layers.TCP{
+			SrcPort: layers.TCPPort(f.tuple.portSrc),
+			DstPort: layers.TCPPort(f.tuple.portDst),
+			Seq:     f.seq,
+			ACK:     true,
+			Window:  65535,
+		}
+		hdrTcp.SetNetworkLayerForChecksum(hdrIp)
+		hdrL4 = hdrTcp
+		payloadLen = pktlen - 14 - flows.Encap.getLen() - hdrIpLen - 20
+	} else {
+		hdrUdp := &layers.UDP{
+			SrcPort: layers.UDPPort(f.tuple.portSrc),
+			DstPort: layers.UDPPort(f.tuple.portDst),
+		}
+		hdrUdp.SetNetworkLayerForChecksum(hdrIp)
+		hdrL4 = hdrUdp
+		payloadLen = pktlen - 14 - flows.Encap.getLen() - hdrIpLen - 8
+	}
+
+	if payloadLen < 0 {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: packet length too small")
+	}
+
+	// TCP sequence number advances by the payload length
+	f.seq += uint32(payloadLen)
+
+	// create ethernet and encapsulation headers
+	hdrs, _ := flows.Encap.headers(hdrEth, ethType)
+	hdrs = append(hdrs, hdrIp.(gopacket.SerializableLayer), hdrL4,
+		gopacket.Payload(make([]byte, payloadLen)))
+
+	// serialize packet data. lengths and checksums are computed
+	bufPkt := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(bufPkt,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		hdrs...)
+	if err != nil {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: %s", err.Error())
+	}
+
+	return bufPkt.Bytes(), nil
+}
+
+// ipAddrRange is a range of IPv4 or IPv6 addresses. IPv6 address ranges are
+// limited to addresses that only differ in the lower 64 bits.
+type ipAddrRange struct {
+	first []byte // first address of the range
+	span  uint64 // number of addresses in the range minus one
+}
+
+// ipAddrRangeCreate creates an address range from its first and last address.
+// The ipv6 parameter specifies whether the addresses are IPv6 or IPv4
+// addresses.
+func ipAddrRangeCreate(first, last net.IP, ipv6 bool) (*ipAddrRange, error) {
+	var a, b uint64
+	if ipv6 {
+		first16 := first.To16()
+		last16 := last.To16()
+		if first16 == nil || last16 == nil || first.To4() != nil ||
+			last.To4() != nil || !bytes.Equal(first16[:8], last16[:8]) {
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceFlows: invalid ipv6 address range")
+		}
+		first = first16
+		a = binary.BigEndian.Uint64(first16[8:])
+		b = binary.BigEndian.Uint64(last16[8:])
+	} else {
+		first4 := first.To4()
+		last4 := last.To4()
+		if first4 == nil || last4 == nil {
+			return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceFlows: invalid ipv4 address range")
+		}
+		first = first4
+		a = uint64(binary.BigEndian.Uint32(first4))
+		b = uint64(binary.BigEndian.Uint32(last4))
+	}
+
+	if a > b {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceFlows: invalid ip address range")
+	}
+
+	return &ipAddrRange{first: first, span: b - a}, nil
+}
+
+// getSize returns the number of addresses in the range.
+func (r *ipAddrRange) getSize() float64 {
+	return float64(r.span) + 1
+}
+
+// random returns a random address from the range.
+func (r *ipAddrRange) random(rng *rand.Rand) net.IP {
+	// random offset from the first address of the range
+	var offset uint64
+	if r.span < math.MaxInt64 {
+		offset = uint64(rng.Int63n(int64(r.span) + 1))
+	} else if r.span < math.MaxUint64 {
+		offset = rng.Uint64() % (r.span + 1)
+	} else {
+		offset = rng.Uint64()
+	}
+
+	ip := make(net.IP, len(r.first))
+	copy(ip, r.first)
+	if len(ip) == net.IPv4len {
+		binary.BigEndian.PutUint32(ip,
+			binary.BigEndian.Uint32(r.first)+uint32(offset))
+	} else {
+		binary.BigEndian.PutUint64(ip[8:],
+			binary.BigEndian.Uint64(r.first[8:])+offset)
+	}
+	return ip
+}
@@ -0,0 +1,360 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the generation of traces consisting of multiple UDP or TCP flows.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+	"time"
+)
+
+// testFlowConfig returns a flow configuration with nFlows flows starting at
+// the beginning of the trace.
+func testFlowConfig(nFlows int, protocol layers.IPProtocol) FlowConfig {
+	return FlowConfig{
+		NFlows:       nFlows,
+		IPSrcFirst:   net.ParseIP("10.0.0.1"),
+		IPSrcLast:    net.ParseIP("10.0.0.4"),
+		IPDstFirst:   net.ParseIP("10.0.1.1"),
+		IPDstLast:    net.ParseIP("10.0.1.4"),
+		PortSrcMin:   1000,
+		PortSrcMax:   1003,
+		PortDstMin:   80,
+		PortDstMax:   80,
+		Protocol:     protocol,
+		FlowSizeDist: FlowSizeFixed,
+		FlowSizeMean: 10,
+	}
+}
+
+// testChecksum returns the ones' complement sum of the data, which is 0xFFFF
+// for data containing a valid internet checksum.
+func testChecksum(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return uint16(sum)
+}
+
+// testCheckChecksums checks the IPv4 header checksum and the UDP/TCP checksum
+// of a packet. It returns the ip version of the packet.
+func testCheckChecksums(t *testing.T, i int, data []byte) int {
+	pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+
+	var ipSrc, ipDst net.IP
+	var protocol layers.IPProtocol
+	var segment []byte
+	version := 4
+	if ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		if testChecksum(0, ip.Contents) != 0xFFFF {
+			t.Fatalf("packet %d: invalid IPv4 header checksum", i)
+		}
+		ipSrc, ipDst, protocol, segment = ip.SrcIP, ip.DstIP, ip.Protocol,
+			ip.Payload
+	} else if ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		ipSrc, ipDst, protocol, segment = ip.SrcIP, ip.DstIP, ip.NextHeader,
+			ip.Payload
+		version = 6
+	} else {
+		t.Fatalf("packet %d: no ip header", i)
+	}
+
+	// pseudo header sum
+	sum := uint32(testChecksum(0, ipSrc)) + uint32(testChecksum(0, ipDst)) +
+		uint32(protocol) + uint32(len(segment))
+	if testChecksum(sum, segment) != 0xFFFF {
+		t.Fatalf("packet %d: invalid %s checksum", i, protocol)
+	}
+
+	return version
+}
+
+// TestGenTraceFlows generates UDP and TCP flows and checks the checksums, the
+// 5-tuples and the round-robin assignment of packets to flows.
+func TestGenTraceFlows(t *testing.T) {
+	protocols := []layers.IPProtocol{layers.IPProtocolUDP,
+		layers.IPProtocolTCP}
+	for _, protocol := range protocols {
+		flows := testFlowConfig(8, protocol)
+		trace, err := GenTraceFlows(5e9, 128, 124, flows,
+			100*time.Microsecond, 1, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// next expected TCP sequence number of each flow
+		seqs := make(map[string]uint32)
+		keyPrev := ""
+
+		n := 0
+		it := trace.Packets()
+		for ; it.Next(); n++ {
+			data := it.Packet().Data
+			testCheckChecksums(t, n, data)
+
+			pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet,
+				gopacket.Default)
+			ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			if ok == false {
+				t.Fatalf("packet %d: no IPv4 header", n)
+			}
+			if ip.SrcIP[2] != 0 || ip.SrcIP[3] < 1 || ip.SrcIP[3] > 4 ||
+				ip.DstIP[2] != 1 || ip.DstIP[3] < 1 || ip.DstIP[3] > 4 {
+				t.Fatalf("packet %d: address not in configured range", n)
+			}
+
+			src, dst := pkt.TransportLayer().TransportFlow().Endpoints()
+			portSrc := binary.BigEndian.Uint16(src.Raw())
+			portDst := binary.BigEndian.Uint16(dst.Raw())
+			if portSrc < 1000 || portSrc > 1003 || portDst != 80 {
+				t.Fatalf("packet %d: port not in configured range", n)
+			}
+
+			// flows take turns, consecutive packets never belong to the
+			// same flow
+			key := ip.NetworkFlow().String() + src.String() + dst.String()
+			if key == keyPrev {
+				t.Fatalf("packet %d: sent by the same flow as the previous "+
+					"packet", n)
+			}
+			keyPrev = key
+
+			// TCP sequence numbers advance by the payload length. each flow
+			// sends 10 packets, then it is restarted with a new sequence
+			// number
+			if tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+				if seq, ok := seqs[key]; ok && seq != tcp.Seq &&
+					(n/8)%10 != 0 {
+					t.Fatalf("packet %d: sequence number %d, expected %d", n,
+						tcp.Seq, seq)
+				}
+				seqs[key] = tcp.Seq + uint32(len(tcp.Payload))
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatal("trace does not contain any packets")
+		}
+		if protocol == layers.IPProtocolTCP && len(seqs) != 8 {
+			t.Fatalf("trace contains %d flows, expected 8", len(seqs))
+		}
+	}
+}
+
+// TestGenTraceFlowsIPv6 generates IPv6 and mixed IPv4/IPv6 flows and checks
+// the checksums and addresses of the packets.
+func TestGenTraceFlowsIPv6(t *testing.T) {
+	for _, ipVersion := range []int{IPVersion6, IPVersionMixed} {
+		flows := testFlowConfig(16, layers.IPProtocolTCP)
+		flows.IPVersion = ipVersion
+		flows.IPv6SrcFirst = net.ParseIP("fd00::1")
+		flows.IPv6SrcLast = net.ParseIP("fd00::4")
+		flows.IPv6DstFirst = net.ParseIP("fd00:1::1")
+		flows.IPv6DstLast = net.ParseIP("fd00:1::4")
+		trace, err := GenTraceFlows(5e9, 128, 124, flows,
+			100*time.Microsecond, 1, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count := make(map[int]int)
+		n := 0
+		it := trace.Packets()
+		for ; it.Next(); n++ {
+			data := it.Packet().Data
+			version := testCheckChecksums(t, n, data)
+			count[version]++
+
+			if version == 6 {
+				pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet,
+					gopacket.Default)
+				ip := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+				if bytes.Compare(ip.SrcIP, flows.IPv6SrcFirst) < 0 ||
+					bytes.Compare(ip.SrcIP, flows.IPv6SrcLast) > 0 ||
+					bytes.Compare(ip.DstIP, flows.IPv6DstFirst) < 0 ||
+					bytes.Compare(ip.DstIP, flows.IPv6DstLast) > 0 {
+					t.Fatalf("packet %d: address not in configured range", n)
+				}
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		if ipVersion == IPVersion6 && count[4] != 0 {
+			t.Fatalf("trace contains %d IPv4 packets", count[4])
+		}
+		if ipVersion == IPVersionMixed && (count[4] == 0 || count[6] == 0) {
+			t.Fatalf("trace contains %d IPv4 and %d IPv6 packets", count[4],
+				count[6])
+		}
+	}
+}
+
+// TestGenTraceFlowsArrival generates flows arriving with a constant rate and
+// checks that no packet is sent while no flow is active.
+func TestGenTraceFlowsArrival(t *testing.T) {
+	// a flow of 10 packets arrives every 20 us. at 1 Gbps, a 128 byte packet
+	// slot takes 1.184 us, so each flow completes before the next one arrives
+	flows := testFlowConfig(4, layers.IPProtocolUDP)
+	flows.FlowArrival = FlowArrivalConstant
+	flows.FlowArrivalRate = 5e4
+	trace, err := GenTraceFlows(1e9, 128, 60, flows, 1000*time.Microsecond,
+		1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 50 flows of 10 packets each
+	n, _ := trace.GetPacketCount()
+	if n != 500 {
+		t.Fatalf("trace contains %d packets, expected 500", n)
+	}
+
+	// gaps between flows extend the inter-packet time of the last packet
+	// before the gap, the trace duration is not affected
+	flows.FlowArrivalRate = 1e4
+	trace, err = GenTraceFlows(1e9, 128, 60, flows, 1000*time.Microsecond,
+		1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ = trace.GetPacketCount()
+	if n != 100 {
+		t.Fatalf("trace contains %d packets, expected 100", n)
+	}
+	if d, _ := trace.GetDuration(); d < 999*time.Microsecond ||
+		d > 1001*time.Microsecond {
+		t.Fatalf("trace duration %s, expected 1ms", d)
+	}
+}
+
+// TestGenTraceFlowsTupleReuse generates flows arriving faster than flows
+// with the same 5-tuple end and checks that a 5-tuple is not reused while a
+// flow using it is still active.
+func TestGenTraceFlowsTupleReuse(t *testing.T) {
+	// at 1 Gbps, the slot rate of 128 byte packets is 844594 packets per
+	// second. 8e4 flows of 10 packets per second arrive, but there are only
+	// 4 distinct 5-tuples
+	flows := testFlowConfig(4, layers.IPProtocolTCP)
+	flows.FlowArrival = FlowArrivalPoisson
+	flows.FlowArrivalRate = 8e4
+	trace, err := GenTraceFlows(1e9, 128, 124, flows, 1000*time.Microsecond,
+		1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// next expected TCP sequence number and number of packets of the current
+	// flow of each 5-tuple. Sequence numbers of a flow advance by the payload
+	// length, a new flow starts with a random sequence number
+	seqs := make(map[string]uint32)
+	nPkts := make(map[string]int)
+
+	it := trace.Packets()
+	for n := 0; it.Next(); n++ {
+		pkt := gopacket.NewPacket(it.Packet().Data,
+			layers.LayerTypeEthernet, gopacket.Default)
+		ip := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		tcp := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		key := ip.NetworkFlow().String() + tcp.TransportFlow().String()
+
+		if seq, ok := seqs[key]; ok && seq != tcp.Seq {
+			// a new flow starts, the previous flow must be complete
+			if nPkts[key] != 10 {
+				t.Fatalf("packet %d: flow ended after %d packets, "+
+					"expected 10", n, nPkts[key])
+			}
+			nPkts[key] = 0
+		}
+		seqs[key] = tcp.Seq + uint32(len(tcp.Payload))
+		nPkts[key]++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 4 {
+		t.Fatalf("trace contains %d 5-tuples, expected 4", len(seqs))
+	}
+}
+
+// TestGenTraceFlowsInvalid checks that invalid flow configurations are
+// rejected.
+func TestGenTraceFlowsInvalid(t *testing.T) {
+	configs := []func(flows *FlowConfig){
+		func(flows *FlowConfig) { flows.NFlows = 0 },
+		func(flows *FlowConfig) { flows.NFlows = 4*4*4 + 1 },
+		func(flows *FlowConfig) { flows.Protocol = layers.IPProtocolICMPv4 },
+		func(flows *FlowConfig) { flows.PortSrcMax = 999 },
+		func(flows *FlowConfig) { flows.PortDstMax = 65536 },
+		func(flows *FlowConfig) { flows.FlowSizeMean = 0.5 },
+		func(flows *FlowConfig) {
+			flows.FlowSizeDist = FlowSizePareto
+			flows.FlowSizeParetoShape = 1
+		},
+		func(flows *FlowConfig) { flows.FlowArrival = FlowArrivalPoisson },
+		func(flows *FlowConfig) {
+			// flows of 10 packets arrive faster than packets are sent
+			flows.FlowArrival = FlowArrivalConstant
+			flows.FlowArrivalRate = 1e6
+		},
+		func(flows *FlowConfig) { flows.IPSrcLast = net.ParseIP("10.0.0.0") },
+		func(flows *FlowConfig) { flows.IPDstFirst = net.ParseIP("::1") },
+		func(flows *FlowConfig) { flows.IPVersion = 3 },
+		func(flows *FlowConfig) {
+			// IPv6 address ranges are missing
+			flows.IPVersion = IPVersion6
+		},
+	}
+	for i, config := range configs {
+		flows := testFlowConfig(8, layers.IPProtocolUDP)
+		config(&flows)
+		_, err := GenTraceFlows(1e9, 128, 60, flows, 100*time.Microsecond,
+			1, 42)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("configuration %d: expected invalid configuration "+
+				"error, got: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements trace generation with packet sizes following a weighted packet
+// size distribution, e.g. the Simple IMIX.
+
+package utils
+
+import (
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket/layers"
+	"math"
+	"net"
+	"time"
+)
+
+// PktlenDistribution defines a weighted packet size distribution. Pktlens
+// contains the packet lengths (size of the Ethernet frame, i.e. starting with
+// destination MAC address, ending with FCS), Weights the relative frequency of
+// each packet length.
+type PktlenDistribution struct {
+	Pktlens []int
+	Weights []float64
+}
+
+// IMIXSimple is the Simple IMIX packet size distribution: 64, 594 and 1518
+// byte frames with a ratio of 7:4:1.
+var IMIXSimple = PktlenDistribution{
+	Pktlens: []int{64, 594, 1518},
+	Weights: []float64{7, 4, 1},
+}
+
+// IMIXInternet is the Internet IMIX packet size distribution: 64, 570 and
+// 1518 byte frames with a ratio of 7:4:1.
+var IMIXInternet = PktlenDistribution{
+	Pktlens: []int{64, 570, 1518},
+	Weights: []float64{7, 4, 1},
+}
+
+// GenTraceIMIX generates traffic with a constant mean data rate and packet
+// sizes following the weighted packet size distribution dist. Only Ethernet
+// and IPv4 headers are generated, payload bits are set to zero. The packet
+// sizes are interleaved deterministically, such that each packet size occurs
+// at evenly spread positions (e.g. for the Simple IMIX, each sequence of 12
+// packets contains 7 packets of 64 bytes, 4 packets of 594 bytes and one
+// packet of 1518 bytes). The inter-packet time of each packet is proportional
+// to its size, so that the data rate is constant. datarate defines the target
+// data rate. pktlenCaptureMax defines the maximum number of data bytes that
+// are written to the hardware. Hardware then appends zero bytes to the packet
+// to restore its original length. The duration parameter specifies the total
+// duration of the generated trace. The parameter nRepeats determins how often
+// the generated trace shall be replayed. Data is only generated for the first
+// replay, the generator wraps around for all further replays.
+// The seed parameter initializes the random number generator, the same seed
+// and parameters always result in identical trace data. If seed is zero, a
+// random seed is selected. The seed is recorded in the trace (see
+// Trace.GetSeed()).
+func GenTraceIMIX(datarate float64, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceIMIX(datarate, dist, pktlenCaptureMax,
+		duration, nRepeats, seed)
+}
+
+// GenTraceIMIX generates a trace like the package function GenTraceIMIX(), but
+// for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceIMIX(datarate float64, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	return tracegen.GenTraceIMIXEncap(nil, IPVersion4, datarate, dist,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceIMIXEncap generates traffic with packet sizes following a weighted
+// packet size distribution like GenTraceIMIX. The encap parameter specifies
+// the encapsulation headers that are inserted between the Ethernet and the IP
+// header (may be nil). The ipVersion parameter selects whether IPv4
+// (IPVersion4), IPv6 (IPVersion6) or alternately IPv4 and IPv6 packets
+// (IPVersionMixed) are generated. All packet lengths of the distribution must
+// be large enough to hold the headers.
+func GenTraceIMIXEncap(encap EncapStack, ipVersion int, datarate float64, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceIMIXEncap(encap, ipVersion, datarate, dist,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceIMIXEncap generates a trace like the package function
+// GenTraceIMIXEncap(), but for the board the trace generator has been created
+// for.
+func (tracegen *TraceGenerator) GenTraceIMIXEncap(encap EncapStack, ipVersion int, datarate float64, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
+	// check packet size distribution
+	if err := dist.check("GenTraceIMIX"); err != nil {
+		return nil, err
+	}
+
+	// check encapsulation headers
+	if err := encap.check(); err != nil {
+		return nil, err
+	}
+
+	// calculate mean packet length and the sum of all weights
+	pktlenMean := dist.getMean()
+	weightSum := 0.0
+	for _, weight := range dist.Weights {
+		weightSum += weight
+	}
+
+	// data rate must not exceed line rate
+	if datarate <= 0 || datarate > tracegen.lineRate {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceIMIX: invalid data rate")
+	}
+
+	// calculate the number of packets we will generate. Packet lengths
+	// include the FCS, add 20 bytes for Ethernet preamble + SOD and
+	// inter-frame gap
+	nPkts := round(duration.Seconds() * datarate / (8 * (pktlenMean + 20)))
+
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Generating %d packets", nPkts)
+
+	// we will reuse the same ethernet header for all packets
+	macSrc, _ := net.ParseMAC("53:00:00:00:00:01")
+	macDst, _ := net.ParseMAC("53:00:00:00:00:02")
+
+	// create ethernet header
+	hdrEth := &layers.Ethernet{
+		SrcMAC:       macSrc,
+		DstMAC:       macDst,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	// create ip headers with random source and destination addresses, which
+	// will be reused for all packets. In mixed mode, packets alternately
+	// carry the IPv4 and the IPv6 header
+	hdrsIp, err := hdrsIpCreate(rng, ipVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// serialize the headers for each packet length and ip header. MAC will
+	// append FCS, so the packets we generate here are 4 bytes shorter
+	pktsData := make([][][]byte, len(dist.Pktlens))
+	for i, pktlen := range dist.Pktlens {
+		for _, hdrIp := range hdrsIp {
+			data, err := hdrsSerialize(hdrEth, encap, hdrIp, pktlen-4)
+			if err != nil {
+				return nil, gofluent10g.ErrorCreate(
+					gofluent10g.ErrInvalidConfig, "GenTraceIMIX: %s",
+					err.Error())
+			}
+			pktsData[i] = append(pktsData[i], data)
+		}
+	}
+
+	// current weights of the packet lengths used for interleaving
+	weightsCurrent := make([]float64, len(dist.Pktlens))
+
+	// accumulated inter-packet clock cycle rounding error
+	accCyclesInterPacketRoundErr := 0.0
+
+	// assemble the trace
+	builder := tracegen.traceBuilderCreate()
+
+	for i := 0; i < nPkts; i++ {
+		// select the next packet length by smooth weighted round-robin: each
+		// packet length gains its weight, the packet length with the largest
+		// current weight is selected and its current weight is reduced by the
+		// sum of all weights. This spreads the packet lengths evenly
+		iPktlen := 0
+		for j := range dist.Pktlens {
+			weightsCurrent[j] += dist.Weights[j]
+			if weightsCurrent[j] > weightsCurrent[iPktlen] {
+				iPktlen = j
+			}
+		}
+		weightsCurrent[iPktlen] -= weightSum
+
+		// MAC will append FCS, so substract 4 bytes from wire length
+		pkt := gofluent10g.TracePacket{
+			Data:    pktsData[iPktlen][i%len(hdrsIp)],
+			Wirelen: dist.Pktlens[iPktlen] - 4,
+		}
+
+		// set capture length
+		if pkt.Wirelen < pktlenCaptureMax {
+			pkt.Caplen = pkt.Wirelen
+		} else {
+			pkt.Caplen = pktlenCaptureMax
+		}
+
+		// determine the inter-packet time in clock cycles, such that the
+		// packet is sent with the target data rate (add 24 bytes for FCS,
+		// preamble, SOD and inter-frame gap)
+		cyclesInterPacket := tracegen.freqClock *
+			float64(8*(pkt.Wirelen+24)) / datarate
+
+		// the inter-packet time is a floating-point number, but clock cycles
+		// must always be integer values. We start by rounding up and
+		// accumulate the resulting rounding error. If the error becomes larger
+		// than 1 full clock cycle, we round down and decrease the accumulated
+		// error for the next packet. On average we will hit the target data
+		// rate.
+		if accCyclesInterPacketRoundErr < 1.0 {
+			// not enough rounding error accumulated yet -> round up
+			pkt.CyclesInterPacket = int(math.Ceil(cyclesInterPacket))
+			accCyclesInterPacketRoundErr +=
+				math.Ceil(cyclesInterPacket) - cyclesInterPacket
+		} else {
+			// enough rounding error accumulated -> round down
+			pkt.CyclesInterPacket = int(math.Floor(cyclesInterPacket))
+			accCyclesInterPacketRoundErr -=
+				cyclesInterPacket - math.Floor(cyclesInterPacket)
+		}
+
+		// add packet to trace
+		if err := builder.AddPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+
+	// print actual replay duration after rounding
+	gofluent10g.Log(gofluent10g.LOG_DEBUG, "Actual trace duration: %s (Target was %s)",
+		builder.GetDuration(), duration)
+
+	// create and return trace
+	return traceCreate(builder, nRepeats, seed)
+}
@@ -0,0 +1,195 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests the generation of traces with weighted packet size distributions.
+
+package utils
+
+import (
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestGenTraceIMIX generates a Simple IMIX trace and checks the interleaving
+// of the packet lengths and the data rate.
+func TestGenTraceIMIX(t *testing.T) {
+	trace, err := GenTraceIMIX(5e9, IMIXSimple, 64, time.Millisecond, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pktlens []int
+	it := trace.Packets()
+	for it.Next() {
+		pkt := it.Packet()
+		pktlens = append(pktlens, pkt.Wirelen+4)
+		if pkt.Caplen != int(math.Min(float64(pkt.Wirelen), 64)) {
+			t.Fatalf("packet %d: invalid capture length %d", len(pktlens)-1,
+				pkt.Caplen)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each sequence of 12 packets contains the packet lengths with a ratio of
+	// 7:4:1
+	if len(pktlens) < 12 {
+		t.Fatalf("trace contains %d packets only", len(pktlens))
+	}
+	for i := 0; i+12 <= len(pktlens); i += 12 {
+		count := make(map[int]int)
+		for _, pktlen := range pktlens[i : i+12] {
+			count[pktlen]++
+		}
+		if count[64] != 7 || count[594] != 4 || count[1518] != 1 {
+			t.Fatalf("packets %d-%d: packet length counts %v", i, i+11,
+				count)
+		}
+	}
+
+	// the number of packets matches the duration at the mean packet length
+	nPktsExp := 1e-3 * 5e9 / (8 * ((7*64+4*594+1518)/12.0 + 20))
+	if math.Abs(float64(len(pktlens))-nPktsExp) > 1 {
+		t.Fatalf("trace contains %d packets, expected %f", len(pktlens),
+			nPktsExp)
+	}
+	if n, _ := trace.GetPacketCount(); n != 2*len(pktlens) {
+		t.Fatalf("trace contains %d packets, expected %d", n, 2*len(pktlens))
+	}
+
+	datarate, err := trace.GetDatarate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(datarate-5e9)/5e9 > 1e-3 {
+		t.Fatalf("data rate %f, expected 5e9", datarate)
+	}
+}
+
+// TestGenTraceIMIXWeights checks that packet lengths occur with the frequency
+// given by non-integer weights.
+func TestGenTraceIMIXWeights(t *testing.T) {
+	dist := PktlenDistribution{
+		Pktlens: []int{64, 128, 256, 1024},
+		Weights: []float64{0.5, 0.25, 0.15, 0.1},
+	}
+	trace, err := GenTraceIMIX(9e9, dist, 64, 10*time.Millisecond, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := make(map[int]int)
+	n := 0
+	it := trace.Packets()
+	for ; it.Next(); n++ {
+		count[it.Packet().Wirelen+4]++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the interleaving is deterministic, so the frequencies deviate by at
+	// most one packet
+	for i, pktlen := range dist.Pktlens {
+		countExp := dist.Weights[i] * float64(n)
+		if math.Abs(float64(count[pktlen])-countExp) > 1 {
+			t.Fatalf("%d byte packets: %d packets, expected %f", pktlen,
+				count[pktlen], countExp)
+		}
+	}
+}
+
+// TestGenTraceIMIXEncap generates an IMIX trace with a VLAN tag and
+// alternating IP versions and checks the decoded headers.
+func TestGenTraceIMIXEncap(t *testing.T) {
+	encap := EncapStack{{Type: EncapVLAN, VLANID: 100}}
+	trace, err := GenTraceIMIXEncap(encap, IPVersionMixed, 5e9, IMIXSimple,
+		128, 100*time.Microsecond, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := trace.Packets()
+	for i := 0; it.Next(); i++ {
+		tracePkt := it.Packet()
+		pkt := gopacket.NewPacket(tracePkt.Data, layers.LayerTypeEthernet,
+			gopacket.Default)
+		vlan, ok := pkt.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+		if ok == false || vlan.VLANIdentifier != 100 {
+			t.Fatalf("packet %d: invalid VLAN header", i)
+		}
+
+		// IP length fields cover the whole packet behind the VLAN tag
+		if i%2 == 0 {
+			ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			if ok == false || int(ip.Length) != tracePkt.Wirelen-18 {
+				t.Fatalf("packet %d: invalid IPv4 header", i)
+			}
+		} else {
+			ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+			if ok == false || int(ip.Length) != tracePkt.Wirelen-58 {
+				t.Fatalf("packet %d: invalid IPv6 header", i)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGenTraceIMIXInvalid checks that invalid distributions and data rates
+// are rejected.
+func TestGenTraceIMIXInvalid(t *testing.T) {
+	dists := []PktlenDistribution{
+		{},
+		{Pktlens: []int{64, 128}, Weights: []float64{1}},
+		{Pktlens: []int{63}, Weights: []float64{1}},
+		{Pktlens: []int{1519}, Weights: []float64{1}},
+		{Pktlens: []int{64, 128}, Weights: []float64{1, 0}},
+	}
+	for i, dist := range dists {
+		_, err := GenTraceIMIX(1e9, dist, 64, time.Millisecond, 1, 1)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("distribution %d: expected invalid configuration "+
+				"error, got: %v", i, err)
+		}
+	}
+
+	for _, datarate := range []float64{0, 10.5e9} {
+		_, err := GenTraceIMIX(datarate, IMIXSimple, 64, time.Millisecond, 1, 1)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("%f bit/s: expected invalid configuration error, got: "+
+				"%v", datarate, err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Implements trace generation with a configurable packet arrival process and
+// packet size distribution.
+
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"github.com/aoeldemann/gofluent10g"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// packet arrival processes
+const (
+	// exponentially distributed inter-arrival times
+	ArrivalPoisson int = 0
+
+	// constant inter-arrival times
+	ArrivalDeterministic int = 1
+
+	// inter-arrival times following an empirical cumulative distribution
+	// function
+	ArrivalEmpirical int = 2
+)
+
+// ArrivalProcess defines the process determining the times at which packets
+// arrive, i.e. are sent by the network tester. The inter-arrival time is the
+// time between the start of two consecutive packets. The mean inter-arrival
+// time is determined by the target data rate and the mean packet size.
+type ArrivalProcess struct {
+	// arrival process (ArrivalPoisson, ArrivalDeterministic,
+	// ArrivalEmpirical)
+	Type int
+
+	// empirical cumulative distribution function of the inter-arrival times
+	// (ArrivalEmpirical). CDF[i] is the probability of an inter-arrival time
+	// being smaller than or equal to Gaps[i]. Inter-arrival times between two
+	// points are interpolated linearly. Both slices must be in ascending order
+	// and the last probability must be 1. The inter-arrival times are scaled
+	// such that the target data rate is reached, only the shape of the
+	// distribution is kept
+	Gaps []time.Duration
+	CDF  []float64
+}
+
+// PktlenDistributionFixed returns a packet size distribution that only
+// contains a single packet length (including FCS).
+func PktlenDistributionFixed(pktlen int) PktlenDistribution {
+	return PktlenDistribution{
+		Pktlens: []int{pktlen},
+		Weights: []float64{1},
+	}
+}
+
+// PktlenDistributionUniform returns a packet size distribution in which all
+// packet lengths between pktlenMin and pktlenMax (including FCS) occur with
+// the same frequency.
+func PktlenDistributionUniform(pktlenMin, pktlenMax int) PktlenDistribution {
+	var dist PktlenDistribution
+	for pktlen := pktlenMin; pktlen <= pktlenMax; pktlen++ {
+		dist.Pktlens = append(dist.Pktlens, pktlen)
+		dist.Weights = append(dist.Weights, 1)
+	}
+	return dist
+}
+
+// PktlenDistributionFromCSV reads a packet size distribution from a CSV file.
+// Each line of the file contains a packet length (including FCS) and its
+// weight (e.g. the number of packets of that length), separated by a comma.
+// Lines starting with '#' are ignored.
+func PktlenDistributionFromCSV(filename string) (_ PktlenDistribution, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	var dist PktlenDistribution
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return dist, gofluent10g.ErrorCreate(gofluent10g.ErrFile,
+			"PktlenDistribution '%s': could not open file", filename)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dist, gofluent10g.ErrorCreate(gofluent10g.ErrFile,
+				"PktlenDistribution '%s': %s", filename, err.Error())
+		}
+
+		pktlen, errPktlen := strconv.Atoi(record[0])
+		weight, errWeight := strconv.ParseFloat(record[1], 64)
+		if errPktlen != nil || errWeight != nil {
+			return dist, gofluent10g.ErrorCreate(
+				gofluent10g.ErrInvalidConfig, "PktlenDistribution '%s': "+
+					"invalid record %d", filename, line)
+		}
+
+		dist.Pktlens = append(dist.Pktlens, pktlen)
+		dist.Weights = append(dist.Weights, weight)
+	}
+
+	return dist, dist.check("PktlenDistribution")
+}
+
+// PktlenDistributionFromPcap creates a packet size distribution from the
+// histogram of the packet lengths of a pcap or pcapng file. Packets shorter
+// than 64 bytes (including FCS) are accounted as 64 byte packets, since they
+// are padded on the wire. Packets larger than 1518 bytes are ignored.
+func PktlenDistributionFromPcap(filename string) (_ PktlenDistribution, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	var dist PktlenDistribution
+
+	f, r, err := gofluent10g.PcapOpen("PktlenDistribution", filename)
+	if err != nil {
+		return dist, err
+	}
+	defer f.Close()
+
+	// count the packets of each length
+	counts := make(map[int]int)
+	nIgnored := 0
+	for {
+		_, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dist, gofluent10g.ErrorCreate(gofluent10g.ErrFile,
+				"PktlenDistribution '%s': %s", filename, err.Error())
+		}
+
+		// captured length does not include the FCS
+		pktlen := ci.Length + 4
+		if pktlen < 64 {
+			pktlen = 64
+		} else if pktlen > 1518 {
+			nIgnored++
+			continue
+		}
+		counts[pktlen]++
+	}
+
+	if nIgnored > 0 {
+		gofluent10g.Log(gofluent10g.LOG_WARN, "PktlenDistribution '%s': "+
+			"ignored %d packets larger than 1518 bytes", filename, nIgnored)
+	}
+
+	// create distribution in ascending order of packet lengths
+	for pktlen := range counts {
+		dist.Pktlens = append(dist.Pktlens, pktlen)
+	}
+	sort.Ints(dist.Pktlens)
+	for _, pktlen := range dist.Pktlens {
+		dist.Weights = append(dist.Weights, float64(counts[pktlen]))
+	}
+
+	return dist, dist.check("PktlenDistribution")
+}
+
+// GenTraceModel generates traffic with packet arrival times following the
+// arrival process arrival and packet sizes following the size distribution
+// dist, which are randomly selected for each packet. Only Ethernet and IPv4
+// headers are generated, payload bits are set to zero. datarate defines the
+// target mean data rate (bits per second, including Ethernet preamble, SOD,
+// FCS and inter-frame gap). Packets arriving before the previous packet has
+// been transmitted at line rate are delayed. pktlenCaptureMax defines
+// the maximum number of data bytes that are written to the hardware. Hardware
+// then appends zero bytes to the packet to restore its original length. The
+// duration parameter specifies the total duration of the generated trace. The
+// parameter nRepeats determins how often the generated trace shall be
+// replayed. The seed parameter initializes the random number generator, the
+// same seed and parameters always result in identical trace data. If seed is
+// zero, a random seed is selected. The seed is recorded in the trace (see
+// Trace.GetSeed()).
+func GenTraceModel(datarate float64, arrival ArrivalProcess, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (*gofluent10g.Trace, error) {
+	return traceGenDefault.GenTraceModel(datarate, arrival, dist,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// GenTraceModel generates a trace like the package function GenTraceModel(),
+// but for the board the trace generator has been created for.
+func (tracegen *TraceGenerator) GenTraceModel(datarate float64, arrival ArrivalProcess, dist PktlenDistribution, pktlenCaptureMax int, duration time.Duration, nRepeats int, seed int64) (_ *gofluent10g.Trace, err error) {
+	defer gofluent10g.ErrorReturn(&err)
+
+	// check packet size distribution
+	if err := dist.check("GenTraceModel"); err != nil {
+		return nil, err
+	}
+
+	// data rate must not exceed line rate
+	if datarate <= 0 || datarate > tracegen.lineRate {
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceModel: invalid data rate")
+	}
+
+	// create random number generator
+	rng, seed := rngCreate(seed)
+
+	// mean inter-arrival time. Packet lengths include the FCS, add 20 bytes
+	// for Ethernet preamble + SOD and inter-frame gap
+	tInterArrivalMean := 8 * (dist.getMean() + 20) / datarate
+
+	// create function returning random inter-arrival times
+	var interArrival func() float64
+	switch arrival.Type {
+	case ArrivalPoisson:
+		interArrival = func() float64 {
+			return tInterArrivalMean * rng.ExpFloat64()
+		}
+	case ArrivalDeterministic:
+		interArrival = func() float64 {
+			return tInterArrivalMean
+		}
+	case ArrivalEmpirical:
+		if err := arrival.check(); err != nil {
+			return nil, err
+		}
+		// scale inter-arrival times to reach the target mean
+		scale := tInterArrivalMean / arrival.getMean()
+		interArrival = func() float64 {
+			return scale * arrival.sample(rng)
+		}
+	default:
+		return nil, gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceModel: invalid arrival process")
+	}
+
+	// cumulative weights of the packet size distribution
+	weightsCum := dist.getWeightsCum()
+
+	// determine send times and lengths of the packets. MAC will append FCS, so
+	// the packets we generate here are 4 bytes shorter
+	var tSend []float64
+	var pktlens []int
+	for t := 0.0; t < duration.Seconds(); t += interArrival() {
+		tSend = append(tSend, t)
+		pktlens = append(pktlens, dist.sample(rng, weightsCum)-4)
+	}
+
+	return tracegen.genTraceSendTimes("GenTraceModel", tSend, pktlens,
+		pktlenCaptureMax, duration, nRepeats, seed)
+}
+
+// check checks whether the packet size distribution is valid. The name
+// parameter is used for error messages.
+func (dist *PktlenDistribution) check(name string) error {
+	if len(dist.Pktlens) == 0 || len(dist.Pktlens) != len(dist.Weights) {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"%s: invalid packet size distribution", name)
+	}
+	for i := range dist.Pktlens {
+		if dist.Pktlens[i] < 64 || dist.Pktlens[i] > 1518 {
+			return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"%s: packet length must be in the range of 64 and 1518 "+
+					"bytes", name)
+		}
+		if dist.Weights[i] <= 0 {
+			return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"%s: weights must be larger than zero", name)
+		}
+	}
+	return nil
+}
+
+// getMean returns the mean packet length (including FCS) of the packet size
+// distribution.
+func (dist *PktlenDistribution) getMean() float64 {
+	weightSum := 0.0
+	pktlenMean := 0.0
+	for i := range dist.Pktlens {
+		weightSum += dist.Weights[i]
+		pktlenMean += dist.Weights[i] * float64(dist.Pktlens[i])
+	}
+	return pktlenMean / weightSum
+}
+
+// getWeightsCum returns the cumulative weights of the packet size
+// distribution.
+func (dist *PktlenDistribution) getWeightsCum() []float64 {
+	weightsCum := make([]float64, len(dist.Weights))
+	sum := 0.0
+	for i, weight := range dist.Weights {
+		sum += weight
+		weightsCum[i] = sum
+	}
+	return weightsCum
+}
+
+// sample returns a random packet length (including FCS) of the packet size
+// distribution. weightsCum are the cumulative weights of the distribution.
+func (dist *PktlenDistribution) sample(rng *rand.Rand, weightsCum []float64) int {
+	r := rng.Float64() * weightsCum[len(weightsCum)-1]
+	i := sort.SearchFloat64s(weightsCum, r)
+	if i == len(weightsCum) {
+		i--
+	}
+	return dist.Pktlens[i]
+}
+
+// check checks whether the empirical inter-arrival time distribution is
+// valid.
+func (arrival *ArrivalProcess) check() error {
+	n := len(arrival.Gaps)
+	if n == 0 || n != len(arrival.CDF) || arrival.CDF[n-1] != 1 {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceModel: invalid inter-arrival time distribution")
+	}
+	for i := range arrival.Gaps {
+		if arrival.Gaps[i] < 0 || arrival.CDF[i] < 0 || (i > 0 &&
+			(arrival.Gaps[i] < arrival.Gaps[i-1] ||
+				arrival.CDF[i] < arrival.CDF[i-1])) {
+			return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+				"GenTraceModel: invalid inter-arrival time distribution")
+		}
+	}
+	if arrival.getMean() <= 0 {
+		return gofluent10g.ErrorCreate(gofluent10g.ErrInvalidConfig,
+			"GenTraceModel: mean inter-arrival time must be larger than zero")
+	}
+	return nil
+}
+
+// getMean returns the mean inter-arrival time (in seconds) of the empirical
+// inter-arrival time distribution.
+func (arrival *ArrivalProcess) getMean() float64 {
+	// the probability of the first point is concentrated on its inter-arrival
+	// time, between two points inter-arrival times are uniformly distributed
+	mean := arrival.CDF[0] * arrival.Gaps[0].Seconds()
+	for i := 1; i < len(arrival.Gaps); i++ {
+		mean += (arrival.CDF[i] - arrival.CDF[i-1]) *
+			(arrival.Gaps[i-1].Seconds() + arrival.Gaps[i].Seconds()) / 2
+	}
+	return mean
+}
+
+// sample returns a random inter-arrival time (in seconds) of the empirical
+// inter-arrival time distribution.
+func (arrival *ArrivalProcess) sample(rng *rand.Rand) float64 {
+	r := rng.Float64()
+	i := sort.SearchFloat64s(arrival.CDF, r)
+	if i == 0 {
+		return arrival.Gaps[0].Seconds()
+	}
+
+	// interpolate linearly between the two points
+	cdfLow, cdfHigh := arrival.CDF[i-1], arrival.CDF[i]
+	gapLow, gapHigh := arrival.Gaps[i-1].Seconds(), arrival.Gaps[i].Seconds()
+	return gapLow + (gapHigh-gapLow)*(r-cdfLow)/(cdfHigh-cdfLow)
+}
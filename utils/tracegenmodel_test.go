@@ -0,0 +1,275 @@
+// The MIT License
+//
+// Copyright (c) 2017-2018 by the author(s)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+// Author(s):
+//   - Andreas Oeldemann <andreas.oeldemann@tum.de>
+//
+// Description:
+//
+// Tests trace generation with configurable arrival processes and packet size
+// distributions.
+
+package utils
+
+import (
+	"errors"
+	"github.com/aoeldemann/gofluent10g"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestArrivalEmpiricalSample samples inter-arrival times from an empirical
+// distribution and compares their histogram with the distribution.
+func TestArrivalEmpiricalSample(t *testing.T) {
+	arrival := ArrivalProcess{
+		Type: ArrivalEmpirical,
+		Gaps: []time.Duration{time.Microsecond, 2 * time.Microsecond,
+			4 * time.Microsecond},
+		CDF: []float64{0.25, 0.5, 1},
+	}
+	if err := arrival.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 25% of the samples are 1 us, 25% are uniformly distributed between 1
+	// and 2 us, 50% between 2 and 4 us
+	meanExp := 0.25*1e-6 + 0.25*1.5e-6 + 0.5*3e-6
+	if math.Abs(arrival.getMean()-meanExp) > 1e-12 {
+		t.Fatalf("mean %g, expected %g", arrival.getMean(), meanExp)
+	}
+
+	rng := rand.New(rand.NewSource(42))
+	nSamples := 100000
+	var nFirst, nLow, nLowLower, nHigh int
+	sum := 0.0
+	for i := 0; i < nSamples; i++ {
+		gap := arrival.sample(rng)
+		sum += gap
+		switch {
+		case gap < 1e-6 || gap > 4e-6:
+			t.Fatalf("sample %g out of range", gap)
+		case gap == 1e-6:
+			nFirst++
+		case gap <= 2e-6:
+			nLow++
+			if gap <= 1.5e-6 {
+				nLowLower++
+			}
+		default:
+			nHigh++
+		}
+	}
+
+	fractions := []struct {
+		name     string
+		n        int
+		expected float64
+	}{
+		{"first point", nFirst, 0.25},
+		{"first interval", nLow, 0.25},
+		{"lower half of first interval", nLowLower, 0.125},
+		{"second interval", nHigh, 0.5},
+	}
+	for _, f := range fractions {
+		if math.Abs(float64(f.n)/float64(nSamples)-f.expected) > 0.01 {
+			t.Fatalf("%s: fraction %f, expected %f", f.name,
+				float64(f.n)/float64(nSamples), f.expected)
+		}
+	}
+	if math.Abs(sum/float64(nSamples)-meanExp)/meanExp > 0.01 {
+		t.Fatalf("sample mean %g, expected %g", sum/float64(nSamples),
+			meanExp)
+	}
+}
+
+// TestGenTraceModel generates traces for all arrival processes and checks the
+// mean data rate and the frequency of the packet lengths.
+func TestGenTraceModel(t *testing.T) {
+	dist := PktlenDistribution{
+		Pktlens: []int{64, 512, 1518},
+		Weights: []float64{6, 3, 1},
+	}
+	arrivals := map[string]ArrivalProcess{
+		"poisson":       {Type: ArrivalPoisson},
+		"deterministic": {Type: ArrivalDeterministic},
+		"empirical": {
+			Type: ArrivalEmpirical,
+			Gaps: []time.Duration{0, time.Microsecond},
+			CDF:  []float64{0, 1},
+		},
+	}
+
+	for name, arrival := range arrivals {
+		trace, err := GenTraceModel(4e9, arrival, dist, 64,
+			10*time.Millisecond, 1, 42)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		testCheckLineRate(t, name, trace)
+
+		mean, err := trace.GetDatarate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// random packet lengths and arrivals cause deviations of a few
+		// percent
+		if math.Abs(mean-4e9)/4e9 > 0.05 {
+			t.Fatalf("%s: mean data rate %f, expected 4e9", name, mean)
+		}
+
+		count := make(map[int]int)
+		n := 0
+		it := trace.Packets()
+		for ; it.Next(); n++ {
+			count[it.Packet().Wirelen+4]++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		for i, pktlen := range dist.Pktlens {
+			fraction := float64(count[pktlen]) / float64(n)
+			if math.Abs(fraction-dist.Weights[i]/10) > 0.02 {
+				t.Fatalf("%s: %d byte packets: fraction %f, expected %f",
+					name, pktlen, fraction, dist.Weights[i]/10)
+			}
+		}
+	}
+
+	// invalid arrival processes
+	arrivalsInvalid := []ArrivalProcess{
+		{Type: 42},
+		{Type: ArrivalEmpirical},
+		{Type: ArrivalEmpirical, Gaps: []time.Duration{time.Microsecond},
+			CDF: []float64{0.5}},
+		{Type: ArrivalEmpirical, Gaps: []time.Duration{2 * time.Microsecond,
+			time.Microsecond}, CDF: []float64{0.5, 1}},
+		{Type: ArrivalEmpirical, Gaps: []time.Duration{0}, CDF: []float64{1}},
+	}
+	for i, arrival := range arrivalsInvalid {
+		_, err := GenTraceModel(4e9, arrival, dist, 64, time.Millisecond, 1,
+			42)
+		if errors.Is(err, gofluent10g.ErrInvalidConfig) == false {
+			t.Fatalf("arrival process %d: expected invalid configuration "+
+				"error, got: %v", i, err)
+		}
+	}
+}
+
+// TestPktlenDistributionFromFile reads packet size distributions from a CSV
+// file and from pcap and pcapng files.
+func TestPktlenDistributionFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofluent10g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filenameCSV := filepath.Join(dir, "dist.csv")
+	err = ioutil.WriteFile(filenameCSV, []byte("# pktlen, weight\n"+
+		"64, 7\n594, 4\n1518, 1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dist, err := PktlenDistributionFromCSV(filenameCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dist.Pktlens) != 3 || dist.Pktlens[1] != 594 ||
+		dist.Weights[1] != 4 {
+		t.Fatalf("invalid distribution %v", dist)
+	}
+
+	// packets of 60 (padded to 64), 100 and 9000 (ignored) bytes, lengths
+	// exclude the FCS
+	lengths := []int{56, 60, 96, 96, 96, 8996}
+	for _, format := range []string{"pcap", "pcapng"} {
+		filename := filepath.Join(dir, "dist."+format)
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w interface {
+			WritePacket(gopacket.CaptureInfo, []byte) error
+		}
+		if format == "pcap" {
+			wPcap := pcapgo.NewWriter(f)
+			wPcap.WriteFileHeader(65535, layers.LinkTypeEthernet)
+			w = wPcap
+		} else {
+			wNg, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w = wNg
+		}
+		for _, length := range lengths {
+			ci := gopacket.CaptureInfo{
+				Timestamp:     time.Now(),
+				CaptureLength: 14,
+				Length:        length,
+			}
+			if err := w.WritePacket(ci, make([]byte, 14)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if wNg, ok := w.(*pcapgo.NgWriter); ok {
+			wNg.Flush()
+		}
+		f.Close()
+
+		dist, err := PktlenDistributionFromPcap(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(dist.Pktlens) != 2 || dist.Pktlens[0] != 64 ||
+			dist.Weights[0] != 2 || dist.Pktlens[1] != 100 ||
+			dist.Weights[1] != 3 {
+			t.Fatalf("%s: invalid distribution %v", format, dist)
+		}
+	}
+
+	// invalid files
+	err = ioutil.WriteFile(filenameCSV, []byte("64, a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PktlenDistributionFromCSV(filenameCSV); errors.Is(err,
+		gofluent10g.ErrInvalidConfig) == false {
+		t.Fatalf("expected invalid configuration error, got: %v", err)
+	}
+	if _, err := PktlenDistributionFromCSV(filepath.Join(dir,
+		"missing.csv")); errors.Is(err, gofluent10g.ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+	if _, err := PktlenDistributionFromPcap(filenameCSV); errors.Is(err,
+		gofluent10g.ErrFile) == false {
+		t.Fatalf("expected file error, got: %v", err)
+	}
+}